@@ -3,20 +3,43 @@ package ztls
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/gabstv/ztls/internal/pkix"
 )
 
 const DefaultEndpoint = "https://ztls.gabs.dev"
 
+// KeyType is the algorithm (and curve) of a private key
+type KeyType = pkix.KeyType
+
+const (
+	KeyRSA       = pkix.KeyRSA
+	KeyECDSAP256 = pkix.KeyECDSAP256
+	KeyECDSAP384 = pkix.KeyECDSAP384
+	KeyEd25519   = pkix.KeyEd25519
+)
+
+// ParseKeyType parses a key type name (rsa, ecdsa-p256, ecdsa-p384 or ed25519)
+func ParseKeyType(name string) (KeyType, error) {
+	return pkix.ParseKeyType(name)
+}
+
 type Client struct {
 	Endpoint string
 	APIKey   string
+	KeyType  KeyType // [OPTIONAL] Key type used by NewKey (default: RSA 4096)
+	// [OPTIONAL] bootstrap token authorizing IssueCertificate instead of the
+	// API key (see NewToken)
+	Token string
+	// [OPTIONAL] HTTP client of the requests, e.g. with a TLS client
+	// certificate for Renew (default: http.DefaultClient)
+	HTTPClient *http.Client
 }
 
 var DefaultClient = &Client{}
@@ -31,7 +54,7 @@ func (c *Client) GetCA(ctx context.Context) ([]byte, error) {
 		req.Header.Set("X-API-KEY", c.APIKey)
 	}
 	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpclient().Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +71,7 @@ func (c *Client) GetCA(ctx context.Context) ([]byte, error) {
 }
 
 func (c *Client) NewKey() ([]byte, error) {
-	return pkix.NewKey(4096)
+	return pkix.NewKeyWithType(c.KeyType, pkix.DefaultRSAKeySize)
 }
 
 type NewCSRInput struct {
@@ -62,6 +85,7 @@ type NewCSRInput struct {
 	PostalCode         []string // [OPTIONAL]
 	IPs                []string // [OPTIONAL] Additional IPs
 	Domains            []string // [OPTIONAL] Additional Domains
+	URIs               []string // [OPTIONAL] URI SANs, e.g. a SPIFFE ID (spiffe://<trust-domain>/<path>)
 }
 
 func CommonName(name string) NewCSRInput {
@@ -81,18 +105,168 @@ func (c *Client) NewCSR(input NewCSRInput, key []byte) ([]byte, error) {
 		PostalCode:         input.PostalCode,
 		IPs:                input.IPs,
 		Domains:            input.Domains,
+		URIs:               input.URIs,
 		CommonName:         input.CommonName,
 	}
 	return pkix.NewCSRPEM(nfo, key, nil)
 }
 
+// Certificate profiles of the ztls server
+const (
+	ProfileServer      = "server"
+	ProfileClient      = "client"
+	ProfilePeer        = "peer"
+	ProfileCodeSigning = "code-signing"
+)
+
+type NewCertificateRequest struct {
+	CSR     []byte // [REQUIRED] PEM encoded CSR
+	Profile string // [OPTIONAL] Certificate profile (default: the server default profile)
+	// [OPTIONAL] Lifetime of the certificate (TTL or NotAfter, not both).
+	// Default: the lifetime of the profile or of the server.
+	TTL      time.Duration
+	NotAfter time.Time
+}
+
 func (c *Client) NewCertificate(ctx context.Context, csr []byte) ([]byte, error) {
-	ur0 := "/1/new-certificate"
+	return c.NewCertificateWithRequest(ctx, NewCertificateRequest{
+		CSR: csr,
+	})
+}
+
+// NewCertificateWithRequest returns the PEM encoded certificate followed by
+// the intermediate certificates (if any)
+func (c *Client) NewCertificateWithRequest(ctx context.Context, input NewCertificateRequest) ([]byte, error) {
+	cert, err := c.IssueCertificate(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(cert.Certificate + cert.Chain), nil
+}
+
+// Certificate is a certificate issued by the server and its metadata
+type Certificate struct {
+	Certificate    string    `json:"certificate"`     // PEM
+	Chain          string    `json:"chain,omitempty"` // PEM, intermediate certificates
+	Serial         string    `json:"serial"`          // decimal
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	Fingerprint    string    `json:"fingerprint"` // SHA-256 of the DER, hex
+	CommonName     string    `json:"common_name,omitempty"`
+	DNSNames       []string  `json:"dns_names,omitempty"`
+	IPAddresses    []string  `json:"ip_addresses,omitempty"`
+	EmailAddresses []string  `json:"email_addresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+}
+
+// Error codes of APIError
+const (
+	ErrCodeInvalidRequest   = "invalid_request"
+	ErrCodeInvalidCSR       = "invalid_csr"
+	ErrCodeInvalidLifetime  = "invalid_lifetime"
+	ErrCodeUnknownProfile   = "unknown_profile"
+	ErrCodePolicyRejected   = "policy_rejected"
+	ErrCodeIdentityMismatch = "identity_mismatch"
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeRateLimited      = "rate_limited"
+	ErrCodeInternal         = "internal_error"
+)
+
+// APIError is an error returned by the server
+type APIError struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return "ztls: " + e.Code + ": " + e.Message
+}
+
+// IssueCertificate requests a certificate. Server errors are returned as
+// *APIError.
+func (c *Client) IssueCertificate(ctx context.Context, input NewCertificateRequest) (*Certificate, error) {
+	ur0 := "/2/new-certificate"
 	if c.APIKey != "" {
-		ur0 = "/1/new-server-certificate"
+		ur0 = "/2/new-server-certificate"
+	}
+	return c.postcert(ctx, ur0, certificateRequestJSON(input))
+}
+
+type certificateRequest struct {
+	CSR         string `json:"csr"`
+	Profile     string `json:"profile,omitempty"`
+	TTL         string `json:"ttl,omitempty"`
+	NotAfter    string `json:"not_after,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+	Signature   string `json:"signature,omitempty"`
+}
+
+func certificateRequestJSON(input NewCertificateRequest) *certificateRequest {
+	d := &certificateRequest{
+		CSR:     string(input.CSR),
+		Profile: input.Profile,
 	}
-	rdr := bytes.NewReader([]byte(fmt.Sprintf("{\"csr\":\"%s\"}", strings.Replace(string(csr), "\n", "\\n", -1))))
-	req, err := http.NewRequest(http.MethodPost, c.url(ur0), rdr)
+	if input.TTL > 0 {
+		d.TTL = input.TTL.String()
+	}
+	if !input.NotAfter.IsZero() {
+		d.NotAfter = input.NotAfter.UTC().Format(time.RFC3339)
+	}
+	return d
+}
+
+// RenewRequest renews a certificate issued by the server
+type RenewRequest struct {
+	NewCertificateRequest // the CSR must have the identity of Certificate
+	// [OPTIONAL] PEM encoded certificate being renewed and its key, which
+	// signs the CSR to prove its possession. Not required if HTTPClient
+	// presents the certificate as a TLS client certificate.
+	Certificate []byte
+	Key         []byte
+	KeyPassword []byte
+}
+
+// Renew renews a certificate without the API key: the request is
+// authenticated by the current certificate (mTLS or a proof of possession of
+// its key). Server errors are returned as *APIError.
+func (c *Client) Renew(ctx context.Context, input RenewRequest) (*Certificate, error) {
+	d := certificateRequestJSON(input.NewCertificateRequest)
+	d.Certificate = string(input.Certificate)
+	if len(input.Key) > 0 {
+		key, err := pkix.ParsePrivateKey(input.Key, input.KeyPassword)
+		if err != nil {
+			return nil, err
+		}
+		csrder, err := pkix.DecodePEM(input.CSR, pkix.PEMCertificateRequest, nil)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := pkix.SignProof(key, csrder)
+		if err != nil {
+			return nil, err
+		}
+		d.Signature = base64.StdEncoding.EncodeToString(sig)
+	}
+	return c.postcert(ctx, "/2/renew", d)
+}
+
+func (c *Client) postcert(ctx context.Context, path string, d *certificateRequest) (*Certificate, error) {
+	cert := &Certificate{}
+	if err := c.postjson(ctx, path, d, cert); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// postjson posts in as JSON to a /2/ route and decodes the response into out
+func (c *Client) postjson(ctx context.Context, path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url(path), bytes.NewReader(body))
 	if err != nil {
 		// this error triggers if the method or url is invalid, hence the panic
 		panic(err)
@@ -100,24 +274,78 @@ func (c *Client) NewCertificate(ctx context.Context, csr []byte) ([]byte, error)
 	if c.APIKey != "" {
 		req.Header.Set("X-API-KEY", c.APIKey)
 	}
+	if c.Token != "" {
+		req.Header.Set("X-BOOTSTRAP-TOKEN", c.Token)
+	}
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpclient().Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		b2 := new(bytes.Buffer)
-		io.Copy(b2, resp.Body)
-		return nil, errors.New("http status " + resp.Status + " " + b2.String())
+		return decodeAPIError(resp)
 	}
-	buf := new(bytes.Buffer)
-	_, err = io.Copy(buf, resp.Body)
-	if err != nil {
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// NewTokenRequest creates a bootstrap token
+type NewTokenRequest struct {
+	CommonName string        `json:"common_name"`     // [REQUIRED] common name of the certificates
+	Names      []string      `json:"names,omitempty"` // [OPTIONAL] additional DNS names and IP addresses
+	Profile    string        `json:"profile,omitempty"`
+	Uses       int           `json:"uses,omitempty"` // [OPTIONAL] default: 1
+	TTL        time.Duration `json:"-"`              // [OPTIONAL] lifetime of the token (default: 1h)
+}
+
+// Token is a bootstrap token, set in Client.Token to request certificates
+// without the API key
+type Token struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UsesLeft  int       `json:"uses_left"`
+}
+
+// NewToken creates a bootstrap token (requires the API key). Server errors
+// are returned as *APIError.
+func (c *Client) NewToken(ctx context.Context, input NewTokenRequest) (*Token, error) {
+	d := struct {
+		NewTokenRequest
+		TTL string `json:"ttl,omitempty"`
+	}{
+		NewTokenRequest: input,
+	}
+	if input.TTL > 0 {
+		d.TTL = input.TTL.String()
+	}
+	t := &Token{}
+	if err := c.postjson(ctx, "/2/tokens", d, t); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return t, nil
+}
+
+// decodeAPIError reads the error of a /2/ response
+func decodeAPIError(resp *http.Response) error {
+	buf := new(bytes.Buffer)
+	io.Copy(buf, resp.Body)
+	d := struct {
+		Error *APIError `json:"error"`
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil || d.Error == nil {
+		return errors.New("http status " + resp.Status + " " + buf.String())
+	}
+	d.Error.StatusCode = resp.StatusCode
+	return d.Error
+}
+
+func (c *Client) httpclient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
 }
 
 func (c *Client) url(remainder string) string {
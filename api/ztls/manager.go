@@ -0,0 +1,435 @@
+package ztls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultRenewFraction is the fraction of the certificate lifetime after
+// which CertManager renews it
+const DefaultRenewFraction = 2.0 / 3
+
+// backoff of the failed renewals
+const (
+	minRetryInterval = time.Second * 10
+	maxRetryInterval = time.Minute * 10
+)
+
+// cache files in CertManager.CacheDir
+const (
+	cacheKeyFile  = "key.pem"
+	cacheCertFile = "cert.pem"
+	cacheCAFile   = "ca.pem"
+)
+
+// Issuer creates the keys and issues the certificates of a CertManager.
+// *Client is an Issuer; other implementations can issue in-process (e.g. with
+// an embedded server).
+type Issuer interface {
+	NewKey() ([]byte, error)
+	IssueCertificate(ctx context.Context, input NewCertificateRequest) (*Certificate, error)
+	GetCA(ctx context.Context) ([]byte, error)
+}
+
+// RenewEvent is passed to CertManager.OnRenew after every renewal attempt
+type RenewEvent struct {
+	Certificate *x509.Certificate // the new certificate (nil if Err is set)
+	Err         error
+	// Failures is the number of consecutive failed renewals (including this
+	// one)
+	Failures int
+}
+
+// CertManagerStats are the renewal metrics of a CertManager
+type CertManagerStats struct {
+	Renewals    uint64 // successful renewals
+	Failures    uint64 // failed renewals
+	LastRenewal time.Time
+	LastError   error     // error of the last failed renewal (nil after a success)
+	NotAfter    time.Time // expiration of the current certificate
+	RenewAt     time.Time // next scheduled renewal
+}
+
+// CertManager obtains a certificate from the server and renews it before it
+// expires. Use its callbacks in a tls.Config (or ServerTLSConfig and
+// ClientTLSConfig) so long-running services pick up the renewed
+// certificates.
+type CertManager struct {
+	Client *Client // [REQUIRED] unless Issuer is set
+	// [OPTIONAL] issues the certificates instead of Client
+	Issuer  Issuer
+	CSR     NewCSRInput // [REQUIRED] identity of the certificate
+	Profile string      // [OPTIONAL] certificate profile (default: the server default profile)
+	// [OPTIONAL] lifetime of the certificates (default: the lifetime of the
+	// profile or of the server)
+	TTL time.Duration
+	// [OPTIONAL] fraction of the lifetime after which the certificate is
+	// renewed (default: DefaultRenewFraction)
+	RenewFraction float64
+	// [OPTIONAL] directory where the key, the certificate and the CA are
+	// cached across restarts (default: no cache)
+	CacheDir string
+	// [OPTIONAL] called after every renewal attempt, e.g. to log or export
+	// the rotations; it must not block
+	OnRenew func(ev RenewEvent)
+	// [OPTIONAL] backoff of the failed renewals: the first retry is after
+	// MinRetryInterval, doubled after each failure up to MaxRetryInterval
+	// (default: 10s and 10m)
+	MinRetryInterval time.Duration
+	MaxRetryInterval time.Duration
+
+	l       sync.RWMutex
+	rnd     *rand.Rand
+	cert    *tls.Certificate
+	certpem []byte
+	keypem  []byte
+	pool    *x509.CertPool
+	renewAt time.Time
+	// metrics
+	renewals uint64
+	failures uint64
+	nfail    int
+	lastok   time.Time
+	lasterr  error
+}
+
+// Start obtains the certificate (from the cache, if still valid) and renews
+// it in the background until ctx is done
+func (m *CertManager) Start(ctx context.Context) error {
+	if err := m.loadcache(); err != nil || m.current() == nil || time.Now().After(m.renewat()) {
+		if err := m.Renew(ctx); err != nil {
+			return err
+		}
+	}
+	go m.run(ctx)
+	return nil
+}
+
+// Renew renews the certificate now: with a proof of possession of the
+// current certificate (see Client.Renew) if it is valid, or with a new
+// request authenticated by the API key or bootstrap token of the Client
+// (or with the Issuer)
+func (m *CertManager) Renew(ctx context.Context) error {
+	err := m.renew(ctx)
+	m.l.Lock()
+	ev := RenewEvent{
+		Err: err,
+	}
+	if err != nil {
+		m.failures++
+		m.nfail++
+		m.lasterr = err
+		ev.Failures = m.nfail
+	} else {
+		m.renewals++
+		m.nfail = 0
+		m.lasterr = nil
+		m.lastok = time.Now()
+		ev.Certificate = m.cert.Leaf
+	}
+	m.l.Unlock()
+	if m.OnRenew != nil {
+		m.OnRenew(ev)
+	}
+	return err
+}
+
+func (m *CertManager) renew(ctx context.Context) error {
+	var is Issuer = m.Client
+	if m.Issuer != nil {
+		is = m.Issuer
+	}
+	key, err := is.NewKey()
+	if err != nil {
+		return err
+	}
+	csr, err := (&Client{}).NewCSR(m.CSR, key)
+	if err != nil {
+		return err
+	}
+	req := NewCertificateRequest{
+		CSR:     csr,
+		Profile: m.Profile,
+		TTL:     m.TTL,
+	}
+	var cert *Certificate
+	m.l.RLock()
+	cur, curcert, curkey := m.cert, m.certpem, m.keypem
+	m.l.RUnlock()
+	cl, isclient := is.(*Client)
+	if isclient && cur != nil && time.Now().Before(cur.Leaf.NotAfter) {
+		cert, err = cl.Renew(ctx, RenewRequest{
+			NewCertificateRequest: req,
+			Certificate:           curcert,
+			Key:                   curkey,
+		})
+	}
+	if cert == nil && (err == nil || !isclient || cl.APIKey != "" || cl.Token != "") {
+		cert, err = is.IssueCertificate(ctx, req)
+	}
+	if err != nil {
+		return err
+	}
+	ca, err := is.GetCA(ctx)
+	if err != nil {
+		return err
+	}
+	certpem := []byte(cert.Certificate + cert.Chain)
+	if err := m.set(certpem, key, ca); err != nil {
+		return err
+	}
+	return m.savecache(certpem, key, ca)
+}
+
+// run renews the certificate at the renewal time, retrying with a jittered
+// exponential backoff
+func (m *CertManager) run(ctx context.Context) {
+	minretry, maxretry := minRetryInterval, maxRetryInterval
+	if m.MinRetryInterval > 0 {
+		minretry = m.MinRetryInterval
+	}
+	if m.MaxRetryInterval > 0 {
+		maxretry = m.MaxRetryInterval
+	}
+	if maxretry < minretry {
+		maxretry = minretry
+	}
+	retry := minretry
+	for {
+		wait := time.Until(m.renewat())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		for m.Renew(ctx) != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(m.jitter(retry)):
+			}
+			if retry *= 2; retry > maxretry {
+				retry = maxretry
+			}
+		}
+		retry = minretry
+	}
+}
+
+// jitter returns d ±20%
+func (m *CertManager) jitter(d time.Duration) time.Duration {
+	m.l.Lock()
+	defer m.l.Unlock()
+	return d + time.Duration((m.random()*0.4-0.2)*float64(d))
+}
+
+// random returns a number in [0, 1) from the random source of the manager
+// (m.l must be held)
+func (m *CertManager) random() float64 {
+	if m.rnd == nil {
+		m.rnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return m.rnd.Float64()
+}
+
+// set replaces the certificate and the CA pool
+func (m *CertManager) set(certpem, keypem, capem []byte) error {
+	cert, err := tls.X509KeyPair(certpem, keypem)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(capem); !ok {
+		return errors.New("could not parse CA certificate")
+	}
+	fraction := m.RenewFraction
+	if fraction <= 0 || fraction >= 1 {
+		fraction = DefaultRenewFraction
+	}
+	lifetime := cert.Leaf.NotAfter.Sub(cert.Leaf.NotBefore)
+	m.l.Lock()
+	defer m.l.Unlock()
+	m.cert = &cert
+	m.certpem = certpem
+	m.keypem = keypem
+	m.pool = pool
+	// up to 5% earlier, so the clients of a fleet don't renew all at once
+	m.renewAt = cert.Leaf.NotBefore.Add(time.Duration(float64(lifetime) * (fraction - m.random()*0.05)))
+	return nil
+}
+
+func (m *CertManager) current() *tls.Certificate {
+	m.l.RLock()
+	defer m.l.RUnlock()
+	return m.cert
+}
+
+func (m *CertManager) renewat() time.Time {
+	m.l.RLock()
+	defer m.l.RUnlock()
+	return m.renewAt
+}
+
+// loadcache loads the cached certificate if it is still valid
+func (m *CertManager) loadcache() error {
+	if m.CacheDir == "" {
+		return nil
+	}
+	var files [3][]byte
+	for i, name := range []string{cacheCertFile, cacheKeyFile, cacheCAFile} {
+		b, err := ioutil.ReadFile(filepath.Join(m.CacheDir, name))
+		if err != nil {
+			return err
+		}
+		files[i] = b
+	}
+	if err := m.set(files[0], files[1], files[2]); err != nil {
+		return err
+	}
+	if cur := m.current(); time.Now().After(cur.Leaf.NotAfter) {
+		m.l.Lock()
+		m.cert = nil
+		m.l.Unlock()
+	}
+	return nil
+}
+
+func (m *CertManager) savecache(certpem, keypem, capem []byte) error {
+	if m.CacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(m.CacheDir, 0700); err != nil {
+		return err
+	}
+	for name, b := range map[string][]byte{
+		cacheCertFile: certpem,
+		cacheKeyFile:  keypem,
+		cacheCAFile:   capem,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(m.CacheDir, name), b, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Certificate returns the current certificate (nil before Start)
+func (m *CertManager) Certificate() *tls.Certificate {
+	return m.current()
+}
+
+// GetCertificate is the tls.Config.GetCertificate callback of servers
+func (m *CertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := m.current(); cert != nil {
+		return cert, nil
+	}
+	return nil, errors.New("ztls: no certificate")
+}
+
+// GetClientCertificate is the tls.Config.GetClientCertificate callback of
+// clients
+func (m *CertManager) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return m.GetCertificate(nil)
+}
+
+// Stats returns the renewal metrics
+func (m *CertManager) Stats() CertManagerStats {
+	m.l.RLock()
+	defer m.l.RUnlock()
+	st := CertManagerStats{
+		Renewals:    m.renewals,
+		Failures:    m.failures,
+		LastRenewal: m.lastok,
+		LastError:   m.lasterr,
+		RenewAt:     m.renewAt,
+	}
+	if m.cert != nil {
+		st.NotAfter = m.cert.Leaf.NotAfter
+	}
+	return st
+}
+
+// CAPool returns the CA certificates of the server, refreshed at every
+// renewal
+func (m *CertManager) CAPool() *x509.CertPool {
+	m.l.RLock()
+	defer m.l.RUnlock()
+	return m.pool
+}
+
+// ServerTLSConfig returns a server TLS config using the current certificate
+// and verifying the client certificates (if required by authType) against
+// the current CA pool. The verifying handshakes use a copy of the config made
+// when they start, so the fields set on it later (e.g. NextProtos) apply.
+func (m *CertManager) ServerTLSConfig(authType tls.ClientAuthType) *tls.Config {
+	c := &tls.Config{
+		ClientAuth:     authType,
+		GetCertificate: m.GetCertificate,
+	}
+	if authType != tls.VerifyClientCertIfGiven && authType != tls.RequireAndVerifyClientCert {
+		return c
+	}
+	// each handshake verifies the client certificates with the CA pool of
+	// the last renewal (and sets the VerifiedChains of the connection)
+	c.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cc := c.Clone()
+		cc.ClientCAs = m.CAPool()
+		return cc, nil
+	}
+	return c
+}
+
+// ClientTLSConfig returns a client TLS config presenting the current
+// certificate and verifying serverName against the current CA pool. If
+// serverName is empty, the name of the dialed host is verified (an IP address
+// requires serverName).
+func (m *CertManager) ClientTLSConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName:           serverName,
+		GetClientCertificate: m.GetClientCertificate,
+		// the verification is done by VerifyConnection, with the CA pool
+		// of the last renewal
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("ztls: no server certificate")
+			}
+			// cs.ServerName is the SNI, which is empty for an IP address:
+			// verify the requested name, as crypto/tls does
+			name := serverName
+			if name == "" {
+				name = cs.ServerName
+			}
+			if name == "" {
+				return errors.New("ztls: no server name")
+			}
+			return m.verify(cs.PeerCertificates, name, x509.ExtKeyUsageServerAuth)
+		},
+	}
+}
+
+// verify verifies a peer certificate chain against the current CA pool
+func (m *CertManager) verify(chain []*x509.Certificate, name string, usage x509.ExtKeyUsage) error {
+	opts := x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         m.CAPool(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(opts)
+	return err
+}
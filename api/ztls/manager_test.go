@@ -0,0 +1,287 @@
+package ztls_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gabstv/ztls/api/ztls"
+	"github.com/gabstv/ztls/embedded"
+	"github.com/gabstv/ztls/embedded/store"
+	"github.com/gabstv/ztls/internal/pkix"
+)
+
+// newTestServer creates an embedded server with an ECDSA root CA
+func newTestServer(t *testing.T) *embedded.Server {
+	t.Helper()
+	key, err := pkix.NewKeyWithType(pkix.KeyECDSAP256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := pkix.NewCACertificate(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return embedded.New(context.Background(), &embedded.Config{
+		Rootkey:         key,
+		Rootcert:        cert,
+		Apikey:          "test",
+		KeyType:         string(pkix.KeyECDSAP256),
+		BackdateSeconds: -1,
+	})
+}
+
+// testIssuer issues in-process with an embedded server, failing the
+// requests while fail is positive
+type testIssuer struct {
+	s    *embedded.Server
+	l    sync.Mutex
+	fail int
+}
+
+func (is *testIssuer) NewKey() ([]byte, error) {
+	return is.s.NewKey()
+}
+
+func (is *testIssuer) IssueCertificate(ctx context.Context, input ztls.NewCertificateRequest) (*ztls.Certificate, error) {
+	is.l.Lock()
+	fail := is.fail > 0
+	is.fail--
+	is.l.Unlock()
+	if fail {
+		return nil, errors.New("unavailable")
+	}
+	chain, err := is.s.Issue(embedded.IssueRequest{
+		CSR:     input.CSR,
+		Profile: input.Profile,
+		TTL:     input.TTL,
+		Trusted: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ztls.Certificate{Certificate: string(chain)}, nil
+}
+
+func (is *testIssuer) GetCA(ctx context.Context) ([]byte, error) {
+	return is.s.RootCA(), nil
+}
+
+func TestCertManager(t *testing.T) {
+	s := newTestServer(t)
+	s.Store = store.NewMemory()
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	ctx, cf := context.WithCancel(context.Background())
+	defer cf()
+	dir := t.TempDir()
+	m := &ztls.CertManager{
+		Client: &ztls.Client{
+			Endpoint: hs.URL,
+			APIKey:   "test",
+			KeyType:  ztls.KeyECDSAP256,
+		},
+		CSR:      ztls.CommonName("svc.example.com"),
+		CacheDir: dir,
+	}
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	first := m.Certificate().Leaf
+
+	// renewals are authenticated by the current certificate
+	m.Client.APIKey = ""
+	if err := m.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	second := m.Certificate().Leaf
+	r, err := s.Store.Get(second.SerialNumber)
+	if err != nil || r.RenewedFrom == nil || r.RenewedFrom.Cmp(first.SerialNumber) != 0 {
+		t.Fatal("expected a renewal of the first certificate", err)
+	}
+
+	// a new manager starts from the cache
+	m2 := &ztls.CertManager{
+		Client:   &ztls.Client{Endpoint: hs.URL},
+		CSR:      ztls.CommonName("svc.example.com"),
+		CacheDir: dir,
+	}
+	if err := m2.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if m2.Certificate().Leaf.SerialNumber.Cmp(second.SerialNumber) != 0 {
+		t.Fatal("expected the cached certificate")
+	}
+
+	// mTLS between the two managers
+	l, err := tls.Listen("tcp", "127.0.0.1:0", m.ServerTLSConfig(tls.RequireAndVerifyClientCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan tls.ConnectionState, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			tconn := conn.(*tls.Conn)
+			if tconn.Handshake() == nil {
+				accepted <- tconn.ConnectionState()
+			}
+			conn.Close()
+		}
+	}()
+	conn, err := tls.Dial("tcp", l.Addr().String(), m2.ClientTLSConfig("svc.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if cs := <-accepted; len(cs.VerifiedChains) == 0 || cs.VerifiedChains[0][0].SerialNumber.Cmp(second.SerialNumber) != 0 {
+		t.Fatal("expected the verified chain of the client certificate")
+	}
+
+	// a client certificate of another CA is rejected
+	m3 := &ztls.CertManager{
+		Issuer: &testIssuer{s: newTestServer(t)},
+		CSR:    ztls.CommonName("svc.example.com"),
+	}
+	if err := m3.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	clientc := m2.ClientTLSConfig("svc.example.com")
+	clientc.GetClientCertificate = m3.GetClientCertificate
+	conn, err = tls.Dial("tcp", l.Addr().String(), clientc)
+	if err == nil {
+		// TLS 1.3: the client learns the rejection on the first read
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if err == nil {
+		t.Fatal("expected the client certificate to be rejected")
+	}
+}
+
+func TestCertManagerServerIP(t *testing.T) {
+	is := &testIssuer{s: newTestServer(t)}
+	ctx := context.Background()
+	for _, tc := range []struct {
+		ip string
+		ok bool
+	}{
+		{"127.0.0.1", true},
+		{"127.0.0.2", false},
+	} {
+		m := &ztls.CertManager{
+			Issuer: is,
+			CSR:    ztls.NewCSRInput{CommonName: "svc", IPs: []string{tc.ip}},
+		}
+		if err := m.Renew(ctx); err != nil {
+			t.Fatal(err)
+		}
+		l, err := tls.Listen("tcp", "127.0.0.1:0", m.ServerTLSConfig(tls.NoClientCert))
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}()
+		conn, err := tls.Dial("tcp", l.Addr().String(), m.ClientTLSConfig("127.0.0.1"))
+		if err == nil {
+			conn.Close()
+		}
+		l.Close()
+		if (err == nil) != tc.ok {
+			t.Fatal(tc.ip, "unexpected handshake result:", err)
+		}
+	}
+}
+
+func TestCertManagerRenewAt(t *testing.T) {
+	m := &ztls.CertManager{
+		Issuer:        &testIssuer{s: newTestServer(t)},
+		CSR:           ztls.CommonName("svc.example.com"),
+		TTL:           time.Hour,
+		RenewFraction: 0.5,
+	}
+	for i := 0; i < 10; i++ {
+		if err := m.Renew(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		leaf := m.Certificate().Leaf
+		lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+		// the fraction of the lifetime, up to 5% earlier
+		renewAt := m.Stats().RenewAt
+		if min, max := leaf.NotBefore.Add(lifetime*45/100), leaf.NotBefore.Add(lifetime/2); renewAt.Before(min) || renewAt.After(max) {
+			t.Fatalf("renewal at %v, expected between %v and %v", renewAt, min, max)
+		}
+	}
+}
+
+func TestCertManagerRetry(t *testing.T) {
+	is := &testIssuer{s: newTestServer(t)}
+	var (
+		l      sync.Mutex
+		events []ztls.RenewEvent
+		times  []time.Time
+	)
+	done := make(chan struct{})
+	m := &ztls.CertManager{
+		Issuer:           is,
+		CSR:              ztls.CommonName("svc.example.com"),
+		TTL:              time.Second * 2,
+		RenewFraction:    0.1,
+		MinRetryInterval: time.Millisecond * 100,
+		MaxRetryInterval: time.Millisecond * 150,
+		OnRenew: func(ev ztls.RenewEvent) {
+			l.Lock()
+			defer l.Unlock()
+			events = append(events, ev)
+			times = append(times, time.Now())
+			if len(events) == 5 {
+				close(done)
+			}
+		},
+	}
+	ctx, cf := context.WithCancel(context.Background())
+	defer cf()
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the next 3 renewals fail
+	is.l.Lock()
+	is.fail = 3
+	is.l.Unlock()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("timeout")
+	}
+	l.Lock()
+	defer l.Unlock()
+	for i, want := range []int{0, 1, 2, 3, 0} {
+		if ev := events[i]; ev.Failures != want || (want > 0) != (ev.Err != nil) {
+			t.Fatalf("event %v: %v failures (%v), expected %v", i, ev.Failures, ev.Err, want)
+		}
+	}
+	// 100ms, then 150ms (the maximum), ±20%
+	for i, want := range []time.Duration{time.Millisecond * 100, time.Millisecond * 150, time.Millisecond * 150} {
+		if d := times[i+2].Sub(times[i+1]); d < want*8/10 || d > want*12/10+time.Millisecond*100 {
+			t.Fatalf("retry %v after %v, expected %v", i, d, want)
+		}
+	}
+	cf()
+	if st := m.Stats(); st.Failures != 3 || st.Renewals < 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
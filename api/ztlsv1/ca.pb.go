@@ -0,0 +1,1044 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: ca.proto
+
+package ztlsv1
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type GetCARequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetCARequest) Reset()         { *m = GetCARequest{} }
+func (m *GetCARequest) String() string { return proto.CompactTextString(m) }
+func (*GetCARequest) ProtoMessage()    {}
+func (*GetCARequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_84034e4847c57e85, []int{0}
+}
+
+func (m *GetCARequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetCARequest.Unmarshal(m, b)
+}
+func (m *GetCARequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetCARequest.Marshal(b, m, deterministic)
+}
+func (m *GetCARequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetCARequest.Merge(m, src)
+}
+func (m *GetCARequest) XXX_Size() int {
+	return xxx_messageInfo_GetCARequest.Size(m)
+}
+func (m *GetCARequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetCARequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetCARequest proto.InternalMessageInfo
+
+type GetCAResponse struct {
+	// PEM encoded root certificate (trust anchor)
+	Root string `protobuf:"bytes,1,opt,name=root,proto3" json:"root,omitempty"`
+	// PEM encoded intermediate certificates (empty if the root signs)
+	Chain                string   `protobuf:"bytes,2,opt,name=chain,proto3" json:"chain,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetCAResponse) Reset()         { *m = GetCAResponse{} }
+func (m *GetCAResponse) String() string { return proto.CompactTextString(m) }
+func (*GetCAResponse) ProtoMessage()    {}
+func (*GetCAResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_84034e4847c57e85, []int{1}
+}
+
+func (m *GetCAResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetCAResponse.Unmarshal(m, b)
+}
+func (m *GetCAResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetCAResponse.Marshal(b, m, deterministic)
+}
+func (m *GetCAResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetCAResponse.Merge(m, src)
+}
+func (m *GetCAResponse) XXX_Size() int {
+	return xxx_messageInfo_GetCAResponse.Size(m)
+}
+func (m *GetCAResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetCAResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetCAResponse proto.InternalMessageInfo
+
+func (m *GetCAResponse) GetRoot() string {
+	if m != nil {
+		return m.Root
+	}
+	return ""
+}
+
+func (m *GetCAResponse) GetChain() string {
+	if m != nil {
+		return m.Chain
+	}
+	return ""
+}
+
+type IssueCertificateRequest struct {
+	// PEM encoded CSR
+	Csr string `protobuf:"bytes,1,opt,name=csr,proto3" json:"csr,omitempty"`
+	// certificate profile (default: the default profile)
+	Profile string `protobuf:"bytes,2,opt,name=profile,proto3" json:"profile,omitempty"`
+	// lifetime of the certificate (ttl_seconds or not_after, not both; default:
+	// the lifetime of the profile or of the server)
+	TtlSeconds           int64    `protobuf:"varint,3,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	NotAfter             int64    `protobuf:"varint,4,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *IssueCertificateRequest) Reset()         { *m = IssueCertificateRequest{} }
+func (m *IssueCertificateRequest) String() string { return proto.CompactTextString(m) }
+func (*IssueCertificateRequest) ProtoMessage()    {}
+func (*IssueCertificateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_84034e4847c57e85, []int{2}
+}
+
+func (m *IssueCertificateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_IssueCertificateRequest.Unmarshal(m, b)
+}
+func (m *IssueCertificateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_IssueCertificateRequest.Marshal(b, m, deterministic)
+}
+func (m *IssueCertificateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_IssueCertificateRequest.Merge(m, src)
+}
+func (m *IssueCertificateRequest) XXX_Size() int {
+	return xxx_messageInfo_IssueCertificateRequest.Size(m)
+}
+func (m *IssueCertificateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_IssueCertificateRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_IssueCertificateRequest proto.InternalMessageInfo
+
+func (m *IssueCertificateRequest) GetCsr() string {
+	if m != nil {
+		return m.Csr
+	}
+	return ""
+}
+
+func (m *IssueCertificateRequest) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+func (m *IssueCertificateRequest) GetTtlSeconds() int64 {
+	if m != nil {
+		return m.TtlSeconds
+	}
+	return 0
+}
+
+func (m *IssueCertificateRequest) GetNotAfter() int64 {
+	if m != nil {
+		return m.NotAfter
+	}
+	return 0
+}
+
+type RenewRequest struct {
+	// PEM encoded CSR with the identity of the renewed certificate
+	Csr        string `protobuf:"bytes,1,opt,name=csr,proto3" json:"csr,omitempty"`
+	Profile    string `protobuf:"bytes,2,opt,name=profile,proto3" json:"profile,omitempty"`
+	TtlSeconds int64  `protobuf:"varint,3,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	NotAfter   int64  `protobuf:"varint,4,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	// PEM encoded certificate being renewed and the signature of the CSR (DER)
+	// by its key; not required over mTLS
+	Certificate          string   `protobuf:"bytes,5,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	Signature            []byte   `protobuf:"bytes,6,opt,name=signature,proto3" json:"signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RenewRequest) Reset()         { *m = RenewRequest{} }
+func (m *RenewRequest) String() string { return proto.CompactTextString(m) }
+func (*RenewRequest) ProtoMessage()    {}
+func (*RenewRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_84034e4847c57e85, []int{3}
+}
+
+func (m *RenewRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RenewRequest.Unmarshal(m, b)
+}
+func (m *RenewRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RenewRequest.Marshal(b, m, deterministic)
+}
+func (m *RenewRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RenewRequest.Merge(m, src)
+}
+func (m *RenewRequest) XXX_Size() int {
+	return xxx_messageInfo_RenewRequest.Size(m)
+}
+func (m *RenewRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RenewRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RenewRequest proto.InternalMessageInfo
+
+func (m *RenewRequest) GetCsr() string {
+	if m != nil {
+		return m.Csr
+	}
+	return ""
+}
+
+func (m *RenewRequest) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+func (m *RenewRequest) GetTtlSeconds() int64 {
+	if m != nil {
+		return m.TtlSeconds
+	}
+	return 0
+}
+
+func (m *RenewRequest) GetNotAfter() int64 {
+	if m != nil {
+		return m.NotAfter
+	}
+	return 0
+}
+
+func (m *RenewRequest) GetCertificate() string {
+	if m != nil {
+		return m.Certificate
+	}
+	return ""
+}
+
+func (m *RenewRequest) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+// Certificate is an issued certificate and its metadata
+type Certificate struct {
+	// PEM encoded certificate and intermediate certificates
+	Certificate string `protobuf:"bytes,1,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	Chain       string `protobuf:"bytes,2,opt,name=chain,proto3" json:"chain,omitempty"`
+	// decimal serial number
+	Serial string `protobuf:"bytes,3,opt,name=serial,proto3" json:"serial,omitempty"`
+	// validity (unix seconds)
+	NotBefore int64 `protobuf:"varint,4,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter  int64 `protobuf:"varint,5,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	// SHA-256 of the DER, hex
+	Fingerprint          string   `protobuf:"bytes,6,opt,name=fingerprint,proto3" json:"fingerprint,omitempty"`
+	CommonName           string   `protobuf:"bytes,7,opt,name=common_name,json=commonName,proto3" json:"common_name,omitempty"`
+	DnsNames             []string `protobuf:"bytes,8,rep,name=dns_names,json=dnsNames,proto3" json:"dns_names,omitempty"`
+	IpAddresses          []string `protobuf:"bytes,9,rep,name=ip_addresses,json=ipAddresses,proto3" json:"ip_addresses,omitempty"`
+	EmailAddresses       []string `protobuf:"bytes,10,rep,name=email_addresses,json=emailAddresses,proto3" json:"email_addresses,omitempty"`
+	Uris                 []string `protobuf:"bytes,11,rep,name=uris,proto3" json:"uris,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Certificate) Reset()         { *m = Certificate{} }
+func (m *Certificate) String() string { return proto.CompactTextString(m) }
+func (*Certificate) ProtoMessage()    {}
+func (*Certificate) Descriptor() ([]byte, []int) {
+	return fileDescriptor_84034e4847c57e85, []int{4}
+}
+
+func (m *Certificate) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Certificate.Unmarshal(m, b)
+}
+func (m *Certificate) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Certificate.Marshal(b, m, deterministic)
+}
+func (m *Certificate) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Certificate.Merge(m, src)
+}
+func (m *Certificate) XXX_Size() int {
+	return xxx_messageInfo_Certificate.Size(m)
+}
+func (m *Certificate) XXX_DiscardUnknown() {
+	xxx_messageInfo_Certificate.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Certificate proto.InternalMessageInfo
+
+func (m *Certificate) GetCertificate() string {
+	if m != nil {
+		return m.Certificate
+	}
+	return ""
+}
+
+func (m *Certificate) GetChain() string {
+	if m != nil {
+		return m.Chain
+	}
+	return ""
+}
+
+func (m *Certificate) GetSerial() string {
+	if m != nil {
+		return m.Serial
+	}
+	return ""
+}
+
+func (m *Certificate) GetNotBefore() int64 {
+	if m != nil {
+		return m.NotBefore
+	}
+	return 0
+}
+
+func (m *Certificate) GetNotAfter() int64 {
+	if m != nil {
+		return m.NotAfter
+	}
+	return 0
+}
+
+func (m *Certificate) GetFingerprint() string {
+	if m != nil {
+		return m.Fingerprint
+	}
+	return ""
+}
+
+func (m *Certificate) GetCommonName() string {
+	if m != nil {
+		return m.CommonName
+	}
+	return ""
+}
+
+func (m *Certificate) GetDnsNames() []string {
+	if m != nil {
+		return m.DnsNames
+	}
+	return nil
+}
+
+func (m *Certificate) GetIpAddresses() []string {
+	if m != nil {
+		return m.IpAddresses
+	}
+	return nil
+}
+
+func (m *Certificate) GetEmailAddresses() []string {
+	if m != nil {
+		return m.EmailAddresses
+	}
+	return nil
+}
+
+func (m *Certificate) GetUris() []string {
+	if m != nil {
+		return m.Uris
+	}
+	return nil
+}
+
+type RevokeRequest struct {
+	// decimal serial number
+	Serial string `protobuf:"bytes,1,opt,name=serial,proto3" json:"serial,omitempty"`
+	// reason name, e.g. keyCompromise (default: unspecified)
+	Reason               string   `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RevokeRequest) Reset()         { *m = RevokeRequest{} }
+func (m *RevokeRequest) String() string { return proto.CompactTextString(m) }
+func (*RevokeRequest) ProtoMessage()    {}
+func (*RevokeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_84034e4847c57e85, []int{5}
+}
+
+func (m *RevokeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RevokeRequest.Unmarshal(m, b)
+}
+func (m *RevokeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RevokeRequest.Marshal(b, m, deterministic)
+}
+func (m *RevokeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RevokeRequest.Merge(m, src)
+}
+func (m *RevokeRequest) XXX_Size() int {
+	return xxx_messageInfo_RevokeRequest.Size(m)
+}
+func (m *RevokeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RevokeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RevokeRequest proto.InternalMessageInfo
+
+func (m *RevokeRequest) GetSerial() string {
+	if m != nil {
+		return m.Serial
+	}
+	return ""
+}
+
+func (m *RevokeRequest) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+type RevokeResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RevokeResponse) Reset()         { *m = RevokeResponse{} }
+func (m *RevokeResponse) String() string { return proto.CompactTextString(m) }
+func (*RevokeResponse) ProtoMessage()    {}
+func (*RevokeResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_84034e4847c57e85, []int{6}
+}
+
+func (m *RevokeResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RevokeResponse.Unmarshal(m, b)
+}
+func (m *RevokeResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RevokeResponse.Marshal(b, m, deterministic)
+}
+func (m *RevokeResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RevokeResponse.Merge(m, src)
+}
+func (m *RevokeResponse) XXX_Size() int {
+	return xxx_messageInfo_RevokeResponse.Size(m)
+}
+func (m *RevokeResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_RevokeResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RevokeResponse proto.InternalMessageInfo
+
+type ListCertificatesRequest struct {
+	// common name, DNS name or IP address of the certificates
+	Host       string `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"`
+	CommonName string `protobuf:"bytes,2,opt,name=common_name,json=commonName,proto3" json:"common_name,omitempty"`
+	ApiKeyId   string `protobuf:"bytes,3,opt,name=api_key_id,json=apiKeyId,proto3" json:"api_key_id,omitempty"`
+	// issuance and validity times (unix seconds; 0 = any)
+	IssuedAfter  int64 `protobuf:"varint,4,opt,name=issued_after,json=issuedAfter,proto3" json:"issued_after,omitempty"`
+	IssuedBefore int64 `protobuf:"varint,5,opt,name=issued_before,json=issuedBefore,proto3" json:"issued_before,omitempty"`
+	ValidAt      int64 `protobuf:"varint,6,opt,name=valid_at,json=validAt,proto3" json:"valid_at,omitempty"`
+	// maximum number of records (the most recent ones; 0 = all)
+	Limit                int32    `protobuf:"varint,7,opt,name=limit,proto3" json:"limit,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListCertificatesRequest) Reset()         { *m = ListCertificatesRequest{} }
+func (m *ListCertificatesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListCertificatesRequest) ProtoMessage()    {}
+func (*ListCertificatesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_84034e4847c57e85, []int{7}
+}
+
+func (m *ListCertificatesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListCertificatesRequest.Unmarshal(m, b)
+}
+func (m *ListCertificatesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListCertificatesRequest.Marshal(b, m, deterministic)
+}
+func (m *ListCertificatesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListCertificatesRequest.Merge(m, src)
+}
+func (m *ListCertificatesRequest) XXX_Size() int {
+	return xxx_messageInfo_ListCertificatesRequest.Size(m)
+}
+func (m *ListCertificatesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListCertificatesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListCertificatesRequest proto.InternalMessageInfo
+
+func (m *ListCertificatesRequest) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+func (m *ListCertificatesRequest) GetCommonName() string {
+	if m != nil {
+		return m.CommonName
+	}
+	return ""
+}
+
+func (m *ListCertificatesRequest) GetApiKeyId() string {
+	if m != nil {
+		return m.ApiKeyId
+	}
+	return ""
+}
+
+func (m *ListCertificatesRequest) GetIssuedAfter() int64 {
+	if m != nil {
+		return m.IssuedAfter
+	}
+	return 0
+}
+
+func (m *ListCertificatesRequest) GetIssuedBefore() int64 {
+	if m != nil {
+		return m.IssuedBefore
+	}
+	return 0
+}
+
+func (m *ListCertificatesRequest) GetValidAt() int64 {
+	if m != nil {
+		return m.ValidAt
+	}
+	return 0
+}
+
+func (m *ListCertificatesRequest) GetLimit() int32 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+type ListCertificatesResponse struct {
+	Certificates         []*CertificateRecord `protobuf:"bytes,1,rep,name=certificates,proto3" json:"certificates,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *ListCertificatesResponse) Reset()         { *m = ListCertificatesResponse{} }
+func (m *ListCertificatesResponse) String() string { return proto.CompactTextString(m) }
+func (*ListCertificatesResponse) ProtoMessage()    {}
+func (*ListCertificatesResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_84034e4847c57e85, []int{8}
+}
+
+func (m *ListCertificatesResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListCertificatesResponse.Unmarshal(m, b)
+}
+func (m *ListCertificatesResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListCertificatesResponse.Marshal(b, m, deterministic)
+}
+func (m *ListCertificatesResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListCertificatesResponse.Merge(m, src)
+}
+func (m *ListCertificatesResponse) XXX_Size() int {
+	return xxx_messageInfo_ListCertificatesResponse.Size(m)
+}
+func (m *ListCertificatesResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListCertificatesResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListCertificatesResponse proto.InternalMessageInfo
+
+func (m *ListCertificatesResponse) GetCertificates() []*CertificateRecord {
+	if m != nil {
+		return m.Certificates
+	}
+	return nil
+}
+
+// CertificateRecord is a certificate recorded by the server
+type CertificateRecord struct {
+	Serial         string   `protobuf:"bytes,1,opt,name=serial,proto3" json:"serial,omitempty"`
+	CommonName     string   `protobuf:"bytes,2,opt,name=common_name,json=commonName,proto3" json:"common_name,omitempty"`
+	DnsNames       []string `protobuf:"bytes,3,rep,name=dns_names,json=dnsNames,proto3" json:"dns_names,omitempty"`
+	IpAddresses    []string `protobuf:"bytes,4,rep,name=ip_addresses,json=ipAddresses,proto3" json:"ip_addresses,omitempty"`
+	EmailAddresses []string `protobuf:"bytes,5,rep,name=email_addresses,json=emailAddresses,proto3" json:"email_addresses,omitempty"`
+	Uris           []string `protobuf:"bytes,6,rep,name=uris,proto3" json:"uris,omitempty"`
+	// address of the client that requested the certificate
+	Requester string `protobuf:"bytes,7,opt,name=requester,proto3" json:"requester,omitempty"`
+	// API key of the request (empty if anonymous)
+	ApiKeyId string `protobuf:"bytes,8,opt,name=api_key_id,json=apiKeyId,proto3" json:"api_key_id,omitempty"`
+	// unix seconds
+	IssuedAt  int64 `protobuf:"varint,9,opt,name=issued_at,json=issuedAt,proto3" json:"issued_at,omitempty"`
+	NotBefore int64 `protobuf:"varint,10,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter  int64 `protobuf:"varint,11,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	// PEM encoded
+	Certificate string `protobuf:"bytes,12,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	// serial number of the renewed certificate (empty if not a renewal)
+	RenewedFrom string `protobuf:"bytes,13,opt,name=renewed_from,json=renewedFrom,proto3" json:"renewed_from,omitempty"`
+	// certificate profile of the request (empty in the older records)
+	Profile              string   `protobuf:"bytes,14,opt,name=profile,proto3" json:"profile,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CertificateRecord) Reset()         { *m = CertificateRecord{} }
+func (m *CertificateRecord) String() string { return proto.CompactTextString(m) }
+func (*CertificateRecord) ProtoMessage()    {}
+func (*CertificateRecord) Descriptor() ([]byte, []int) {
+	return fileDescriptor_84034e4847c57e85, []int{9}
+}
+
+func (m *CertificateRecord) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CertificateRecord.Unmarshal(m, b)
+}
+func (m *CertificateRecord) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CertificateRecord.Marshal(b, m, deterministic)
+}
+func (m *CertificateRecord) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CertificateRecord.Merge(m, src)
+}
+func (m *CertificateRecord) XXX_Size() int {
+	return xxx_messageInfo_CertificateRecord.Size(m)
+}
+func (m *CertificateRecord) XXX_DiscardUnknown() {
+	xxx_messageInfo_CertificateRecord.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CertificateRecord proto.InternalMessageInfo
+
+func (m *CertificateRecord) GetSerial() string {
+	if m != nil {
+		return m.Serial
+	}
+	return ""
+}
+
+func (m *CertificateRecord) GetCommonName() string {
+	if m != nil {
+		return m.CommonName
+	}
+	return ""
+}
+
+func (m *CertificateRecord) GetDnsNames() []string {
+	if m != nil {
+		return m.DnsNames
+	}
+	return nil
+}
+
+func (m *CertificateRecord) GetIpAddresses() []string {
+	if m != nil {
+		return m.IpAddresses
+	}
+	return nil
+}
+
+func (m *CertificateRecord) GetEmailAddresses() []string {
+	if m != nil {
+		return m.EmailAddresses
+	}
+	return nil
+}
+
+func (m *CertificateRecord) GetUris() []string {
+	if m != nil {
+		return m.Uris
+	}
+	return nil
+}
+
+func (m *CertificateRecord) GetRequester() string {
+	if m != nil {
+		return m.Requester
+	}
+	return ""
+}
+
+func (m *CertificateRecord) GetApiKeyId() string {
+	if m != nil {
+		return m.ApiKeyId
+	}
+	return ""
+}
+
+func (m *CertificateRecord) GetIssuedAt() int64 {
+	if m != nil {
+		return m.IssuedAt
+	}
+	return 0
+}
+
+func (m *CertificateRecord) GetNotBefore() int64 {
+	if m != nil {
+		return m.NotBefore
+	}
+	return 0
+}
+
+func (m *CertificateRecord) GetNotAfter() int64 {
+	if m != nil {
+		return m.NotAfter
+	}
+	return 0
+}
+
+func (m *CertificateRecord) GetCertificate() string {
+	if m != nil {
+		return m.Certificate
+	}
+	return ""
+}
+
+func (m *CertificateRecord) GetRenewedFrom() string {
+	if m != nil {
+		return m.RenewedFrom
+	}
+	return ""
+}
+
+func (m *CertificateRecord) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+func init() {
+	proto.RegisterType((*GetCARequest)(nil), "ztls.v1.GetCARequest")
+	proto.RegisterType((*GetCAResponse)(nil), "ztls.v1.GetCAResponse")
+	proto.RegisterType((*IssueCertificateRequest)(nil), "ztls.v1.IssueCertificateRequest")
+	proto.RegisterType((*RenewRequest)(nil), "ztls.v1.RenewRequest")
+	proto.RegisterType((*Certificate)(nil), "ztls.v1.Certificate")
+	proto.RegisterType((*RevokeRequest)(nil), "ztls.v1.RevokeRequest")
+	proto.RegisterType((*RevokeResponse)(nil), "ztls.v1.RevokeResponse")
+	proto.RegisterType((*ListCertificatesRequest)(nil), "ztls.v1.ListCertificatesRequest")
+	proto.RegisterType((*ListCertificatesResponse)(nil), "ztls.v1.ListCertificatesResponse")
+	proto.RegisterType((*CertificateRecord)(nil), "ztls.v1.CertificateRecord")
+}
+
+func init() { proto.RegisterFile("ca.proto", fileDescriptor_84034e4847c57e85) }
+
+var fileDescriptor_84034e4847c57e85 = []byte{
+	// 755 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x55, 0x4f, 0x6f, 0xd3, 0x4a,
+	0x10, 0x97, 0xe3, 0xfc, 0xb1, 0xc7, 0x49, 0x5e, 0xde, 0xaa, 0x2f, 0xf5, 0x4b, 0xfb, 0xf4, 0x52,
+	0x73, 0x20, 0xa7, 0x48, 0x2d, 0x08, 0xa9, 0x17, 0x50, 0x5a, 0x09, 0x54, 0x40, 0x1c, 0xcc, 0x01,
+	0xa9, 0x17, 0x6b, 0x6b, 0x6f, 0xda, 0x55, 0x6d, 0xaf, 0xd9, 0xdd, 0x04, 0x95, 0x2b, 0x5f, 0x8a,
+	0x0b, 0x1f, 0x84, 0x3b, 0x7c, 0x0f, 0xe4, 0xf5, 0x26, 0x71, 0x9c, 0x26, 0xe5, 0xc4, 0x6d, 0xe7,
+	0xb7, 0xe3, 0xf1, 0xce, 0xfc, 0x7e, 0x33, 0x03, 0x56, 0x88, 0xc7, 0x19, 0x67, 0x92, 0xa1, 0xd6,
+	0x67, 0x19, 0x8b, 0xf1, 0xfc, 0xd8, 0xeb, 0x42, 0xfb, 0x15, 0x91, 0xe7, 0x13, 0x9f, 0x7c, 0x9c,
+	0x11, 0x21, 0xbd, 0x53, 0xe8, 0x68, 0x5b, 0x64, 0x2c, 0x15, 0x04, 0x21, 0xa8, 0x73, 0xc6, 0xa4,
+	0x6b, 0x0c, 0x8d, 0x91, 0xed, 0xab, 0x33, 0xda, 0x83, 0x46, 0x78, 0x83, 0x69, 0xea, 0xd6, 0x14,
+	0x58, 0x18, 0xde, 0x17, 0x03, 0xf6, 0x2f, 0x84, 0x98, 0x91, 0x73, 0xc2, 0x25, 0x9d, 0xd2, 0x10,
+	0x4b, 0xa2, 0xc3, 0xa2, 0x1e, 0x98, 0xa1, 0xe0, 0x3a, 0x48, 0x7e, 0x44, 0x2e, 0xb4, 0x32, 0xce,
+	0xa6, 0x34, 0x26, 0x3a, 0xca, 0xc2, 0x44, 0xff, 0x83, 0x23, 0x65, 0x1c, 0x08, 0x12, 0xb2, 0x34,
+	0x12, 0xae, 0x39, 0x34, 0x46, 0xa6, 0x0f, 0x52, 0xc6, 0xef, 0x0b, 0x04, 0x1d, 0x80, 0x9d, 0x32,
+	0x19, 0xe0, 0xa9, 0x24, 0xdc, 0xad, 0xab, 0x6b, 0x2b, 0x65, 0x72, 0x92, 0xdb, 0xde, 0x57, 0x03,
+	0xda, 0x3e, 0x49, 0xc9, 0xa7, 0x3f, 0xfe, 0x6b, 0x34, 0x04, 0x27, 0x5c, 0xa5, 0xee, 0x36, 0x54,
+	0xec, 0x32, 0x84, 0x0e, 0xc1, 0x16, 0xf4, 0x3a, 0xc5, 0x72, 0xc6, 0x89, 0xdb, 0x1c, 0x1a, 0xa3,
+	0xb6, 0xbf, 0x02, 0xbc, 0xef, 0x35, 0x70, 0x4a, 0xb5, 0xab, 0xc6, 0x33, 0x36, 0xe3, 0xdd, 0x4b,
+	0x04, 0xea, 0x43, 0x53, 0x10, 0x4e, 0x71, 0xac, 0x12, 0xb0, 0x7d, 0x6d, 0xa1, 0xff, 0x00, 0xf2,
+	0xc7, 0x5f, 0x91, 0x29, 0xe3, 0x44, 0xbf, 0x3e, 0x4f, 0xe7, 0x4c, 0x01, 0xeb, 0xb9, 0x35, 0x36,
+	0x73, 0x9b, 0xd2, 0xf4, 0x9a, 0xf0, 0x8c, 0xd3, 0x54, 0xaa, 0xb7, 0xdb, 0x7e, 0x19, 0xca, 0x6b,
+	0x17, 0xb2, 0x24, 0x61, 0x69, 0x90, 0xe2, 0x84, 0xb8, 0x2d, 0xe5, 0x01, 0x05, 0xf4, 0x0e, 0x27,
+	0x2a, 0x7e, 0x94, 0x0a, 0x75, 0x2b, 0x5c, 0x6b, 0x68, 0x8e, 0x6c, 0xdf, 0x8a, 0x52, 0x91, 0xdf,
+	0x09, 0x74, 0x04, 0x6d, 0x9a, 0x05, 0x38, 0x8a, 0x38, 0x11, 0x82, 0x08, 0xd7, 0x56, 0xf7, 0x0e,
+	0xcd, 0x26, 0x0b, 0x08, 0x3d, 0x86, 0xbf, 0x48, 0x82, 0x69, 0x5c, 0xf2, 0x02, 0xe5, 0xd5, 0x55,
+	0xf0, 0xca, 0x11, 0x41, 0x7d, 0xc6, 0xa9, 0x70, 0x1d, 0x75, 0xab, 0xce, 0xde, 0x0b, 0xe8, 0xf8,
+	0x64, 0xce, 0x6e, 0x97, 0x8a, 0x5c, 0x15, 0xc9, 0x58, 0x2b, 0x52, 0x1f, 0x9a, 0x9c, 0x60, 0xc1,
+	0x16, 0x35, 0xd5, 0x96, 0xd7, 0x83, 0xee, 0x22, 0x40, 0xd1, 0x19, 0xde, 0x4f, 0x03, 0xf6, 0xdf,
+	0x52, 0x21, 0x4b, 0x94, 0x89, 0x45, 0x74, 0x04, 0xf5, 0x1b, 0x26, 0x96, 0x5d, 0x93, 0x9f, 0xab,
+	0x05, 0xaa, 0x6d, 0x14, 0xe8, 0x10, 0x00, 0x67, 0x34, 0xb8, 0x25, 0x77, 0x01, 0x8d, 0x34, 0x77,
+	0x16, 0xce, 0xe8, 0x1b, 0x72, 0x77, 0x11, 0xa9, 0x0a, 0xe5, 0xdd, 0x15, 0xad, 0xa9, 0xcf, 0x29,
+	0xb0, 0x82, 0xa4, 0x47, 0xd0, 0xd1, 0x2e, 0x9a, 0xe3, 0x82, 0x45, 0xfd, 0x9d, 0xa6, 0xf9, 0x5f,
+	0xb0, 0xe6, 0x38, 0xa6, 0x51, 0x80, 0x0b, 0x1a, 0x4d, 0xbf, 0xa5, 0xec, 0x89, 0xea, 0xeb, 0x98,
+	0x26, 0x54, 0x2a, 0xf2, 0x1a, 0x7e, 0x61, 0x78, 0x97, 0xe0, 0x6e, 0xa6, 0xa9, 0xa7, 0xc3, 0x73,
+	0x68, 0x97, 0xf4, 0x28, 0x5c, 0x63, 0x68, 0x8e, 0x9c, 0x93, 0xc1, 0x58, 0x8f, 0x97, 0xf1, 0xda,
+	0x28, 0x08, 0x19, 0x8f, 0xfc, 0x35, 0x7f, 0xef, 0x9b, 0x09, 0x7f, 0x6f, 0xf8, 0x6c, 0xe5, 0xe6,
+	0xc1, 0x0a, 0xae, 0x49, 0xcc, 0x7c, 0x40, 0x62, 0xf5, 0xdf, 0x92, 0x58, 0x63, 0xa7, 0xc4, 0x9a,
+	0x2b, 0x89, 0xe5, 0xcd, 0xcd, 0x0b, 0xfa, 0x09, 0xd7, 0xf2, 0x5f, 0x01, 0x15, 0x72, 0xad, 0x0a,
+	0xb9, 0x07, 0x60, 0x2f, 0xc8, 0x95, 0xae, 0x5d, 0xf4, 0x9e, 0x66, 0x56, 0x56, 0xfa, 0x16, 0x76,
+	0xf6, 0xad, 0xb3, 0x7b, 0x26, 0xb5, 0x37, 0x67, 0xc8, 0x11, 0xb4, 0x79, 0x3e, 0x2f, 0x49, 0x14,
+	0x4c, 0x39, 0x4b, 0xdc, 0x4e, 0xe1, 0xa2, 0xb1, 0x97, 0x9c, 0x25, 0xe5, 0x81, 0xd9, 0x5d, 0x1b,
+	0x98, 0x27, 0x3f, 0x6a, 0xb0, 0x57, 0xe2, 0x6f, 0x32, 0x93, 0x37, 0x8c, 0x53, 0x79, 0x87, 0x9e,
+	0x41, 0x43, 0xed, 0x11, 0xf4, 0xcf, 0x52, 0x0b, 0xe5, 0x3d, 0x33, 0xe8, 0x57, 0x61, 0x2d, 0xa8,
+	0xd7, 0xd0, 0xab, 0xee, 0x10, 0x34, 0x5c, 0xfa, 0x6e, 0x59, 0x2f, 0x83, 0xbd, 0xfb, 0x04, 0x87,
+	0x9e, 0x42, 0x43, 0x6d, 0x82, 0xd2, 0x1b, 0xca, 0x9b, 0x61, 0xcb, 0x57, 0xa7, 0xd0, 0x2c, 0x1a,
+	0x1d, 0xf5, 0x4b, 0x9f, 0x95, 0x46, 0xc7, 0x60, 0x7f, 0x03, 0xd7, 0x8f, 0xff, 0x00, 0xbd, 0x6a,
+	0xa7, 0x94, 0x1e, 0xbf, 0x65, 0x56, 0x0c, 0x8e, 0x76, 0x78, 0x14, 0x81, 0xcf, 0xac, 0xcb, 0x66,
+	0xee, 0x33, 0x3f, 0xbe, 0x6a, 0xaa, 0xfd, 0xfd, 0xe4, 0xd7, 0x00, 0x86, 0xb2, 0xa1, 0x4d, 0xcb,
+	0x07, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// CertificateAuthorityClient is the client API for CertificateAuthority service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type CertificateAuthorityClient interface {
+	// GetCA returns the CA certificates (anonymous)
+	GetCA(ctx context.Context, in *GetCARequest, opts ...grpc.CallOption) (*GetCAResponse, error)
+	// IssueCertificate signs a CSR, authorized by the API key or the
+	// bootstrap token (anonymous requests have a lower rate limit)
+	IssueCertificate(ctx context.Context, in *IssueCertificateRequest, opts ...grpc.CallOption) (*Certificate, error)
+	// Renew renews a certificate, authenticated by the TLS client certificate
+	// or by the certificate and signature fields
+	Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*Certificate, error)
+	// Revoke revokes a certificate (API key; named keys can only revoke their
+	// certificates)
+	Revoke(ctx context.Context, in *RevokeRequest, opts ...grpc.CallOption) (*RevokeResponse, error)
+	// ListCertificates lists the issued certificates (API key)
+	ListCertificates(ctx context.Context, in *ListCertificatesRequest, opts ...grpc.CallOption) (*ListCertificatesResponse, error)
+}
+
+type certificateAuthorityClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewCertificateAuthorityClient(cc *grpc.ClientConn) CertificateAuthorityClient {
+	return &certificateAuthorityClient{cc}
+}
+
+func (c *certificateAuthorityClient) GetCA(ctx context.Context, in *GetCARequest, opts ...grpc.CallOption) (*GetCAResponse, error) {
+	out := new(GetCAResponse)
+	err := c.cc.Invoke(ctx, "/ztls.v1.CertificateAuthority/GetCA", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateAuthorityClient) IssueCertificate(ctx context.Context, in *IssueCertificateRequest, opts ...grpc.CallOption) (*Certificate, error) {
+	out := new(Certificate)
+	err := c.cc.Invoke(ctx, "/ztls.v1.CertificateAuthority/IssueCertificate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateAuthorityClient) Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*Certificate, error) {
+	out := new(Certificate)
+	err := c.cc.Invoke(ctx, "/ztls.v1.CertificateAuthority/Renew", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateAuthorityClient) Revoke(ctx context.Context, in *RevokeRequest, opts ...grpc.CallOption) (*RevokeResponse, error) {
+	out := new(RevokeResponse)
+	err := c.cc.Invoke(ctx, "/ztls.v1.CertificateAuthority/Revoke", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *certificateAuthorityClient) ListCertificates(ctx context.Context, in *ListCertificatesRequest, opts ...grpc.CallOption) (*ListCertificatesResponse, error) {
+	out := new(ListCertificatesResponse)
+	err := c.cc.Invoke(ctx, "/ztls.v1.CertificateAuthority/ListCertificates", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CertificateAuthorityServer is the server API for CertificateAuthority service.
+type CertificateAuthorityServer interface {
+	// GetCA returns the CA certificates (anonymous)
+	GetCA(context.Context, *GetCARequest) (*GetCAResponse, error)
+	// IssueCertificate signs a CSR, authorized by the API key or the
+	// bootstrap token (anonymous requests have a lower rate limit)
+	IssueCertificate(context.Context, *IssueCertificateRequest) (*Certificate, error)
+	// Renew renews a certificate, authenticated by the TLS client certificate
+	// or by the certificate and signature fields
+	Renew(context.Context, *RenewRequest) (*Certificate, error)
+	// Revoke revokes a certificate (API key; named keys can only revoke their
+	// certificates)
+	Revoke(context.Context, *RevokeRequest) (*RevokeResponse, error)
+	// ListCertificates lists the issued certificates (API key)
+	ListCertificates(context.Context, *ListCertificatesRequest) (*ListCertificatesResponse, error)
+}
+
+// UnimplementedCertificateAuthorityServer can be embedded to have forward compatible implementations.
+type UnimplementedCertificateAuthorityServer struct {
+}
+
+func (*UnimplementedCertificateAuthorityServer) GetCA(ctx context.Context, req *GetCARequest) (*GetCAResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCA not implemented")
+}
+func (*UnimplementedCertificateAuthorityServer) IssueCertificate(ctx context.Context, req *IssueCertificateRequest) (*Certificate, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method IssueCertificate not implemented")
+}
+func (*UnimplementedCertificateAuthorityServer) Renew(ctx context.Context, req *RenewRequest) (*Certificate, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Renew not implemented")
+}
+func (*UnimplementedCertificateAuthorityServer) Revoke(ctx context.Context, req *RevokeRequest) (*RevokeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Revoke not implemented")
+}
+func (*UnimplementedCertificateAuthorityServer) ListCertificates(ctx context.Context, req *ListCertificatesRequest) (*ListCertificatesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCertificates not implemented")
+}
+
+func RegisterCertificateAuthorityServer(s *grpc.Server, srv CertificateAuthorityServer) {
+	s.RegisterService(&_CertificateAuthority_serviceDesc, srv)
+}
+
+func _CertificateAuthority_GetCA_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCARequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateAuthorityServer).GetCA(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ztls.v1.CertificateAuthority/GetCA",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateAuthorityServer).GetCA(ctx, req.(*GetCARequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertificateAuthority_IssueCertificate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(IssueCertificateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateAuthorityServer).IssueCertificate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ztls.v1.CertificateAuthority/IssueCertificate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateAuthorityServer).IssueCertificate(ctx, req.(*IssueCertificateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertificateAuthority_Renew_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateAuthorityServer).Renew(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ztls.v1.CertificateAuthority/Renew",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateAuthorityServer).Renew(ctx, req.(*RenewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertificateAuthority_Revoke_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateAuthorityServer).Revoke(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ztls.v1.CertificateAuthority/Revoke",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateAuthorityServer).Revoke(ctx, req.(*RevokeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CertificateAuthority_ListCertificates_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCertificatesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CertificateAuthorityServer).ListCertificates(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ztls.v1.CertificateAuthority/ListCertificates",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CertificateAuthorityServer).ListCertificates(ctx, req.(*ListCertificatesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _CertificateAuthority_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "ztls.v1.CertificateAuthority",
+	HandlerType: (*CertificateAuthorityServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetCA",
+			Handler:    _CertificateAuthority_GetCA_Handler,
+		},
+		{
+			MethodName: "IssueCertificate",
+			Handler:    _CertificateAuthority_IssueCertificate_Handler,
+		},
+		{
+			MethodName: "Renew",
+			Handler:    _CertificateAuthority_Renew_Handler,
+		},
+		{
+			MethodName: "Revoke",
+			Handler:    _CertificateAuthority_Revoke_Handler,
+		},
+		{
+			MethodName: "ListCertificates",
+			Handler:    _CertificateAuthority_ListCertificates_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "ca.proto",
+}
@@ -0,0 +1,33 @@
+// Package ztlsv1 is the gRPC API of the ztls server (ztls.v1.CertificateAuthority):
+//
+//	conn, err := grpc.Dial("ca.example.com:9443", grpc.WithTransportCredentials(creds))
+//	ca := ztlsv1.NewCertificateAuthorityClient(conn)
+//	cert, err := ca.IssueCertificate(ztlsv1.WithAPIKey(ctx, secret), &ztlsv1.IssueCertificateRequest{
+//		Csr: string(csrpem),
+//	})
+package ztlsv1
+
+//go:generate protoc -I. --go_out=plugins=grpc:. ca.proto
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// metadata keys of the credentials of the requests
+const (
+	APIKeyMetadata = "x-api-key"
+	TokenMetadata  = "x-bootstrap-token"
+)
+
+// WithAPIKey returns a context that sends the API key with the requests
+func WithAPIKey(ctx context.Context, secret string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, APIKeyMetadata, secret)
+}
+
+// WithToken returns a context that sends a bootstrap token with the
+// IssueCertificate requests
+func WithToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, TokenMetadata, token)
+}
@@ -20,6 +20,11 @@ func main() {
 		cli.StringFlag{
 			Name: "apikey",
 		},
+		cli.StringFlag{
+			Name:   "token",
+			EnvVar: "ZTLS_TOKEN",
+			Usage:  "bootstrap token (instead of the API key); the common name must be the one of the token",
+		},
 		cli.StringFlag{
 			Name:  "key-out, key",
 			Value: "key.pem",
@@ -31,6 +36,23 @@ func main() {
 		cli.StringFlag{
 			Name: "common-name",
 		},
+		cli.StringFlag{
+			Name:  "spiffe-id",
+			Usage: "SPIFFE ID of the certificate (URI SAN), e.g. spiffe://example.org/ns/prod/sa/api",
+		},
+		cli.StringFlag{
+			Name:  "key-type",
+			Usage: "rsa, ecdsa-p256, ecdsa-p384 or ed25519",
+			Value: "rsa",
+		},
+		cli.StringFlag{
+			Name:  "profile",
+			Usage: "certificate profile: server, client, peer, code-signing (default: the server default profile)",
+		},
+		cli.DurationFlag{
+			Name:  "ttl",
+			Usage: "certificate lifetime, e.g. 8h (default: the server default lifetime)",
+		},
 	}
 
 	app.Action = run
@@ -39,9 +61,15 @@ func main() {
 }
 
 func run(c *cli.Context) error {
+	kt, err := ztls.ParseKeyType(c.String("key-type"))
+	if err != nil {
+		return err
+	}
 	cl := &ztls.Client{
 		Endpoint: c.String("endpoint"),
 		APIKey:   c.String("apikey"),
+		Token:    c.String("token"),
+		KeyType:  kt,
 	}
 
 	keybytes, err := cl.NewKey()
@@ -61,15 +89,23 @@ func run(c *cli.Context) error {
 		}
 	}
 
-	csrb, err := cl.NewCSR(ztls.NewCSRInput{
+	csrin := ztls.NewCSRInput{
 		CommonName: cname,
-	}, keybytes)
+	}
+	if v := c.String("spiffe-id"); v != "" {
+		csrin.URIs = []string{v}
+	}
+	csrb, err := cl.NewCSR(csrin, keybytes)
 
 	if err != nil {
 		return err
 	}
 
-	certb, err := cl.NewCertificate(context.Background(), csrb)
+	certb, err := cl.NewCertificateWithRequest(context.Background(), ztls.NewCertificateRequest{
+		CSR:     csrb,
+		Profile: c.String("profile"),
+		TTL:     c.Duration("ttl"),
+	})
 
 	if err != nil {
 		return err
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gabstv/ztls/embedded"
+	"github.com/urfave/cli"
+)
+
+// apikeycommand is "config apikey": the named API keys of a config file
+func apikeycommand() cli.Command {
+	configflag := cli.StringFlag{
+		Name:   "config",
+		EnvVar: "ZTLS_CONFIG",
+		Usage:  "Path of the config file (updated in place)",
+		Value:  "ztlsconfig.txt",
+	}
+	return cli.Command{
+		Name:  "apikey",
+		Usage: "manage the named API keys of a config file (restart the server to apply)",
+		Subcommands: []cli.Command{
+			cli.Command{
+				Name:   "add",
+				Usage:  "add an API key and print its secret",
+				Action: cmdapikeyadd,
+				Flags: []cli.Flag{
+					configflag,
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "Unique id of the key, e.g. the name of the team using it (default, token, acme, est and scep are reserved)",
+					},
+					cli.StringSliceFlag{
+						Name:  "route",
+						Usage: "Route the key can use: " + embedded.RouteNewServerCertificate + ", " + embedded.RouteCertificates + ", " + embedded.RouteTokens + ", " + embedded.RouteSDS + ", " + embedded.RouteRevoke + " (can be repeated; default: all)",
+					},
+					cli.StringSliceFlag{
+						Name:  "profile",
+						Usage: "Profile the key can request (can be repeated; default: all)",
+					},
+					cli.StringSliceFlag{
+						Name:  "allowed-dns",
+						Usage: "Names the key can request, e.g. *.team.internal (can be repeated; default: all)",
+					},
+					cli.StringSliceFlag{
+						Name:  "allowed-spiffe-id",
+						Usage: "SPIFFE IDs the key can request, e.g. spiffe://example.org/ns/team/* (can be repeated; default: all if the key has no other scope, none otherwise)",
+					},
+					cli.DurationFlag{
+						Name:  "expires",
+						Usage: "Lifetime of the key, e.g. 2160h (default: never expires)",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "revoke",
+				Usage:  "disable an API key",
+				Action: cmdapikeyrevoke,
+				Flags: []cli.Flag{
+					configflag,
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "Id of the key",
+					},
+					cli.BoolFlag{
+						Name:  "delete",
+						Usage: "Remove the key from the config instead of disabling it",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "list",
+				Usage:  "list the API keys",
+				Action: cmdapikeylist,
+				Flags: []cli.Flag{
+					configflag,
+				},
+			},
+		},
+	}
+}
+
+func cmdapikeyadd(c *cli.Context) error {
+	logsetup(c)
+	id := c.String("id")
+	if id == "" {
+		return cli.NewExitError("--id is required", 10)
+	}
+	if embedded.IsReservedAPIKeyID(id) {
+		return cli.NewExitError("the API key id "+id+" is reserved", 10)
+	}
+	for _, r := range c.StringSlice("route") {
+		switch r {
+		case embedded.RouteNewServerCertificate, embedded.RouteCertificates, embedded.RouteTokens, embedded.RouteSDS, embedded.RouteRevoke:
+		default:
+			return cli.NewExitError("invalid --route: "+r, 10)
+		}
+	}
+	cfg, headers, err := readconfigfile(c.String("config"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	if cfg.APIKeyByID(id) != nil {
+		return cli.NewExitError("the API key id "+id+" already exists", 10)
+	}
+	secret, err := embedded.NewAPIKeySecret()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	k := &embedded.APIKey{
+		Id:               id,
+		SecretSha256:     embedded.HashAPIKeySecret(secret),
+		Routes:           c.StringSlice("route"),
+		Profiles:         c.StringSlice("profile"),
+		AllowedDns:       c.StringSlice("allowed-dns"),
+		Created:          time.Now().Unix(),
+		AllowedSpiffeIds: c.StringSlice("allowed-spiffe-id"),
+	}
+	if d := c.Duration("expires"); d > 0 {
+		k.Expires = time.Now().Add(d).Unix()
+	}
+	cfg.Apikeys = append(cfg.Apikeys, k)
+	if err := writeconfigfile(c.String("config"), cfg, headers); err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	println("API key " + id + " added. The secret (X-API-KEY) is shown only once:")
+	fmt.Println(secret)
+	return nil
+}
+
+func cmdapikeyrevoke(c *cli.Context) error {
+	logsetup(c)
+	id := c.String("id")
+	if id == "" {
+		return cli.NewExitError("--id is required", 10)
+	}
+	cfg, headers, err := readconfigfile(c.String("config"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	k := cfg.APIKeyByID(id)
+	if k == nil {
+		return cli.NewExitError("API key "+id+" not found", 10)
+	}
+	if c.Bool("delete") {
+		keys := cfg.Apikeys[:0]
+		for _, v := range cfg.Apikeys {
+			if v != k {
+				keys = append(keys, v)
+			}
+		}
+		cfg.Apikeys = keys
+	} else {
+		k.Disabled = true
+	}
+	if err := writeconfigfile(c.String("config"), cfg, headers); err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	println("API key " + id + " revoked")
+	return nil
+}
+
+func cmdapikeylist(c *cli.Context) error {
+	logsetup(c)
+	cfg, _, err := readconfigfile(c.String("config"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	all := func(v []string) string {
+		if len(v) == 0 {
+			return "*"
+		}
+		return strings.Join(v, ",")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tROUTES\tPROFILES\tNAMES\tCREATED\tEXPIRES")
+	for _, k := range cfg.GetApikeys() {
+		status, expires := "active", "never"
+		if k.GetExpires() > 0 {
+			t := time.Unix(k.GetExpires(), 0)
+			expires = t.Format(time.RFC3339)
+			if time.Now().After(t) {
+				status = "expired"
+			}
+		}
+		if k.GetDisabled() {
+			status = "disabled"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", k.GetId(), status, all(k.GetRoutes()),
+			all(k.GetProfiles()), all(k.GetAllowedDns()), time.Unix(k.GetCreated(), 0).Format(time.RFC3339), expires)
+	}
+	return w.Flush()
+}
+
+// readconfigfile reads a config file and its PEM headers
+func readconfigfile(path string) (*embedded.Config, map[string]string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	blk, _ := pem.Decode(raw)
+	if blk == nil {
+		return nil, nil, errors.New("invalid config file: " + path)
+	}
+	cfg, err := embedded.UnmarshalConfig(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, blk.Headers, nil
+}
+
+func writeconfigfile(path string, cfg *embedded.Config, headers map[string]string) error {
+	return ioutil.WriteFile(path, cfg.Marshal(headers), 0600)
+}
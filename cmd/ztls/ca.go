@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"time"
+
+	ipkix "github.com/gabstv/ztls/internal/pkix"
+	"github.com/urfave/cli"
+)
+
+// caflags are the flags used to customize a new CA certificate
+func caflags(cn string, pathlen, days int) []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "cn",
+			Usage: "CA subject common name",
+			Value: cn,
+		},
+		cli.StringSliceFlag{
+			Name:  "country",
+			Usage: "CA subject country (Alpha2 code)",
+		},
+		cli.StringSliceFlag{
+			Name:  "province",
+			Usage: "CA subject province/state",
+		},
+		cli.StringSliceFlag{
+			Name:  "locality",
+			Usage: "CA subject locality/city",
+		},
+		cli.StringSliceFlag{
+			Name:  "org",
+			Usage: "CA subject organization",
+		},
+		cli.StringSliceFlag{
+			Name:  "ou",
+			Usage: "CA subject organizational unit",
+		},
+		cli.StringSliceFlag{
+			Name:  "street-address",
+			Usage: "CA subject street address",
+		},
+		cli.StringSliceFlag{
+			Name:  "postal-code",
+			Usage: "CA subject postal code",
+		},
+		cli.StringFlag{
+			Name:  "serial",
+			Usage: "CA serial number (decimal or 0x prefixed hex). Default: random",
+		},
+		cli.StringFlag{
+			Name:  "not-before",
+			Usage: "Start of the validity period (RFC3339). Default: now",
+		},
+		cli.IntFlag{
+			Name:  "validity-days",
+			Usage: "Validity period (days)",
+			Value: days,
+		},
+		cli.IntFlag{
+			Name:  "path-len",
+			Usage: "Max number of intermediate CAs below this CA (-1: unlimited)",
+			Value: pathlen,
+		},
+		cli.StringSliceFlag{
+			Name:  "permitted-dns",
+			Usage: "Name constraint: permitted DNS domain (repeatable)",
+		},
+		cli.StringSliceFlag{
+			Name:  "excluded-dns",
+			Usage: "Name constraint: excluded DNS domain (repeatable)",
+		},
+		cli.StringSliceFlag{
+			Name:  "permitted-ip",
+			Usage: "Name constraint: permitted IP range in CIDR notation (repeatable)",
+		},
+		cli.StringSliceFlag{
+			Name:  "excluded-ip",
+			Usage: "Name constraint: excluded IP range in CIDR notation (repeatable)",
+		},
+		cli.BoolFlag{
+			Name:  "name-constraints-critical",
+			Usage: "Mark the name constraints extension as critical",
+		},
+	}
+}
+
+// cainput reads the caflags into a NewCACertificateInput (without the key)
+func cainput(c *cli.Context) (ipkix.NewCACertificateInput, error) {
+	input := ipkix.NewCACertificateInput{
+		Subject: pkix.Name{
+			CommonName:         c.String("cn"),
+			Country:            c.StringSlice("country"),
+			Province:           c.StringSlice("province"),
+			Locality:           c.StringSlice("locality"),
+			Organization:       c.StringSlice("org"),
+			OrganizationalUnit: c.StringSlice("ou"),
+			StreetAddress:      c.StringSlice("street-address"),
+			PostalCode:         c.StringSlice("postal-code"),
+		},
+		MaxPathLen:              c.Int("path-len"),
+		PermittedDNSDomains:     c.StringSlice("permitted-dns"),
+		ExcludedDNSDomains:      c.StringSlice("excluded-dns"),
+		NameConstraintsCritical: c.Bool("name-constraints-critical"),
+	}
+	if v := c.String("serial"); v != "" {
+		serial, err := parseserial(v)
+		if err != nil {
+			return input, err
+		}
+		input.SerialNumber = serial
+	}
+	input.NotBefore = time.Now().Add(time.Minute * -15)
+	if v := c.String("not-before"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return input, err
+		}
+		input.NotBefore = t
+	}
+	days := c.Int("validity-days")
+	if days <= 0 {
+		return input, errors.New("invalid validity-days")
+	}
+	input.NotAfter = input.NotBefore.AddDate(0, 0, days)
+	var err error
+	if input.PermittedIPRanges, err = ipkix.ParseCIDRs(c.StringSlice("permitted-ip")); err != nil {
+		return input, err
+	}
+	if input.ExcludedIPRanges, err = ipkix.ParseCIDRs(c.StringSlice("excluded-ip")); err != nil {
+		return input, err
+	}
+	return input, nil
+}
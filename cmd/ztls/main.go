@@ -3,16 +3,26 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math/big"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/gabstv/ztls/embedded"
+	"github.com/gabstv/ztls/embedded/acme"
+	"github.com/gabstv/ztls/embedded/middlewares"
+	"github.com/gabstv/ztls/embedded/store"
 	"github.com/gabstv/ztls/internal/clix"
 	"github.com/gabstv/ztls/internal/metadata"
 	"github.com/gabstv/ztls/internal/pkix"
@@ -50,12 +60,22 @@ func main() {
 			Description: "generate a new master key and certificate authority",
 			Usage:       "generate a new key and CA cert",
 			Action:      cmdgen,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "key-type, kt",
+					Usage: "Key type: rsa, ecdsa-p256, ecdsa-p384, ed25519",
+					Value: "rsa",
+				},
 				cli.IntFlag{
 					Name:  "keysize, ksz",
-					Usage: "Key size (bits): 2048, 4096, 8192",
+					Usage: "RSA key size (bits): 2048, 4096, 8192",
 					Value: 4096,
 				},
+				cli.StringFlag{
+					Name:   "key-password",
+					EnvVar: "ZTLS_KEY_PASSWORD",
+					Usage:  "Write the key as an encrypted PKCS#8 PEM (ENCRYPTED PRIVATE KEY) using this password",
+				},
 				cli.StringFlag{
 					Name:  "output-dir, odir",
 					Usage: "Output directory",
@@ -70,7 +90,58 @@ func main() {
 					Usage: "Certificate output name",
 					Value: "ca-cert.pem",
 				},
-			},
+			}, caflags("ztls", 1, 7300)...),
+		},
+		cli.Command{
+			Name:        "intermediate",
+			ShortName:   "int",
+			Description: "generate a new intermediate CA key and certificate signed by the root CA",
+			Usage:       "generate a new intermediate CA key and cert",
+			Action:      cmdintermediate,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "root-key",
+					Usage: "The root key (PEM). " + clix.ContentUsage(),
+				},
+				cli.StringFlag{
+					Name:   "root-key-password",
+					EnvVar: "ZTLS_ROOT_KEY_PASSWORD",
+					Usage:  "The root key password (if the key is encrypted)",
+				},
+				cli.StringFlag{
+					Name:  "root-cert",
+					Usage: "The root certificate (CA PEM). " + clix.ContentUsage(),
+				},
+				cli.StringFlag{
+					Name:  "key-type, kt",
+					Usage: "Key type: rsa, ecdsa-p256, ecdsa-p384, ed25519",
+					Value: "rsa",
+				},
+				cli.IntFlag{
+					Name:  "keysize, ksz",
+					Usage: "RSA key size (bits): 2048, 4096, 8192",
+					Value: 4096,
+				},
+				cli.StringFlag{
+					Name:   "key-password",
+					EnvVar: "ZTLS_KEY_PASSWORD",
+					Usage:  "Write the key as an encrypted PKCS#8 PEM (ENCRYPTED PRIVATE KEY) using this password",
+				},
+				cli.StringFlag{
+					Name:  "output-dir, odir",
+					Usage: "Output directory",
+				},
+				cli.StringFlag{
+					Name:  "key-output-name, kout",
+					Usage: "Key output name",
+					Value: "intermediate-key.pem",
+				},
+				cli.StringFlag{
+					Name:  "cert-output-name, caout",
+					Usage: "Certificate output name",
+					Value: "intermediate-cert.pem",
+				},
+			}, caflags("ztls Intermediate CA", 0, 1825)...),
 		},
 		cli.Command{
 			Name:        "serve",
@@ -89,8 +160,111 @@ func main() {
 					EnvVar: "LISTEN",
 					Value:  ":8080",
 				},
+				cli.StringFlag{
+					Name:   "data-dir",
+					EnvVar: "ZTLS_DATA_DIR",
+					Usage:  "Directory of the persisted server data (revocation list, issued certificates, ACME accounts, bootstrap tokens). Default: in memory only",
+				},
+				cli.BoolFlag{
+					Name:   "tls",
+					EnvVar: "ZTLS_TLS",
+					Usage:  "Serve HTTPS (default certificate: issued by the server to itself and renewed automatically)",
+				},
+				cli.StringFlag{
+					Name:   "tls-cert",
+					EnvVar: "ZTLS_TLS_CERT",
+					Usage:  "PEM certificate (and chain) of the HTTPS listener instead of a self-issued one. " + clix.ContentUsage(),
+				},
+				cli.StringFlag{
+					Name:   "tls-key",
+					EnvVar: "ZTLS_TLS_KEY",
+					Usage:  "PEM key of --tls-cert. " + clix.ContentUsage(),
+				},
+				cli.StringSliceFlag{
+					Name:  "tls-host",
+					Usage: "Name or IP of the self-issued certificate (can be repeated; default: the host of the public URL or localhost)",
+				},
+				cli.StringFlag{
+					Name:   "tls-client-auth",
+					EnvVar: "ZTLS_TLS_CLIENT_AUTH",
+					Value:  "optional",
+					Usage:  "Verification of the client certificates against the root CA: none, optional or require",
+				},
+				cli.StringFlag{
+					Name:   "sds",
+					EnvVar: "ZTLS_SDS",
+					Usage:  "Serve the Envoy SDS (secret discovery) gRPC service on this address (host:port, or unix:/path); with TLS if --tls is set. The streams are authenticated by an API key in the x-api-key metadata",
+				},
+				cli.StringFlag{
+					Name:   "grpc",
+					EnvVar: "ZTLS_GRPC",
+					Usage:  "Serve the ztls.v1.CertificateAuthority gRPC API on this address (host:port, or unix:/path); with TLS if --tls is set. Same API keys, policies and rate limits as the /2/ routes",
+				},
+			},
+		},
+		cli.Command{
+			Name:        "revoke",
+			Usage:       "revoke a certificate",
+			Description: "add a certificate to the revocation list of a server data directory",
+			Action:      cmdrevoke,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "data-dir",
+					EnvVar: "ZTLS_DATA_DIR",
+					Usage:  "Directory of the persisted server data (same as serve --data-dir)",
+				},
+				cli.StringFlag{
+					Name:  "serial",
+					Usage: "Serial number of the certificate (decimal, 0x prefixed hex or colon separated hex)",
+				},
+				cli.StringFlag{
+					Name:  "cert",
+					Usage: "The certificate to revoke (instead of --serial). " + clix.ContentUsage(),
+				},
+				cli.StringFlag{
+					Name:  "reason",
+					Usage: "unspecified, keyCompromise, cACompromise, affiliationChanged, superseded, cessationOfOperation, certificateHold, privilegeWithdrawn, aACompromise",
+					Value: "unspecified",
+				},
+			},
+		},
+		cli.Command{
+			Name:        "certificates",
+			ShortName:   "certs",
+			Usage:       "list issued certificates",
+			Description: "list the certificates recorded in a server data directory (the server must be stopped; use GET /1/certificates otherwise)",
+			Action:      cmdcertificates,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "data-dir",
+					EnvVar: "ZTLS_DATA_DIR",
+					Usage:  "Directory of the persisted server data (same as serve --data-dir)",
+				},
+				cli.StringFlag{
+					Name:  "host",
+					Usage: "Only certificates of this host (common name, DNS name or IP address)",
+				},
+				cli.StringFlag{
+					Name:  "cn",
+					Usage: "Only certificates with this common name",
+				},
+				cli.StringFlag{
+					Name:  "api-key-id",
+					Usage: "Only certificates requested with this API key",
+				},
+				cli.BoolFlag{
+					Name:  "valid",
+					Usage: "Only certificates that are currently valid",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Output JSON (includes the certificates)",
+				},
 			},
 		},
+		tokencommand(),
+		signcommand(),
+		issuecommand(),
 		cli.Command{
 			Name:      "config",
 			ShortName: "cfg",
@@ -100,19 +274,135 @@ func main() {
 					Name:   "new",
 					Usage:  "create a new ZTLS config file",
 					Action: cmdcfgnew,
-					Flags: []cli.Flag{
+					Flags: append([]cli.Flag{
 						cli.StringFlag{
 							Name:  "key",
 							Usage: "The root key (PEM). " + clix.ContentUsage(),
 						},
+						cli.StringFlag{
+							Name:   "key-password",
+							EnvVar: "ZTLS_KEY_PASSWORD",
+							Usage:  "The root key password (if the key is encrypted)",
+						},
 						cli.StringFlag{
 							Name:  "cert",
 							Usage: "The root certificate (CA PEM). " + clix.ContentUsage(),
 						},
+						cli.StringFlag{
+							Name:  "issuer-key",
+							Usage: "The intermediate CA key (PEM). When set, --key can be omitted to keep the root key offline. " + clix.ContentUsage(),
+						},
+						cli.StringFlag{
+							Name:   "issuer-key-password",
+							EnvVar: "ZTLS_ISSUER_KEY_PASSWORD",
+							Usage:  "The intermediate CA key password (if the key is encrypted)",
+						},
+						cli.StringFlag{
+							Name:  "issuer-cert",
+							Usage: "The intermediate CA certificate (PEM). " + clix.ContentUsage(),
+						},
 						cli.StringFlag{
 							Name:  "apikey",
 							Usage: "API Key for authenticated rest routes",
 						},
+						cli.StringFlag{
+							Name:  "public-url",
+							Usage: "Public base URL of the server (e.g. https://ca.example.com), used in the CRL distribution point of issued certificates",
+						},
+						cli.StringFlag{
+							Name:  "trust-domain",
+							Usage: "SPIFFE trust domain (e.g. example.org) of the URI SANs of the certificates (default: no URI SANs)",
+						},
+						cli.StringFlag{
+							Name:  "policy",
+							Usage: "Issuance policy (JSON) of the anonymous requests, e.g. {\"allowedDns\": [\"*.internal.example.com\"]}. " + clix.ContentUsage(),
+						},
+						cli.StringFlag{
+							Name:  "apikey-policy",
+							Usage: "Issuance policy (JSON) of the requests authenticated by --apikey (default: same as --policy). " + clix.ContentUsage(),
+						},
+						cli.StringFlag{
+							Name:  "profiles",
+							Usage: "Certificate profiles (JSON) by name, e.g. {\"web\": {\"extKeyUsage\": [\"serverAuth\"]}}. " + clix.ContentUsage(),
+						},
+						cli.StringFlag{
+							Name:  "default-profile",
+							Usage: "Profile of the requests that don't select one (built-in: server, client, peer, code-signing)",
+							Value: "peer",
+						},
+						cli.DurationFlag{
+							Name:  "default-lifetime",
+							Usage: "Lifetime of the certificates when neither the request nor the profile sets one",
+							Value: embedded.DefaultLifetime,
+						},
+						cli.DurationFlag{
+							Name:  "max-lifetime",
+							Usage: "Maximum lifetime of the certificates (0 = until the CA expires)",
+						},
+						cli.DurationFlag{
+							Name:  "backdate",
+							Usage: "NotBefore is backdated by this value to tolerate clock skew (0 = disabled)",
+							Value: embedded.DefaultBackdate,
+						},
+						cli.DurationFlag{
+							Name:  "short-lived",
+							Usage: "Certificates with a lifetime up to this value have no CRL nor OCSP URL (0 = disabled)",
+							Value: embedded.DefaultShortLived,
+						},
+						cli.BoolFlag{
+							Name:  "acme",
+							Usage: "Enable the ACME (RFC 8555) server on /acme",
+						},
+						cli.StringFlag{
+							Name:  "acme-resolver",
+							Usage: "DNS server (host:port) used to validate ACME dns-01 challenges (default: system resolver)",
+						},
+						cli.StringSliceFlag{
+							Name:  "acme-auto-approve",
+							Usage: "Names authorized by ACME without validation, e.g. *.internal (can be repeated)",
+						},
+						cli.StringFlag{
+							Name:  "acme-profile",
+							Usage: "Certificate profile of the ACME orders (default: server)",
+						},
+						cli.BoolFlag{
+							Name:  "est",
+							Usage: "Enable the EST (RFC 7030) enrollment endpoints on /.well-known/est",
+						},
+						cli.StringFlag{
+							Name:  "est-username",
+							Usage: "HTTP basic auth username of the EST clients (empty: TLS client certificates only)",
+						},
+						cli.StringFlag{
+							Name:  "est-password",
+							Usage: "HTTP basic auth password of the EST clients",
+						},
+						cli.StringFlag{
+							Name:  "est-profile",
+							Usage: "Certificate profile of the EST enrollments (default: --default-profile)",
+						},
+						cli.BoolFlag{
+							Name:  "scep",
+							Usage: "Enable the SCEP (RFC 8894) responder on /scep",
+						},
+						cli.StringSliceFlag{
+							Name:  "scep-challenge",
+							Usage: "Accepted SCEP challenge password (can be repeated; none: only renewals are accepted)",
+						},
+						cli.StringFlag{
+							Name:  "scep-profile",
+							Usage: "Certificate profile of the SCEP enrollments (default: --default-profile)",
+						},
+						cli.StringFlag{
+							Name:  "key-type, kt",
+							Usage: "Root key type (if --key is not set): rsa, ecdsa-p256, ecdsa-p384, ed25519",
+							Value: "rsa",
+						},
+						cli.StringFlag{
+							Name:  "leaf-key-type",
+							Usage: "Key type of the keys generated by the server: rsa, ecdsa-p256, ecdsa-p384, ed25519",
+							Value: "rsa",
+						},
 						cli.StringFlag{
 							Name:  "output, o",
 							Usage: "output file path",
@@ -122,8 +412,9 @@ func main() {
 							Name:  "stdout",
 							Usage: "output config to standard output",
 						},
-					},
+					}, caflags("ztls", 1, 7300)...),
 				},
+				apikeycommand(),
 			},
 		},
 		cli.Command{
@@ -189,21 +480,41 @@ func cmdgen(c *cli.Context) error {
 	logsetup(c)
 
 	keysz := c.Int("keysize")
+	kt, err := pkix.ParseKeyType(c.String("key-type"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 10)
+	}
 
-	log.Info().Int("keysize", keysz).Msg("generating RSA PRIVATE KEY")
+	log.Info().Str("keytype", string(kt)).Int("keysize", keysz).Msg("generating PRIVATE KEY")
 
-	keypem, err := pkix.NewKey(keysz)
+	keypem, err := pkix.NewKeyWithType(kt, keysz)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 11)
 	}
 
-	log.Info().Int("keysize", keysz).Msg("generating CA CERTIFICATE")
+	log.Info().Str("keytype", string(kt)).Msg("generating CA CERTIFICATE")
 
-	certpem, err := pkix.NewCACertificate(keypem)
+	cain, err := cainput(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 10)
+	}
+	cain.Key = keypem
+	certpem, err := pkix.NewCACertificateWithInput(cain)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 11)
 	}
 
+	if pw := c.String("key-password"); pw != "" {
+		key, err := pkix.ParsePrivateKey(keypem, nil)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 11)
+		}
+		keypem, err = pkix.MarshalEncryptedPrivateKeyPEM(key, []byte(pw))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 11)
+		}
+	}
+
 	keypath := c.String("key-output-name")
 	certpath := c.String("cert-output-name")
 
@@ -225,6 +536,72 @@ func cmdgen(c *cli.Context) error {
 	return nil
 }
 
+func cmdintermediate(c *cli.Context) error {
+	logsetup(c)
+
+	rootkey := clix.ParseContentValue(c.String("root-key"), true)
+	rootcertpem := clix.ParseContentValue(c.String("root-cert"), true)
+	if rootkey == nil || rootcertpem == nil {
+		return cli.NewExitError("--root-key and --root-cert are required", 10)
+	}
+	rootk, err := pkix.ParsePrivateKey(rootkey, []byte(c.String("root-key-password")))
+	if err != nil {
+		return cli.NewExitError("root key: "+err.Error(), 10)
+	}
+	rootcert, err := parsecert(rootcertpem)
+	if err != nil {
+		return cli.NewExitError("root cert: "+err.Error(), 10)
+	}
+
+	kt, err := pkix.ParseKeyType(c.String("key-type"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 10)
+	}
+	keypem, err := pkix.NewKeyWithType(kt, c.Int("keysize"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+
+	cain, err := cainput(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 10)
+	}
+	cain.Key = keypem
+	cain.Parent = rootcert
+	cain.ParentKey = rootk
+	certpem, err := pkix.NewIntermediateCertificate(cain)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+
+	if pw := c.String("key-password"); pw != "" {
+		key, err := pkix.ParsePrivateKey(keypem, nil)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 11)
+		}
+		keypem, err = pkix.MarshalEncryptedPrivateKeyPEM(key, []byte(pw))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 11)
+		}
+	}
+
+	keypath := c.String("key-output-name")
+	certpath := c.String("cert-output-name")
+	if basedir := c.String("output-dir"); basedir != "" {
+		keypath = filepath.Join(basedir, keypath)
+		certpath = filepath.Join(basedir, certpath)
+	}
+	if err := ioutil.WriteFile(keypath, keypem, 0740); err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	log.Debug().Str("path", keypath).Msg("intermediate key written")
+	if err := ioutil.WriteFile(certpath, certpem, 0740); err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	log.Debug().Str("path", certpath).Msg("intermediate cert written")
+	return nil
+}
+
 func cmdserve(c *cli.Context) error {
 	logsetup(c)
 	configd := clix.ParseContentValue(c.String("config"), true)
@@ -233,7 +610,13 @@ func cmdserve(c *cli.Context) error {
 			return cli.NewExitError("invalid config", 1)
 		}
 		// create a new config on the spot
-		configd = genconfig(nil, nil, "")
+		var err error
+		configd, err = genconfig(genconfigInput{
+			CAInput: pkix.NewCACertificateInput{MaxPathLen: 1},
+		})
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
 		println("####")
 		println("####")
 		println("CONFIG GENERATED - YOU MUST COPY THIS BELOW:")
@@ -251,11 +634,70 @@ func cmdserve(c *cli.Context) error {
 	if err != nil {
 		return cli.NewExitError("invalid config: "+err.Error(), 1)
 	}
+	if dir := c.String("data-dir"); dir != "" {
+		rl, err := embedded.OpenFileRevocationList(revocationspath(dir))
+		if err != nil {
+			return cli.NewExitError("data dir: "+err.Error(), 1)
+		}
+		esv.Revocations = rl
+		st, err := store.OpenBolt(storepath(dir), time.Second*5)
+		if err != nil {
+			return cli.NewExitError("data dir: "+err.Error(), 1)
+		}
+		defer st.Close()
+		esv.Store = st
+		as, err := acme.OpenBoltStorage(acmepath(dir), time.Second*5)
+		if err != nil {
+			return cli.NewExitError("data dir: "+err.Error(), 1)
+		}
+		defer as.Close()
+		esv.ACMEStorage = as
+		tl, err := embedded.OpenFileTokenList(tokenspath(dir))
+		if err != nil {
+			return cli.NewExitError("data dir: "+err.Error(), 1)
+		}
+		esv.Tokens = tl
+	}
 
-	log.Info().Str("listen", c.String("listen")).Msg("ListenAndServe")
-	httpch, err := esv.ListenAndServeAsync(ctx, c.String("listen"), time.Second*3)
-	if err != nil {
-		return cli.NewExitError("HTTP: "+err.Error(), 2)
+	var httpch <-chan struct{}
+	var grpctls *tls.Config // SDS and gRPC API
+	if c.Bool("tls") {
+		opts, err := tlsoptions(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		// one config (and self-issued certificate) for all the listeners
+		tlsc, err := esv.TLSConfig(opts)
+		if err != nil {
+			return cli.NewExitError("HTTPS: "+err.Error(), 2)
+		}
+		log.Info().Str("listen", c.String("listen")).Msg("ListenAndServeTLS")
+		if httpch, err = esv.ListenAndServeTLSConfigAsync(ctx, c.String("listen"), tlsc, time.Second*3); err != nil {
+			return cli.NewExitError("HTTPS: "+err.Error(), 2)
+		}
+		if c.String("sds") != "" || c.String("grpc") != "" {
+			grpctls = tlsc
+		}
+	} else {
+		log.Info().Str("listen", c.String("listen")).Msg("ListenAndServe")
+		if httpch, err = esv.ListenAndServeAsync(ctx, c.String("listen"), time.Second*3); err != nil {
+			return cli.NewExitError("HTTP: "+err.Error(), 2)
+		}
+	}
+
+	var sdsch <-chan struct{}
+	if addr := c.String("sds"); addr != "" {
+		log.Info().Str("listen", addr).Bool("tls", grpctls != nil).Msg("ListenAndServeSDS")
+		if sdsch, err = esv.ListenAndServeSDSAsync(ctx, addr, grpctls); err != nil {
+			return cli.NewExitError("SDS: "+err.Error(), 2)
+		}
+	}
+	var grpcch <-chan struct{}
+	if addr := c.String("grpc"); addr != "" {
+		log.Info().Str("listen", addr).Bool("tls", grpctls != nil).Msg("ListenAndServeGRPC")
+		if grpcch, err = esv.ListenAndServeGRPCAsync(ctx, addr, grpctls); err != nil {
+			return cli.NewExitError("gRPC: "+err.Error(), 2)
+		}
 	}
 
 	sig := make(chan os.Signal, 1)
@@ -266,27 +708,257 @@ func cmdserve(c *cli.Context) error {
 	cf()
 	<-httpch
 	log.Warn().Msg("rest server shutdown")
+	if sdsch != nil {
+		<-sdsch
+		log.Warn().Msg("sds server shutdown")
+	}
+	if grpcch != nil {
+		<-grpcch
+		log.Warn().Msg("grpc server shutdown")
+	}
+	return nil
+}
+
+// tlsoptions reads the --tls-* flags of serve
+func tlsoptions(c *cli.Context) (embedded.TLSOptions, error) {
+	opts := embedded.TLSOptions{
+		Hosts: c.StringSlice("tls-host"),
+	}
+	if c.String("tls-cert") != "" || c.String("tls-key") != "" {
+		opts.Certificate = clix.ParseContentValue(c.String("tls-cert"), true)
+		opts.Key = clix.ParseContentValue(c.String("tls-key"), true)
+		if opts.Certificate == nil || opts.Key == nil {
+			return opts, errors.New("invalid --tls-cert or --tls-key")
+		}
+	}
+	switch c.String("tls-client-auth") {
+	case "none":
+		opts.ClientAuth = tls.NoClientCert
+	case "optional", "":
+		opts.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		opts.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return opts, fmt.Errorf("invalid --tls-client-auth: %v", c.String("tls-client-auth"))
+	}
+	return opts, nil
+}
+
+func revocationspath(datadir string) string {
+	return filepath.Join(datadir, "revocations.json")
+}
+
+func storepath(datadir string) string {
+	return filepath.Join(datadir, "certificates.db")
+}
+
+func acmepath(datadir string) string {
+	return filepath.Join(datadir, "acme.db")
+}
+
+func tokenspath(datadir string) string {
+	return filepath.Join(datadir, "tokens.json")
+}
+
+func cmdcertificates(c *cli.Context) error {
+	logsetup(c)
+	dir := c.String("data-dir")
+	if dir == "" {
+		return cli.NewExitError("--data-dir is required", 10)
+	}
+	f := store.Filter{
+		Host:       c.String("host"),
+		CommonName: c.String("cn"),
+		APIKeyID:   c.String("api-key-id"),
+	}
+	if c.Bool("valid") {
+		f.ValidAt = time.Now()
+	}
+	st, err := store.OpenBolt(storepath(dir), time.Second)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	defer st.Close()
+	list, err := st.List(f)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	if c.Bool("json") {
+		raw, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return cli.NewExitError(err.Error(), 11)
+		}
+		fmt.Println(string(raw))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tCOMMON NAME\tSANS\tISSUED\tEXPIRES\tREQUESTER\tAPI KEY\tRENEWS")
+	for _, r := range list {
+		sans := append(append([]string{}, r.DNSNames...), r.IPAddresses...)
+		renews := ""
+		if r.RenewedFrom != nil {
+			renews = r.RenewedFrom.String()
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", r.Serial, r.CommonName, strings.Join(sans, ","),
+			r.IssuedAt.Format(time.RFC3339), r.NotAfter.Format(time.RFC3339), r.Requester, r.APIKeyID, renews)
+	}
+	return w.Flush()
+}
+
+func cmdrevoke(c *cli.Context) error {
+	logsetup(c)
+	dir := c.String("data-dir")
+	if dir == "" {
+		return cli.NewExitError("--data-dir is required", 10)
+	}
+	var serial *big.Int
+	if v := c.String("serial"); v != "" {
+		var err error
+		if serial, err = parseserial(v); err != nil {
+			return cli.NewExitError(err.Error(), 10)
+		}
+	} else if v := c.String("cert"); v != "" {
+		cert, err := parsecert(clix.ParseContentValue(v, true))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 10)
+		}
+		serial = cert.SerialNumber
+	} else {
+		return cli.NewExitError("--serial or --cert is required", 10)
+	}
+	reason, err := embedded.ParseRevocationReason(c.String("reason"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 10)
+	}
+	rl, err := embedded.OpenFileRevocationList(revocationspath(dir))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	if err := rl.Revoke(embedded.Revocation{
+		Serial:    serial,
+		Reason:    reason,
+		RevokedAt: time.Now(),
+	}); err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	log.Info().Str("serial", serial.String()).Str("reason", reason.String()).Msg("certificate revoked")
 	return nil
 }
 
+// parseserial parses a serial number: decimal, 0x prefixed hex or colon
+// separated hex (as printed by openssl)
+func parseserial(v string) (*big.Int, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	base := 0
+	if strings.Contains(v, ":") {
+		v = strings.Replace(v, ":", "", -1)
+		base = 16
+	}
+	serial, ok := new(big.Int).SetString(v, base)
+	if !ok || serial.Sign() <= 0 {
+		return nil, errors.New("invalid serial: " + v)
+	}
+	return serial, nil
+}
+
 func cmdcfgnew(c *cli.Context) error {
 	logsetup(c)
-	var key, cert []byte
-	var apikey string
+	input := genconfigInput{}
 	if vv := c.String("key"); vv != "" {
 		if v := clix.ParseContentValue(vv, true); v != nil {
-			key = v
+			input.Key = v
 		}
 	}
-	if vv := c.String("cert, ca"); vv != "" {
+	if vv := c.String("key-password"); vv != "" {
+		input.KeyPassword = []byte(vv)
+	}
+	if vv := c.String("cert"); vv != "" {
+		if v := clix.ParseContentValue(vv, true); v != nil {
+			input.CA = v
+		}
+	}
+	if vv := c.String("issuer-key"); vv != "" {
+		if v := clix.ParseContentValue(vv, true); v != nil {
+			input.IssuerKey = v
+		}
+	}
+	if vv := c.String("issuer-key-password"); vv != "" {
+		input.IssuerKeyPassword = []byte(vv)
+	}
+	if vv := c.String("issuer-cert"); vv != "" {
 		if v := clix.ParseContentValue(vv, true); v != nil {
-			cert = v
+			input.IssuerCert = v
 		}
 	}
 	if vv := c.String("apikey"); vv != "" {
-		apikey = vv
+		input.APIKey = vv
+	}
+	input.PublicURL = c.String("public-url")
+	input.TrustDomain = c.String("trust-domain")
+	if td := input.TrustDomain; td != "" {
+		if _, err := pkix.ParseSPIFFEID("spiffe://" + td); err != nil {
+			return cli.NewExitError("--trust-domain: "+err.Error(), 10)
+		}
+	}
+	var err error
+	if input.Policy, err = parsepolicy(c.String("policy")); err != nil {
+		return cli.NewExitError("--policy: "+err.Error(), 10)
+	}
+	if input.APIKeyPolicy, err = parsepolicy(c.String("apikey-policy")); err != nil {
+		return cli.NewExitError("--apikey-policy: "+err.Error(), 10)
+	}
+	if v := c.String("profiles"); v != "" {
+		if input.Profiles, err = embedded.UnmarshalProfilesJSON(jsonflag(v)); err != nil {
+			return cli.NewExitError("--profiles: "+err.Error(), 10)
+		}
+	}
+	input.DefaultProfile = c.String("default-profile")
+	input.DefaultLifetime = c.Duration("default-lifetime")
+	input.MaxLifetime = c.Duration("max-lifetime")
+	input.Backdate = disabledzero(c.Duration("backdate"))
+	input.ShortLived = disabledzero(c.Duration("short-lived"))
+	if c.Bool("acme") {
+		input.ACME = &embedded.ACME{
+			Enabled:     true,
+			Resolver:    c.String("acme-resolver"),
+			AutoApprove: c.StringSlice("acme-auto-approve"),
+			Profile:     c.String("acme-profile"),
+		}
+	}
+	if c.Bool("est") {
+		input.EST = &embedded.EST{
+			Enabled:  true,
+			Username: c.String("est-username"),
+			Password: c.String("est-password"),
+			Profile:  c.String("est-profile"),
+		}
+		if (input.EST.Username == "") != (input.EST.Password == "") {
+			return cli.NewExitError("--est-username and --est-password must be set together", 10)
+		}
+	}
+	if c.Bool("scep") {
+		input.SCEP = &embedded.SCEP{
+			Enabled:            true,
+			ChallengePasswords: c.StringSlice("scep-challenge"),
+			Profile:            c.String("scep-profile"),
+		}
+	}
+	if input.MaxLifetime > 0 && input.DefaultLifetime > input.MaxLifetime {
+		return cli.NewExitError("--default-lifetime exceeds --max-lifetime", 10)
+	}
+	if input.CAInput, err = cainput(c); err != nil {
+		return cli.NewExitError(err.Error(), 10)
+	}
+	if input.KeyType, err = pkix.ParseKeyType(c.String("key-type")); err != nil {
+		return cli.NewExitError(err.Error(), 10)
+	}
+	if input.LeafKeyType, err = pkix.ParseKeyType(c.String("leaf-key-type")); err != nil {
+		return cli.NewExitError(err.Error(), 10)
+	}
+	cfgb, err := genconfig(input)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
 	}
-	cfgb := genconfig(key, cert, apikey)
 	if c.Bool("stdout") {
 		print(string(cfgb))
 		return nil
@@ -338,29 +1010,115 @@ func cmdutilbase64decode(c *cli.Context) error {
 	return cli.NewExitError("output file not specified", 10)
 }
 
-func genconfig(prekey, preca []byte, preapikey string) []byte {
+type genconfigInput struct {
+	Key         []byte
+	KeyPassword []byte
+	CA          []byte
+	APIKey      string
+	KeyType     pkix.KeyType // root key type (if Key is nil)
+	LeafKeyType pkix.KeyType
+	CAInput     pkix.NewCACertificateInput // CA parameters (if CA is nil)
+	PublicURL   string
+	TrustDomain string
+	// intermediate CA (optional); when set, Key can be nil and the root key
+	// is kept out of the config
+	IssuerKey         []byte
+	IssuerKeyPassword []byte
+	IssuerCert        []byte
+	// issuance policies (optional)
+	Policy       *embedded.Policy
+	APIKeyPolicy *embedded.Policy
+	// certificate profiles (optional)
+	Profiles       map[string]*embedded.Profile
+	DefaultProfile string
+	// lifetimes (zero values use the server defaults, negative values
+	// disable Backdate and ShortLived)
+	DefaultLifetime time.Duration
+	MaxLifetime     time.Duration
+	Backdate        time.Duration
+	ShortLived      time.Duration
+	// ACME server (optional)
+	ACME *embedded.ACME
+	// EST enrollment (optional)
+	EST *embedded.EST
+	// SCEP responder (optional)
+	SCEP *embedded.SCEP
+}
+
+// cfgseconds converts a duration to a config value: 0 is the server default
+// and -1 disables the setting
+func cfgseconds(v, def time.Duration) int64 {
+	switch {
+	case v == 0, v == def:
+		return 0
+	case v < 0:
+		return -1
+	}
+	return int64(v / time.Second)
+}
+
+// disabledzero maps a zero duration flag (disabled) to -1
+func disabledzero(v time.Duration) time.Duration {
+	if v == 0 {
+		return -1
+	}
+	return v
+}
+
+// parsepolicy parses a policy flag value: inline JSON or a content value
+// (nil if empty)
+func parsepolicy(v string) (*embedded.Policy, error) {
+	if v == "" {
+		return nil, nil
+	}
+	return embedded.UnmarshalPolicyJSON(jsonflag(v))
+}
+
+// jsonflag returns the value of a JSON flag: inline JSON or a content value
+func jsonflag(v string) []byte {
+	if strings.HasPrefix(strings.TrimSpace(v), "{") {
+		return []byte(v)
+	}
+	return clix.ParseContentValue(v, true)
+}
+
+func genconfig(input genconfigInput) ([]byte, error) {
 	var key, ca []byte
 	var apikey string
-	if prekey != nil {
-		key = prekey
-	} else {
-		nkey, err := pkix.NewKey(4096)
+	if input.IssuerKey != nil || input.IssuerCert != nil {
+		if input.IssuerKey == nil || input.IssuerCert == nil {
+			return nil, errors.New("both the issuer key and the issuer cert are required")
+		}
+		if input.CA == nil {
+			return nil, errors.New("the root certificate is required when using an intermediate CA")
+		}
+		if err := checkissuer(input.CA, input.IssuerCert, input.IssuerKey, input.IssuerKeyPassword); err != nil {
+			return nil, err
+		}
+	}
+	if input.Key != nil {
+		key = input.Key
+	} else if input.IssuerKey == nil {
+		nkey, err := pkix.NewKeyWithType(input.KeyType, pkix.DefaultRSAKeySize)
 		if err != nil {
 			panic(err)
 		}
 		key = nkey
 	}
-	if preca != nil {
-		ca = preca
+	if input.CA != nil {
+		ca = input.CA
 	} else {
-		newca, err := pkix.NewCACertificate(key)
+		cain := input.CAInput
+		cain.Key = key
+		cain.KeyPassword = input.KeyPassword
+		newca, err := pkix.NewCACertificateWithInput(cain)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		ca = newca
 	}
-	if preapikey != "" {
-		apikey = preapikey
+	if input.APIKey != "" {
+		apikey = input.APIKey
 	} else {
 		u, err := uuid.NewRandom()
 		if err != nil {
@@ -370,14 +1128,96 @@ func genconfig(prekey, preca []byte, preapikey string) []byte {
 	}
 
 	cfg := &embedded.Config{
-		Rootcert: ca,
-		Rootkey:  key,
-		Apikey:   apikey,
+		Rootcert:    ca,
+		Rootkey:     key,
+		RootkeyPw:   input.KeyPassword,
+		Apikey:      apikey,
+		KeyType:     string(input.LeafKeyType),
+		Issuerkey:   input.IssuerKey,
+		IssuerkeyPw: input.IssuerKeyPassword,
+		Issuercert:  input.IssuerCert,
+		PublicUrl:   input.PublicURL,
+		TrustDomain: input.TrustDomain,
+		Policy:      input.Policy,
+		Profiles:    input.Profiles,
+		Acme:        input.ACME,
+		Est:         input.EST,
+		Scep:        input.SCEP,
+	}
+	if input.DefaultProfile != embedded.DefaultProfile {
+		cfg.DefaultProfile = input.DefaultProfile
+	}
+	if input.DefaultLifetime > 0 {
+		cfg.DefaultLifetimeSeconds = cfgseconds(input.DefaultLifetime, embedded.DefaultLifetime)
+	}
+	if input.MaxLifetime > 0 {
+		cfg.MaxLifetimeSeconds = cfgseconds(input.MaxLifetime, 0)
+	}
+	cfg.BackdateSeconds = cfgseconds(input.Backdate, embedded.DefaultBackdate)
+	cfg.ShortLivedSeconds = cfgseconds(input.ShortLived, embedded.DefaultShortLived)
+	if input.APIKeyPolicy != nil {
+		cfg.ApikeyPolicies = map[string]*embedded.Policy{
+			middlewares.DefaultAPIKeyID: input.APIKeyPolicy,
+		}
+	}
+	if key == nil {
+		cfg.RootkeyPw = nil
+	}
+	expires := caexpires(ca)
+	if input.IssuerCert != nil {
+		expires = caexpires(input.IssuerCert)
 	}
 	pem := cfg.Marshal(map[string]string{
 		"Generator": "ztls CLI",
-		"Expires":   time.Now().AddDate(20, 0, 0).String(),
+		"Expires":   expires,
 		"X-API-KEY": apikey,
 	})
-	return pem
+	return pem, nil
+}
+
+// checkissuer verifies that the intermediate certificate is signed by the
+// root and matches the intermediate key
+func checkissuer(rootpem, certpem, keypem, keypw []byte) error {
+	root, err := parsecert(rootpem)
+	if err != nil {
+		return err
+	}
+	cert, err := parsecert(certpem)
+	if err != nil {
+		return err
+	}
+	if !cert.IsCA {
+		return errors.New("the issuer certificate is not a CA")
+	}
+	if err := cert.CheckSignatureFrom(root); err != nil {
+		return errors.New("the issuer certificate is not signed by the root: " + err.Error())
+	}
+	key, err := pkix.ParsePrivateKey(keypem, keypw)
+	if err != nil {
+		return err
+	}
+	pubder, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(pubder, cert.RawSubjectPublicKeyInfo) {
+		return errors.New("the issuer key does not match the issuer certificate")
+	}
+	return nil
+}
+
+func parsecert(certpem []byte) (*x509.Certificate, error) {
+	der, err := pkix.DecodePEM(certpem, pkix.PEMCertificate, nil)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+func caexpires(capem []byte) string {
+	cert, err := parsecert(capem)
+	if err != nil {
+		return ""
+	}
+	return cert.NotAfter.String()
 }
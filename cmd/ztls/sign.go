@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/gabstv/ztls/embedded"
+	"github.com/gabstv/ztls/embedded/store"
+	"github.com/gabstv/ztls/internal/clix"
+	"github.com/gabstv/ztls/internal/pkix"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli"
+)
+
+// offlineflags are the flags of the commands that issue certificates without
+// a running server (sign, issue)
+func offlineflags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			EnvVar: "ZTLS_CONFIG",
+			Usage:  "The server configuration (CA and profiles). " + clix.ContentUsage(),
+		},
+		cli.StringFlag{
+			Name:   "data-dir",
+			EnvVar: "ZTLS_DATA_DIR",
+			Usage:  "Record the certificate in this server data directory (same as serve --data-dir; the server must be stopped)",
+		},
+		cli.StringFlag{
+			Name:  "profile",
+			Usage: "Certificate profile (default: the server default profile)",
+		},
+		cli.DurationFlag{
+			Name:  "ttl",
+			Usage: "Lifetime of the certificate (default: the lifetime of the profile or of the server)",
+		},
+		cli.StringFlag{
+			Name:  "out, o",
+			Usage: "Output file of the certificate and its chain (default: stdout)",
+		},
+		cli.BoolFlag{
+			Name:  "bundle",
+			Usage: "Append the root CA to the output",
+		},
+	}
+}
+
+// signcommand is "sign": signs a CSR with the CA of a config file
+func signcommand() cli.Command {
+	return cli.Command{
+		Name:        "sign",
+		Usage:       "sign a CSR without a running server",
+		Description: "sign a PEM encoded CSR with the CA of a config file (the issuance policy doesn't apply)",
+		Action:      cmdsign,
+		Flags: append(offlineflags(),
+			cli.StringFlag{
+				Name:  "csr",
+				Usage: "The PEM encoded CSR. " + clix.ContentUsage() + " (default: path)",
+			},
+		),
+	}
+}
+
+// issuecommand is "issue": creates a key and a certificate with the CA of a
+// config file
+func issuecommand() cli.Command {
+	return cli.Command{
+		Name:        "issue",
+		Usage:       "create a key and a certificate without a running server",
+		Description: "create a private key, a CSR and a certificate signed by the CA of a config file (the issuance policy doesn't apply)",
+		Action:      cmdissue,
+		Flags: append(offlineflags(),
+			cli.StringFlag{
+				Name:  "cn",
+				Usage: "Common name of the certificate",
+			},
+			cli.StringSliceFlag{
+				Name:  "dns",
+				Usage: "DNS name of the certificate (can be repeated)",
+			},
+			cli.StringSliceFlag{
+				Name:  "ip",
+				Usage: "IP address of the certificate (can be repeated)",
+			},
+			cli.StringFlag{
+				Name:  "spiffe-id",
+				Usage: "SPIFFE ID of the certificate (URI SAN), in the trust domain of the config",
+			},
+			cli.StringFlag{
+				Name:  "key-type, kt",
+				Usage: "Key type: rsa, ecdsa-p256, ecdsa-p384, ed25519 (default: the key type of the config)",
+			},
+			cli.StringFlag{
+				Name:  "key-out",
+				Usage: "Output file of the private key (default: written after the certificate)",
+			},
+		),
+	}
+}
+
+func cmdsign(c *cli.Context) error {
+	logsetup(c)
+	if c.String("csr") == "" {
+		return cli.NewExitError("--csr is required", 10)
+	}
+	csr := clix.ParseContentValue(c.String("csr"), true)
+	if len(csr) == 0 {
+		return cli.NewExitError("invalid --csr", 10)
+	}
+	esv, closefn, err := offlineserver(c)
+	if err != nil {
+		return err
+	}
+	defer closefn()
+	var chain []byte
+	if c.String("profile") == "" && c.Duration("ttl") == 0 {
+		chain, err = esv.NewCertificateRaw(csr)
+	} else {
+		chain, err = esv.Issue(offlinerequest(c, csr))
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	return writeissued(c, esv, chain, nil)
+}
+
+func cmdissue(c *cli.Context) error {
+	logsetup(c)
+	nfo := pkix.CSRInfo{
+		CommonName: c.String("cn"),
+		Domains:    c.StringSlice("dns"),
+		IPs:        c.StringSlice("ip"),
+	}
+	if v := c.String("spiffe-id"); v != "" {
+		nfo.URIs = []string{v}
+	}
+	if nfo.CommonName == "" && len(nfo.Domains) == 0 && len(nfo.IPs) == 0 && len(nfo.URIs) == 0 {
+		return cli.NewExitError("--cn, --dns, --ip or --spiffe-id is required", 10)
+	}
+	esv, closefn, err := offlineserver(c)
+	if err != nil {
+		return err
+	}
+	defer closefn()
+	var key []byte
+	if v := c.String("key-type"); v != "" {
+		kt, err := pkix.ParseKeyType(v)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 10)
+		}
+		key, err = esv.NewKeyWithType(kt)
+	} else {
+		key, err = esv.NewKey()
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	csr, err := pkix.NewCSRPEM(nfo, key, nil)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 10)
+	}
+	chain, err := esv.Issue(offlinerequest(c, csr))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	return writeissued(c, esv, chain, key)
+}
+
+// offlineserver loads the server of the --config and --data-dir flags
+func offlineserver(c *cli.Context) (*embedded.Server, func(), error) {
+	if c.String("config") == "" {
+		return nil, nil, cli.NewExitError("--config is required", 10)
+	}
+	esv, err := embedded.NewWithConfig(context.Background(), clix.ParseContentValue(c.String("config"), true))
+	if err != nil {
+		return nil, nil, cli.NewExitError("invalid config: "+err.Error(), 10)
+	}
+	dir := c.String("data-dir")
+	if dir == "" {
+		return esv, func() {}, nil
+	}
+	st, err := store.OpenBolt(storepath(dir), time.Second)
+	if err != nil {
+		return nil, nil, cli.NewExitError("data dir: "+err.Error(), 11)
+	}
+	esv.Store = st
+	return esv, func() { st.Close() }, nil
+}
+
+func offlinerequest(c *cli.Context, csr []byte) embedded.IssueRequest {
+	return embedded.IssueRequest{
+		CSR:     csr,
+		Profile: c.String("profile"),
+		TTL:     c.Duration("ttl"),
+		Trusted: true,
+	}
+}
+
+// writeissued writes the certificate chain, the root CA (--bundle) and the
+// key (if any) to --out and --key-out
+func writeissued(c *cli.Context, esv *embedded.Server, chain, key []byte) error {
+	out := chain
+	if c.Bool("bundle") {
+		out = append(out, esv.RootCA()...)
+	}
+	if len(key) > 0 {
+		if path := c.String("key-out"); path != "" {
+			if err := ioutil.WriteFile(path, key, 0600); err != nil {
+				return cli.NewExitError(err.Error(), 11)
+			}
+			log.Debug().Str("path", path).Msg("key written")
+		} else {
+			out = append(out, key...)
+		}
+	}
+	path := c.String("out")
+	if path == "" || path == "-" {
+		os.Stdout.Write(out)
+		return nil
+	}
+	mode := os.FileMode(0644)
+	if len(key) > 0 && c.String("key-out") == "" {
+		mode = 0600
+	}
+	if err := ioutil.WriteFile(path, out, mode); err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	log.Debug().Str("path", path).Msg("certificate written")
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gabstv/ztls/embedded"
+	"github.com/gabstv/ztls/embedded/store"
+	"github.com/gabstv/ztls/internal/pkix"
+	"github.com/urfave/cli"
+)
+
+// testconfig writes a config file with a "short" profile (client auth, 1h)
+// and a policy that rejects the names of the tests
+func testconfig(t *testing.T, dir string) (path string, rootcert []byte) {
+	t.Helper()
+	rootkey, err := pkix.NewKeyWithType(pkix.KeyECDSAP256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootcert, err = pkix.NewCACertificateWithInput(pkix.NewCACertificateInput{
+		Key: rootkey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &embedded.Config{
+		Rootkey:  rootkey,
+		Rootcert: rootcert,
+		Apikey:   "test",
+		KeyType:  string(pkix.KeyECDSAP256),
+		Profiles: map[string]*embedded.Profile{
+			"short": {
+				ExtKeyUsage:     []string{"clientAuth"},
+				LifetimeSeconds: 3600,
+			},
+		},
+		Policy: &embedded.Policy{
+			AllowedDns: []string{"*.other.org"},
+		},
+	}
+	path = filepath.Join(dir, "config.pem")
+	if err := ioutil.WriteFile(path, cfg.Marshal(nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, rootcert
+}
+
+// runcommand runs the offline commands of the CLI
+func runcommand(args ...string) error {
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "loglevel, ll",
+			Value: "warn",
+		},
+	}
+	app.Commands = []cli.Command{
+		signcommand(),
+		issuecommand(),
+	}
+	return app.Run(append([]string{"ztls"}, args...))
+}
+
+func readcerts(t *testing.T, path string) []*x509.Certificate {
+	t.Helper()
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certs []*x509.Certificate
+	for {
+		var b *pem.Block
+		if b, raw = pem.Decode(raw); b == nil {
+			break
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
+func filemode(t *testing.T, path string) os.FileMode {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi.Mode().Perm()
+}
+
+// checkrecord checks that the certificate is recorded in the data dir
+func checkrecord(t *testing.T, datadir string, cert *x509.Certificate, profile string) {
+	t.Helper()
+	st, err := store.OpenBolt(storepath(datadir), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	rec, err := st.Get(cert.SerialNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.CommonName != cert.Subject.CommonName || rec.Profile != profile {
+		t.Fatal("unexpected record:", rec.CommonName, rec.Profile)
+	}
+}
+
+func TestIssue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ztls-issue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config, rootcert := testconfig(t, dir)
+	datadir := filepath.Join(dir, "data")
+	if err := os.Mkdir(datadir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	certpath := filepath.Join(dir, "cert.pem")
+	keypath := filepath.Join(dir, "key.pem")
+
+	// the policy doesn't apply, the profile and the TTL do
+	err = runcommand("issue", "--config", config, "--data-dir", datadir,
+		"--cn", "svc.example.org", "--dns", "svc.example.org",
+		"--profile", "short", "--ttl", "30m", "--bundle",
+		"--out", certpath, "--key-out", keypath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := filemode(t, keypath); m != 0600 {
+		t.Fatal("unexpected key file mode:", m)
+	}
+	if m := filemode(t, certpath); m != 0644 {
+		t.Fatal("unexpected certificate file mode:", m)
+	}
+	certs := readcerts(t, certpath)
+	if len(certs) != 2 {
+		t.Fatal("expected the certificate and the root CA, got", len(certs))
+	}
+	if root := readcerts(t, writetemp(t, dir, rootcert))[0]; !root.Equal(certs[1]) {
+		t.Fatal("expected the root CA after the certificate")
+	}
+	leaf := certs[0]
+	if leaf.Subject.CommonName != "svc.example.org" {
+		t.Fatal("unexpected common name:", leaf.Subject.CommonName)
+	}
+	if d := leaf.NotAfter.Sub(time.Now()); d > time.Minute*31 || d < time.Minute*29 {
+		t.Fatal("unexpected lifetime:", d)
+	}
+	if len(leaf.ExtKeyUsage) != 1 || leaf.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Fatal("unexpected ext key usage:", leaf.ExtKeyUsage)
+	}
+	if _, err := tls.LoadX509KeyPair(certpath, keypath); err != nil {
+		t.Fatal(err)
+	}
+	checkrecord(t, datadir, leaf, "short")
+
+	// without --key-out, the key follows the certificate
+	if err := os.Remove(certpath); err != nil {
+		t.Fatal(err)
+	}
+	err = runcommand("issue", "--config", config, "--cn", "svc.example.org", "--out", certpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := filemode(t, certpath); m != 0600 {
+		t.Fatal("unexpected certificate and key file mode:", m)
+	}
+	if _, err := tls.LoadX509KeyPair(certpath, certpath); err != nil {
+		t.Fatal(err)
+	}
+	if certs := readcerts(t, certpath); len(certs) != 1 {
+		t.Fatal("expected the certificate without the root CA, got", len(certs))
+	}
+}
+
+func TestSign(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ztls-sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config, _ := testconfig(t, dir)
+	datadir := filepath.Join(dir, "data")
+	if err := os.Mkdir(datadir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	key, err := pkix.NewKeyWithType(pkix.KeyECDSAP256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := pkix.NewCSRPEM(pkix.CSRInfo{
+		CommonName: "svc.example.org",
+		Domains:    []string{"svc.example.org"},
+	}, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrpath := writetemp(t, dir, csr)
+	certpath := filepath.Join(dir, "cert.pem")
+
+	// default profile
+	err = runcommand("sign", "--config", config, "--data-dir", datadir, "--csr", csrpath, "--out", certpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := filemode(t, certpath); m != 0644 {
+		t.Fatal("unexpected certificate file mode:", m)
+	}
+	certs := readcerts(t, certpath)
+	if len(certs) != 1 {
+		t.Fatal("expected the certificate without the root CA, got", len(certs))
+	}
+	checkrecord(t, datadir, certs[0], embedded.DefaultProfile)
+
+	// --profile and --ttl
+	err = runcommand("sign", "--config", config, "--data-dir", datadir, "--csr", csrpath,
+		"--profile", "short", "--ttl", "10m", "--bundle", "--out", certpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certs = readcerts(t, certpath); len(certs) != 2 {
+		t.Fatal("expected the certificate and the root CA, got", len(certs))
+	}
+	if d := certs[0].NotAfter.Sub(time.Now()); d > time.Minute*11 || d < time.Minute*9 {
+		t.Fatal("unexpected lifetime:", d)
+	}
+	checkrecord(t, datadir, certs[0], "short")
+
+	// the CSR is required
+	exiter := cli.OsExiter
+	defer func() { cli.OsExiter = exiter }()
+	cli.OsExiter = func(int) {}
+	err = runcommand("sign", "--config", config)
+	if ec, ok := err.(cli.ExitCoder); !ok || ec.ExitCode() != 10 {
+		t.Fatal("expected exit code 10, got", err)
+	}
+}
+
+func writetemp(t *testing.T, dir string, data []byte) string {
+	t.Helper()
+	f, err := ioutil.TempFile(dir, "*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gabstv/ztls/embedded"
+	"github.com/gabstv/ztls/internal/clix"
+	"github.com/urfave/cli"
+)
+
+// tokencommand is "token": the bootstrap tokens of a server data directory
+func tokencommand() cli.Command {
+	datadirflag := cli.StringFlag{
+		Name:   "data-dir",
+		EnvVar: "ZTLS_DATA_DIR",
+		Usage:  "Directory of the persisted server data (same as serve --data-dir)",
+	}
+	return cli.Command{
+		Name:  "token",
+		Usage: "manage the bootstrap tokens (one-time enrollment tokens) of a server data directory",
+		Subcommands: []cli.Command{
+			cli.Command{
+				Name:        "new",
+				Usage:       "create a bootstrap token and print it",
+				Description: "create a token that authorizes certificate requests for a fixed identity (header X-BOOTSTRAP-TOKEN, or easycert --token) instead of the API key",
+				Action:      cmdtokennew,
+				Flags: []cli.Flag{
+					datadirflag,
+					cli.StringFlag{
+						Name:   "config",
+						EnvVar: "ZTLS_CONFIG",
+						Usage:  "The server configuration, used to validate --profile (default: built-in profiles only). " + clix.ContentUsage(),
+					},
+					cli.StringFlag{
+						Name:  "cn",
+						Usage: "Common name of the certificates",
+					},
+					cli.StringSliceFlag{
+						Name:  "name",
+						Usage: "Additional DNS name or IP address of the certificates (can be repeated)",
+					},
+					cli.StringFlag{
+						Name:  "profile",
+						Usage: "Certificate profile (default: the server default profile)",
+					},
+					cli.IntFlag{
+						Name:  "uses",
+						Usage: "Number of certificate requests authorized by the token",
+						Value: 1,
+					},
+					cli.DurationFlag{
+						Name:  "ttl",
+						Usage: "Lifetime of the token",
+						Value: embedded.DefaultTokenTTL,
+					},
+				},
+			},
+			cli.Command{
+				Name:   "list",
+				Usage:  "list the bootstrap tokens",
+				Action: cmdtokenlist,
+				Flags: []cli.Flag{
+					datadirflag,
+				},
+			},
+			cli.Command{
+				Name:   "revoke",
+				Usage:  "delete a bootstrap token",
+				Action: cmdtokenrevoke,
+				Flags: []cli.Flag{
+					datadirflag,
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "Id of the token",
+					},
+				},
+			},
+		},
+	}
+}
+
+func opentokens(c *cli.Context) (embedded.TokenList, error) {
+	dir := c.String("data-dir")
+	if dir == "" {
+		return nil, cli.NewExitError("--data-dir is required", 10)
+	}
+	tl, err := embedded.OpenFileTokenList(tokenspath(dir))
+	if err != nil {
+		return nil, cli.NewExitError(err.Error(), 11)
+	}
+	return tl, nil
+}
+
+func cmdtokennew(c *cli.Context) error {
+	logsetup(c)
+	tl, err := opentokens(c)
+	if err != nil {
+		return err
+	}
+	cfg := &embedded.Config{}
+	if v := c.String("config"); v != "" {
+		if cfg, err = embedded.UnmarshalConfig(clix.ParseContentValue(v, true)); err != nil {
+			return cli.NewExitError("invalid config: "+err.Error(), 10)
+		}
+	}
+	if c.String("cn") == "" {
+		return cli.NewExitError("--cn is required", 10)
+	}
+	esv := embedded.New(context.Background(), cfg)
+	esv.Tokens = tl
+	t, value, err := esv.NewToken(embedded.TokenRequest{
+		CommonName: c.String("cn"),
+		Names:      c.StringSlice("name"),
+		Profile:    c.String("profile"),
+		Uses:       c.Int("uses"),
+		TTL:        c.Duration("ttl"),
+	})
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	println("token " + t.ID + " expires at " + t.ExpiresAt.Format(time.RFC3339) + ":")
+	fmt.Println(value)
+	return nil
+}
+
+func cmdtokenlist(c *cli.Context) error {
+	logsetup(c)
+	tl, err := opentokens(c)
+	if err != nil {
+		return err
+	}
+	list, err := tl.List()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCN\tNAMES\tPROFILE\tUSES LEFT\tEXPIRES\tAPI KEY")
+	for _, t := range list {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", t.ID, t.CommonName, strings.Join(t.Names, ","),
+			t.Profile, t.UsesLeft, t.ExpiresAt.Format(time.RFC3339), t.APIKeyID)
+	}
+	return w.Flush()
+}
+
+func cmdtokenrevoke(c *cli.Context) error {
+	logsetup(c)
+	tl, err := opentokens(c)
+	if err != nil {
+		return err
+	}
+	id := c.String("id")
+	if _, ok, err := tl.Get(id); err != nil || !ok {
+		return cli.NewExitError("token "+id+" not found", 10)
+	}
+	if err := tl.Delete(id); err != nil {
+		return cli.NewExitError(err.Error(), 11)
+	}
+	println("token " + id + " revoked")
+	return nil
+}
@@ -0,0 +1,239 @@
+// Package acme implements an ACME (RFC 8555) server backed by the ztls
+// issuance, so standard clients (certbot, Caddy, Traefik, cert-manager) can
+// request certificates.
+package acme
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ReneKroon/ttlcache"
+	echo "github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultPath is the path where the ACME endpoints are mounted
+const DefaultPath = "/acme"
+
+const (
+	nonceTTL      = time.Hour
+	orderLifetime = time.Hour * 24 * 7
+)
+
+// CertRequest is a certificate request of a finalized order
+type CertRequest struct {
+	CSR       []byte // PEM encoded
+	AccountID string
+	RemoteIP  string
+	Profile   string
+	NotAfter  time.Time // zero = default lifetime
+}
+
+// IssueFunc signs a certificate request, returning the PEM encoded
+// certificate followed by the intermediate certificates
+type IssueFunc func(req CertRequest) (chain []byte, err error)
+
+// RevokeFunc revokes a certificate (reason is a RFC 5280 CRL reason code)
+type RevokeFunc func(serial *big.Int, reason int) error
+
+type Config struct {
+	// Issue signs the certificates [REQUIRED]
+	Issue IssueFunc
+	// Revoke enables the revokeCert endpoint [OPTIONAL]
+	Revoke RevokeFunc
+	// Storage keeps the accounts, orders and certificates (default: memory)
+	Storage Storage
+	// BaseURL is the public URL of the server, e.g. https://ca.example.com
+	// (default: derived from the request)
+	BaseURL string
+	// Path is where the endpoints are mounted (default: DefaultPath)
+	Path string
+	// Resolver is the DNS server (host:port) used to validate dns-01
+	// challenges (default: the system resolver)
+	Resolver string
+	// AutoApprove are the names authorized without validation:
+	// "example.internal" matches the name, "*.example.internal" matches any
+	// subdomain
+	AutoApprove []string
+	// Profile is the certificate profile of the orders
+	Profile string
+	// HTTPPort is the port used to validate http-01 challenges (default: 80)
+	HTTPPort int
+}
+
+// Server is an ACME server
+type Server struct {
+	cfg      Config
+	l        sync.Mutex // serializes the state changes
+	nonces   *ttlcache.Cache
+	resolver *net.Resolver
+	httpc    *http.Client
+}
+
+// New creates an ACME server
+func New(cfg Config) *Server {
+	if cfg.Storage == nil {
+		cfg.Storage = NewMemoryStorage()
+	}
+	if cfg.Path == "" {
+		cfg.Path = DefaultPath
+	}
+	if cfg.HTTPPort == 0 {
+		cfg.HTTPPort = 80
+	}
+	s := &Server{
+		cfg:      cfg,
+		nonces:   ttlcache.NewCache(),
+		resolver: net.DefaultResolver,
+		httpc: &http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+	s.nonces.SkipTtlExtensionOnHit(true)
+	s.nonces.SetTTL(nonceTTL)
+	if cfg.Resolver != "" {
+		s.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, cfg.Resolver)
+			},
+		}
+	}
+	return s
+}
+
+// Register mounts the ACME endpoints on Config.Path
+func (s *Server) Register(e *echo.Echo) {
+	g := e.Group(s.cfg.Path)
+	g.GET("/directory", s.directory)
+	g.HEAD("/new-nonce", s.newnonce)
+	g.GET("/new-nonce", s.newnonce)
+	g.POST("/new-account", s.post(authJWK, s.newaccount))
+	g.POST("/new-order", s.post(authKID, s.neworder))
+	g.POST("/acct/:id", s.post(authKID, s.getaccount))
+	g.POST("/order/:id", s.post(authKID, s.getorder))
+	g.POST("/order/:id/finalize", s.post(authKID, s.finalize))
+	g.POST("/authz/:id", s.post(authKID, s.getauthz))
+	g.POST("/chall/:id/:type", s.post(authKID, s.challenge))
+	g.POST("/cert/:id", s.post(authKID, s.getcert))
+	if s.cfg.Revoke != nil {
+		g.POST("/revoke-cert", s.post(authAny, s.revokecert))
+	}
+}
+
+// url returns the absolute URL of an endpoint
+func (s *Server) url(c echo.Context, parts ...string) string {
+	base := strings.TrimSuffix(s.cfg.BaseURL, "/")
+	if base == "" {
+		base = c.Scheme() + "://" + c.Request().Host
+	}
+	return base + s.cfg.Path + "/" + strings.Join(parts, "/")
+}
+
+func (s *Server) directory(c echo.Context) error {
+	d := map[string]interface{}{
+		"newNonce":   s.url(c, "new-nonce"),
+		"newAccount": s.url(c, "new-account"),
+		"newOrder":   s.url(c, "new-order"),
+	}
+	if s.cfg.Revoke != nil {
+		d["revokeCert"] = s.url(c, "revoke-cert")
+	}
+	return c.JSON(200, d)
+}
+
+func (s *Server) newnonce(c echo.Context) error {
+	s.headers(c)
+	if c.Request().Method == http.MethodHead {
+		return c.NoContent(200)
+	}
+	return c.NoContent(204)
+}
+
+// headers sets the headers of every ACME response
+func (s *Server) headers(c echo.Context) {
+	h := c.Response().Header()
+	h.Set("Replay-Nonce", s.nonce())
+	h.Set("Cache-Control", "no-store")
+	h.Add("Link", "<"+s.url(c, "directory")+">;rel=\"index\"")
+}
+
+func (s *Server) nonce() string {
+	v := randomid()
+	s.nonces.Set(v, true)
+	return v
+}
+
+// usenonce consumes a nonce
+func (s *Server) usenonce(v string) bool {
+	if _, ok := s.nonces.Get(v); !ok {
+		return false
+	}
+	return s.nonces.Remove(v)
+}
+
+// randomid returns a random base64url string (128 bits)
+func randomid() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// problem is an ACME error (RFC 8555, section 6.7)
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+func (p *problem) Error() string {
+	return p.Type + ": " + p.Detail
+}
+
+func newproblem(status int, typ, detail string) *problem {
+	return &problem{
+		Type:   "urn:ietf:params:acme:error:" + typ,
+		Detail: detail,
+		Status: status,
+	}
+}
+
+// Rejected returns an error that IssueFunc can use to reject a request
+// (e.g. because of the issuance policy)
+func Rejected(detail string) error {
+	return newproblem(403, "rejectedIdentifier", detail)
+}
+
+func malformed(detail string) *problem {
+	return newproblem(400, "malformed", detail)
+}
+
+func unauthorized(detail string) *problem {
+	return newproblem(403, "unauthorized", detail)
+}
+
+func notfound() *problem {
+	return newproblem(404, "malformed", "not found")
+}
+
+// fail writes an error response
+func (s *Server) fail(c echo.Context, err error) error {
+	p, ok := err.(*problem)
+	if !ok {
+		log.Error().Err(err).Str("path", c.Path()).Msg("ACME internal error")
+		p = newproblem(500, "serverInternal", "internal error")
+	}
+	raw, _ := json.Marshal(p)
+	return c.Blob(p.Status, "application/problem+json", raw)
+}
@@ -0,0 +1,469 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+func (s *Server) newaccount(c echo.Context, req *request) error {
+	var p struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.payload, &p); err != nil {
+		return malformed("invalid payload")
+	}
+	id := req.key.thumbprint()
+	s.l.Lock()
+	defer s.l.Unlock()
+	acct, err := s.loadaccount(id)
+	if err == nil {
+		c.Response().Header().Set("Location", s.url(c, "acct", id))
+		return c.JSON(200, s.accountjson(acct))
+	}
+	if err != ErrNotFound {
+		return err
+	}
+	if p.OnlyReturnExisting {
+		return newproblem(400, "accountDoesNotExist", "unknown account")
+	}
+	if err := checkcontact(p.Contact); err != nil {
+		return err
+	}
+	acct = &account{
+		ID:        id,
+		Status:    statusValid,
+		Contact:   p.Contact,
+		Key:       req.key,
+		CreatedAt: time.Now(),
+	}
+	if err := s.cfg.Storage.Put(kindAccount, id, acct); err != nil {
+		return err
+	}
+	c.Response().Header().Set("Location", s.url(c, "acct", id))
+	return c.JSON(201, s.accountjson(acct))
+}
+
+func checkcontact(contact []string) error {
+	for _, v := range contact {
+		if !strings.HasPrefix(v, "mailto:") {
+			return newproblem(400, "unsupportedContact", "only mailto: contacts are supported")
+		}
+	}
+	return nil
+}
+
+func (s *Server) getaccount(c echo.Context, req *request) error {
+	if c.Param("id") != req.account.ID {
+		return unauthorized("the account URL doesn't match the key")
+	}
+	acct := req.account
+	if !req.postasget() {
+		var p struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &p); err != nil {
+			return malformed("invalid payload")
+		}
+		if p.Status != "" && p.Status != statusDeactivated {
+			return malformed("invalid status: " + p.Status)
+		}
+		if err := checkcontact(p.Contact); err != nil {
+			return err
+		}
+		s.l.Lock()
+		defer s.l.Unlock()
+		if p.Contact != nil {
+			acct.Contact = p.Contact
+		}
+		if p.Status != "" {
+			acct.Status = p.Status
+		}
+		if err := s.cfg.Storage.Put(kindAccount, acct.ID, acct); err != nil {
+			return err
+		}
+	}
+	return c.JSON(200, s.accountjson(acct))
+}
+
+func (s *Server) neworder(c echo.Context, req *request) error {
+	var p struct {
+		Identifiers []identifier `json:"identifiers"`
+		NotAfter    string       `json:"notAfter"`
+	}
+	if err := json.Unmarshal(req.payload, &p); err != nil {
+		return malformed("invalid payload")
+	}
+	if len(p.Identifiers) == 0 {
+		return malformed("no identifiers")
+	}
+	now := time.Now()
+	o := &order{
+		ID:        randomid(),
+		AccountID: req.account.ID,
+		Status:    statusPending,
+		Expires:   now.Add(orderLifetime),
+	}
+	if p.NotAfter != "" {
+		t, err := time.Parse(time.RFC3339, p.NotAfter)
+		if err != nil || !t.After(now) {
+			return malformed("invalid notAfter")
+		}
+		o.NotAfter = t
+	}
+	seen := make(map[string]bool)
+	for _, v := range p.Identifiers {
+		name := strings.ToLower(strings.TrimSuffix(v.Value, "."))
+		if v.Type != "dns" {
+			return newproblem(400, "unsupportedIdentifier", "unsupported identifier type: "+v.Type)
+		}
+		if !validname(name) {
+			return newproblem(400, "rejectedIdentifier", "invalid DNS name: "+v.Value)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		o.Identifiers = append(o.Identifiers, identifier{Type: "dns", Value: name})
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	for _, v := range o.Identifiers {
+		a := s.newauthz(req.account, v, o.Expires)
+		if err := s.cfg.Storage.Put(kindAuthz, a.ID, a); err != nil {
+			return err
+		}
+		o.Authorizations = append(o.Authorizations, a.ID)
+	}
+	if err := s.cfg.Storage.Put(kindOrder, o.ID, o); err != nil {
+		return err
+	}
+	o, err := s.loadorder(req.account, o.ID)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("Location", s.url(c, "order", o.ID))
+	return c.JSON(201, s.orderjson(c, o))
+}
+
+// validname reports whether a name is a valid DNS name (a wildcard is only
+// allowed as the first label)
+func validname(name string) bool {
+	if strings.HasPrefix(name, "*.") {
+		name = name[2:]
+	}
+	if name == "" || len(name) > 253 || net.ParseIP(name) != nil {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// newauthz creates the authorization of an identifier. Auto approved names
+// are valid right away.
+func (s *Server) newauthz(acct *account, id identifier, expires time.Time) *authz {
+	a := &authz{
+		ID:         randomid(),
+		AccountID:  acct.ID,
+		Status:     statusPending,
+		Expires:    expires,
+		Identifier: id,
+	}
+	types := []string{"http-01", "dns-01"}
+	if strings.HasPrefix(id.Value, "*.") {
+		// wildcard names can only be validated by dns-01
+		a.Wildcard = true
+		a.Identifier.Value = id.Value[2:]
+		types = []string{"dns-01"}
+	}
+	for _, typ := range types {
+		a.Challenges = append(a.Challenges, challenge{
+			Type:   typ,
+			Status: statusPending,
+			Token:  randomid() + randomid(),
+		})
+	}
+	if s.autoapprove(id.Value) {
+		a.Status = statusValid
+		a.Challenges = a.Challenges[:1]
+		a.Challenges[0].Status = statusValid
+		a.Challenges[0].Validated = time.Now()
+	}
+	return a
+}
+
+func (s *Server) autoapprove(name string) bool {
+	for _, pattern := range s.cfg.AutoApprove {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if strings.HasPrefix(pattern, "*.") {
+			suffix := pattern[1:]
+			if len(name) > len(suffix) && strings.HasSuffix(name, suffix) {
+				return true
+			}
+		} else if pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) getorder(c echo.Context, req *request) error {
+	s.l.Lock()
+	o, err := s.loadorder(req.account, c.Param("id"))
+	s.l.Unlock()
+	if err != nil {
+		return err
+	}
+	return c.JSON(200, s.orderjson(c, o))
+}
+
+func (s *Server) getauthz(c echo.Context, req *request) error {
+	a, err := s.loadauthz(req.account, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	if !req.postasget() {
+		var p struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &p); err != nil || p.Status != statusDeactivated {
+			return malformed("invalid payload")
+		}
+		s.l.Lock()
+		defer s.l.Unlock()
+		a.Status = statusDeactivated
+		if err := s.cfg.Storage.Put(kindAuthz, a.ID, a); err != nil {
+			return err
+		}
+	}
+	return c.JSON(200, s.authzjson(c, a))
+}
+
+func (s *Server) challenge(c echo.Context, req *request) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	a, err := s.loadauthz(req.account, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	var ch *challenge
+	for i := range a.Challenges {
+		if a.Challenges[i].Type == c.Param("type") {
+			ch = &a.Challenges[i]
+		}
+	}
+	if ch == nil {
+		return notfound()
+	}
+	// an empty object starts the validation, POST-as-GET returns the
+	// challenge
+	if !req.postasget() && ch.Status == statusPending && a.Status == statusPending {
+		ch.Status = statusProcessing
+		if err := s.cfg.Storage.Put(kindAuthz, a.ID, a); err != nil {
+			return err
+		}
+		go s.validate(*a, ch.Type, req.account.Key.thumbprint())
+	}
+	c.Response().Header().Add("Link", "<"+s.url(c, "authz", a.ID)+">;rel=\"up\"")
+	return c.JSON(200, s.challengejson(c, a, ch))
+}
+
+func (s *Server) finalize(c echo.Context, req *request) error {
+	var p struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.payload, &p); err != nil {
+		return malformed("invalid payload")
+	}
+	der, err := base64.RawURLEncoding.DecodeString(p.CSR)
+	if err != nil {
+		return newproblem(400, "badCSR", "invalid CSR encoding")
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return newproblem(400, "badCSR", err.Error())
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return newproblem(400, "badCSR", err.Error())
+	}
+
+	s.l.Lock()
+	o, err := s.loadorder(req.account, c.Param("id"))
+	if err == nil && o.Status != statusReady {
+		err = newproblem(403, "orderNotReady", "the order is "+o.Status)
+	}
+	if err == nil {
+		err = checkcsrnames(csr, o.Identifiers)
+	}
+	if err == nil {
+		o.Status = statusProcessing
+		err = s.cfg.Storage.Put(kindOrder, o.ID, o)
+	}
+	s.l.Unlock()
+	if err != nil {
+		return err
+	}
+
+	chain, err := s.cfg.Issue(CertRequest{
+		CSR: pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE REQUEST",
+			Bytes: der,
+		}),
+		AccountID: req.account.ID,
+		RemoteIP:  c.RealIP(),
+		Profile:   s.cfg.Profile,
+		NotAfter:  o.NotAfter,
+	})
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	if err == nil {
+		err = s.savecert(o, req.account, chain)
+	}
+	if err != nil {
+		o.Status = statusInvalid
+		if p, ok := err.(*problem); ok {
+			o.Error = p
+		} else {
+			o.Error = newproblem(500, "serverInternal", "the certificate could not be issued")
+		}
+		if perr := s.cfg.Storage.Put(kindOrder, o.ID, o); perr != nil {
+			return perr
+		}
+		return err
+	}
+	c.Response().Header().Set("Location", s.url(c, "order", o.ID))
+	return c.JSON(200, s.orderjson(c, o))
+}
+
+// savecert stores the certificate of an order and marks it as valid
+func (s *Server) savecert(o *order, acct *account, chain []byte) error {
+	blk, _ := pem.Decode(chain)
+	if blk == nil {
+		return malformed("invalid certificate")
+	}
+	cert, err := x509.ParseCertificate(blk.Bytes)
+	if err != nil {
+		return err
+	}
+	id := cert.SerialNumber.String()
+	if err := s.cfg.Storage.Put(kindCert, id, &certificate{
+		AccountID: acct.ID,
+		Chain:     string(chain),
+	}); err != nil {
+		return err
+	}
+	o.Status = statusValid
+	o.Certificate = id
+	return s.cfg.Storage.Put(kindOrder, o.ID, o)
+}
+
+// checkcsrnames checks that the CSR requests exactly the names of the order
+func checkcsrnames(csr *x509.CertificateRequest, ids []identifier) error {
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return newproblem(400, "badCSR", "the CSR can only contain DNS names")
+	}
+	names := make(map[string]bool)
+	for _, v := range csr.DNSNames {
+		names[strings.ToLower(v)] = true
+	}
+	if cn := csr.Subject.CommonName; cn != "" {
+		names[strings.ToLower(cn)] = true
+	}
+	want := make([]string, 0, len(ids))
+	for _, v := range ids {
+		want = append(want, v.Value)
+	}
+	got := make([]string, 0, len(names))
+	for k := range names {
+		got = append(got, k)
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	if strings.Join(want, ",") != strings.Join(got, ",") {
+		return newproblem(400, "badCSR", "the CSR names don't match the order identifiers")
+	}
+	return nil
+}
+
+func (s *Server) getcert(c echo.Context, req *request) error {
+	cert := &certificate{}
+	if err := s.cfg.Storage.Get(kindCert, c.Param("id"), cert); err == ErrNotFound {
+		return notfound()
+	} else if err != nil {
+		return err
+	}
+	if cert.AccountID != req.account.ID {
+		return unauthorized("the certificate belongs to another account")
+	}
+	return c.Blob(200, "application/pem-certificate-chain", []byte(cert.Chain))
+}
+
+func (s *Server) revokecert(c echo.Context, req *request) error {
+	var p struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	if err := json.Unmarshal(req.payload, &p); err != nil {
+		return malformed("invalid payload")
+	}
+	if p.Reason < 0 || p.Reason > 10 || p.Reason == 7 {
+		return newproblem(400, "badRevocationReason", "invalid reason")
+	}
+	der, err := base64.RawURLEncoding.DecodeString(p.Certificate)
+	if err != nil {
+		return malformed("invalid certificate encoding")
+	}
+	x, err := x509.ParseCertificate(der)
+	if err != nil {
+		return malformed(err.Error())
+	}
+	cert := &certificate{}
+	if err := s.cfg.Storage.Get(kindCert, x.SerialNumber.String(), cert); err == ErrNotFound {
+		return notfound()
+	} else if err != nil {
+		return err
+	}
+	if blk, _ := pem.Decode([]byte(cert.Chain)); blk == nil || string(blk.Bytes) != string(der) {
+		return notfound()
+	}
+	if req.account != nil && req.account.ID != cert.AccountID {
+		return unauthorized("the certificate belongs to another account")
+	}
+	if req.key != nil {
+		// signed by the key of the certificate
+		pub, err := req.key.publickey()
+		if err != nil {
+			return err
+		}
+		k, ok := x.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+		if !ok || !k.Equal(pub) {
+			return unauthorized("the request is not signed by the certificate key")
+		}
+	}
+	if err := s.cfg.Revoke(new(big.Int).Set(x.SerialNumber), p.Reason); err != nil {
+		return err
+	}
+	return c.NoContent(200)
+}
@@ -0,0 +1,268 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"path"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// jwk is a public JSON Web Key (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+func b64int(v string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid JWK parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publickey returns the public key of the JWK
+func (k *jwk) publickey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64int(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64int(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31 || n.BitLen() < 2048 {
+			return nil, fmt.Errorf("unsupported RSA key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %v", k.Crv)
+		}
+		x, err := b64int(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64int(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || k.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("unsupported OKP key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %v", k.Kty)
+}
+
+// thumbprint returns the JWK thumbprint (RFC 7638), base64url encoded
+func (k *jwk) thumbprint() string {
+	var v string
+	switch k.Kty {
+	case "RSA":
+		v = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, k.E, k.N)
+	case "EC":
+		v = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, k.Crv, k.X, k.Y)
+	default:
+		v = fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s"}`, k.Crv, k.Kty, k.X)
+	}
+	h := sha256.Sum256([]byte(v))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// verifysig verifies a JWS signature
+func verifysig(alg string, pub crypto.PublicKey, input, sig []byte) bool {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return false
+		}
+		h := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) == nil
+	case *ecdsa.PublicKey:
+		var digest []byte
+		switch {
+		case alg == "ES256" && k.Curve == elliptic.P256():
+			h := sha256.Sum256(input)
+			digest = h[:]
+		case alg == "ES384" && k.Curve == elliptic.P384():
+			h := sha512.Sum384(input)
+			digest = h[:]
+		case alg == "ES512" && k.Curve == elliptic.P521():
+			h := sha512.Sum512(input)
+			digest = h[:]
+		default:
+			return false
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(k, input, sig)
+	}
+	return false
+}
+
+type authMode int
+
+const (
+	authKID authMode = iota // signed by an account key (kid)
+	authJWK                 // signed by the embedded key (jwk)
+	authAny
+)
+
+// request is a verified JWS request
+type request struct {
+	payload []byte
+	account *account // set if the request is signed with kid
+	key     *jwk     // set if the request is signed with jwk
+}
+
+// postasget reports whether the request has an empty payload
+func (r *request) postasget() bool {
+	return len(r.payload) == 0
+}
+
+type jwsmessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+type jwsheader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+	JWK   json.RawMessage `json:"jwk"`
+	KID   string          `json:"kid"`
+}
+
+type postfunc func(c echo.Context, req *request) error
+
+// post verifies the JWS of a request (RFC 8555, section 6.2) before calling
+// fn
+func (s *Server) post(mode authMode, fn postfunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		s.headers(c)
+		req, err := s.verify(c, mode)
+		if err != nil {
+			return s.fail(c, err)
+		}
+		if err := fn(c, req); err != nil {
+			return s.fail(c, err)
+		}
+		return nil
+	}
+}
+
+func (s *Server) verify(c echo.Context, mode authMode) (*request, error) {
+	if ct := c.Request().Header.Get("Content-Type"); ct != "application/jose+json" {
+		return nil, newproblem(415, "malformed", "invalid content type: "+ct)
+	}
+	raw, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, malformed(err.Error())
+	}
+	var msg jwsmessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return nil, malformed("invalid JWS")
+	}
+	hraw, err := base64.RawURLEncoding.DecodeString(msg.Protected)
+	if err != nil {
+		return nil, malformed("invalid protected header")
+	}
+	var hdr jwsheader
+	if err := json.Unmarshal(hraw, &hdr); err != nil {
+		return nil, malformed("invalid protected header")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(msg.Payload)
+	if err != nil {
+		return nil, malformed("invalid payload")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(msg.Signature)
+	if err != nil {
+		return nil, malformed("invalid signature")
+	}
+	if !s.usenonce(hdr.Nonce) {
+		return nil, newproblem(400, "badNonce", "invalid or expired nonce")
+	}
+	if u, err := url.Parse(hdr.URL); err != nil || u.Path != c.Request().URL.Path {
+		return nil, unauthorized("the url header doesn't match the request")
+	}
+	req := &request{
+		payload: payload,
+	}
+	var pub crypto.PublicKey
+	switch {
+	case len(hdr.JWK) > 0 && hdr.KID == "" && mode != authKID:
+		req.key = &jwk{}
+		if err := json.Unmarshal(hdr.JWK, req.key); err != nil {
+			return nil, malformed("invalid jwk")
+		}
+		if pub, err = req.key.publickey(); err != nil {
+			return nil, newproblem(400, "badPublicKey", err.Error())
+		}
+	case hdr.KID != "" && len(hdr.JWK) == 0 && mode != authJWK:
+		acct, err := s.loadaccount(path.Base(hdr.KID))
+		if err == ErrNotFound {
+			return nil, newproblem(400, "accountDoesNotExist", "unknown account")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if acct.Status != statusValid {
+			return nil, unauthorized("the account is " + acct.Status)
+		}
+		if pub, err = acct.Key.publickey(); err != nil {
+			return nil, err
+		}
+		req.account = acct
+	default:
+		return nil, malformed("the request must be signed with either jwk or kid")
+	}
+	switch hdr.Alg {
+	case "RS256", "ES256", "ES384", "ES512", "EdDSA":
+	default:
+		return nil, newproblem(400, "badSignatureAlgorithm", "unsupported algorithm: "+hdr.Alg)
+	}
+	if !verifysig(hdr.Alg, pub, []byte(msg.Protected+"."+msg.Payload), sig) {
+		return nil, malformed("invalid signature")
+	}
+	return req, nil
+}
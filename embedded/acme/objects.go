@@ -0,0 +1,231 @@
+package acme
+
+import (
+	"time"
+
+	echo "github.com/labstack/echo/v4"
+)
+
+// object statuses (RFC 8555, section 7.1.6)
+const (
+	statusPending     = "pending"
+	statusReady       = "ready"
+	statusProcessing  = "processing"
+	statusValid       = "valid"
+	statusInvalid     = "invalid"
+	statusDeactivated = "deactivated"
+)
+
+// storage kinds
+const (
+	kindAccount = "account"
+	kindOrder   = "order"
+	kindAuthz   = "authz"
+	kindCert    = "cert"
+)
+
+type account struct {
+	ID        string    `json:"id"` // JWK thumbprint
+	Status    string    `json:"status"`
+	Contact   []string  `json:"contact,omitempty"`
+	Key       *jwk      `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	ID             string       `json:"id"`
+	AccountID      string       `json:"account_id"`
+	Status         string       `json:"status"`
+	Expires        time.Time    `json:"expires"`
+	Identifiers    []identifier `json:"identifiers"`
+	NotAfter       time.Time    `json:"not_after"`
+	Authorizations []string     `json:"authorizations"`
+	Certificate    string       `json:"certificate,omitempty"`
+	Error          *problem     `json:"error,omitempty"`
+}
+
+type authz struct {
+	ID         string      `json:"id"`
+	AccountID  string      `json:"account_id"`
+	Status     string      `json:"status"`
+	Expires    time.Time   `json:"expires"`
+	Identifier identifier  `json:"identifier"`
+	Wildcard   bool        `json:"wildcard,omitempty"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type      string    `json:"type"`
+	Status    string    `json:"status"`
+	Token     string    `json:"token"`
+	Validated time.Time `json:"validated"`
+	Error     *problem  `json:"error,omitempty"`
+}
+
+type certificate struct {
+	AccountID string `json:"account_id"`
+	Chain     string `json:"chain"` // PEM
+}
+
+func rfc3339(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+type accountJSON struct {
+	Status  string   `json:"status"`
+	Contact []string `json:"contact,omitempty"`
+}
+
+func (s *Server) accountjson(a *account) accountJSON {
+	return accountJSON{
+		Status:  a.Status,
+		Contact: a.Contact,
+	}
+}
+
+type orderJSON struct {
+	Status         string       `json:"status"`
+	Expires        string       `json:"expires"`
+	Identifiers    []identifier `json:"identifiers"`
+	NotAfter       string       `json:"notAfter,omitempty"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+	Error          *problem     `json:"error,omitempty"`
+}
+
+func (s *Server) orderjson(c echo.Context, o *order) orderJSON {
+	v := orderJSON{
+		Status:         o.Status,
+		Expires:        rfc3339(o.Expires),
+		Identifiers:    o.Identifiers,
+		NotAfter:       rfc3339(o.NotAfter),
+		Authorizations: make([]string, 0, len(o.Authorizations)),
+		Finalize:       s.url(c, "order", o.ID, "finalize"),
+		Error:          o.Error,
+	}
+	for _, id := range o.Authorizations {
+		v.Authorizations = append(v.Authorizations, s.url(c, "authz", id))
+	}
+	if o.Certificate != "" {
+		v.Certificate = s.url(c, "cert", o.Certificate)
+	}
+	return v
+}
+
+type challengeJSON struct {
+	Type      string   `json:"type"`
+	URL       string   `json:"url"`
+	Status    string   `json:"status"`
+	Token     string   `json:"token"`
+	Validated string   `json:"validated,omitempty"`
+	Error     *problem `json:"error,omitempty"`
+}
+
+func (s *Server) challengejson(c echo.Context, a *authz, ch *challenge) challengeJSON {
+	return challengeJSON{
+		Type:      ch.Type,
+		URL:       s.url(c, "chall", a.ID, ch.Type),
+		Status:    ch.Status,
+		Token:     ch.Token,
+		Validated: rfc3339(ch.Validated),
+		Error:     ch.Error,
+	}
+}
+
+type authzJSON struct {
+	Identifier identifier      `json:"identifier"`
+	Status     string          `json:"status"`
+	Expires    string          `json:"expires"`
+	Challenges []challengeJSON `json:"challenges"`
+	Wildcard   bool            `json:"wildcard,omitempty"`
+}
+
+func (s *Server) authzjson(c echo.Context, a *authz) authzJSON {
+	v := authzJSON{
+		Identifier: a.Identifier,
+		Status:     a.Status,
+		Expires:    rfc3339(a.Expires),
+		Challenges: make([]challengeJSON, 0, len(a.Challenges)),
+		Wildcard:   a.Wildcard,
+	}
+	for i := range a.Challenges {
+		v.Challenges = append(v.Challenges, s.challengejson(c, a, &a.Challenges[i]))
+	}
+	return v
+}
+
+func (s *Server) loadaccount(id string) (*account, error) {
+	a := &account{}
+	if err := s.cfg.Storage.Get(kindAccount, id, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// loadorder returns an order of the account, updating its status from the
+// status of the authorizations
+func (s *Server) loadorder(acct *account, id string) (*order, error) {
+	o := &order{}
+	if err := s.cfg.Storage.Get(kindOrder, id, o); err == ErrNotFound {
+		return nil, notfound()
+	} else if err != nil {
+		return nil, err
+	}
+	if o.AccountID != acct.ID {
+		return nil, unauthorized("the order belongs to another account")
+	}
+	if o.Status != statusPending {
+		return o, nil
+	}
+	status := statusReady
+	if time.Now().After(o.Expires) {
+		status = statusInvalid
+	}
+	for _, id := range o.Authorizations {
+		a, err := s.loadauthz(acct, id)
+		if err != nil {
+			return nil, err
+		}
+		switch a.Status {
+		case statusValid:
+		case statusPending:
+			if status == statusReady {
+				status = statusPending
+			}
+		default:
+			status = statusInvalid
+		}
+	}
+	if status != o.Status {
+		o.Status = status
+		if err := s.cfg.Storage.Put(kindOrder, o.ID, o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+func (s *Server) loadauthz(acct *account, id string) (*authz, error) {
+	a := &authz{}
+	if err := s.cfg.Storage.Get(kindAuthz, id, a); err == ErrNotFound {
+		return nil, notfound()
+	} else if err != nil {
+		return nil, err
+	}
+	if a.AccountID != acct.ID {
+		return nil, unauthorized("the authorization belongs to another account")
+	}
+	if a.Status == statusPending && time.Now().After(a.Expires) {
+		a.Status = statusInvalid
+	}
+	return a, nil
+}
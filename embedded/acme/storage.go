@@ -0,0 +1,116 @@
+package acme
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNotFound is returned by Storage.Get when there is no object
+var ErrNotFound = errors.New("acme: not found")
+
+// Storage persists the ACME objects (accounts, orders, authorizations and
+// certificates) as JSON
+type Storage interface {
+	// Get decodes the object of a kind and id into v (ErrNotFound if it
+	// doesn't exist)
+	Get(kind, id string, v interface{}) error
+	// Put saves an object
+	Put(kind, id string, v interface{}) error
+	Close() error
+}
+
+// NewMemoryStorage creates an in-memory (not persisted) Storage
+func NewMemoryStorage() Storage {
+	return &memStorage{
+		m: make(map[string][]byte),
+	}
+}
+
+type memStorage struct {
+	l sync.RWMutex
+	m map[string][]byte
+}
+
+func (s *memStorage) Get(kind, id string, v interface{}) error {
+	s.l.RLock()
+	raw, ok := s.m[kind+"/"+id]
+	s.l.RUnlock()
+	if !ok {
+		return ErrNotFound
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func (s *memStorage) Put(kind, id string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.l.Lock()
+	s.m[kind+"/"+id] = raw
+	s.l.Unlock()
+	return nil
+}
+
+func (s *memStorage) Close() error {
+	return nil
+}
+
+// OpenBoltStorage opens (or creates) a Storage persisted in a BoltDB file.
+// It fails after timeout if another process holds the file lock (0 waits
+// forever).
+func OpenBoltStorage(path string, timeout time.Duration) (Storage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		if err == bolt.ErrTimeout {
+			return nil, errors.New("acme: " + path + " is locked by another process")
+		}
+		return nil, err
+	}
+	return &boltStorage{db: db}, nil
+}
+
+type boltStorage struct {
+	db *bolt.DB
+}
+
+func (s *boltStorage) Get(kind, id string, v interface{}) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(kind))
+		if b == nil {
+			return ErrNotFound
+		}
+		raw := b.Get([]byte(id))
+		if raw == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(raw, v)
+	})
+}
+
+func (s *boltStorage) Put(kind, id string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(kind))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), raw)
+	})
+}
+
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,117 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const validationTimeout = time.Second * 30
+
+// validate runs a challenge validation and updates the authorization
+func (s *Server) validate(a authz, typ, thumbprint string) {
+	var ch *challenge
+	for i := range a.Challenges {
+		if a.Challenges[i].Type == typ {
+			ch = &a.Challenges[i]
+		}
+	}
+	keyauth := ch.Token + "." + thumbprint
+	ctx, cf := context.WithTimeout(context.Background(), validationTimeout)
+	defer cf()
+	var err error
+	switch typ {
+	case "http-01":
+		err = s.validatehttp01(ctx, a.Identifier.Value, ch.Token, keyauth)
+	case "dns-01":
+		err = s.validatedns01(ctx, a.Identifier.Value, keyauth)
+	default:
+		err = fmt.Errorf("unsupported challenge type: %v", typ)
+	}
+
+	s.l.Lock()
+	defer s.l.Unlock()
+	// reload the authorization, it may have been deactivated in the meantime
+	cur := &authz{}
+	if gerr := s.cfg.Storage.Get(kindAuthz, a.ID, cur); gerr != nil {
+		log.Error().Err(gerr).Str("authz", a.ID).Msg("ACME validation: load error")
+		return
+	}
+	for i := range cur.Challenges {
+		if cur.Challenges[i].Type == typ {
+			ch = &cur.Challenges[i]
+		}
+	}
+	if err != nil {
+		log.Info().Err(err).Str("name", a.Identifier.Value).Str("type", typ).Msg("ACME validation failed")
+		ch.Status = statusInvalid
+		ch.Error = newproblem(403, "incorrectResponse", err.Error())
+		if cur.Status == statusPending {
+			cur.Status = statusInvalid
+		}
+	} else {
+		ch.Status = statusValid
+		ch.Validated = time.Now()
+		if cur.Status == statusPending {
+			cur.Status = statusValid
+		}
+	}
+	if perr := s.cfg.Storage.Put(kindAuthz, cur.ID, cur); perr != nil {
+		log.Error().Err(perr).Str("authz", a.ID).Msg("ACME validation: save error")
+	}
+}
+
+// validatehttp01 fetches the key authorization from
+// http://<name>/.well-known/acme-challenge/<token>
+func (s *Server) validatehttp01(ctx context.Context, name, token, keyauth string) error {
+	host := name
+	if s.cfg.HTTPPort != 80 {
+		host = net.JoinHostPort(name, strconv.Itoa(s.cfg.HTTPPort))
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://"+host+"/.well-known/acme-challenge/"+token, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpc.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http-01: %v returned %v", req.URL, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return err
+	}
+	if string(bytes.TrimSpace(body)) != keyauth {
+		return fmt.Errorf("http-01: invalid key authorization at %v", req.URL)
+	}
+	return nil
+}
+
+// validatedns01 looks up the TXT record _acme-challenge.<name>
+func (s *Server) validatedns01(ctx context.Context, name, keyauth string) error {
+	h := sha256.Sum256([]byte(keyauth))
+	want := base64.RawURLEncoding.EncodeToString(h[:])
+	records, err := s.resolver.LookupTXT(ctx, "_acme-challenge."+name)
+	if err != nil {
+		return err
+	}
+	for _, v := range records {
+		if v == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("dns-01: no matching TXT record at _acme-challenge.%v", name)
+}
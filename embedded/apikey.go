@@ -0,0 +1,135 @@
+package embedded
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/gabstv/ztls/embedded/middlewares"
+	echo "github.com/labstack/echo/v4"
+)
+
+// routes of the API key scopes (APIKey.Routes)
+const (
+	RouteNewServerCertificate = "new-server-certificate"
+	RouteCertificates         = "certificates"
+	RouteTokens               = "tokens"
+	RouteSDS                  = "sds"
+	RouteRevoke               = "revoke"
+)
+
+// NewAPIKeySecret creates a random API key secret
+func NewAPIKeySecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashAPIKeySecret returns the hash of a secret stored in
+// APIKey.SecretSha256
+func HashAPIKeySecret(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+// IsReservedAPIKeyID reports whether an id is the id of the default API key
+// or of the requests without an API key (tokens, ACME, EST, SCEP), which
+// named API keys can't use
+func IsReservedAPIKeyID(id string) bool {
+	switch id {
+	case middlewares.DefaultAPIKeyID, TokenAPIKeyID, ACMEAPIKeyID, ESTAPIKeyID, SCEPAPIKeyID:
+		return true
+	}
+	return false
+}
+
+// AuthenticateAPIKey returns the id of the API key of a secret if it can use
+// route. The secret is compared with every key in constant time.
+func (s *Server) AuthenticateAPIKey(secret, route string) (string, error) {
+	if secret == "" {
+		return "", errAPIKeyInvalid
+	}
+	h := HashAPIKeySecret(secret)
+	var found *APIKey
+	if s.cfg.Apikey != "" && subtle.ConstantTimeCompare(h, HashAPIKeySecret(s.cfg.Apikey)) == 1 {
+		found = &APIKey{
+			Id: middlewares.DefaultAPIKeyID,
+		}
+	}
+	for _, k := range s.cfg.GetApikeys() {
+		if subtle.ConstantTimeCompare(h, k.GetSecretSha256()) == 1 && !IsReservedAPIKeyID(k.GetId()) {
+			found = k
+		}
+	}
+	if found == nil {
+		return "", errAPIKeyInvalid
+	}
+	if err := found.check(); err != nil {
+		return "", err
+	}
+	if !found.CanUse(route) {
+		return "", fmt.Errorf("%w: %v", middlewares.ErrKeyForbidden, route)
+	}
+	return found.GetId(), nil
+}
+
+// APIKeyByID returns the named API key with the given id (nil if there is
+// none)
+func (c *Config) APIKeyByID(id string) *APIKey {
+	for _, k := range c.GetApikeys() {
+		if k.GetId() == id {
+			return k
+		}
+	}
+	return nil
+}
+
+// check returns an error if the key is disabled or expired
+func (k *APIKey) check() error {
+	if k.GetDisabled() {
+		return errAPIKeyDisabled
+	}
+	if k.GetExpires() > 0 && time.Now().After(time.Unix(k.GetExpires(), 0)) {
+		return errAPIKeyExpired
+	}
+	return nil
+}
+
+// CanUse reports whether the key can use a route
+func (k *APIKey) CanUse(route string) bool {
+	if len(k.GetRoutes()) == 0 {
+		return true
+	}
+	for _, v := range k.GetRoutes() {
+		if v == route {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy returns the restrictions of the key as a policy (nil if the key
+// doesn't restrict the profiles and names)
+func (k *APIKey) Policy() *Policy {
+	if len(k.GetProfiles()) == 0 && len(k.GetAllowedDns()) == 0 && len(k.GetAllowedSpiffeIds()) == 0 {
+		return nil
+	}
+	return &Policy{
+		AllowedDns:       k.GetAllowedDns(),
+		AllowWildcards:   true, // left to the policy of the server
+		AllowedProfiles:  k.GetProfiles(),
+		AllowedSpiffeIds: k.GetAllowedSpiffeIds(),
+	}
+}
+
+// apikeymw authenticates the requests of a route with an API key
+func (s *Server) apikeymw(route string, fail middlewares.FailFunc) echo.MiddlewareFunc {
+	return middlewares.APIKeyFunc(func(secret string) (string, error) {
+		return s.AuthenticateAPIKey(secret, route)
+	}, fail)
+}
@@ -21,10 +21,59 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 type Config struct {
-	Rootkey              []byte   `protobuf:"bytes,1,opt,name=rootkey,proto3" json:"rootkey,omitempty"`
-	RootkeyPw            []byte   `protobuf:"bytes,2,opt,name=rootkey_pw,json=rootkeyPw,proto3" json:"rootkey_pw,omitempty"`
-	Rootcert             []byte   `protobuf:"bytes,3,opt,name=rootcert,proto3" json:"rootcert,omitempty"`
-	Apikey               string   `protobuf:"bytes,4,opt,name=apikey,proto3" json:"apikey,omitempty"`
+	Rootkey   []byte `protobuf:"bytes,1,opt,name=rootkey,proto3" json:"rootkey,omitempty"`
+	RootkeyPw []byte `protobuf:"bytes,2,opt,name=rootkey_pw,json=rootkeyPw,proto3" json:"rootkey_pw,omitempty"`
+	Rootcert  []byte `protobuf:"bytes,3,opt,name=rootcert,proto3" json:"rootcert,omitempty"`
+	Apikey    string `protobuf:"bytes,4,opt,name=apikey,proto3" json:"apikey,omitempty"`
+	// key type of the keys created by Server.NewKey (rsa, ecdsa-p256,
+	// ecdsa-p384 or ed25519)
+	KeyType string `protobuf:"bytes,5,opt,name=key_type,json=keyType,proto3" json:"key_type,omitempty"`
+	// intermediate (issuing) CA; when set, certificates are signed by it
+	// instead of the root, and the root key can be left out of the config
+	Issuerkey   []byte `protobuf:"bytes,6,opt,name=issuerkey,proto3" json:"issuerkey,omitempty"`
+	IssuerkeyPw []byte `protobuf:"bytes,7,opt,name=issuerkey_pw,json=issuerkeyPw,proto3" json:"issuerkey_pw,omitempty"`
+	Issuercert  []byte `protobuf:"bytes,8,opt,name=issuercert,proto3" json:"issuercert,omitempty"`
+	// public base URL of the server (e.g. https://ca.example.com); used in the
+	// CRL distribution point and the OCSP URL of the issued certificates
+	PublicUrl string `protobuf:"bytes,9,opt,name=public_url,json=publicUrl,proto3" json:"public_url,omitempty"`
+	// issuance policy of the anonymous requests; also used by the API keys
+	// without an entry in apikey_policies (no policy = anything but URI SANs
+	// is allowed)
+	Policy *Policy `protobuf:"bytes,10,opt,name=policy,proto3" json:"policy,omitempty"`
+	// issuance policies of the requests authenticated by an API key, by API
+	// key id ("default" is the apikey of this config)
+	ApikeyPolicies map[string]*Policy `protobuf:"bytes,11,rep,name=apikey_policies,json=apikeyPolicies,proto3" json:"apikey_policies,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// certificate profiles, by name; they override the built-in profiles
+	// (server, client, peer and code-signing)
+	Profiles map[string]*Profile `protobuf:"bytes,12,rep,name=profiles,proto3" json:"profiles,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// profile used when a request doesn't select one (default: peer)
+	DefaultProfile string `protobuf:"bytes,13,opt,name=default_profile,json=defaultProfile,proto3" json:"default_profile,omitempty"`
+	// lifetime of the certificates when neither the request nor the profile
+	// sets one (seconds; default: 1 year)
+	DefaultLifetimeSeconds int64 `protobuf:"varint,14,opt,name=default_lifetime_seconds,json=defaultLifetimeSeconds,proto3" json:"default_lifetime_seconds,omitempty"`
+	// maximum lifetime of the certificates (seconds; 0 = no limit other than
+	// the CA expiration)
+	MaxLifetimeSeconds int64 `protobuf:"varint,15,opt,name=max_lifetime_seconds,json=maxLifetimeSeconds,proto3" json:"max_lifetime_seconds,omitempty"`
+	// NotBefore is set to the issuance time minus this value to tolerate
+	// clock skew (seconds; default: 15 minutes, -1 = no backdate)
+	BackdateSeconds int64 `protobuf:"varint,16,opt,name=backdate_seconds,json=backdateSeconds,proto3" json:"backdate_seconds,omitempty"`
+	// certificates with a lifetime up to this value are short-lived: they
+	// have no CRL distribution point nor OCSP URL (seconds; default: 24h,
+	// -1 = disabled)
+	ShortLivedSeconds int64 `protobuf:"varint,17,opt,name=short_lived_seconds,json=shortLivedSeconds,proto3" json:"short_lived_seconds,omitempty"`
+	// ACME (RFC 8555) server mode, mounted on /acme
+	Acme *ACME `protobuf:"bytes,18,opt,name=acme,proto3" json:"acme,omitempty"`
+	// EST (RFC 7030) enrollment, mounted on /.well-known/est
+	Est *EST `protobuf:"bytes,19,opt,name=est,proto3" json:"est,omitempty"`
+	// SCEP (RFC 8894) responder, mounted on /scep
+	Scep *SCEP `protobuf:"bytes,20,opt,name=scep,proto3" json:"scep,omitempty"`
+	// named API keys, in addition to apikey (whose id is "default")
+	Apikeys []*APIKey `protobuf:"bytes,21,rep,name=apikeys,proto3" json:"apikeys,omitempty"`
+	// SPIFFE trust domain of the URI SANs (spiffe://<trust_domain>/<path>);
+	// empty: the certificates can't have URI SANs. Only the in-process
+	// (trusted) requests have them without a policy allowing them
+	// (Policy.allowed_spiffe_ids).
+	TrustDomain          string   `protobuf:"bytes,22,opt,name=trust_domain,json=trustDomain,proto3" json:"trust_domain,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -83,21 +132,814 @@ func (m *Config) GetApikey() string {
 	return ""
 }
 
+func (m *Config) GetKeyType() string {
+	if m != nil {
+		return m.KeyType
+	}
+	return ""
+}
+
+func (m *Config) GetIssuerkey() []byte {
+	if m != nil {
+		return m.Issuerkey
+	}
+	return nil
+}
+
+func (m *Config) GetIssuerkeyPw() []byte {
+	if m != nil {
+		return m.IssuerkeyPw
+	}
+	return nil
+}
+
+func (m *Config) GetIssuercert() []byte {
+	if m != nil {
+		return m.Issuercert
+	}
+	return nil
+}
+
+func (m *Config) GetPublicUrl() string {
+	if m != nil {
+		return m.PublicUrl
+	}
+	return ""
+}
+
+func (m *Config) GetPolicy() *Policy {
+	if m != nil {
+		return m.Policy
+	}
+	return nil
+}
+
+func (m *Config) GetApikeyPolicies() map[string]*Policy {
+	if m != nil {
+		return m.ApikeyPolicies
+	}
+	return nil
+}
+
+func (m *Config) GetProfiles() map[string]*Profile {
+	if m != nil {
+		return m.Profiles
+	}
+	return nil
+}
+
+func (m *Config) GetDefaultProfile() string {
+	if m != nil {
+		return m.DefaultProfile
+	}
+	return ""
+}
+
+func (m *Config) GetDefaultLifetimeSeconds() int64 {
+	if m != nil {
+		return m.DefaultLifetimeSeconds
+	}
+	return 0
+}
+
+func (m *Config) GetMaxLifetimeSeconds() int64 {
+	if m != nil {
+		return m.MaxLifetimeSeconds
+	}
+	return 0
+}
+
+func (m *Config) GetBackdateSeconds() int64 {
+	if m != nil {
+		return m.BackdateSeconds
+	}
+	return 0
+}
+
+func (m *Config) GetShortLivedSeconds() int64 {
+	if m != nil {
+		return m.ShortLivedSeconds
+	}
+	return 0
+}
+
+func (m *Config) GetAcme() *ACME {
+	if m != nil {
+		return m.Acme
+	}
+	return nil
+}
+
+func (m *Config) GetEst() *EST {
+	if m != nil {
+		return m.Est
+	}
+	return nil
+}
+
+func (m *Config) GetScep() *SCEP {
+	if m != nil {
+		return m.Scep
+	}
+	return nil
+}
+
+func (m *Config) GetApikeys() []*APIKey {
+	if m != nil {
+		return m.Apikeys
+	}
+	return nil
+}
+
+func (m *Config) GetTrustDomain() string {
+	if m != nil {
+		return m.TrustDomain
+	}
+	return ""
+}
+
+// APIKey is a named API key. Its requests also use the policy of its id in
+// apikey_policies.
+type APIKey struct {
+	// unique id, e.g. the name of the team using the key
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// SHA-256 of the secret (the secret itself is not stored)
+	SecretSha256 []byte `protobuf:"bytes,2,opt,name=secret_sha256,json=secretSha256,proto3" json:"secret_sha256,omitempty"`
+	// routes the key can use: new-server-certificate, certificates, tokens,
+	// sds, revoke (empty = all)
+	Routes []string `protobuf:"bytes,3,rep,name=routes,proto3" json:"routes,omitempty"`
+	// profiles the key can request (empty = all)
+	Profiles []string `protobuf:"bytes,4,rep,name=profiles,proto3" json:"profiles,omitempty"`
+	// names the key can request, same syntax as Policy.allowed_dns (empty =
+	// all)
+	AllowedDns []string `protobuf:"bytes,5,rep,name=allowed_dns,json=allowedDns,proto3" json:"allowed_dns,omitempty"`
+	// creation and expiration times (unix seconds; expires 0 = never)
+	Created int64 `protobuf:"varint,6,opt,name=created,proto3" json:"created,omitempty"`
+	Expires int64 `protobuf:"varint,7,opt,name=expires,proto3" json:"expires,omitempty"`
+	// disabled (revoked) keys are rejected
+	Disabled bool `protobuf:"varint,8,opt,name=disabled,proto3" json:"disabled,omitempty"`
+	// SPIFFE IDs the key can request, same syntax as
+	// Policy.allowed_spiffe_ids (empty = all if the key has no other scope,
+	// none otherwise)
+	AllowedSpiffeIds     []string `protobuf:"bytes,9,rep,name=allowed_spiffe_ids,json=allowedSpiffeIds,proto3" json:"allowed_spiffe_ids,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *APIKey) Reset()         { *m = APIKey{} }
+func (m *APIKey) String() string { return proto.CompactTextString(m) }
+func (*APIKey) ProtoMessage()    {}
+func (*APIKey) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3eaf2c85e69e9ea4, []int{1}
+}
+
+func (m *APIKey) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_APIKey.Unmarshal(m, b)
+}
+func (m *APIKey) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_APIKey.Marshal(b, m, deterministic)
+}
+func (m *APIKey) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_APIKey.Merge(m, src)
+}
+func (m *APIKey) XXX_Size() int {
+	return xxx_messageInfo_APIKey.Size(m)
+}
+func (m *APIKey) XXX_DiscardUnknown() {
+	xxx_messageInfo_APIKey.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_APIKey proto.InternalMessageInfo
+
+func (m *APIKey) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *APIKey) GetSecretSha256() []byte {
+	if m != nil {
+		return m.SecretSha256
+	}
+	return nil
+}
+
+func (m *APIKey) GetRoutes() []string {
+	if m != nil {
+		return m.Routes
+	}
+	return nil
+}
+
+func (m *APIKey) GetProfiles() []string {
+	if m != nil {
+		return m.Profiles
+	}
+	return nil
+}
+
+func (m *APIKey) GetAllowedDns() []string {
+	if m != nil {
+		return m.AllowedDns
+	}
+	return nil
+}
+
+func (m *APIKey) GetCreated() int64 {
+	if m != nil {
+		return m.Created
+	}
+	return 0
+}
+
+func (m *APIKey) GetExpires() int64 {
+	if m != nil {
+		return m.Expires
+	}
+	return 0
+}
+
+func (m *APIKey) GetDisabled() bool {
+	if m != nil {
+		return m.Disabled
+	}
+	return false
+}
+
+func (m *APIKey) GetAllowedSpiffeIds() []string {
+	if m != nil {
+		return m.AllowedSpiffeIds
+	}
+	return nil
+}
+
+// ACME configures the ACME server. Its requests use the policy of the
+// "acme" API key id (see apikey_policies).
+type ACME struct {
+	Enabled bool `protobuf:"varint,1,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	// DNS server (host:port) used to validate dns-01 challenges (default:
+	// the system resolver)
+	Resolver string `protobuf:"bytes,2,opt,name=resolver,proto3" json:"resolver,omitempty"`
+	// names authorized without validation: "example.internal" matches the
+	// name, "*.example.internal" matches any subdomain
+	AutoApprove []string `protobuf:"bytes,3,rep,name=auto_approve,json=autoApprove,proto3" json:"auto_approve,omitempty"`
+	// certificate profile of the ACME orders (default: server)
+	Profile              string   `protobuf:"bytes,4,opt,name=profile,proto3" json:"profile,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ACME) Reset()         { *m = ACME{} }
+func (m *ACME) String() string { return proto.CompactTextString(m) }
+func (*ACME) ProtoMessage()    {}
+func (*ACME) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3eaf2c85e69e9ea4, []int{2}
+}
+
+func (m *ACME) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ACME.Unmarshal(m, b)
+}
+func (m *ACME) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ACME.Marshal(b, m, deterministic)
+}
+func (m *ACME) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ACME.Merge(m, src)
+}
+func (m *ACME) XXX_Size() int {
+	return xxx_messageInfo_ACME.Size(m)
+}
+func (m *ACME) XXX_DiscardUnknown() {
+	xxx_messageInfo_ACME.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ACME proto.InternalMessageInfo
+
+func (m *ACME) GetEnabled() bool {
+	if m != nil {
+		return m.Enabled
+	}
+	return false
+}
+
+func (m *ACME) GetResolver() string {
+	if m != nil {
+		return m.Resolver
+	}
+	return ""
+}
+
+func (m *ACME) GetAutoApprove() []string {
+	if m != nil {
+		return m.AutoApprove
+	}
+	return nil
+}
+
+func (m *ACME) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+// EST configures the EST enrollment endpoints. Its requests use the policy
+// of the "est" API key id (see apikey_policies).
+type EST struct {
+	Enabled bool `protobuf:"varint,1,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	// HTTP basic auth credentials of the clients (empty: only TLS client
+	// certificates issued by this CA are accepted)
+	Username string `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Password string `protobuf:"bytes,3,opt,name=password,proto3" json:"password,omitempty"`
+	// certificate profile of the enrollments (default: default_profile)
+	Profile              string   `protobuf:"bytes,4,opt,name=profile,proto3" json:"profile,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *EST) Reset()         { *m = EST{} }
+func (m *EST) String() string { return proto.CompactTextString(m) }
+func (*EST) ProtoMessage()    {}
+func (*EST) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3eaf2c85e69e9ea4, []int{3}
+}
+
+func (m *EST) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_EST.Unmarshal(m, b)
+}
+func (m *EST) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_EST.Marshal(b, m, deterministic)
+}
+func (m *EST) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_EST.Merge(m, src)
+}
+func (m *EST) XXX_Size() int {
+	return xxx_messageInfo_EST.Size(m)
+}
+func (m *EST) XXX_DiscardUnknown() {
+	xxx_messageInfo_EST.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_EST proto.InternalMessageInfo
+
+func (m *EST) GetEnabled() bool {
+	if m != nil {
+		return m.Enabled
+	}
+	return false
+}
+
+func (m *EST) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *EST) GetPassword() string {
+	if m != nil {
+		return m.Password
+	}
+	return ""
+}
+
+func (m *EST) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+// SCEP configures the SCEP responder. Its requests use the policy of the
+// "scep" API key id (see apikey_policies).
+type SCEP struct {
+	Enabled bool `protobuf:"varint,1,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	// accepted challenge passwords of the PKCSReq messages (empty: only
+	// RenewalReq messages signed by a certificate of this CA are accepted)
+	ChallengePasswords []string `protobuf:"bytes,2,rep,name=challenge_passwords,json=challengePasswords,proto3" json:"challenge_passwords,omitempty"`
+	// certificate profile of the enrollments (default: default_profile)
+	Profile              string   `protobuf:"bytes,3,opt,name=profile,proto3" json:"profile,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SCEP) Reset()         { *m = SCEP{} }
+func (m *SCEP) String() string { return proto.CompactTextString(m) }
+func (*SCEP) ProtoMessage()    {}
+func (*SCEP) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3eaf2c85e69e9ea4, []int{4}
+}
+
+func (m *SCEP) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SCEP.Unmarshal(m, b)
+}
+func (m *SCEP) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SCEP.Marshal(b, m, deterministic)
+}
+func (m *SCEP) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SCEP.Merge(m, src)
+}
+func (m *SCEP) XXX_Size() int {
+	return xxx_messageInfo_SCEP.Size(m)
+}
+func (m *SCEP) XXX_DiscardUnknown() {
+	xxx_messageInfo_SCEP.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SCEP proto.InternalMessageInfo
+
+func (m *SCEP) GetEnabled() bool {
+	if m != nil {
+		return m.Enabled
+	}
+	return false
+}
+
+func (m *SCEP) GetChallengePasswords() []string {
+	if m != nil {
+		return m.ChallengePasswords
+	}
+	return nil
+}
+
+func (m *SCEP) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+// Policy restricts the certificates that can be issued. Empty fields allow
+// everything, except allowed_spiffe_ids.
+type Policy struct {
+	// allowed DNS names (and DNS-like common names): "example.com" matches
+	// the name, "*.example.com" matches any subdomain of example.com
+	AllowedDns []string `protobuf:"bytes,1,rep,name=allowed_dns,json=allowedDns,proto3" json:"allowed_dns,omitempty"`
+	// denied DNS names (same patterns as allowed_dns); takes precedence
+	DeniedDns []string `protobuf:"bytes,2,rep,name=denied_dns,json=deniedDns,proto3" json:"denied_dns,omitempty"`
+	// allow wildcard names (*.example.com) in the request
+	AllowWildcards bool `protobuf:"varint,3,opt,name=allow_wildcards,json=allowWildcards,proto3" json:"allow_wildcards,omitempty"`
+	// allowed IP addresses (CIDR, e.g. 10.0.0.0/8)
+	AllowedIpRanges []string `protobuf:"bytes,4,rep,name=allowed_ip_ranges,json=allowedIpRanges,proto3" json:"allowed_ip_ranges,omitempty"`
+	// denied IP addresses (CIDR); takes precedence
+	DeniedIpRanges []string `protobuf:"bytes,5,rep,name=denied_ip_ranges,json=deniedIpRanges,proto3" json:"denied_ip_ranges,omitempty"`
+	// subject fields that must be set: CN, O, OU, C, ST, L, STREET,
+	// POSTALCODE, SERIALNUMBER
+	RequiredSubject []string `protobuf:"bytes,6,rep,name=required_subject,json=requiredSubject,proto3" json:"required_subject,omitempty"`
+	// subject fields that must not be set
+	ForbiddenSubject []string `protobuf:"bytes,7,rep,name=forbidden_subject,json=forbiddenSubject,proto3" json:"forbidden_subject,omitempty"`
+	// minimum RSA key size (bits)
+	MinRsaBits uint32 `protobuf:"varint,8,opt,name=min_rsa_bits,json=minRsaBits,proto3" json:"min_rsa_bits,omitempty"`
+	// allowed public key types: rsa, ecdsa-p256, ecdsa-p384, ecdsa-p521,
+	// ed25519
+	AllowedKeyTypes []string `protobuf:"bytes,9,rep,name=allowed_key_types,json=allowedKeyTypes,proto3" json:"allowed_key_types,omitempty"`
+	// maximum lifetime of the certificates (seconds)
+	MaxLifetimeSeconds int64 `protobuf:"varint,10,opt,name=max_lifetime_seconds,json=maxLifetimeSeconds,proto3" json:"max_lifetime_seconds,omitempty"`
+	// profiles that can be requested (empty = all)
+	AllowedProfiles []string `protobuf:"bytes,11,rep,name=allowed_profiles,json=allowedProfiles,proto3" json:"allowed_profiles,omitempty"`
+	// SPIFFE IDs that can be requested: "spiffe://example.org/svc" matches
+	// the ID, "spiffe://example.org/ns/prod/*" matches the IDs under the path
+	// (empty = no URI SANs)
+	AllowedSpiffeIds     []string `protobuf:"bytes,12,rep,name=allowed_spiffe_ids,json=allowedSpiffeIds,proto3" json:"allowed_spiffe_ids,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Policy) Reset()         { *m = Policy{} }
+func (m *Policy) String() string { return proto.CompactTextString(m) }
+func (*Policy) ProtoMessage()    {}
+func (*Policy) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3eaf2c85e69e9ea4, []int{5}
+}
+
+func (m *Policy) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Policy.Unmarshal(m, b)
+}
+func (m *Policy) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Policy.Marshal(b, m, deterministic)
+}
+func (m *Policy) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Policy.Merge(m, src)
+}
+func (m *Policy) XXX_Size() int {
+	return xxx_messageInfo_Policy.Size(m)
+}
+func (m *Policy) XXX_DiscardUnknown() {
+	xxx_messageInfo_Policy.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Policy proto.InternalMessageInfo
+
+func (m *Policy) GetAllowedDns() []string {
+	if m != nil {
+		return m.AllowedDns
+	}
+	return nil
+}
+
+func (m *Policy) GetDeniedDns() []string {
+	if m != nil {
+		return m.DeniedDns
+	}
+	return nil
+}
+
+func (m *Policy) GetAllowWildcards() bool {
+	if m != nil {
+		return m.AllowWildcards
+	}
+	return false
+}
+
+func (m *Policy) GetAllowedIpRanges() []string {
+	if m != nil {
+		return m.AllowedIpRanges
+	}
+	return nil
+}
+
+func (m *Policy) GetDeniedIpRanges() []string {
+	if m != nil {
+		return m.DeniedIpRanges
+	}
+	return nil
+}
+
+func (m *Policy) GetRequiredSubject() []string {
+	if m != nil {
+		return m.RequiredSubject
+	}
+	return nil
+}
+
+func (m *Policy) GetForbiddenSubject() []string {
+	if m != nil {
+		return m.ForbiddenSubject
+	}
+	return nil
+}
+
+func (m *Policy) GetMinRsaBits() uint32 {
+	if m != nil {
+		return m.MinRsaBits
+	}
+	return 0
+}
+
+func (m *Policy) GetAllowedKeyTypes() []string {
+	if m != nil {
+		return m.AllowedKeyTypes
+	}
+	return nil
+}
+
+func (m *Policy) GetMaxLifetimeSeconds() int64 {
+	if m != nil {
+		return m.MaxLifetimeSeconds
+	}
+	return 0
+}
+
+func (m *Policy) GetAllowedProfiles() []string {
+	if m != nil {
+		return m.AllowedProfiles
+	}
+	return nil
+}
+
+func (m *Policy) GetAllowedSpiffeIds() []string {
+	if m != nil {
+		return m.AllowedSpiffeIds
+	}
+	return nil
+}
+
+// Profile is a kind of certificate (e.g. TLS server, TLS client)
+type Profile struct {
+	// key usages: digitalSignature, contentCommitment, keyEncipherment,
+	// dataEncipherment, keyAgreement (empty = based on the key type)
+	KeyUsage []string `protobuf:"bytes,1,rep,name=key_usage,json=keyUsage,proto3" json:"key_usage,omitempty"`
+	// extended key usages: serverAuth, clientAuth, codeSigning,
+	// emailProtection, timeStamping, ocspSigning, any
+	ExtKeyUsage []string `protobuf:"bytes,2,rep,name=ext_key_usage,json=extKeyUsage,proto3" json:"ext_key_usage,omitempty"`
+	// lifetime of the certificates (seconds; 0 = server default)
+	LifetimeSeconds int64 `protobuf:"varint,3,opt,name=lifetime_seconds,json=lifetimeSeconds,proto3" json:"lifetime_seconds,omitempty"`
+	// add the common name to the DNS names if it is a DNS name
+	CopyCnToSan bool `protobuf:"varint,4,opt,name=copy_cn_to_san,json=copyCnToSan,proto3" json:"copy_cn_to_san,omitempty"`
+	// extensions added to the certificates
+	Extensions           []*Extension `protobuf:"bytes,5,rep,name=extensions,proto3" json:"extensions,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *Profile) Reset()         { *m = Profile{} }
+func (m *Profile) String() string { return proto.CompactTextString(m) }
+func (*Profile) ProtoMessage()    {}
+func (*Profile) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3eaf2c85e69e9ea4, []int{6}
+}
+
+func (m *Profile) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Profile.Unmarshal(m, b)
+}
+func (m *Profile) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Profile.Marshal(b, m, deterministic)
+}
+func (m *Profile) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Profile.Merge(m, src)
+}
+func (m *Profile) XXX_Size() int {
+	return xxx_messageInfo_Profile.Size(m)
+}
+func (m *Profile) XXX_DiscardUnknown() {
+	xxx_messageInfo_Profile.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Profile proto.InternalMessageInfo
+
+func (m *Profile) GetKeyUsage() []string {
+	if m != nil {
+		return m.KeyUsage
+	}
+	return nil
+}
+
+func (m *Profile) GetExtKeyUsage() []string {
+	if m != nil {
+		return m.ExtKeyUsage
+	}
+	return nil
+}
+
+func (m *Profile) GetLifetimeSeconds() int64 {
+	if m != nil {
+		return m.LifetimeSeconds
+	}
+	return 0
+}
+
+func (m *Profile) GetCopyCnToSan() bool {
+	if m != nil {
+		return m.CopyCnToSan
+	}
+	return false
+}
+
+func (m *Profile) GetExtensions() []*Extension {
+	if m != nil {
+		return m.Extensions
+	}
+	return nil
+}
+
+// Extension is a X.509 certificate extension
+type Extension struct {
+	// object identifier (e.g. 1.3.6.1.4.1.11129.2.4.3)
+	Oid      string `protobuf:"bytes,1,opt,name=oid,proto3" json:"oid,omitempty"`
+	Critical bool   `protobuf:"varint,2,opt,name=critical,proto3" json:"critical,omitempty"`
+	// DER encoded value
+	Value                []byte   `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Extension) Reset()         { *m = Extension{} }
+func (m *Extension) String() string { return proto.CompactTextString(m) }
+func (*Extension) ProtoMessage()    {}
+func (*Extension) Descriptor() ([]byte, []int) {
+	return fileDescriptor_3eaf2c85e69e9ea4, []int{7}
+}
+
+func (m *Extension) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Extension.Unmarshal(m, b)
+}
+func (m *Extension) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Extension.Marshal(b, m, deterministic)
+}
+func (m *Extension) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Extension.Merge(m, src)
+}
+func (m *Extension) XXX_Size() int {
+	return xxx_messageInfo_Extension.Size(m)
+}
+func (m *Extension) XXX_DiscardUnknown() {
+	xxx_messageInfo_Extension.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Extension proto.InternalMessageInfo
+
+func (m *Extension) GetOid() string {
+	if m != nil {
+		return m.Oid
+	}
+	return ""
+}
+
+func (m *Extension) GetCritical() bool {
+	if m != nil {
+		return m.Critical
+	}
+	return false
+}
+
+func (m *Extension) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Config)(nil), "embedded.Config")
+	proto.RegisterMapType((map[string]*Policy)(nil), "embedded.Config.ApikeyPoliciesEntry")
+	proto.RegisterMapType((map[string]*Profile)(nil), "embedded.Config.ProfilesEntry")
+	proto.RegisterType((*APIKey)(nil), "embedded.APIKey")
+	proto.RegisterType((*ACME)(nil), "embedded.ACME")
+	proto.RegisterType((*EST)(nil), "embedded.EST")
+	proto.RegisterType((*SCEP)(nil), "embedded.SCEP")
+	proto.RegisterType((*Policy)(nil), "embedded.Policy")
+	proto.RegisterType((*Profile)(nil), "embedded.Profile")
+	proto.RegisterType((*Extension)(nil), "embedded.Extension")
 }
 
 func init() { proto.RegisterFile("config.proto", fileDescriptor_3eaf2c85e69e9ea4) }
 
 var fileDescriptor_3eaf2c85e69e9ea4 = []byte{
-	// 130 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x49, 0xce, 0xcf, 0x4b,
-	0xcb, 0x4c, 0xd7, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x48, 0xcd, 0x4d, 0x4a, 0x4d, 0x49,
-	0x49, 0x4d, 0x51, 0x2a, 0xe5, 0x62, 0x73, 0x06, 0xcb, 0x08, 0x49, 0x70, 0xb1, 0x17, 0xe5, 0xe7,
-	0x97, 0x64, 0xa7, 0x56, 0x4a, 0x30, 0x2a, 0x30, 0x6a, 0xf0, 0x04, 0xc1, 0xb8, 0x42, 0xb2, 0x5c,
-	0x5c, 0x50, 0x66, 0x7c, 0x41, 0xb9, 0x04, 0x13, 0x58, 0x92, 0x13, 0x2a, 0x12, 0x50, 0x2e, 0x24,
-	0xc5, 0xc5, 0x01, 0xe2, 0x24, 0xa7, 0x16, 0x95, 0x48, 0x30, 0x83, 0x25, 0xe1, 0x7c, 0x21, 0x31,
-	0x2e, 0xb6, 0xc4, 0x82, 0x4c, 0x90, 0x99, 0x2c, 0x0a, 0x8c, 0x1a, 0x9c, 0x41, 0x50, 0x5e, 0x12,
-	0x1b, 0xd8, 0x1d, 0xc6, 0x80, 0x00, 0x00, 0x00, 0xff, 0xff, 0x61, 0x34, 0x99, 0xed, 0x97, 0x00,
-	0x00, 0x00,
+	// 1098 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x7c, 0x56, 0xdd, 0x6e, 0xdb, 0x46,
+	0x13, 0x85, 0x44, 0x47, 0x12, 0x47, 0xbf, 0x5e, 0xe7, 0x0b, 0xf8, 0xa5, 0x4d, 0xa2, 0x2a, 0x45,
+	0xa3, 0xa4, 0x85, 0x5b, 0x38, 0x68, 0x11, 0xe4, 0xce, 0x75, 0x7c, 0x11, 0x24, 0x69, 0x05, 0xca,
+	0x41, 0x2f, 0x89, 0x15, 0x39, 0xb2, 0xb7, 0xa6, 0x48, 0x66, 0x97, 0xb4, 0xa4, 0xb7, 0x28, 0xfa,
+	0x62, 0x7d, 0x9d, 0x5e, 0x16, 0x3b, 0xcb, 0xa5, 0x24, 0xff, 0xdd, 0x71, 0xce, 0x39, 0x7b, 0x66,
+	0x77, 0x39, 0x33, 0x24, 0x74, 0xc2, 0x34, 0x99, 0x8b, 0xf3, 0xc3, 0x4c, 0xa6, 0x79, 0xca, 0x5a,
+	0xb8, 0x98, 0x61, 0x14, 0x61, 0x34, 0xfa, 0xbb, 0x05, 0x8d, 0x13, 0xa2, 0x98, 0x07, 0x4d, 0x99,
+	0xa6, 0xf9, 0x25, 0xae, 0xbd, 0xda, 0xb0, 0x36, 0xee, 0xf8, 0x36, 0x64, 0x4f, 0x00, 0xca, 0xc7,
+	0x20, 0x5b, 0x7a, 0x75, 0x22, 0xdd, 0x12, 0x99, 0x2c, 0xd9, 0x63, 0x68, 0xe9, 0x20, 0x44, 0x99,
+	0x7b, 0x0e, 0x91, 0x55, 0xcc, 0x1e, 0x41, 0x83, 0x67, 0x42, 0x7b, 0xee, 0x0d, 0x6b, 0x63, 0xd7,
+	0x2f, 0x23, 0xf6, 0x7f, 0x68, 0x69, 0xbb, 0x7c, 0x9d, 0xa1, 0xf7, 0x80, 0x98, 0xe6, 0x25, 0xae,
+	0xcf, 0xd6, 0x19, 0xb2, 0xaf, 0xc1, 0x15, 0x4a, 0x15, 0x28, 0xf5, 0xaa, 0x86, 0x49, 0x56, 0x01,
+	0xec, 0x1b, 0xe8, 0x54, 0x81, 0xde, 0x4d, 0x93, 0x04, 0xed, 0x0a, 0x9b, 0x2c, 0xd9, 0x53, 0x00,
+	0x13, 0xd2, 0x8e, 0x5a, 0x24, 0xd8, 0x42, 0xf4, 0x71, 0xb2, 0x62, 0x16, 0x8b, 0x30, 0x28, 0x64,
+	0xec, 0xb9, 0x94, 0xdd, 0x35, 0xc8, 0x67, 0x19, 0xb3, 0x31, 0x34, 0xb2, 0x34, 0x16, 0xe1, 0xda,
+	0x83, 0x61, 0x6d, 0xdc, 0x3e, 0x1a, 0x1c, 0xda, 0xdb, 0x3a, 0x9c, 0x10, 0xee, 0x97, 0x3c, 0xfb,
+	0x04, 0x7d, 0x73, 0x9c, 0x80, 0x00, 0x81, 0xca, 0x6b, 0x0f, 0x9d, 0x71, 0xfb, 0xe8, 0xdb, 0xcd,
+	0x12, 0x73, 0xb9, 0x87, 0xc7, 0xa4, 0x9b, 0x94, 0xb2, 0xd3, 0x24, 0x97, 0x6b, 0xbf, 0xc7, 0x77,
+	0x40, 0xf6, 0x16, 0x5a, 0x99, 0x4c, 0xe7, 0x22, 0x46, 0xe5, 0x75, 0xc8, 0xe7, 0xe9, 0x0d, 0x9f,
+	0x49, 0x29, 0x30, 0x0e, 0x95, 0x9e, 0xbd, 0x80, 0x7e, 0x84, 0x73, 0x5e, 0xc4, 0x79, 0x50, 0x62,
+	0x5e, 0x97, 0x0e, 0xd6, 0x2b, 0xe1, 0x72, 0x21, 0x7b, 0x03, 0x9e, 0x15, 0xc6, 0x62, 0x8e, 0xb9,
+	0x58, 0x60, 0xa0, 0x30, 0x4c, 0x93, 0x48, 0x79, 0xbd, 0x61, 0x6d, 0xec, 0xf8, 0x8f, 0x4a, 0xfe,
+	0x63, 0x49, 0x4f, 0x0d, 0xcb, 0x7e, 0x82, 0x87, 0x0b, 0xbe, 0xba, 0xb9, 0xaa, 0x4f, 0xab, 0xd8,
+	0x82, 0xaf, 0xae, 0xaf, 0x78, 0x09, 0x83, 0x19, 0x0f, 0x2f, 0x23, 0x9e, 0x6f, 0xd4, 0x03, 0x52,
+	0xf7, 0x2d, 0x6e, 0xa5, 0x87, 0x70, 0xa0, 0x2e, 0x52, 0xa9, 0x37, 0x75, 0x85, 0x51, 0xa5, 0xde,
+	0x27, 0xf5, 0x3e, 0x51, 0x1f, 0x35, 0x63, 0xf5, 0x23, 0xd8, 0xe3, 0xe1, 0x02, 0x3d, 0x46, 0xaf,
+	0xa8, 0xb7, 0xb9, 0xa7, 0xe3, 0x93, 0x4f, 0xa7, 0x3e, 0x71, 0xec, 0x19, 0x38, 0xa8, 0x72, 0xef,
+	0x80, 0x24, 0xdd, 0x8d, 0xe4, 0x74, 0x7a, 0xe6, 0x6b, 0x46, 0x9b, 0xa8, 0x10, 0x33, 0xef, 0xe1,
+	0x75, 0x93, 0xe9, 0xc9, 0xe9, 0xc4, 0x27, 0x8e, 0xbd, 0x82, 0xa6, 0x79, 0x4d, 0xca, 0xfb, 0xdf,
+	0xd0, 0xd9, 0x2d, 0x87, 0xe3, 0xc9, 0xfb, 0x0f, 0xb8, 0xf6, 0xad, 0x40, 0xd7, 0x66, 0x2e, 0x0b,
+	0x95, 0x07, 0x51, 0xba, 0xe0, 0x22, 0xf1, 0x1e, 0xd1, 0x1b, 0x68, 0x13, 0xf6, 0x8e, 0xa0, 0xc7,
+	0x53, 0x38, 0xb8, 0xa5, 0x14, 0xd8, 0x00, 0x1c, 0xdb, 0x77, 0xae, 0xaf, 0x1f, 0xd9, 0x77, 0xf0,
+	0xe0, 0x8a, 0xc7, 0x05, 0x52, 0xbb, 0xdd, 0x56, 0x84, 0x86, 0x7e, 0x5b, 0x7f, 0x53, 0x7b, 0xfc,
+	0x1b, 0x74, 0x77, 0xea, 0xe2, 0x16, 0xbb, 0x17, 0xbb, 0x76, 0xfb, 0x5b, 0x76, 0x66, 0xe5, 0x96,
+	0xdf, 0xe8, 0xaf, 0x3a, 0x34, 0xcc, 0xd9, 0x58, 0x0f, 0xea, 0x22, 0x2a, 0x8d, 0xea, 0x22, 0x62,
+	0xcf, 0xa1, 0xab, 0x30, 0x94, 0x98, 0x07, 0xea, 0x82, 0x1f, 0xfd, 0xfc, 0x4b, 0x39, 0x0d, 0x3a,
+	0x06, 0x9c, 0x12, 0xa6, 0x9b, 0x5e, 0xa6, 0x45, 0x8e, 0xca, 0x73, 0x86, 0x8e, 0x6e, 0x7a, 0x13,
+	0xe9, 0x41, 0x51, 0x15, 0xf8, 0x1e, 0x31, 0x55, 0xcc, 0x9e, 0x41, 0x9b, 0xc7, 0x71, 0xba, 0xc4,
+	0x28, 0x88, 0x12, 0xe5, 0x3d, 0x20, 0x1a, 0x4a, 0xe8, 0x5d, 0xa2, 0xf4, 0x78, 0x0a, 0x25, 0xf2,
+	0x1c, 0x23, 0x1a, 0x0a, 0x8e, 0x6f, 0x43, 0xcd, 0xe0, 0x2a, 0x13, 0x12, 0x15, 0x4d, 0x03, 0xc7,
+	0xb7, 0xa1, 0x4e, 0x18, 0x09, 0xc5, 0x67, 0x31, 0x46, 0x34, 0x07, 0x5a, 0x7e, 0x15, 0xb3, 0x1f,
+	0x80, 0xd9, 0x84, 0x2a, 0x13, 0xf3, 0x39, 0x06, 0x22, 0x52, 0x9e, 0x4b, 0x79, 0x07, 0x25, 0x33,
+	0x25, 0xe2, 0x7d, 0xa4, 0x46, 0x6b, 0xd8, 0xd3, 0x95, 0x45, 0xb9, 0x12, 0x63, 0x58, 0x23, 0x43,
+	0x1b, 0xd2, 0x14, 0x44, 0x95, 0xc6, 0x57, 0x28, 0xe9, 0x52, 0x5c, 0xbf, 0x8a, 0x75, 0x61, 0xf0,
+	0x22, 0x4f, 0x03, 0x9e, 0x65, 0x32, 0xbd, 0xc2, 0xf2, 0x5a, 0xda, 0x1a, 0x3b, 0x36, 0x90, 0x36,
+	0xb6, 0x8d, 0x6b, 0x26, 0xa5, 0x0d, 0x47, 0x5f, 0xc0, 0x39, 0x9d, 0x9e, 0xdd, 0x9f, 0xb9, 0x50,
+	0x28, 0x13, 0xbe, 0x40, 0x9b, 0xd9, 0xc6, 0x74, 0xe5, 0x5c, 0xa9, 0x65, 0x2a, 0x23, 0x9a, 0xcd,
+	0xae, 0x5f, 0xc5, 0xf7, 0xa4, 0xbc, 0x84, 0x3d, 0xdd, 0x02, 0xf7, 0xe4, 0xfc, 0x11, 0x0e, 0xc2,
+	0x0b, 0x1e, 0xc7, 0x98, 0x9c, 0x63, 0x60, 0x1d, 0x95, 0x57, 0xa7, 0x83, 0xb1, 0x8a, 0x9a, 0x58,
+	0x66, 0x3b, 0x99, 0xb3, 0x9b, 0xec, 0x5f, 0x07, 0x1a, 0xa6, 0xa6, 0xaf, 0x17, 0x41, 0xed, 0x46,
+	0x11, 0x3c, 0x01, 0x88, 0x30, 0x11, 0x25, 0x6f, 0xb2, 0xb9, 0x06, 0xd1, 0xf4, 0x0b, 0xe8, 0x93,
+	0x38, 0x58, 0x8a, 0x38, 0x0a, 0xb9, 0xde, 0x91, 0x43, 0xfb, 0xee, 0x11, 0xfc, 0x87, 0x45, 0xd9,
+	0x2b, 0xd8, 0xb7, 0x89, 0x44, 0x16, 0x48, 0x9e, 0x9c, 0x57, 0x25, 0xd9, 0x2f, 0x89, 0xf7, 0x99,
+	0x4f, 0x30, 0x1b, 0xc3, 0xa0, 0xcc, 0xb9, 0x91, 0x9a, 0xf2, 0xec, 0x19, 0xbc, 0x52, 0xbe, 0x84,
+	0x81, 0xc4, 0x2f, 0x85, 0x90, 0xba, 0xa6, 0x8a, 0xd9, 0x9f, 0x18, 0xe6, 0x5e, 0xc3, 0x98, 0x5a,
+	0x7c, 0x6a, 0x60, 0xf6, 0x3d, 0xec, 0xcf, 0x53, 0x39, 0x13, 0x51, 0x84, 0x49, 0xa5, 0x6d, 0x9a,
+	0xe2, 0xab, 0x08, 0x2b, 0x1e, 0x42, 0x67, 0x21, 0x92, 0x40, 0x2a, 0x1e, 0xcc, 0x44, 0xae, 0xa8,
+	0x94, 0xbb, 0x3e, 0x2c, 0x44, 0xe2, 0x2b, 0xfe, 0xab, 0xc8, 0x77, 0xce, 0x63, 0x3f, 0xab, 0xb6,
+	0x96, 0xed, 0x79, 0x3e, 0x98, 0xcf, 0xeb, 0xdd, 0x73, 0x1c, 0xee, 0x9b, 0xe3, 0xd6, 0xbd, 0xea,
+	0xdf, 0xf6, 0x8e, 0xb9, 0x1d, 0x3f, 0x77, 0x74, 0x55, 0xe7, 0x8e, 0xae, 0xfa, 0xa7, 0x06, 0xcd,
+	0x72, 0x29, 0xfb, 0x0a, 0x5c, 0xbd, 0xf5, 0x42, 0xf1, 0x73, 0x2c, 0xdf, 0xbc, 0xfe, 0x45, 0xf8,
+	0xac, 0x63, 0x36, 0x82, 0x2e, 0xae, 0xf2, 0x60, 0x23, 0x30, 0xaf, 0xbe, 0x8d, 0xab, 0xfc, 0x83,
+	0xd5, 0xbc, 0x84, 0xc1, 0x8d, 0x33, 0x39, 0xe6, 0x6b, 0x13, 0x5f, 0x3b, 0xd0, 0x73, 0xe8, 0x85,
+	0x69, 0xb6, 0x0e, 0xc2, 0x24, 0xc8, 0xd3, 0x40, 0xf1, 0x84, 0x1a, 0xa0, 0xe5, 0xb7, 0x35, 0x7a,
+	0x92, 0x9c, 0xa5, 0x53, 0x9e, 0xb0, 0xd7, 0x00, 0xb8, 0xca, 0x31, 0x51, 0x22, 0x2d, 0x07, 0x52,
+	0xfb, 0xe8, 0x60, 0xeb, 0x2b, 0x62, 0x39, 0x7f, 0x4b, 0x36, 0xfa, 0x1d, 0xdc, 0x8a, 0xd0, 0x63,
+	0x38, 0xad, 0xa6, 0xa7, 0x7e, 0xd4, 0xed, 0x18, 0x4a, 0x91, 0x8b, 0x90, 0xc7, 0xd4, 0xaa, 0x2d,
+	0xbf, 0x8a, 0xd9, 0x43, 0x3b, 0xa2, 0xcd, 0x3f, 0x94, 0x09, 0x66, 0x0d, 0xfa, 0x63, 0x7b, 0xfd,
+	0xdf, 0x00, 0x4a, 0x63, 0x4a, 0x25, 0xc1, 0x09, 0x00, 0x00,
 }
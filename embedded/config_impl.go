@@ -1,8 +1,10 @@
 package embedded
 
 import (
+	"bytes"
 	"encoding/pem"
 
+	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
 )
 
@@ -13,7 +15,28 @@ func (e err0) Error() string {
 }
 
 const (
-	errInvalidPEM err0 = "invalid PEM encoding"
+	errInvalidPEM       err0 = "invalid PEM encoding"
+	errNoIssuer         err0 = "the signing key or certificate is missing or invalid"
+	errInvalidSerial    err0 = "invalid serial number"
+	errNoStore          err0 = "the certificate store is not configured"
+	errSerialRetries    err0 = "could not reserve a unique serial number"
+	errLifetimeConflict err0 = "ttl and not_after can't be used together"
+	errInvalidLifetime  err0 = "invalid certificate lifetime"
+	errInvalidCSR       err0 = "invalid certificate request"
+	errUnknownProfile   err0 = "unknown certificate profile"
+	errRenewProof       err0 = "invalid proof of possession of the certificate key"
+	errRenewUntrusted   err0 = "the certificate was not issued by this server"
+	errRenewExpired     err0 = "the certificate is expired"
+	errRenewRevoked     err0 = "the certificate is revoked"
+	errRenewIdentity    err0 = "the CSR doesn't have the identity of the certificate"
+	errRenewProfile     err0 = "the profile doesn't have the key usages of the certificate"
+	errAPIKeyInvalid    err0 = "invalid/missing header X-API-KEY"
+	errAPIKeyDisabled   err0 = "the API key is disabled"
+	errAPIKeyExpired    err0 = "the API key is expired"
+	errTokenInvalid     err0 = "invalid bootstrap token"
+	errTokenExpired     err0 = "the bootstrap token is expired"
+	errTokenUsed        err0 = "the bootstrap token was already used"
+	errTokenIdentity    err0 = "the CSR doesn't have the identity of the bootstrap token"
 )
 
 func UnmarshalConfig(pemcfg []byte) (*Config, error) {
@@ -44,3 +67,24 @@ func (c *Config) Marshal(metad map[string]string) (pemdata []byte) {
 	pemdata = pem.EncodeToMemory(blk)
 	return
 }
+
+// UnmarshalPolicyJSON parses a JSON encoded Policy, e.g.
+// {"allowedDns": ["*.internal.example.com"], "minRsaBits": 2048}
+func UnmarshalPolicyJSON(raw []byte) (*Policy, error) {
+	p := &Policy{}
+	if err := jsonpb.Unmarshal(bytes.NewReader(raw), p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// UnmarshalProfilesJSON parses JSON encoded profiles by name, e.g.
+// {"web": {"extKeyUsage": ["serverAuth"], "copyCnToSan": true}}
+func UnmarshalProfilesJSON(raw []byte) (map[string]*Profile, error) {
+	cfg := &Config{}
+	wrapped := append(append([]byte(`{"profiles":`), raw...), '}')
+	if err := jsonpb.Unmarshal(bytes.NewReader(wrapped), cfg); err != nil {
+		return nil, err
+	}
+	return cfg.Profiles, nil
+}
@@ -0,0 +1,119 @@
+package embedded
+
+import (
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gabstv/ztls/internal/pkix"
+)
+
+// DefaultCRLValidity is the CRL validity (NextUpdate - ThisUpdate) used when
+// Server.CRLValidity is not set. The CRL is regenerated when half of its
+// validity has elapsed or when a certificate is revoked.
+const DefaultCRLValidity = time.Hour * 24
+
+type crlcache struct {
+	l          sync.Mutex
+	der        []byte
+	thisUpdate time.Time
+	count      int
+	last       time.Time
+}
+
+// Revoke revokes a certificate issued by this server
+func (s *Server) Revoke(serial *big.Int, reason RevocationReason) error {
+	if serial == nil || serial.Sign() <= 0 {
+		return errInvalidSerial
+	}
+	if err := s.Revocations.Revoke(Revocation{
+		Serial:    serial,
+		Reason:    reason,
+		RevokedAt: time.Now(),
+	}); err != nil {
+		return err
+	}
+	s.ocspforget(serial)
+	return nil
+}
+
+// IsRevoked reports whether a certificate is revoked
+func (s *Server) IsRevoked(serial *big.Int) (bool, error) {
+	_, ok, err := s.Revocations.Get(serial)
+	return ok, err
+}
+
+// CRL returns the current DER encoded CRL, signed by the issuing CA
+func (s *Server) CRL() ([]byte, error) {
+	list, err := s.Revocations.List()
+	if err != nil {
+		return nil, err
+	}
+	var last time.Time
+	if len(list) > 0 {
+		last = list[len(list)-1].RevokedAt
+	}
+	validity := s.CRLValidity
+	if validity <= 0 {
+		validity = DefaultCRLValidity
+	}
+	now := time.Now()
+
+	s.crl.l.Lock()
+	defer s.crl.l.Unlock()
+	if s.crl.der != nil && s.crl.count == len(list) && s.crl.last.Equal(last) &&
+		now.Before(s.crl.thisUpdate.Add(validity/2)) {
+		return s.crl.der, nil
+	}
+
+	cacert, cakey := s.getca(), s.getkey()
+	if cacert == nil || cakey == nil {
+		return nil, errNoIssuer
+	}
+	revoked := make([]pkix.RevokedCertificate, 0, len(list))
+	for _, v := range list {
+		revoked = append(revoked, pkix.RevokedCertificate{
+			SerialNumber: v.Serial,
+			RevokedAt:    v.RevokedAt,
+			Reason:       int(v.Reason),
+		})
+	}
+	der, err := pkix.NewCRL(pkix.NewCRLInput{
+		CACert:     cacert,
+		CAKey:      cakey,
+		Revoked:    revoked,
+		Number:     big.NewInt(now.UnixNano()),
+		ThisUpdate: now,
+		NextUpdate: now.Add(validity),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.crl.der = der
+	s.crl.thisUpdate = now
+	s.crl.count = len(list)
+	s.crl.last = last
+	return der, nil
+}
+
+// CRLPEM returns the current CRL (PEM encoded)
+func (s *Server) CRLPEM() ([]byte, error) {
+	der, err := s.CRL()
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "X509 CRL",
+		Bytes: der,
+	}), nil
+}
+
+// crlurls returns the CRL distribution points of issued certificates
+func (s *Server) crlurls() []string {
+	if s.cfg.GetPublicUrl() == "" {
+		return nil
+	}
+	return []string{strings.TrimSuffix(s.cfg.GetPublicUrl(), "/") + "/1/crl"}
+}
@@ -0,0 +1,317 @@
+// Package est implements the EST (RFC 7030) enrollment endpoints, used by
+// network equipment and IoT devices to request certificates.
+package est
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+
+	"github.com/gabstv/ztls/internal/pkix"
+	echo "github.com/labstack/echo/v4"
+	"go.mozilla.org/pkcs7"
+)
+
+// Path is where the EST endpoints are mounted
+const Path = "/.well-known/est"
+
+const (
+	mimeCerts = "application/pkcs7-mime; smime-type=certs-only"
+	mimePKCS8 = "application/pkcs8"
+)
+
+// EnrollRequest is an enrollment (or re-enrollment) request
+type EnrollRequest struct {
+	CSR      []byte // PEM encoded
+	RemoteIP string
+	// Username is set if the client authenticated with HTTP basic auth
+	Username string
+	// Client is set if the client authenticated with a TLS certificate
+	Client *x509.Certificate
+	// Renewal is true for simplereenroll
+	Renewal bool
+}
+
+// EnrollFunc signs a certificate request, returning the PEM encoded
+// certificate followed by the intermediate certificates. Errors of type
+// *echo.HTTPError set the response status code (default: 400).
+type EnrollFunc func(req EnrollRequest) (chain []byte, err error)
+
+type Config struct {
+	// Enroll signs the certificates [REQUIRED]
+	Enroll EnrollFunc
+	// CACerts returns the PEM encoded CA certificates (intermediate and
+	// root) [REQUIRED]
+	CACerts func() []byte
+	// NewKey creates the private keys of serverkeygen [REQUIRED]
+	NewKey func() (crypto.Signer, error)
+	// HTTP basic auth credentials (empty: basic auth is disabled)
+	Username string
+	Password string
+	// ClientCAs verifies the TLS client certificates (nil: client
+	// certificates are not accepted)
+	ClientCAs *x509.CertPool
+	// IsRevoked reports whether a verified client certificate is revoked;
+	// revoked certificates and errors don't authenticate (nil: revocation
+	// is not checked)
+	IsRevoked func(cert *x509.Certificate) (bool, error)
+}
+
+// Server serves the EST endpoints
+type Server struct {
+	cfg Config
+}
+
+// New creates an EST server
+func New(cfg Config) *Server {
+	return &Server{
+		cfg: cfg,
+	}
+}
+
+// Register mounts the EST endpoints on Path
+func (s *Server) Register(e *echo.Echo) {
+	g := e.Group(Path)
+	g.GET("/cacerts", s.cacerts)
+	g.POST("/simpleenroll", s.enroll(false), s.auth)
+	g.POST("/simplereenroll", s.enroll(true), s.auth)
+	g.POST("/serverkeygen", s.serverkeygen, s.auth)
+}
+
+const (
+	ctxUsername = "est.username"
+	ctxClient   = "est.client"
+)
+
+// auth authenticates the client with a TLS certificate issued by
+// Config.ClientCAs or with HTTP basic auth
+func (s *Server) auth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if cert := s.clientcert(c); cert != nil {
+			c.Set(ctxClient, cert)
+			return next(c)
+		}
+		user, pw, ok := c.Request().BasicAuth()
+		if ok && s.cfg.Username != "" &&
+			subtle.ConstantTimeCompare([]byte(user), []byte(s.cfg.Username)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(pw), []byte(s.cfg.Password)) == 1 {
+			c.Set(ctxUsername, user)
+			return next(c)
+		}
+		if s.cfg.Username != "" {
+			c.Response().Header().Set("WWW-Authenticate", `Basic realm="est"`)
+		}
+		return c.String(401, "authentication required")
+	}
+}
+
+// clientcert returns the verified TLS client certificate (nil if there is
+// none)
+func (s *Server) clientcert(c echo.Context) *x509.Certificate {
+	tlss := c.Request().TLS
+	if s.cfg.ClientCAs == nil || tlss == nil || len(tlss.PeerCertificates) == 0 {
+		return nil
+	}
+	inter := x509.NewCertPool()
+	for _, v := range tlss.PeerCertificates[1:] {
+		inter.AddCert(v)
+	}
+	cert := tlss.PeerCertificates[0]
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         s.cfg.ClientCAs,
+		Intermediates: inter,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil
+	}
+	if s.cfg.IsRevoked != nil {
+		if revoked, err := s.cfg.IsRevoked(cert); err != nil || revoked {
+			return nil
+		}
+	}
+	return cert
+}
+
+func (s *Server) cacerts(c echo.Context) error {
+	p7, err := certsonly(s.cfg.CACerts())
+	if err != nil {
+		return err
+	}
+	return s.writeb64(c, "application/pkcs7-mime", p7)
+}
+
+func (s *Server) enroll(renewal bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		csr, err := readcsr(c)
+		if err != nil {
+			return c.String(400, err.Error())
+		}
+		client, _ := c.Get(ctxClient).(*x509.Certificate)
+		if renewal && client != nil && !pkix.SameNames(csr, client) {
+			return c.String(400, "the subject of the CSR doesn't match the client certificate")
+		}
+		chain, err := s.issue(c, csr, renewal)
+		if err != nil {
+			return fail(c, err)
+		}
+		p7, err := certsonly(chain)
+		if err != nil {
+			return err
+		}
+		return s.writeb64(c, mimeCerts, p7)
+	}
+}
+
+func (s *Server) serverkeygen(c echo.Context) error {
+	csr, err := readcsr(c)
+	if err != nil {
+		return c.String(400, err.Error())
+	}
+	key, err := s.cfg.NewKey()
+	if err != nil {
+		return err
+	}
+	// the server key replaces the key of the CSR
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        csr.Subject,
+		DNSNames:       csr.DNSNames,
+		EmailAddresses: csr.EmailAddresses,
+		IPAddresses:    csr.IPAddresses,
+		URIs:           csr.URIs,
+	}, key)
+	if err != nil {
+		return err
+	}
+	csr, err = x509.ParseCertificateRequest(der)
+	if err != nil {
+		return err
+	}
+	chain, err := s.issue(c, csr, false)
+	if err != nil {
+		return fail(c, err)
+	}
+	p7, err := certsonly(chain)
+	if err != nil {
+		return err
+	}
+	keyder, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	for _, part := range []struct {
+		ctype string
+		data  []byte
+	}{
+		{mimePKCS8, keyder},
+		{mimeCerts, p7},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.ctype},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b64lines(part.data)); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	return c.Blob(200, "multipart/mixed; boundary="+mw.Boundary(), buf.Bytes())
+}
+
+func (s *Server) issue(c echo.Context, csr *x509.CertificateRequest, renewal bool) ([]byte, error) {
+	username, _ := c.Get(ctxUsername).(string)
+	client, _ := c.Get(ctxClient).(*x509.Certificate)
+	return s.cfg.Enroll(EnrollRequest{
+		CSR: pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE REQUEST",
+			Bytes: csr.Raw,
+		}),
+		RemoteIP: c.RealIP(),
+		Username: username,
+		Client:   client,
+		Renewal:  renewal,
+	})
+}
+
+// fail writes the error of an EnrollFunc
+func fail(c echo.Context, err error) error {
+	if herr, ok := err.(*echo.HTTPError); ok {
+		return c.String(herr.Code, fmt.Sprint(herr.Message))
+	}
+	return c.String(400, err.Error())
+}
+
+// readcsr reads a base64 encoded PKCS#10 request (application/pkcs10)
+func readcsr(c echo.Context) (*x509.CertificateRequest, error) {
+	raw, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, err
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, string(raw)))
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+	return csr, nil
+}
+
+// certsonly encodes PEM certificates as a degenerate (certs-only) PKCS#7
+func certsonly(pemcerts []byte) ([]byte, error) {
+	var der []byte
+	for {
+		var blk *pem.Block
+		blk, pemcerts = pem.Decode(pemcerts)
+		if blk == nil {
+			break
+		}
+		if blk.Type == "CERTIFICATE" {
+			der = append(der, blk.Bytes...)
+		}
+	}
+	return pkcs7.DegenerateCertificate(der)
+}
+
+func (s *Server) writeb64(c echo.Context, ctype string, data []byte) error {
+	c.Response().Header().Set("Content-Transfer-Encoding", "base64")
+	return c.Blob(200, ctype, b64lines(data))
+}
+
+// b64lines encodes data as base64 in lines of 64 characters
+func b64lines(data []byte) []byte {
+	v := base64.StdEncoding.EncodeToString(data)
+	buf := &bytes.Buffer{}
+	for len(v) > 64 {
+		buf.WriteString(v[:64])
+		buf.WriteString("\r\n")
+		v = v[64:]
+	}
+	buf.WriteString(v)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,101 @@
+package embedded
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// jsonfile persists an in-memory list as a JSON file shared with other
+// processes (e.g. the CLI commands): the file is read again when it changes
+// and it is replaced atomically
+type jsonfile struct {
+	l       sync.Mutex
+	path    string
+	modtime time.Time
+	size    int64
+	// decode replaces the in-memory list with the content of the file
+	decode func(raw []byte) error
+	// encode returns the value written to the file
+	encode func() interface{}
+}
+
+// openjsonfile creates the directory of path and reads the file (if any)
+func openjsonfile(path string, decode func(raw []byte) error, encode func() interface{}) (*jsonfile, error) {
+	jf := &jsonfile{
+		path:   path,
+		decode: decode,
+		encode: encode,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := jf.reload(); err != nil {
+		return nil, err
+	}
+	return jf, nil
+}
+
+// reload reads the file if it was modified since the last read (jf.l must be
+// held)
+func (jf *jsonfile) reload() error {
+	fi, err := os.Stat(jf.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(jf.modtime) && fi.Size() == jf.size {
+		return nil
+	}
+	raw, err := ioutil.ReadFile(jf.path)
+	if err != nil {
+		return err
+	}
+	if err := jf.decode(raw); err != nil {
+		return fmt.Errorf("%v: %v", jf.path, err)
+	}
+	jf.modtime = fi.ModTime()
+	jf.size = fi.Size()
+	return nil
+}
+
+// read reloads the file before a read of the in-memory list
+func (jf *jsonfile) read() error {
+	jf.l.Lock()
+	defer jf.l.Unlock()
+	return jf.reload()
+}
+
+// update applies fn to the reloaded list and writes the file
+func (jf *jsonfile) update(fn func() error) error {
+	jf.l.Lock()
+	defer jf.l.Unlock()
+	if err := jf.reload(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(jf.encode(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := jf.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, jf.path); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(jf.path); err == nil {
+		jf.modtime = fi.ModTime()
+		jf.size = fi.Size()
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package embedded
+
+import (
+	"time"
+)
+
+// DefaultLifetime is the lifetime of the certificates when neither the
+// request, the profile nor the config sets one
+const DefaultLifetime = time.Hour * 24 * 365
+
+// DefaultBackdate is subtracted from the NotBefore of the certificates (to
+// tolerate clock skew) when Config.BackdateSeconds is not set
+const DefaultBackdate = time.Minute * 15
+
+// DefaultShortLived is the maximum lifetime of short-lived certificates when
+// Config.ShortLivedSeconds is not set. Short-lived certificates are not
+// meant to be revoked (they expire first), so they have no CRL distribution
+// point nor OCSP URL.
+const DefaultShortLived = time.Hour * 24
+
+func seconds(v int64, def time.Duration) time.Duration {
+	switch {
+	case v < 0:
+		return 0
+	case v == 0:
+		return def
+	}
+	return time.Duration(v) * time.Second
+}
+
+// Backdate returns the clock skew tolerance of NotBefore
+func (s *Server) Backdate() time.Duration {
+	return seconds(s.cfg.GetBackdateSeconds(), DefaultBackdate)
+}
+
+// MaxLifetime returns the maximum lifetime of the certificates (0 if there
+// is no limit other than the CA expiration)
+func (s *Server) MaxLifetime() time.Duration {
+	return seconds(s.cfg.GetMaxLifetimeSeconds(), 0)
+}
+
+// IsShortLived reports whether a certificate lifetime is short enough to
+// skip the revocation information
+func (s *Server) IsShortLived(lifetime time.Duration) bool {
+	max := seconds(s.cfg.GetShortLivedSeconds(), DefaultShortLived)
+	return max > 0 && lifetime <= max
+}
+
+// validity returns the validity period of a certificate. The requested
+// lifetime (TTL or NotAfter) can't exceed the maximum of the config and of
+// the policy (nil if the request is trusted); the default lifetime (of the
+// profile or the config) is shortened to the maximum.
+func (s *Server) validity(req IssueRequest, profile *Profile, policy *Policy) (notBefore, notAfter time.Time, err error) {
+	now := time.Now()
+	notBefore = now.Add(-s.Backdate())
+	max := s.MaxLifetime()
+	if pmax := policy.MaxLifetime(); pmax > 0 && (max == 0 || pmax < max) {
+		max = pmax
+	}
+	var lifetime time.Duration
+	switch {
+	case req.TTL != 0 && !req.NotAfter.IsZero():
+		return notBefore, notAfter, errLifetimeConflict
+	case req.TTL < 0:
+		return notBefore, notAfter, errInvalidLifetime
+	case req.TTL > 0:
+		lifetime = req.TTL
+	case !req.NotAfter.IsZero():
+		if lifetime = req.NotAfter.Sub(now); lifetime <= 0 {
+			return notBefore, notAfter, errInvalidLifetime
+		}
+	}
+	if lifetime > 0 {
+		if max > 0 && lifetime > max {
+			return notBefore, notAfter, policyErrorf("the requested lifetime (%v) exceeds the maximum (%v)", lifetime, max)
+		}
+		return notBefore, now.Add(lifetime), nil
+	}
+	lifetime = seconds(s.cfg.GetDefaultLifetimeSeconds(), DefaultLifetime)
+	if v := profile.Lifetime(); v > 0 {
+		lifetime = v
+	}
+	if max > 0 && lifetime > max {
+		lifetime = max
+	}
+	return notBefore, now.Add(lifetime), nil
+}
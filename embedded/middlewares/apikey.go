@@ -1,14 +1,61 @@
 package middlewares
 
-import "github.com/labstack/echo/v4"
+import (
+	"crypto/subtle"
+	"errors"
+
+	"github.com/labstack/echo/v4"
+)
+
+// APIKeyIDKey is the context key of the id of the API key that authenticated
+// the request
+const APIKeyIDKey = "ztls.apikey_id"
+
+// DefaultAPIKeyID is the id of the API key set in the config
+const DefaultAPIKeyID = "default"
+
+// ErrKeyForbidden is returned (wrapped) by a KeyFunc if the key is valid but
+// can't be used in the request
+var ErrKeyForbidden = errors.New("the API key can't use this route")
+
+// KeyFunc authenticates the secret of an API key (header X-API-KEY),
+// returning the id of the key. The error message is sent to the client.
+type KeyFunc func(secret string) (id string, err error)
 
 func APIKey(key string) echo.MiddlewareFunc {
+	return APIKeyWithFail(key, TextFail)
+}
+
+// APIKeyWithFail is APIKey with a custom error response
+func APIKeyWithFail(key string, fail FailFunc) echo.MiddlewareFunc {
+	return APIKeyFunc(func(secret string) (string, error) {
+		if key == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(key)) != 1 {
+			return "", errors.New("invalid/missing header X-API-KEY")
+		}
+		return DefaultAPIKeyID, nil
+	}, fail)
+}
+
+// APIKeyFunc authenticates the requests with the API keys accepted by fn
+func APIKeyFunc(fn KeyFunc, fail FailFunc) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			if c.Request().Header.Get("X-API-KEY") != key {
-				return c.String(401, "invalid/missing header X-API-KEY")
+			id, err := fn(c.Request().Header.Get("X-API-KEY"))
+			if errors.Is(err, ErrKeyForbidden) {
+				return fail(c, 403, CodeForbidden, err.Error())
 			}
+			if err != nil {
+				return fail(c, 401, CodeUnauthorized, err.Error())
+			}
+			c.Set(APIKeyIDKey, id)
 			return next(c)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// GetAPIKeyID returns the id of the API key that authenticated the request
+// (empty if the request is anonymous)
+func GetAPIKeyID(c echo.Context) string {
+	v, _ := c.Get(APIKeyIDKey).(string)
+	return v
+}
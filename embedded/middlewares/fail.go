@@ -0,0 +1,19 @@
+package middlewares
+
+import "github.com/labstack/echo/v4"
+
+// Error codes of the middleware failures
+const (
+	CodeUnauthorized = "unauthorized"
+	CodeForbidden    = "forbidden"
+	CodeRateLimited  = "rate_limited"
+	CodeInternal     = "internal_error"
+)
+
+// FailFunc writes the error response of a middleware
+type FailFunc func(c echo.Context, status int, code, message string) error
+
+// TextFail writes the message as plain text (the /1/ API)
+func TextFail(c echo.Context, status int, code, message string) error {
+	return c.String(status, message)
+}
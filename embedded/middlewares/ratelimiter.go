@@ -3,6 +3,7 @@ package middlewares
 import (
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/ReneKroon/ttlcache"
@@ -11,11 +12,21 @@ import (
 
 type rlitem struct {
 	IP    string
-	Route string
 	Count uint64
 }
 
-func RateLimiter(max uint64, period time.Duration) echo.MiddlewareFunc {
+// Limiter counts the requests of each IP address in a period. A Limiter can
+// be shared by several routes (and by the gRPC API).
+type Limiter struct {
+	l     sync.Mutex
+	max   uint64
+	cache *ttlcache.Cache
+	// limit is the X-RateLimit-Limit header (requests per hour)
+	limit string
+}
+
+// NewLimiter creates a Limiter of max requests per period
+func NewLimiter(max uint64, period time.Duration) *Limiter {
 	if period <= 0 {
 		panic("invalid period")
 	}
@@ -25,35 +36,54 @@ func RateLimiter(max uint64, period time.Duration) echo.MiddlewareFunc {
 	cache := ttlcache.NewCache()
 	cache.SkipTtlExtensionOnHit(true)
 	cache.SetTTL(period)
-	xx := int64((time.Duration(max) * time.Hour) / period)
-	xxs := fmt.Sprint(xx)
+	return &Limiter{
+		max:   max,
+		cache: cache,
+		limit: fmt.Sprint(int64((time.Duration(max) * time.Hour) / period)),
+	}
+}
 
-	preph := func(h http.Header, n uint64) {
-		h.Set("X-RateLimit-Limit", xxs)
-		h.Set("X-RateLimit-Remaining", fmt.Sprint(max-n))
+// Allow counts a request of ip and reports whether it is within the limit,
+// with the number of requests of the period
+func (rl *Limiter) Allow(ip string) (uint64, bool) {
+	rl.l.Lock()
+	defer rl.l.Unlock()
+	ci, ok := rl.cache.Get(ip)
+	if !ok {
+		rl.cache.Set(ip, &rlitem{ip, 1})
+		return 1, true
+	}
+	rli := ci.(*rlitem)
+	if rli.Count+1 >= rl.max {
+		return rl.max, false
 	}
+	rli.Count = rli.Count + 1
+	rl.cache.Set(ip, rli)
+	return rli.Count, true
+}
 
+func RateLimiter(max uint64, period time.Duration) echo.MiddlewareFunc {
+	return RateLimiterWithFail(max, period, TextFail)
+}
+
+// RateLimiterWithFail is RateLimiter with a custom error response
+func RateLimiterWithFail(max uint64, period time.Duration, fail FailFunc) echo.MiddlewareFunc {
+	return LimiterMiddleware(NewLimiter(max, period), fail)
+}
+
+// LimiterMiddleware limits the requests with a (shared) Limiter
+func LimiterMiddleware(rl *Limiter, fail FailFunc) echo.MiddlewareFunc {
+	preph := func(h http.Header, n uint64) {
+		h.Set("X-RateLimit-Limit", rl.limit)
+		h.Set("X-RateLimit-Remaining", fmt.Sprint(rl.max-n))
+	}
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			rli := &rlitem{c.RealIP(), c.Path(), 0}
-			if _, ok := cache.Get(rli.IP); !ok {
-				rli.Count = 1
-				cache.Set(rli.IP, rli)
-				preph(c.Response().Header(), 1)
-				return next(c)
-			}
-			ci, ok := cache.Get(c.RealIP())
+			n, ok := rl.Allow(c.RealIP())
+			preph(c.Response().Header(), n)
 			if !ok {
-				return c.String(500, "failed to setup TTL")
-			}
-			rli = ci.(*rlitem)
-			if rli.Count+1 >= max {
-				preph(c.Response().Header(), max)
-				return c.String(429, "too many requests")
+				return fail(c, 429, CodeRateLimited, "too many requests")
 			}
-			rli.Count = rli.Count + 1
-			cache.Set(rli.IP, rli)
-			preph(c.Response().Header(), rli.Count)
 			return next(c)
 		}
 	}
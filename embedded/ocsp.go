@@ -0,0 +1,185 @@
+package embedded
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ReneKroon/ttlcache"
+	"github.com/gabstv/ztls/embedded/store"
+	ipkix "github.com/gabstv/ztls/internal/pkix"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ocsp"
+)
+
+// DefaultOCSPValidity is the validity (NextUpdate - ThisUpdate) of the OCSP
+// responses. Responses are cached for half of this period.
+const DefaultOCSPValidity = time.Hour
+
+// ocspSignerValidity is the lifetime of the delegated OCSP signing
+// certificate. It is renewed when half of it has elapsed.
+const ocspSignerValidity = time.Hour * 24 * 7
+
+type ocspstate struct {
+	l         sync.Mutex
+	key       crypto.Signer
+	cert      *x509.Certificate
+	cacheonce sync.Once
+	cache     *ttlcache.Cache
+}
+
+func (s *Server) ocspcache() *ttlcache.Cache {
+	s.ocsp.cacheonce.Do(func() {
+		s.ocsp.cache = ttlcache.NewCache()
+		s.ocsp.cache.SkipTtlExtensionOnHit(true)
+		s.ocsp.cache.SetTTL(DefaultOCSPValidity / 2)
+	})
+	return s.ocsp.cache
+}
+
+// ocspsigner returns the delegated OCSP signing key and certificate, issued
+// by the issuing CA
+func (s *Server) ocspsigner(cacert *x509.Certificate, cakey crypto.Signer) (crypto.Signer, *x509.Certificate, error) {
+	s.ocsp.l.Lock()
+	defer s.ocsp.l.Unlock()
+	now := time.Now()
+	if s.ocsp.cert != nil && bytes.Equal(s.ocsp.cert.RawIssuer, cacert.RawSubject) &&
+		now.Before(s.ocsp.cert.NotAfter.Add(-ocspSignerValidity/2)) {
+		return s.ocsp.key, s.ocsp.cert, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := ipkix.NewOCSPSignerCertificate(cacert, cakey, key.Public(), now.Add(ocspSignerValidity))
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.ocsp.key, s.ocsp.cert = key, cert
+	return key, cert, nil
+}
+
+// OCSP answers a DER encoded OCSP request (RFC 6960), returning a DER encoded
+// OCSP response. Errors are reported as OCSP error responses.
+func (s *Server) OCSP(reqder []byte) []byte {
+	req, err := ocsp.ParseRequest(reqder)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse
+	}
+	cacert, cakey := s.getca(), s.getkey()
+	if cacert == nil || cakey == nil {
+		return ocsp.InternalErrorErrorResponse
+	}
+	if !ocspissuermatch(req, cacert) {
+		return ocsp.UnauthorizedErrorResponse
+	}
+	cachekey := req.SerialNumber.String() + "/" + req.HashAlgorithm.String()
+	if v, ok := s.ocspcache().Get(cachekey); ok {
+		c := v.(*ocspcached)
+		// the certificate can be revoked by another process (e.g. `ztls
+		// revoke` on a file revocation list) while a Good response is cached
+		if revoked, err := s.IsRevoked(req.SerialNumber); !c.good || (err == nil && !revoked) {
+			return c.resp
+		}
+	}
+	resp, good, err := s.ocspresponse(req, cacert, cakey)
+	if err != nil {
+		log.Error().Err(err).Msg("OCSP response error")
+		return ocsp.InternalErrorErrorResponse
+	}
+	s.ocspcache().Set(cachekey, &ocspcached{resp, good})
+	return resp
+}
+
+// ocspcached is a cached OCSP response
+type ocspcached struct {
+	resp []byte
+	good bool
+}
+
+func (s *Server) ocspresponse(req *ocsp.Request, cacert *x509.Certificate, cakey crypto.Signer) (resp []byte, good bool, err error) {
+	signer, signercert, err := s.ocspsigner(cacert, cakey)
+	if err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	tpl := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(DefaultOCSPValidity),
+		Certificate:  signercert,
+		IssuerHash:   req.HashAlgorithm,
+	}
+	if s.Store != nil {
+		// only the certificates in the inventory are known
+		if _, err := s.Store.Get(req.SerialNumber); err == store.ErrNotFound {
+			tpl.Status = ocsp.Unknown
+			resp, err = ocsp.CreateResponse(cacert, signercert, tpl, signer)
+			return resp, false, err
+		} else if err != nil {
+			return nil, false, err
+		}
+	}
+	rev, revoked, err := s.Revocations.Get(req.SerialNumber)
+	if err != nil {
+		return nil, false, err
+	}
+	if revoked {
+		tpl.Status = ocsp.Revoked
+		tpl.RevokedAt = rev.RevokedAt
+		tpl.RevocationReason = int(rev.Reason)
+	}
+	resp, err = ocsp.CreateResponse(cacert, signercert, tpl, signer)
+	return resp, tpl.Status == ocsp.Good, err
+}
+
+// ocspforget removes the cached OCSP responses of a serial number
+func (s *Server) ocspforget(serial *big.Int) {
+	for _, h := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		s.ocspcache().Remove(serial.String() + "/" + h.String())
+	}
+}
+
+// ocspissuermatch reports whether the request refers to the issuing CA
+func ocspissuermatch(req *ocsp.Request, cacert *x509.Certificate) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cacert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+	h := req.HashAlgorithm.New()
+	h.Write(spki.PublicKey.RightAlign())
+	if !bytes.Equal(h.Sum(nil), req.IssuerKeyHash) {
+		return false
+	}
+	h.Reset()
+	h.Write(cacert.RawSubject)
+	return bytes.Equal(h.Sum(nil), req.IssuerNameHash)
+}
+
+// ocspurls returns the OCSP responder URLs of issued certificates
+func (s *Server) ocspurls() []string {
+	if s.cfg.GetPublicUrl() == "" {
+		return nil
+	}
+	return []string{strings.TrimSuffix(s.cfg.GetPublicUrl(), "/") + "/1/ocsp"}
+}
@@ -0,0 +1,212 @@
+package embedded
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gabstv/ztls/internal/pkix"
+)
+
+// PolicyError is returned when a request is rejected by the issuance policy
+type PolicyError struct {
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return "rejected by policy: " + e.Reason
+}
+
+func policyErrorf(format string, args ...interface{}) *PolicyError {
+	return &PolicyError{
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
+
+// PolicyFor returns the issuance policy of an API key id (an empty id is an
+// anonymous request). It returns nil if nothing is restricted.
+func (s *Server) PolicyFor(apikeyid string) *Policy {
+	if apikeyid != "" {
+		if p, ok := s.cfg.GetApikeyPolicies()[apikeyid]; ok {
+			return p
+		}
+	}
+	return s.cfg.GetPolicy()
+}
+
+// MaxLifetime returns the maximum certificate lifetime (0 if unlimited)
+func (p *Policy) MaxLifetime() time.Duration {
+	return time.Duration(p.GetMaxLifetimeSeconds()) * time.Second
+}
+
+// Check validates a certificate request against the policy. A nil policy
+// allows everything but the URI SANs (see AllowedSpiffeIds). The returned
+// error is a *PolicyError if the request is rejected.
+func (p *Policy) Check(csr *x509.CertificateRequest) error {
+	if p == nil {
+		return p.checkspiffe(csr)
+	}
+	names := csr.DNSNames
+	if cn := csr.Subject.CommonName; pkix.IsDNSName(cn) {
+		names = append([]string{cn}, names...)
+	}
+	for _, name := range names {
+		if err := p.checkdns(name); err != nil {
+			return err
+		}
+	}
+	ips := csr.IPAddresses
+	if ip := net.ParseIP(csr.Subject.CommonName); ip != nil {
+		ips = append([]net.IP{ip}, ips...)
+	}
+	for _, ip := range ips {
+		if err := p.checkip(ip); err != nil {
+			return err
+		}
+	}
+	if err := p.checksubject(csr); err != nil {
+		return err
+	}
+	if err := p.checkspiffe(csr); err != nil {
+		return err
+	}
+	return p.checkkey(csr.PublicKey)
+}
+
+func (p *Policy) checkdns(name string) error {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if strings.HasPrefix(name, "*.") && !p.GetAllowWildcards() {
+		return policyErrorf("wildcard name %q is not allowed", name)
+	}
+	for _, v := range p.GetDeniedDns() {
+		if matchdns(v, name) {
+			return policyErrorf("DNS name %q is denied", name)
+		}
+	}
+	if len(p.GetAllowedDns()) == 0 {
+		return nil
+	}
+	for _, v := range p.GetAllowedDns() {
+		if matchdns(v, name) {
+			return nil
+		}
+	}
+	return policyErrorf("DNS name %q is not allowed", name)
+}
+
+func (p *Policy) checkip(ip net.IP) error {
+	denied, err := pkix.ParseCIDRs(p.GetDeniedIpRanges())
+	if err != nil {
+		return err
+	}
+	for _, v := range denied {
+		if v.Contains(ip) {
+			return policyErrorf("IP address %v is denied", ip)
+		}
+	}
+	if len(p.GetAllowedIpRanges()) == 0 {
+		return nil
+	}
+	allowed, err := pkix.ParseCIDRs(p.GetAllowedIpRanges())
+	if err != nil {
+		return err
+	}
+	for _, v := range allowed {
+		if v.Contains(ip) {
+			return nil
+		}
+	}
+	return policyErrorf("IP address %v is not allowed", ip)
+}
+
+func (p *Policy) checksubject(csr *x509.CertificateRequest) error {
+	subj := csr.Subject
+	fields := map[string]bool{
+		"CN":           subj.CommonName != "",
+		"O":            len(subj.Organization) > 0,
+		"OU":           len(subj.OrganizationalUnit) > 0,
+		"C":            len(subj.Country) > 0,
+		"ST":           len(subj.Province) > 0,
+		"L":            len(subj.Locality) > 0,
+		"STREET":       len(subj.StreetAddress) > 0,
+		"POSTALCODE":   len(subj.PostalCode) > 0,
+		"SERIALNUMBER": subj.SerialNumber != "",
+	}
+	for _, v := range p.GetRequiredSubject() {
+		if set, ok := fields[strings.ToUpper(v)]; ok && !set {
+			return policyErrorf("subject field %v is required", v)
+		}
+	}
+	for _, v := range p.GetForbiddenSubject() {
+		if set := fields[strings.ToUpper(v)]; set {
+			return policyErrorf("subject field %v is not allowed", v)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) checkkey(pub interface{}) error {
+	kt := publickeytype(pub)
+	if rsapub, ok := pub.(*rsa.PublicKey); ok && p.GetMinRsaBits() > 0 {
+		if bits := rsapub.N.BitLen(); bits < int(p.GetMinRsaBits()) {
+			return policyErrorf("RSA key size %v is below the minimum (%v)", bits, p.GetMinRsaBits())
+		}
+	}
+	if len(p.GetAllowedKeyTypes()) == 0 {
+		return nil
+	}
+	for _, v := range p.GetAllowedKeyTypes() {
+		if t, err := pkix.ParseKeyType(v); err == nil && string(t) == kt {
+			return nil
+		}
+		if strings.EqualFold(v, kt) {
+			return nil
+		}
+	}
+	return policyErrorf("key type %v is not allowed", kt)
+}
+
+// CheckProfile validates the profile selected by a request. A nil policy
+// allows every profile.
+func (p *Policy) CheckProfile(name string) error {
+	if len(p.GetAllowedProfiles()) == 0 {
+		return nil
+	}
+	for _, v := range p.GetAllowedProfiles() {
+		if v == name {
+			return nil
+		}
+	}
+	return policyErrorf("profile %q is not allowed", name)
+}
+
+// publickeytype returns the key type name of a public key (as in
+// Policy.AllowedKeyTypes)
+func publickeytype(pub interface{}) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return string(pkix.KeyRSA)
+	case *ecdsa.PublicKey:
+		return "ecdsa-" + strings.ToLower(strings.Replace(k.Curve.Params().Name, "-", "", 1))
+	case ed25519.PublicKey:
+		return string(pkix.KeyEd25519)
+	}
+	return fmt.Sprintf("%T", pub)
+}
+
+// matchdns reports whether a name matches a pattern: "example.com" matches
+// itself, "*.example.com" matches any subdomain of example.com (including
+// wildcard names)
+func matchdns(pattern, name string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	if strings.HasPrefix(pattern, "*.") {
+		suffix := pattern[1:]
+		return len(name) > len(suffix) && strings.HasSuffix(name, suffix)
+	}
+	return pattern == name
+}
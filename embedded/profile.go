@@ -0,0 +1,151 @@
+package embedded
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ipkix "github.com/gabstv/ztls/internal/pkix"
+)
+
+// Built-in certificate profiles
+const (
+	ProfileServer      = "server"
+	ProfileClient      = "client"
+	ProfilePeer        = "peer"
+	ProfileCodeSigning = "code-signing"
+)
+
+// DefaultProfile is used when a request doesn't select a profile and
+// Config.DefaultProfile is not set
+const DefaultProfile = ProfilePeer
+
+var builtinProfiles = map[string]*Profile{
+	ProfileServer: {
+		ExtKeyUsage: []string{"serverAuth"},
+		CopyCnToSan: true,
+	},
+	ProfileClient: {
+		ExtKeyUsage: []string{"clientAuth"},
+	},
+	ProfilePeer: {
+		ExtKeyUsage: []string{"serverAuth", "clientAuth"},
+		CopyCnToSan: true,
+	},
+	ProfileCodeSigning: {
+		KeyUsage:    []string{"digitalSignature"},
+		ExtKeyUsage: []string{"codeSigning"},
+	},
+}
+
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+}
+
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverauth":      x509.ExtKeyUsageServerAuth,
+	"clientauth":      x509.ExtKeyUsageClientAuth,
+	"codesigning":     x509.ExtKeyUsageCodeSigning,
+	"emailprotection": x509.ExtKeyUsageEmailProtection,
+	"timestamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspsigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// Profile returns a certificate profile by name (an empty name is the
+// default profile)
+func (s *Server) Profile(name string) (*Profile, error) {
+	if name == "" {
+		name = s.DefaultProfileName()
+	}
+	if p, ok := s.cfg.GetProfiles()[name]; ok {
+		return p, nil
+	}
+	if p, ok := builtinProfiles[name]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("%w: %v", errUnknownProfile, name)
+}
+
+// DefaultProfileName returns the name of the profile used when a request
+// doesn't select one
+func (s *Server) DefaultProfileName() string {
+	if v := s.cfg.GetDefaultProfile(); v != "" {
+		return v
+	}
+	return DefaultProfile
+}
+
+// Lifetime returns the lifetime of the certificates of the profile (0 if
+// not set)
+func (p *Profile) Lifetime() time.Duration {
+	return time.Duration(p.GetLifetimeSeconds()) * time.Second
+}
+
+// apply sets the key usages and extensions of the profile. Empty usages
+// keep the defaults of ipkix.NewCertificatePEM.
+func (p *Profile) apply(input *ipkix.NewCertificatePEMInput) error {
+	for _, v := range p.GetKeyUsage() {
+		ku, ok := keyUsageNames[strings.ToLower(v)]
+		if !ok {
+			return fmt.Errorf("unknown key usage: %v", v)
+		}
+		input.KeyUsage |= ku
+	}
+	ekus, err := p.extkeyusages()
+	if err != nil {
+		return err
+	}
+	input.ExtKeyUsage = append(input.ExtKeyUsage, ekus...)
+	for _, v := range p.GetExtensions() {
+		oid, err := parseoid(v.GetOid())
+		if err != nil {
+			return err
+		}
+		input.ExtraExtensions = append(input.ExtraExtensions, pkix.Extension{
+			Id:       oid,
+			Critical: v.GetCritical(),
+			Value:    v.GetValue(),
+		})
+	}
+	input.CopyCommonName = p.GetCopyCnToSan()
+	return nil
+}
+
+// extkeyusages returns the extended key usages of the profile (nil keeps the
+// defaults of ipkix.NewCertificatePEM)
+func (p *Profile) extkeyusages() ([]x509.ExtKeyUsage, error) {
+	var ekus []x509.ExtKeyUsage
+	for _, v := range p.GetExtKeyUsage() {
+		eku, ok := extKeyUsageNames[strings.ToLower(v)]
+		if !ok {
+			return nil, fmt.Errorf("unknown extended key usage: %v", v)
+		}
+		ekus = append(ekus, eku)
+	}
+	return ekus, nil
+}
+
+func parseoid(v string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(v, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid object identifier: %v", v)
+	}
+	oid := make(asn1.ObjectIdentifier, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid object identifier: %v", v)
+		}
+		oid = append(oid, n)
+	}
+	return oid, nil
+}
@@ -0,0 +1,155 @@
+package embedded
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gabstv/ztls/internal/pkix"
+)
+
+// RenewRequest is a renewal authenticated by the current certificate
+type RenewRequest struct {
+	CSR []byte // [REQUIRED] PEM encoded
+	// Current is the certificate being renewed [REQUIRED]
+	Current *x509.Certificate
+	// TLS is true if Current was presented as a TLS client certificate,
+	// which proves the possession of its key. Otherwise, Signature must be
+	// a pkix.SignProof signature of the CSR (DER) by the key of Current, or
+	// empty if the CSR has the key of Current.
+	TLS       bool
+	Signature []byte
+	Requester string // [OPTIONAL] e.g. the IP address of the client
+	Profile   string // [OPTIONAL] certificate profile (default: the profile of Current)
+	// [OPTIONAL] requested lifetime (TTL or NotAfter, not both)
+	TTL      time.Duration
+	NotAfter time.Time
+}
+
+// Renew issues a certificate with the identity (subject and alternative
+// names) of a certificate issued by this server, unexpired and not revoked.
+// The policy of the API key and the profile of the original request apply: a
+// profile with other extended key usages is rejected.
+func (s *Server) Renew(req RenewRequest) (cert []byte, err error) {
+	if req.Current == nil {
+		return nil, errRenewUntrusted
+	}
+	csrder, err := pkix.DecodePEM(req.CSR, pkix.PEMCertificateRequest, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidCSR, err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrder)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidCSR, err)
+	}
+	if !req.TLS {
+		if len(req.Signature) > 0 {
+			if err := pkix.VerifyProof(req.Current.PublicKey, csrder, req.Signature); err != nil {
+				return nil, errRenewProof
+			}
+		} else if !samepublickey(csr, req.Current) || csr.CheckSignature() != nil {
+			return nil, errRenewProof
+		}
+	}
+	if err := s.checkcurrent(req.Current); err != nil {
+		return nil, err
+	}
+	apikeyid, profname := "", req.Profile
+	if s.Store != nil {
+		r, err := s.Store.Get(req.Current.SerialNumber)
+		if err != nil {
+			return nil, errRenewUntrusted
+		}
+		apikeyid = r.APIKeyID
+		// the renewal keeps the profile of the original request
+		if r.Profile != "" {
+			if profname != "" && profname != r.Profile {
+				return nil, errRenewProfile
+			}
+			profname = r.Profile
+		}
+	}
+	if !pkix.SameNames(csr, req.Current) {
+		return nil, errRenewIdentity
+	}
+	if err := s.checkprofile(profname, req.Current); err != nil {
+		return nil, err
+	}
+	return s.Issue(IssueRequest{
+		CSR:         req.CSR,
+		Requester:   req.Requester,
+		APIKeyID:    apikeyid,
+		Profile:     profname,
+		TTL:         req.TTL,
+		NotAfter:    req.NotAfter,
+		RenewedFrom: req.Current.SerialNumber,
+	})
+}
+
+// checkcurrent checks that a certificate was issued by this server, is
+// unexpired and is not revoked
+func (s *Server) checkcurrent(cert *x509.Certificate) error {
+	now := time.Now()
+	if now.After(cert.NotAfter) {
+		return errRenewExpired
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(s.RootCA())
+	inter := x509.NewCertPool()
+	inter.AppendCertsFromPEM(s.Chain())
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inter,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return errRenewUntrusted
+	}
+	revoked, err := s.IsRevoked(cert.SerialNumber)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return errRenewRevoked
+	}
+	return nil
+}
+
+// checkprofile checks that a profile issues the extended key usages of a
+// certificate, so a renewal can't extend them
+func (s *Server) checkprofile(name string, cert *x509.Certificate) error {
+	profile, err := s.Profile(name)
+	if err != nil {
+		return err
+	}
+	ekus, err := profile.extkeyusages()
+	if err != nil {
+		return err
+	}
+	if ekus == nil {
+		// the defaults of pkix.NewCertificatePEM
+		ekus = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+	if len(cert.UnknownExtKeyUsage) > 0 || !sameekus(ekus, cert.ExtKeyUsage) {
+		return errRenewProfile
+	}
+	return nil
+}
+
+func sameekus(a, b []x509.ExtKeyUsage) bool {
+	set := func(list []x509.ExtKeyUsage) map[x509.ExtKeyUsage]bool {
+		v := make(map[x509.ExtKeyUsage]bool, len(list))
+		for _, eku := range list {
+			v[eku] = true
+		}
+		return v
+	}
+	return reflect.DeepEqual(set(a), set(b))
+}
+
+func samepublickey(csr *x509.CertificateRequest, cert *x509.Certificate) bool {
+	k, ok := csr.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(cert.PublicKey)
+}
@@ -0,0 +1,207 @@
+package embedded
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RevocationReason is a CRL reason code (RFC 5280, section 5.3.1)
+type RevocationReason int
+
+const (
+	ReasonUnspecified          RevocationReason = 0
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonCACompromise         RevocationReason = 2
+	ReasonAffiliationChanged   RevocationReason = 3
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+	ReasonCertificateHold      RevocationReason = 6
+	ReasonPrivilegeWithdrawn   RevocationReason = 9
+	ReasonAACompromise         RevocationReason = 10
+)
+
+var reasonNames = map[RevocationReason]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "aACompromise",
+}
+
+func (r RevocationReason) String() string {
+	if v, ok := reasonNames[r]; ok {
+		return v
+	}
+	return fmt.Sprintf("RevocationReason(%d)", int(r))
+}
+
+// ParseRevocationReason parses a reason name (case insensitive, as in
+// RFC 5280: keyCompromise, superseded, ...)
+func ParseRevocationReason(name string) (RevocationReason, error) {
+	if name == "" {
+		return ReasonUnspecified, nil
+	}
+	for k, v := range reasonNames {
+		if strings.EqualFold(v, name) {
+			return k, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason: %v", name)
+}
+
+// Revocation is a revoked certificate entry
+type Revocation struct {
+	Serial    *big.Int
+	Reason    RevocationReason
+	RevokedAt time.Time
+}
+
+// RevocationList persists the revoked certificates
+type RevocationList interface {
+	// Revoke adds (or replaces) a revocation entry
+	Revoke(r Revocation) error
+	// Get returns the revocation entry of a serial number (ok is false if
+	// the certificate is not revoked)
+	Get(serial *big.Int) (r Revocation, ok bool, err error)
+	// List returns all entries sorted by RevokedAt
+	List() ([]Revocation, error)
+}
+
+// NewMemRevocationList creates an in-memory (not persisted) RevocationList
+func NewMemRevocationList() RevocationList {
+	return &memRevocationList{
+		m: make(map[string]Revocation),
+	}
+}
+
+type memRevocationList struct {
+	l sync.RWMutex
+	m map[string]Revocation
+}
+
+func (rl *memRevocationList) Revoke(r Revocation) error {
+	if r.Serial == nil {
+		return errInvalidSerial
+	}
+	if r.RevokedAt.IsZero() {
+		r.RevokedAt = time.Now()
+	}
+	rl.l.Lock()
+	defer rl.l.Unlock()
+	rl.m[r.Serial.String()] = r
+	return nil
+}
+
+func (rl *memRevocationList) Get(serial *big.Int) (Revocation, bool, error) {
+	if serial == nil {
+		return Revocation{}, false, errInvalidSerial
+	}
+	rl.l.RLock()
+	defer rl.l.RUnlock()
+	r, ok := rl.m[serial.String()]
+	return r, ok, nil
+}
+
+func (rl *memRevocationList) List() ([]Revocation, error) {
+	rl.l.RLock()
+	defer rl.l.RUnlock()
+	outp := make([]Revocation, 0, len(rl.m))
+	for _, v := range rl.m {
+		outp = append(outp, v)
+	}
+	sort.Slice(outp, func(i, j int) bool {
+		return outp[i].RevokedAt.Before(outp[j].RevokedAt)
+	})
+	return outp, nil
+}
+
+// OpenFileRevocationList opens (or creates) a RevocationList persisted as a
+// JSON file. Changes made to the file by other processes (e.g. the
+// `ztls revoke` command) are picked up automatically.
+func OpenFileRevocationList(path string) (RevocationList, error) {
+	rl := &fileRevocationList{
+		mem: NewMemRevocationList().(*memRevocationList),
+	}
+	f, err := openjsonfile(path, rl.decode, rl.encode)
+	if err != nil {
+		return nil, err
+	}
+	rl.f = f
+	return rl, nil
+}
+
+type fileRevocationList struct {
+	f   *jsonfile
+	mem *memRevocationList
+}
+
+type revocationJSON struct {
+	Serial    string    `json:"serial"`
+	Reason    int       `json:"reason"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+func (rl *fileRevocationList) decode(raw []byte) error {
+	var items []revocationJSON
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return err
+	}
+	m := make(map[string]Revocation, len(items))
+	for _, v := range items {
+		serial, ok := new(big.Int).SetString(v.Serial, 10)
+		if !ok {
+			return fmt.Errorf("invalid serial: %v", v.Serial)
+		}
+		m[serial.String()] = Revocation{
+			Serial:    serial,
+			Reason:    RevocationReason(v.Reason),
+			RevokedAt: v.RevokedAt,
+		}
+	}
+	rl.mem.l.Lock()
+	rl.mem.m = m
+	rl.mem.l.Unlock()
+	return nil
+}
+
+func (rl *fileRevocationList) encode() interface{} {
+	all, _ := rl.mem.List()
+	items := make([]revocationJSON, 0, len(all))
+	for _, v := range all {
+		items = append(items, revocationJSON{
+			Serial:    v.Serial.String(),
+			Reason:    int(v.Reason),
+			RevokedAt: v.RevokedAt.UTC(),
+		})
+	}
+	return items
+}
+
+func (rl *fileRevocationList) Revoke(r Revocation) error {
+	return rl.f.update(func() error {
+		return rl.mem.Revoke(r)
+	})
+}
+
+func (rl *fileRevocationList) Get(serial *big.Int) (Revocation, bool, error) {
+	if err := rl.f.read(); err != nil {
+		return Revocation{}, false, err
+	}
+	return rl.mem.Get(serial)
+}
+
+func (rl *fileRevocationList) List() ([]Revocation, error) {
+	if err := rl.f.read(); err != nil {
+		return nil, err
+	}
+	return rl.mem.List()
+}
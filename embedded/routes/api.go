@@ -1,20 +1,46 @@
 package routes
 
 import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabstv/ztls/embedded/middlewares"
+	"github.com/gabstv/ztls/embedded/store"
 	echo "github.com/labstack/echo/v4"
 )
 
-type CSRFunc func(csr []byte) (cert []byte, err error)
+// CSRRequest is a certificate request received by the API
+type CSRRequest struct {
+	CSR      []byte // PEM encoded
+	RemoteIP string
+	APIKeyID string // empty if the request is anonymous
+	Profile  string // empty selects the default profile
+	TTL      time.Duration
+	NotAfter time.Time
+	// Token is the bootstrap token of the request (header
+	// X-BOOTSTRAP-TOKEN), empty if there is none
+	Token string
+}
+
+type CSRFunc func(req CSRRequest) (cert []byte, err error)
 
 func PostCSR(csrfn CSRFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		d := struct {
-			CSR string `json:"csr" xml:"csr" form:"csr"`
-		}{}
-		if err := c.Bind(&d); err != nil {
+		req, err := bindcsr(c)
+		if err != nil {
 			return c.String(400, err.Error())
 		}
-		cert, err := csrfn([]byte(d.CSR))
+		cert, err := csrfn(req)
+		if herr, ok := err.(*echo.HTTPError); ok {
+			return c.String(herr.Code, fmt.Sprint(herr.Message))
+		}
 		if err != nil {
 			return c.String(400, err.Error())
 		}
@@ -22,8 +48,144 @@ func PostCSR(csrfn CSRFunc) echo.HandlerFunc {
 	}
 }
 
+// csrform are the fields of a certificate request: csr, profile, ttl and
+// not_after (RFC 3339)
+type csrform struct {
+	CSR      string `json:"csr" xml:"csr" form:"csr"`
+	Profile  string `json:"profile" xml:"profile" form:"profile"`
+	TTL      string `json:"ttl" xml:"ttl" form:"ttl"`
+	NotAfter string `json:"not_after" xml:"not_after" form:"not_after"`
+}
+
+func (d csrform) request(c echo.Context) (CSRRequest, error) {
+	req := CSRRequest{
+		CSR:      []byte(d.CSR),
+		RemoteIP: c.RealIP(),
+		APIKeyID: middlewares.GetAPIKeyID(c),
+		Profile:  d.Profile,
+		Token:    c.Request().Header.Get("X-BOOTSTRAP-TOKEN"),
+	}
+	if d.TTL != "" {
+		ttl, err := ParseTTL(d.TTL)
+		if err != nil {
+			return req, errors.New("ttl: " + err.Error())
+		}
+		req.TTL = ttl
+	}
+	if d.NotAfter != "" {
+		t, err := time.Parse(time.RFC3339, d.NotAfter)
+		if err != nil {
+			return req, errors.New("not_after: " + err.Error())
+		}
+		req.NotAfter = t
+	}
+	return req, nil
+}
+
+// bindcsr reads the fields of a certificate request (see csrform)
+func bindcsr(c echo.Context) (CSRRequest, error) {
+	d := csrform{}
+	if err := c.Bind(&d); err != nil {
+		return CSRRequest{}, err
+	}
+	return d.request(c)
+}
+
+// ParseTTL parses a lifetime: a duration (e.g. 4h, 90m) or a number of
+// seconds
+func ParseTTL(v string) (time.Duration, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(v)
+}
+
 func GetCA(ca []byte) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		return c.String(200, string(ca))
 	}
 }
+
+type BlobFunc func() ([]byte, error)
+
+// GetBlob serves the bytes returned by fn with the given content type
+func GetBlob(contentType string, fn BlobFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		b, err := fn()
+		if err != nil {
+			return c.String(500, err.Error())
+		}
+		return c.Blob(200, contentType, b)
+	}
+}
+
+type OCSPFunc func(req []byte) []byte
+
+// OCSP answers OCSP requests (RFC 6960, appendix A): POST with a DER body or
+// GET with the base64 (and URL) encoded request in the path
+func OCSP(fn OCSPFunc, maxage time.Duration) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var reqder []byte
+		if c.Request().Method == http.MethodGet {
+			raw, err := url.PathUnescape(c.Param("*"))
+			if err != nil {
+				return c.String(400, err.Error())
+			}
+			raw = strings.TrimRight(raw, "=")
+			if reqder, err = base64.RawStdEncoding.DecodeString(raw); err != nil {
+				return c.String(400, err.Error())
+			}
+		} else {
+			var err error
+			if reqder, err = ioutil.ReadAll(c.Request().Body); err != nil {
+				return c.String(400, err.Error())
+			}
+		}
+		resp := fn(reqder)
+		c.Response().Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", int(maxage.Seconds())))
+		return c.Blob(200, "application/ocsp-response", resp)
+	}
+}
+
+type ListFunc func(f store.Filter) ([]store.Record, error)
+
+// ListCertificates returns the issued certificates (JSON) matching the query
+// parameters: host, cn, api_key_id, valid_at, issued_after, issued_before
+// (RFC 3339) and limit
+func ListCertificates(fn ListFunc) echo.HandlerFunc {
+	return listcertificates(fn, middlewares.TextFail)
+}
+
+func listcertificates(fn ListFunc, fail middlewares.FailFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		f := store.Filter{
+			Host:       c.QueryParam("host"),
+			CommonName: c.QueryParam("cn"),
+			APIKeyID:   c.QueryParam("api_key_id"),
+		}
+		for k, t := range map[string]*time.Time{
+			"valid_at":      &f.ValidAt,
+			"issued_after":  &f.IssuedAfter,
+			"issued_before": &f.IssuedBefore,
+		} {
+			if v := c.QueryParam(k); v != "" {
+				var err error
+				if *t, err = time.Parse(time.RFC3339, v); err != nil {
+					return fail(c, 400, CodeInvalidRequest, k+": "+err.Error())
+				}
+			}
+		}
+		if v := c.QueryParam("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fail(c, 400, CodeInvalidRequest, "limit: "+err.Error())
+			}
+			f.Limit = n
+		}
+		list, err := fn(f)
+		if err != nil {
+			return fail(c, 500, CodeInternal, err.Error())
+		}
+		return c.JSON(200, list)
+	}
+}
@@ -0,0 +1,252 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/gabstv/ztls/embedded/middlewares"
+	echo "github.com/labstack/echo/v4"
+)
+
+// Error codes of the /2/ API
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeInvalidCSR       = "invalid_csr"
+	CodeInvalidLifetime  = "invalid_lifetime"
+	CodeUnknownProfile   = "unknown_profile"
+	CodePolicyRejected   = "policy_rejected"
+	CodeIdentityMismatch = "identity_mismatch"
+	CodeUnauthorized     = middlewares.CodeUnauthorized
+	CodeForbidden        = middlewares.CodeForbidden
+	CodeRateLimited      = middlewares.CodeRateLimited
+	CodeInternal         = middlewares.CodeInternal
+)
+
+// APIError is a typed error of the /2/ API
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// NewAPIError creates an APIError
+func NewAPIError(status int, code, message string) *APIError {
+	return &APIError{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+// FailJSON writes an error of the /2/ API: {"error": {"code", "message"}}
+func FailJSON(c echo.Context, status int, code, message string) error {
+	return c.JSON(status, map[string]*APIError{
+		"error": NewAPIError(status, code, message),
+	})
+}
+
+// Certificate is an issued certificate and its metadata
+type Certificate struct {
+	Certificate    string    `json:"certificate"`     // PEM
+	Chain          string    `json:"chain,omitempty"` // PEM, intermediate certificates
+	Serial         string    `json:"serial"`          // decimal
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	Fingerprint    string    `json:"fingerprint"` // SHA-256 of the DER, hex
+	CommonName     string    `json:"common_name,omitempty"`
+	DNSNames       []string  `json:"dns_names,omitempty"`
+	IPAddresses    []string  `json:"ip_addresses,omitempty"`
+	EmailAddresses []string  `json:"email_addresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+}
+
+// NewCertificate parses a PEM certificate followed by its intermediate
+// certificates
+func NewCertificate(chainpem []byte) (*Certificate, error) {
+	blk, rest := pem.Decode(chainpem)
+	if blk == nil {
+		return nil, errors.New("invalid PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(blk.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	fp := sha256.Sum256(cert.Raw)
+	v := &Certificate{
+		Certificate:    string(pem.EncodeToMemory(blk)),
+		Chain:          string(rest),
+		Serial:         cert.SerialNumber.String(),
+		NotBefore:      cert.NotBefore.UTC(),
+		NotAfter:       cert.NotAfter.UTC(),
+		Fingerprint:    hex.EncodeToString(fp[:]),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+	}
+	for _, ip := range cert.IPAddresses {
+		v.IPAddresses = append(v.IPAddresses, ip.String())
+	}
+	for _, u := range cert.URIs {
+		v.URIs = append(v.URIs, u.String())
+	}
+	return v, nil
+}
+
+// PostCSRV2 signs a certificate request (same fields as PostCSR) and returns
+// the Certificate as JSON. Errors of type *APIError are returned as is,
+// others as internal errors.
+func PostCSRV2(csrfn CSRFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req, err := bindcsr(c)
+		if err != nil {
+			return FailJSON(c, 400, CodeInvalidRequest, err.Error())
+		}
+		chain, err := csrfn(req)
+		return writecert(c, chain, err)
+	}
+}
+
+// writecert writes the response of PostCSRV2 and PostRenew
+func writecert(c echo.Context, chain []byte, err error) error {
+	if aerr, ok := err.(*APIError); ok {
+		return FailJSON(c, aerr.Status, aerr.Code, aerr.Message)
+	}
+	if err != nil {
+		return FailJSON(c, 500, CodeInternal, err.Error())
+	}
+	cert, err := NewCertificate(chain)
+	if err != nil {
+		return FailJSON(c, 500, CodeInternal, err.Error())
+	}
+	return c.JSON(200, cert)
+}
+
+// GetCAV2 returns the PEM encoded root and intermediate certificates as JSON
+func GetCAV2(root, chain []byte) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(200, struct {
+			Root  string `json:"root"`
+			Chain string `json:"chain,omitempty"`
+		}{string(root), string(chain)})
+	}
+}
+
+// ListCertificatesV2 is ListCertificates with JSON errors
+func ListCertificatesV2(fn ListFunc) echo.HandlerFunc {
+	return listcertificates(fn, FailJSON)
+}
+
+// RenewRequest is a renewal received by the API
+type RenewRequest struct {
+	CSRRequest
+	// PeerCertificates are the TLS client certificates (if any)
+	PeerCertificates []*x509.Certificate
+	// Certificate (PEM) and Signature authenticate the request when no
+	// client certificate is used
+	Certificate []byte
+	Signature   []byte
+}
+
+type RenewFunc func(req RenewRequest) (cert []byte, err error)
+
+// PostRenew renews a certificate: the fields of PostCSR, plus certificate
+// (PEM) and signature (base64) if the request is not made over mTLS. The
+// response is the same as PostCSRV2.
+func PostRenew(fn RenewFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d := struct {
+			csrform
+			Certificate string `json:"certificate" xml:"certificate" form:"certificate"`
+			Signature   string `json:"signature" xml:"signature" form:"signature"`
+		}{}
+		if err := c.Bind(&d); err != nil {
+			return FailJSON(c, 400, CodeInvalidRequest, err.Error())
+		}
+		csrreq, err := d.request(c)
+		if err != nil {
+			return FailJSON(c, 400, CodeInvalidRequest, err.Error())
+		}
+		req := RenewRequest{
+			CSRRequest:  csrreq,
+			Certificate: []byte(d.Certificate),
+		}
+		if d.Signature != "" {
+			if req.Signature, err = base64.StdEncoding.DecodeString(d.Signature); err != nil {
+				return FailJSON(c, 400, CodeInvalidRequest, "signature: "+err.Error())
+			}
+		}
+		if tlss := c.Request().TLS; tlss != nil {
+			req.PeerCertificates = tlss.PeerCertificates
+		}
+		chain, err := fn(req)
+		return writecert(c, chain, err)
+	}
+}
+
+// TokenRequest is a bootstrap token request received by the API
+type TokenRequest struct {
+	CommonName string
+	Names      []string
+	Profile    string
+	Uses       int
+	TTL        time.Duration
+	APIKeyID   string
+}
+
+// Token is a bootstrap token created by the API
+type Token struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"` // presented in the header X-BOOTSTRAP-TOKEN
+	ExpiresAt time.Time `json:"expires_at"`
+	UsesLeft  int       `json:"uses_left"`
+}
+
+type TokenFunc func(req TokenRequest) (*Token, error)
+
+// PostToken creates a bootstrap token from the fields common_name, names,
+// profile, uses and ttl. The response is the Token as JSON.
+func PostToken(fn TokenFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		d := struct {
+			CommonName string   `json:"common_name" form:"common_name"`
+			Names      []string `json:"names" form:"names"`
+			Profile    string   `json:"profile" form:"profile"`
+			Uses       int      `json:"uses" form:"uses"`
+			TTL        string   `json:"ttl" form:"ttl"`
+		}{}
+		if err := c.Bind(&d); err != nil {
+			return FailJSON(c, 400, CodeInvalidRequest, err.Error())
+		}
+		req := TokenRequest{
+			CommonName: d.CommonName,
+			Names:      d.Names,
+			Profile:    d.Profile,
+			Uses:       d.Uses,
+			APIKeyID:   middlewares.GetAPIKeyID(c),
+		}
+		if d.TTL != "" {
+			ttl, err := ParseTTL(d.TTL)
+			if err != nil {
+				return FailJSON(c, 400, CodeInvalidRequest, "ttl: "+err.Error())
+			}
+			req.TTL = ttl
+		}
+		t, err := fn(req)
+		if aerr, ok := err.(*APIError); ok {
+			return FailJSON(c, aerr.Status, aerr.Code, aerr.Message)
+		}
+		if err != nil {
+			return FailJSON(c, 500, CodeInternal, err.Error())
+		}
+		return c.JSON(200, t)
+	}
+}
@@ -0,0 +1,97 @@
+package embedded
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"github.com/gabstv/ztls/embedded/scep"
+	"github.com/gabstv/ztls/internal/pkix"
+)
+
+// scepRAValidity is the lifetime of the SCEP RA certificate (when the CA key
+// is not RSA). It is renewed when half of it has elapsed.
+const scepRAValidity = time.Hour * 24 * 30
+
+const scepRAKeySize = 2048
+
+type scepstate struct {
+	l    sync.Mutex
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+// SCEPAPIKeyID is the API key id of the SCEP requests (its policy applies)
+const SCEPAPIKeyID = "scep"
+
+func (s *Server) scepserver() *scep.Server {
+	cfg := s.cfg.GetScep()
+	return scep.New(scep.Config{
+		Enroll: func(req scep.EnrollRequest) ([]byte, error) {
+			if req.Renewal {
+				if revoked, err := s.IsRevoked(req.Signer.SerialNumber); err != nil || revoked {
+					return nil, policyErrorf("the signer certificate is revoked")
+				}
+			}
+			return s.Issue(IssueRequest{
+				CSR:       req.CSR,
+				Requester: req.RemoteIP,
+				APIKeyID:  SCEPAPIKeyID,
+				Profile:   cfg.GetProfile(),
+			})
+		},
+		CACerts:            s.scepcacerts,
+		RA:                 s.scepra,
+		ChallengePasswords: cfg.GetChallengePasswords(),
+	})
+}
+
+// scepcacerts returns the issuing CA followed by the root (if different)
+func (s *Server) scepcacerts() []*x509.Certificate {
+	cacert := s.getca()
+	certs := []*x509.Certificate{cacert}
+	if len(s.cfg.Issuercert) > 0 {
+		if der, err := pkix.DecodePEM(s.cfg.Rootcert, pkix.PEMCertificate, nil); err == nil {
+			if root, err := x509.ParseCertificate(der); err == nil {
+				certs = append(certs, root)
+			}
+		}
+	}
+	return certs
+}
+
+// scepra returns the SCEP RA: the issuing CA if its key is RSA, otherwise
+// an RSA certificate issued by the CA
+func (s *Server) scepra() (*rsa.PrivateKey, *x509.Certificate, error) {
+	cacert, cakey := s.getca(), s.getkey()
+	if cacert == nil || cakey == nil {
+		return nil, nil, errNoIssuer
+	}
+	if k, ok := cakey.(*rsa.PrivateKey); ok {
+		return k, cacert, nil
+	}
+	s.scep.l.Lock()
+	defer s.scep.l.Unlock()
+	now := time.Now()
+	if s.scep.cert != nil && bytes.Equal(s.scep.cert.RawIssuer, cacert.RawSubject) &&
+		now.Before(s.scep.cert.NotAfter.Add(-scepRAValidity/2)) {
+		return s.scep.key, s.scep.cert, nil
+	}
+	key, err := rsa.GenerateKey(rand.Reader, scepRAKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := pkix.NewSCEPRACertificate(cacert, cakey, key.Public(), now.Add(scepRAValidity))
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.scep.key, s.scep.cert = key, cert
+	return key, cert, nil
+}
@@ -0,0 +1,381 @@
+// Package scep implements a SCEP (RFC 8894) responder, for the devices
+// (printers, MDM-managed computers) that can only enroll with SCEP.
+package scep
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gabstv/ztls/internal/pkix"
+	echo "github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"go.mozilla.org/pkcs7"
+)
+
+// Path is where the SCEP responder is mounted
+const Path = "/scep"
+
+// message types
+const (
+	msgCertRep    = "3"
+	msgRenewalReq = "17"
+	msgPKCSReq    = "19"
+)
+
+// pkiStatus values
+const (
+	statusSuccess = "0"
+	statusFailure = "2"
+)
+
+// failInfo values
+const (
+	failBadAlg          = "0"
+	failBadMessageCheck = "1"
+	failBadRequest      = "2"
+)
+
+var (
+	oidMessageType       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidPKIStatus         = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidFailInfo          = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSenderNonce       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidRecipientNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidTransactionID     = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+	oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+)
+
+// EnrollRequest is a PKCSReq or RenewalReq message
+type EnrollRequest struct {
+	CSR      []byte // PEM encoded
+	RemoteIP string
+	// Signer is the certificate that signed the message: self-signed for
+	// PKCSReq, issued by this CA for RenewalReq
+	Signer  *x509.Certificate
+	Renewal bool
+}
+
+// EnrollFunc signs a certificate request, returning the PEM encoded
+// certificate followed by the intermediate certificates
+type EnrollFunc func(req EnrollRequest) (chain []byte, err error)
+
+type Config struct {
+	// Enroll signs the certificates [REQUIRED]
+	Enroll EnrollFunc
+	// CACerts returns the CA certificates: the issuing CA first, followed
+	// by its parents [REQUIRED]
+	CACerts func() []*x509.Certificate
+	// RA returns the key and certificate that decrypt the requests and sign
+	// the responses (it can be the issuing CA if its key is RSA) [REQUIRED]
+	RA func() (*rsa.PrivateKey, *x509.Certificate, error)
+	// ChallengePasswords are the accepted challenge passwords of PKCSReq
+	// (empty: PKCSReq is disabled)
+	ChallengePasswords []string
+}
+
+// Server is a SCEP responder
+type Server struct {
+	cfg Config
+}
+
+// New creates a SCEP responder
+func New(cfg Config) *Server {
+	return &Server{
+		cfg: cfg,
+	}
+}
+
+// Register mounts the SCEP responder on Path (and Path/pkiclient.exe)
+func (s *Server) Register(e *echo.Echo) {
+	for _, p := range []string{Path, Path + "/pkiclient.exe"} {
+		e.GET(p, s.handle)
+		e.POST(p, s.handle)
+	}
+}
+
+func (s *Server) handle(c echo.Context) error {
+	switch op := c.QueryParam("operation"); op {
+	case "GetCACaps":
+		return c.String(200, strings.Join([]string{
+			"POSTPKIOperation",
+			"Renewal",
+			"SHA-1",
+			"SHA-256",
+			"AES",
+			"SCEPStandard",
+		}, "\n"))
+	case "GetCACert":
+		return s.getcacert(c)
+	case "PKIOperation":
+		var msg []byte
+		var err error
+		if c.Request().Method == http.MethodPost {
+			msg, err = ioutil.ReadAll(c.Request().Body)
+		} else {
+			msg, err = base64.StdEncoding.DecodeString(c.QueryParam("message"))
+		}
+		if err != nil {
+			return c.String(400, "invalid message")
+		}
+		resp, err := s.pkioperation(c, msg)
+		if err != nil {
+			return c.String(400, err.Error())
+		}
+		return c.Blob(200, "application/x-pki-message", resp)
+	default:
+		return c.String(400, "unsupported operation: "+op)
+	}
+}
+
+func (s *Server) getcacert(c echo.Context) error {
+	_, ra, err := s.cfg.RA()
+	if err != nil {
+		return err
+	}
+	cas := s.cfg.CACerts()
+	if len(cas) == 1 && ra.Equal(cas[0]) {
+		return c.Blob(200, "application/x-x509-ca-cert", ra.Raw)
+	}
+	var der []byte
+	if !ra.Equal(cas[0]) {
+		der = append(der, ra.Raw...)
+	}
+	for _, v := range cas {
+		der = append(der, v.Raw...)
+	}
+	p7, err := pkcs7.DegenerateCertificate(der)
+	if err != nil {
+		return err
+	}
+	return c.Blob(200, "application/x-x509-ca-ra-cert", p7)
+}
+
+// pkimessage is a verified request
+type pkimessage struct {
+	msgtype string
+	txid    string
+	nonce   []byte
+	signer  *x509.Certificate
+	content []byte // pkcsPKIEnvelope
+}
+
+func (s *Server) pkioperation(c echo.Context, raw []byte) ([]byte, error) {
+	p7, err := pkcs7.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := p7.Verify(); err != nil {
+		return nil, err
+	}
+	msg := &pkimessage{
+		signer:  p7.GetOnlySigner(),
+		content: p7.Content,
+	}
+	if msg.signer == nil {
+		return nil, errors.New("the message must have exactly one signer")
+	}
+	if err := p7.UnmarshalSignedAttribute(oidMessageType, &msg.msgtype); err != nil {
+		return nil, err
+	}
+	if err := p7.UnmarshalSignedAttribute(oidTransactionID, &msg.txid); err != nil {
+		return nil, err
+	}
+	if err := p7.UnmarshalSignedAttribute(oidSenderNonce, &msg.nonce); err != nil {
+		return nil, err
+	}
+	rakey, ra, err := s.cfg.RA()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := msg.signer.PublicKey.(*rsa.PublicKey); !ok {
+		// the response is encrypted with the signer key
+		return s.certrep(msg, rakey, ra, failBadAlg, nil)
+	}
+	chain, failinfo := s.enroll(c, msg, rakey, ra)
+	return s.certrep(msg, rakey, ra, failinfo, chain)
+}
+
+// enroll decrypts and signs the request of a PKCSReq/RenewalReq message.
+// It returns the failInfo of the response if it fails.
+func (s *Server) enroll(c echo.Context, msg *pkimessage, rakey *rsa.PrivateKey, ra *x509.Certificate) ([]byte, string) {
+	if msg.msgtype != msgPKCSReq && msg.msgtype != msgRenewalReq {
+		return nil, failBadRequest
+	}
+	env, err := pkcs7.Parse(msg.content)
+	if err != nil {
+		return nil, failBadMessageCheck
+	}
+	der, err := env.Decrypt(ra, rakey)
+	if err != nil {
+		return nil, failBadMessageCheck
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil || csr.CheckSignature() != nil {
+		return nil, failBadRequest
+	}
+	renewal := msg.msgtype == msgRenewalReq
+	if renewal {
+		if !s.issuedbyca(msg.signer) {
+			return nil, failBadMessageCheck
+		}
+		// a renewal keeps the names of the renewed certificate
+		if !pkix.SameNames(csr, msg.signer) {
+			return nil, failBadRequest
+		}
+	} else if !s.checkchallenge(csr) {
+		return nil, failBadRequest
+	}
+	chain, err := s.cfg.Enroll(EnrollRequest{
+		CSR: pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE REQUEST",
+			Bytes: der,
+		}),
+		RemoteIP: c.RealIP(),
+		Signer:   msg.signer,
+		Renewal:  renewal,
+	})
+	if err != nil {
+		log.Info().Err(err).Str("cn", csr.Subject.CommonName).Msg("SCEP enrollment rejected")
+		return nil, failBadRequest
+	}
+	return chain, ""
+}
+
+// issuedbyca reports whether a certificate was issued by this CA
+func (s *Server) issuedbyca(cert *x509.Certificate) bool {
+	cas := s.cfg.CACerts()
+	roots := x509.NewCertPool()
+	roots.AddCert(cas[len(cas)-1])
+	inter := x509.NewCertPool()
+	for _, v := range cas[:len(cas)-1] {
+		inter.AddCert(v)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: inter,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+func (s *Server) checkchallenge(csr *x509.CertificateRequest) bool {
+	pw, err := challengepassword(csr)
+	if err != nil || pw == "" {
+		return false
+	}
+	ok := false
+	for _, v := range s.cfg.ChallengePasswords {
+		if subtle.ConstantTimeCompare([]byte(pw), []byte(v)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+type csrinfo struct {
+	Version    int
+	Subject    asn1.RawValue
+	PublicKey  asn1.RawValue
+	Attributes []csrattribute `asn1:"tag:0"`
+}
+
+type csrattribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// challengepassword returns the challengePassword attribute of a CSR
+// (RFC 2985, section 5.4.1)
+func challengepassword(csr *x509.CertificateRequest) (string, error) {
+	var info csrinfo
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &info); err != nil {
+		return "", err
+	}
+	for _, attr := range info.Attributes {
+		if !attr.Type.Equal(oidChallengePassword) || len(attr.Values) != 1 {
+			continue
+		}
+		var pw string
+		if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &pw); err != nil {
+			return "", err
+		}
+		return pw, nil
+	}
+	return "", nil
+}
+
+// encryptl guards pkcs7.ContentEncryptionAlgorithm
+var encryptl sync.Mutex
+
+// certrep creates the CertRep response of a message: a failure if failinfo
+// is set, or the issued certificates encrypted with the signer key
+func (s *Server) certrep(msg *pkimessage, rakey *rsa.PrivateKey, ra *x509.Certificate, failinfo string, chain []byte) ([]byte, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	attrs := []pkcs7.Attribute{
+		{Type: oidMessageType, Value: msgCertRep},
+		{Type: oidTransactionID, Value: msg.txid},
+		{Type: oidSenderNonce, Value: nonce},
+		{Type: oidRecipientNonce, Value: msg.nonce},
+	}
+	var content []byte
+	if failinfo != "" {
+		attrs = append(attrs,
+			pkcs7.Attribute{Type: oidPKIStatus, Value: statusFailure},
+			pkcs7.Attribute{Type: oidFailInfo, Value: failinfo},
+		)
+	} else {
+		attrs = append(attrs, pkcs7.Attribute{Type: oidPKIStatus, Value: statusSuccess})
+		certs, err := certsonly(chain)
+		if err != nil {
+			return nil, err
+		}
+		encryptl.Lock()
+		pkcs7.ContentEncryptionAlgorithm = pkcs7.EncryptionAlgorithmAES128CBC
+		content, err = pkcs7.Encrypt(certs, []*x509.Certificate{msg.signer})
+		encryptl.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSigner(ra, rakey, pkcs7.SignerInfoConfig{
+		ExtraSignedAttributes: attrs,
+	}); err != nil {
+		return nil, fmt.Errorf("sign: %v", err)
+	}
+	return sd.Finish()
+}
+
+// certsonly encodes PEM certificates as a degenerate (certs-only) PKCS#7
+func certsonly(pemcerts []byte) ([]byte, error) {
+	var der []byte
+	for {
+		var blk *pem.Block
+		blk, pemcerts = pem.Decode(pemcerts)
+		if blk == nil {
+			break
+		}
+		if blk.Type == "CERTIFICATE" {
+			der = append(der, blk.Bytes...)
+		}
+	}
+	return pkcs7.DegenerateCertificate(der)
+}
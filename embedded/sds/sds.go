@@ -0,0 +1,374 @@
+// Package sds implements the Envoy Secret Discovery Service (SDS v3): Envoy
+// proxies subscribe to secrets by name and receive their certificates (and
+// the trusted CA) over a gRPC stream, including the renewed certificates
+// before they expire.
+package sds
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/rand"
+	"strconv"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	secret "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SecretType is the type URL of the SDS resources
+const SecretType = "type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.Secret"
+
+// DefaultValidationName is the default name of the validation_context
+// secret (the trusted CA)
+const DefaultValidationName = "ROOTCA"
+
+// DefaultRenewFraction is the fraction of the certificate lifetime after
+// which the certificates are renewed and pushed
+const DefaultRenewFraction = 2.0 / 3
+
+// retryInterval is the delay before retrying a failed renewal
+const retryInterval = time.Second * 10
+
+// IssueRequest is a tls_certificate secret requested by an Envoy node
+type IssueRequest struct {
+	// Name is the name of the secret: its identity, a DNS name or a SPIFFE
+	// ID (spiffe://...)
+	Name    string
+	NodeID  string
+	Cluster string
+	// Requester is the id returned by Config.Authenticate
+	Requester string
+}
+
+// IssueFunc creates a key and a certificate for a secret, returning the PEM
+// encoded certificate chain and key. Errors created by the status package
+// set the code of the stream error (default: Internal).
+type IssueFunc func(ctx context.Context, req IssueRequest) (chain, key []byte, err error)
+
+type Config struct {
+	// Issue issues the tls_certificate secrets [REQUIRED]
+	Issue IssueFunc
+	// CACerts returns the PEM encoded trusted CA certificates of the
+	// validation_context secret [REQUIRED]
+	CACerts func() []byte
+	// Authenticate authenticates the streams (e.g. with the gRPC metadata)
+	// and returns the requester id. Errors created by the status package set
+	// the code of the stream error (default: Unauthenticated). [OPTIONAL]
+	// default: the streams are not authenticated
+	Authenticate func(ctx context.Context) (string, error)
+	// ValidationName is the name of the validation_context secret [OPTIONAL]
+	// default: DefaultValidationName
+	ValidationName string
+	// RenewFraction is the fraction of the lifetime after which the
+	// certificates are renewed [OPTIONAL] default: DefaultRenewFraction
+	RenewFraction float64
+}
+
+// Server serves the SDS gRPC service
+type Server struct {
+	cfg Config
+}
+
+// New creates an SDS server
+func New(cfg Config) *Server {
+	if cfg.ValidationName == "" {
+		cfg.ValidationName = DefaultValidationName
+	}
+	if cfg.RenewFraction <= 0 || cfg.RenewFraction >= 1 {
+		cfg.RenewFraction = DefaultRenewFraction
+	}
+	return &Server{
+		cfg: cfg,
+	}
+}
+
+// Register registers the SDS service on a gRPC server
+func (s *Server) Register(gs *grpc.Server) {
+	secret.RegisterSecretDiscoveryServiceServer(gs, &service{s: s})
+}
+
+// service implements secret.SecretDiscoveryServiceServer
+type service struct {
+	secret.UnimplementedSecretDiscoveryServiceServer
+	s *Server
+}
+
+func (sv *service) FetchSecrets(ctx context.Context, req *discovery.DiscoveryRequest) (*discovery.DiscoveryResponse, error) {
+	requester, err := sv.s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st := newstream(sv.s, requester, req.GetNode())
+	if err := st.subscribe(ctx, req.GetResourceNames()); err != nil {
+		return nil, err
+	}
+	return st.response()
+}
+
+func (sv *service) StreamSecrets(stream secret.SecretDiscoveryService_StreamSecretsServer) error {
+	ctx := stream.Context()
+	requester, err := sv.s.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	reqs := make(chan *discovery.DiscoveryRequest)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var st *sdsstream
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		send := true
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			if status.Code(err) == codes.Canceled {
+				return nil
+			}
+			return err
+		case req := <-reqs:
+			if st == nil {
+				st = newstream(sv.s, requester, req.GetNode())
+			}
+			if req.GetErrorDetail() != nil {
+				// NACK: the previous response is kept by Envoy
+				log.Warn().Str("node", st.node.GetId()).Str("error", req.GetErrorDetail().GetMessage()).Msg("SDS response rejected")
+				continue
+			}
+			if req.GetResponseNonce() != "" && req.GetResponseNonce() != st.nonce {
+				// stale request (a newer response was sent)
+				continue
+			}
+			if req.GetResponseNonce() != "" && st.same(req.GetResourceNames()) {
+				// ACK
+				continue
+			}
+			if err := st.subscribe(ctx, req.GetResourceNames()); err != nil {
+				return err
+			}
+		case <-timer.C:
+			renewed, err := st.renew(ctx)
+			if err != nil {
+				log.Error().Err(err).Str("node", st.node.GetId()).Msg("SDS certificate renewal failed")
+			}
+			send = renewed
+		}
+		if !send {
+			timer.Reset(time.Until(st.next()))
+			continue
+		}
+		resp, err := st.response()
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+		timer.Reset(time.Until(st.next()))
+	}
+}
+
+func (s *Server) authenticate(ctx context.Context) (string, error) {
+	if s.cfg.Authenticate == nil {
+		return "", nil
+	}
+	id, err := s.cfg.Authenticate(ctx)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return "", err
+		}
+		return "", status.Error(codes.Unauthenticated, err.Error())
+	}
+	return id, nil
+}
+
+// sdsstream is the state of a stream: the subscribed secrets and the issued
+// certificates
+type sdsstream struct {
+	s         *Server
+	requester string
+	node      *core.Node
+	names     []string
+	certs     map[string]*issued
+	version   int
+	nonce     string
+}
+
+// issued is a certificate of a tls_certificate secret
+type issued struct {
+	chain   []byte
+	key     []byte
+	renewAt time.Time
+}
+
+func newstream(s *Server, requester string, node *core.Node) *sdsstream {
+	return &sdsstream{
+		s:         s,
+		requester: requester,
+		node:      node,
+		certs:     make(map[string]*issued),
+	}
+}
+
+// same reports whether names are the subscribed secrets
+func (st *sdsstream) same(names []string) bool {
+	if len(names) != len(st.names) {
+		return false
+	}
+	for i := range names {
+		if names[i] != st.names[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// subscribe replaces the subscribed secrets, issuing the new certificates
+func (st *sdsstream) subscribe(ctx context.Context, names []string) error {
+	certs := make(map[string]*issued, len(names))
+	for _, name := range names {
+		if name == st.s.cfg.ValidationName {
+			continue
+		}
+		if c, ok := st.certs[name]; ok {
+			certs[name] = c
+			continue
+		}
+		c, err := st.issue(ctx, name)
+		if err != nil {
+			if _, ok := status.FromError(err); ok {
+				return err
+			}
+			return status.Error(codes.Internal, err.Error())
+		}
+		certs[name] = c
+	}
+	st.names = append([]string{}, names...)
+	st.certs = certs
+	return nil
+}
+
+// renew renews the certificates due for renewal
+func (st *sdsstream) renew(ctx context.Context) (renewed bool, lasterr error) {
+	for name, c := range st.certs {
+		if time.Now().Before(c.renewAt) {
+			continue
+		}
+		nc, err := st.issue(ctx, name)
+		if err != nil {
+			// retry later; the current certificate is still valid
+			c.renewAt = time.Now().Add(retryInterval)
+			lasterr = err
+			continue
+		}
+		st.certs[name] = nc
+		renewed = true
+	}
+	return renewed, lasterr
+}
+
+func (st *sdsstream) issue(ctx context.Context, name string) (*issued, error) {
+	chain, key, err := st.s.cfg.Issue(ctx, IssueRequest{
+		Name:      name,
+		NodeID:    st.node.GetId(),
+		Cluster:   st.node.GetCluster(),
+		Requester: st.requester,
+	})
+	if err != nil {
+		return nil, err
+	}
+	blk, _ := pem.Decode(chain)
+	if blk == nil {
+		return nil, errors.New("sds: invalid certificate")
+	}
+	cert, err := x509.ParseCertificate(blk.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	// from now, as NotBefore can be backdated
+	now := time.Now()
+	return &issued{
+		chain:   chain,
+		key:     key,
+		renewAt: now.Add(time.Duration(float64(cert.NotAfter.Sub(now)) * st.s.cfg.RenewFraction)),
+	}, nil
+}
+
+// next returns the time of the next renewal
+func (st *sdsstream) next() time.Time {
+	next := time.Now().Add(time.Hour * 24 * 365)
+	for _, c := range st.certs {
+		if c.renewAt.Before(next) {
+			next = c.renewAt
+		}
+	}
+	return next
+}
+
+// response creates a response with all the subscribed secrets
+func (st *sdsstream) response() (*discovery.DiscoveryResponse, error) {
+	st.version++
+	st.nonce = strconv.FormatInt(rand.Int63(), 36)
+	resp := &discovery.DiscoveryResponse{
+		VersionInfo: strconv.Itoa(st.version),
+		TypeUrl:     SecretType,
+		Nonce:       st.nonce,
+	}
+	for _, name := range st.names {
+		sec := &tlsv3.Secret{
+			Name: name,
+		}
+		if c, ok := st.certs[name]; ok {
+			sec.Type = &tlsv3.Secret_TlsCertificate{
+				TlsCertificate: &tlsv3.TlsCertificate{
+					CertificateChain: inline(c.chain),
+					PrivateKey:       inline(c.key),
+				},
+			}
+		} else {
+			sec.Type = &tlsv3.Secret_ValidationContext{
+				ValidationContext: &tlsv3.CertificateValidationContext{
+					TrustedCa: inline(st.s.cfg.CACerts()),
+				},
+			}
+		}
+		any, err := ptypes.MarshalAny(sec)
+		if err != nil {
+			return nil, err
+		}
+		resp.Resources = append(resp.Resources, any)
+	}
+	return resp, nil
+}
+
+func inline(b []byte) *core.DataSource {
+	return &core.DataSource{
+		Specifier: &core.DataSource_InlineBytes{
+			InlineBytes: b,
+		},
+	}
+}
@@ -2,14 +2,18 @@ package embedded
 
 import (
 	"context"
-	"crypto/rsa"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"fmt"
+	"math/big"
 	"math/rand"
 	"sync"
 	"time"
 
+	"github.com/gabstv/ztls/embedded/acme"
+	"github.com/gabstv/ztls/embedded/store"
 	"github.com/gabstv/ztls/internal/pkix"
 	echo "github.com/labstack/echo/v4"
 	"github.com/rs/zerolog/log"
@@ -31,6 +35,25 @@ type Server struct {
 	ctx    context.Context
 	cfg    *Config
 	NextID IDFunc
+	// Revocations stores the revoked certificates (default: in memory)
+	Revocations RevocationList
+	// CRLValidity is the validity of the generated CRLs
+	// (default: DefaultCRLValidity)
+	CRLValidity time.Duration
+	// Store records the issued certificates and guarantees unique serial
+	// numbers (default: nil, nothing is recorded)
+	Store store.Store
+	// ACMEStorage keeps the ACME accounts and orders when the ACME server is
+	// enabled (default: in memory)
+	ACMEStorage acme.Storage
+	// Tokens stores the bootstrap tokens (default: in memory)
+	Tokens TokenList
+
+	crl  crlcache
+	ocsp ocspstate
+	scep scepstate
+
+	limits limits
 
 	// http stuff
 	httponce    sync.Once
@@ -41,7 +64,13 @@ func New(ctx context.Context, cfg *Config) *Server {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	return &Server{ctx: ctx, cfg: cfg, NextID: RandID}
+	return &Server{
+		ctx:         ctx,
+		cfg:         cfg,
+		NextID:      RandID,
+		Revocations: NewMemRevocationList(),
+		Tokens:      NewMemTokenList(),
+	}
 }
 
 func NewWithConfig(ctx context.Context, pemcfg []byte) (*Server, error) {
@@ -52,22 +81,29 @@ func NewWithConfig(ctx context.Context, pemcfg []byte) (*Server, error) {
 	return New(ctx, cfg), nil
 }
 
-func (s *Server) getkey() *rsa.PrivateKey {
-	rawkey, err := pkix.DecodePEM(s.cfg.Rootkey, pkix.PEMRSAPrivateKey, s.cfg.RootkeyPw)
-	if err != nil {
-		log.Error().Err(err).Msg("getkey() error (PEM)")
-		return nil
+// getkey returns the key that signs new certificates (the intermediate key,
+// if present, or the root key)
+func (s *Server) getkey() crypto.Signer {
+	rawkey, pw := s.cfg.Rootkey, s.cfg.RootkeyPw
+	if len(s.cfg.Issuerkey) > 0 {
+		rawkey, pw = s.cfg.Issuerkey, s.cfg.IssuerkeyPw
 	}
-	rootk, err := x509.ParsePKCS1PrivateKey(rawkey)
+	rootk, err := pkix.ParsePrivateKey(rawkey, pw)
 	if err != nil {
-		log.Error().Err(err).Msg("getkey() error (PARSE)")
+		log.Error().Err(err).Msg("getkey() error")
 		return nil
 	}
 	return rootk
 }
 
+// getca returns the certificate that signs new certificates (the
+// intermediate certificate, if present, or the root certificate)
 func (s *Server) getca() *x509.Certificate {
-	rawcert, err := pkix.DecodePEM(s.cfg.Rootcert, pkix.PEMCertificate, nil)
+	rawpem := s.cfg.Rootcert
+	if len(s.cfg.Issuercert) > 0 {
+		rawpem = s.cfg.Issuercert
+	}
+	rawcert, err := pkix.DecodePEM(rawpem, pkix.PEMCertificate, nil)
 	if err != nil {
 		log.Error().Err(err).Msg("getca() error (PEM)")
 		return nil
@@ -80,28 +116,216 @@ func (s *Server) getca() *x509.Certificate {
 	return rootc
 }
 
+// NewCertificateRaw signs a PEM encoded CSR. The returned PEM contains the
+// new certificate followed by the intermediate certificate (if any).
 func (s *Server) NewCertificateRaw(csrpem []byte) (cert []byte, err error) {
-	if len(csrpem) < 10 {
+	return s.Issue(IssueRequest{
+		CSR:     csrpem,
+		Trusted: true,
+	})
+}
+
+// IssueRequest is a certificate request and its origin
+type IssueRequest struct {
+	CSR       []byte // [REQUIRED] PEM encoded
+	Requester string // [OPTIONAL] e.g. the IP address of the client
+	APIKeyID  string // [OPTIONAL] id of the API key used in the request
+	Profile   string // [OPTIONAL] certificate profile (default: Server.DefaultProfileName)
+	// [OPTIONAL] requested lifetime (TTL or NotAfter, not both); the
+	// default is the lifetime of the profile or Config.DefaultLifetimeSeconds
+	TTL      time.Duration
+	NotAfter time.Time
+	// Trusted requests skip the issuance policy (e.g. in-process requests).
+	// Otherwise, the policy of APIKeyID applies (see Server.PolicyFor).
+	Trusted bool
+	// [OPTIONAL] serial number of the renewed certificate (see Server.Renew)
+	RenewedFrom *big.Int
+}
+
+// maxSerialRetries is the number of attempts to get an unused serial number
+const maxSerialRetries = 10
+
+// Issue signs a CSR and records the certificate in the Store (if set). The
+// returned PEM contains the new certificate followed by the intermediate
+// certificate (if any).
+func (s *Server) Issue(req IssueRequest) (cert []byte, err error) {
+	if len(req.CSR) < 10 {
 		return nil, errInvalidPEM
 	}
-	csra1, err := pkix.DecodePEM(csrpem, pkix.PEMCertificateRequest, nil)
+	creq, err := parsecsr(req.CSR)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkuris(creq); err != nil {
+		return nil, err
+	}
+	profname := req.Profile
+	if profname == "" {
+		profname = s.DefaultProfileName()
+	}
+	profile, err := s.Profile(profname)
+	if err != nil {
+		return nil, err
+	}
+	var policy *Policy
+	if !req.Trusted {
+		policy = s.PolicyFor(req.APIKeyID)
+		if err := policy.CheckProfile(profname); err != nil {
+			return nil, err
+		}
+		if err := policy.Check(creq); err != nil {
+			return nil, err
+		}
+		// the scope of a named API key
+		if kp := s.cfg.APIKeyByID(req.APIKeyID).Policy(); kp != nil {
+			if err := kp.CheckProfile(profname); err != nil {
+				return nil, err
+			}
+			if err := kp.Check(creq); err != nil {
+				return nil, err
+			}
+		}
+	}
+	notBefore, notAfter, err := s.validity(req, profile, policy)
 	if err != nil {
 		return nil, err
 	}
-	creq, err := x509.ParseCertificateRequest(csra1)
+	cacert, cakey := s.getca(), s.getkey()
+	if cacert == nil || cakey == nil {
+		return nil, errNoIssuer
+	}
+	if notAfter.After(cacert.NotAfter) {
+		// a certificate doesn't outlive its issuer
+		notAfter = cacert.NotAfter
+	}
+	serial, err := s.nextserial()
 	if err != nil {
 		return nil, err
 	}
-	return pkix.NewCertificatePEM(pkix.NewCertificatePEMInput{
-		CACert:       s.getca(),
-		CAKey:        s.getkey(),
+	if s.Store != nil {
+		defer func() {
+			if err == nil {
+				return
+			}
+			// the certificate was not issued: free its serial number
+			if rerr := s.Store.Release(big.NewInt(serial)); rerr != nil {
+				log.Error().Err(rerr).Int64("serial", serial).Msg("release serial number")
+			}
+		}()
+	}
+	input := pkix.NewCertificatePEMInput{
+		CACert:       cacert,
+		CAKey:        cakey,
 		CSR:          creq,
-		SerialNumber: s.NextID(),
-		Expires:      time.Now().AddDate(5, 0, 0), //TODO: better expiritaion checks
-	})
+		SerialNumber: serial,
+		NotBefore:    notBefore,
+		Expires:      notAfter,
+	}
+	if !s.IsShortLived(time.Until(notAfter)) {
+		input.CRLDistributionPoints = s.crlurls()
+		input.OCSPServer = s.ocspurls()
+	}
+	if err := profile.apply(&input); err != nil {
+		return nil, err
+	}
+	cert, err = pkix.NewCertificatePEM(input)
+	if err != nil {
+		return nil, err
+	}
+	if s.Store != nil {
+		leafder, err := pkix.DecodePEM(cert, pkix.PEMCertificate, nil)
+		if err != nil {
+			return nil, err
+		}
+		leaf, err := x509.ParseCertificate(leafder)
+		if err != nil {
+			return nil, err
+		}
+		r := store.NewRecord(leaf)
+		r.Requester = req.Requester
+		r.APIKeyID = req.APIKeyID
+		r.Profile = profname
+		r.RenewedFrom = req.RenewedFrom
+		if err := s.Store.Put(r); err != nil {
+			return nil, err
+		}
+	}
+	return append(cert, s.Chain()...), nil
+}
+
+// parsecsr decodes a PEM encoded CSR and checks its signature
+func parsecsr(csrpem []byte) (*x509.CertificateRequest, error) {
+	der, err := pkix.DecodePEM(csrpem, pkix.PEMCertificateRequest, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidCSR, err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidCSR, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidCSR, err)
+	}
+	return csr, nil
+}
+
+// nextserial returns a serial number from NextID, reserved in the Store (if
+// set) so it is never used twice
+func (s *Server) nextserial() (int64, error) {
+	for i := 0; i < maxSerialRetries; i++ {
+		id := s.NextID()
+		if id <= 0 {
+			continue
+		}
+		if s.Store == nil {
+			return id, nil
+		}
+		err := s.Store.Reserve(big.NewInt(id))
+		if err == store.ErrSerialExists {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+	return 0, errSerialRetries
+}
+
+// Certificates returns the issued certificates matching the filter
+func (s *Server) Certificates(f store.Filter) ([]store.Record, error) {
+	if s.Store == nil {
+		return nil, errNoStore
+	}
+	return s.Store.List(f)
+}
+
+// Chain returns the PEM encoded intermediate certificate that is appended to
+// every issued certificate (nil if the server signs with the root key).
+func (s *Server) Chain() []byte {
+	if len(s.cfg.Issuercert) == 0 {
+		return nil
+	}
+	return s.cfg.Issuercert
+}
+
+// Context returns the context of the server
+func (s *Server) Context() context.Context {
+	return s.ctx
+}
+
+// RootCA returns the PEM encoded root certificate (trust anchor)
+func (s *Server) RootCA() []byte {
+	return s.cfg.Rootcert
 }
 
 func (s *Server) NewCertificateCSR(csr CSRReader, key []byte) (cert []byte, err error) {
+	return s.NewCertificateCSRWithProfile(csr, key, "")
+}
+
+// NewCertificateCSRWithProfile creates a CSR with the private key (PEM) and
+// signs it with a certificate profile (an empty name is the default profile)
+func (s *Server) NewCertificateCSRWithProfile(csr CSRReader, key []byte, profile string) (cert []byte, err error) {
 	nfo := pkix.CSRInfo{
 		Country:            csr.GetCountry(),
 		Province:           csr.GetProvince(),
@@ -114,23 +338,43 @@ func (s *Server) NewCertificateCSR(csr CSRReader, key []byte) (cert []byte, err
 		Domains:            csr.GetDomains(),
 		CommonName:         csr.GetCommonName(),
 	}
+	if ur, ok := csr.(CSRURIReader); ok {
+		nfo.URIs = ur.GetURIs()
+	}
 	csrpem, err := pkix.NewCSRPEM(nfo, key, nil)
 	if err != nil {
 		return nil, err
 	}
-	return s.NewCertificateRaw(csrpem)
+	return s.Issue(IssueRequest{
+		CSR:     csrpem,
+		Profile: profile,
+		Trusted: true,
+	})
 }
 
+// NewKey creates a new private key (PEM encoded) of the type set in the
+// config (Config.KeyType). RSA 4096 is used if no type is set.
 func (s *Server) NewKey() (key []byte, err error) {
-	return pkix.NewKey(4096)
+	kt, err := pkix.ParseKeyType(s.cfg.GetKeyType())
+	if err != nil {
+		return nil, err
+	}
+	return s.NewKeyWithType(kt)
+}
+
+// NewKeyWithType creates a new private key (PEM encoded) of the given type
+func (s *Server) NewKeyWithType(kt KeyType) (key []byte, err error) {
+	return pkix.NewKeyWithType(kt, pkix.DefaultRSAKeySize)
 }
 
+// NewClientAuto creates a key and a client certificate (ProfileClient) and
+// returns a TLS config to connect to serverName
 func (s *Server) NewClientAuto(serverName string, csr CSRReader) (tlsc *tls.Config, keypem, certpem []byte, err error) {
 	keypem, err = s.NewKey()
 	if err != nil {
 		return
 	}
-	certpem, err = s.NewCertificateCSR(csr, keypem)
+	certpem, err = s.NewCertificateCSRWithProfile(csr, keypem, ProfileClient)
 	if err != nil {
 		return
 	}
@@ -151,12 +395,14 @@ func (s *Server) NewClientAuto(serverName string, csr CSRReader) (tlsc *tls.Conf
 	return
 }
 
+// NewServerAuto creates a key and a server certificate (ProfileServer) and
+// returns a TLS config that requires client certificates
 func (s *Server) NewServerAuto(csr CSRReader) (tlsc *tls.Config, keypem, certpem []byte, err error) {
 	keypem, err = s.NewKey()
 	if err != nil {
 		return
 	}
-	certpem, err = s.NewCertificateCSR(csr, keypem)
+	certpem, err = s.NewCertificateCSRWithProfile(csr, keypem, ProfileServer)
 	if err != nil {
 		return
 	}
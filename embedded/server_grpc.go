@@ -0,0 +1,339 @@
+package embedded
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gabstv/ztls/api/ztlsv1"
+	"github.com/gabstv/ztls/embedded/middlewares"
+	"github.com/gabstv/ztls/embedded/routes"
+	"github.com/gabstv/ztls/embedded/store"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RegisterGRPC registers the ztls.v1.CertificateAuthority service (the gRPC
+// API) on a gRPC server. The requests are authorized and rate limited as the
+// /2/ routes: the API key is read from the ztlsv1.APIKeyMetadata metadata and
+// the bootstrap token from ztlsv1.TokenMetadata.
+func (s *Server) RegisterGRPC(gs *grpc.Server) {
+	ztlsv1.RegisterCertificateAuthorityServer(gs, &caservice{s: s})
+}
+
+// ListenAndServeGRPCAsync serves the gRPC API on addr (host:port, or
+// unix:/path for a unix socket) until ctx is done, with TLS if tlsc is not
+// nil
+func (s *Server) ListenAndServeGRPCAsync(ctx context.Context, addr string, tlsc *tls.Config) (<-chan struct{}, error) {
+	return servegrpc(ctx, "gRPC", addr, tlsc, s.RegisterGRPC)
+}
+
+// caservice implements ztlsv1.CertificateAuthorityServer
+type caservice struct {
+	ztlsv1.UnimplementedCertificateAuthorityServer
+	s *Server
+}
+
+func (sv *caservice) GetCA(ctx context.Context, req *ztlsv1.GetCARequest) (*ztlsv1.GetCAResponse, error) {
+	return &ztlsv1.GetCAResponse{
+		Root:  string(sv.s.RootCA()),
+		Chain: string(sv.s.Chain()),
+	}, nil
+}
+
+func (sv *caservice) IssueCertificate(ctx context.Context, req *ztlsv1.IssueCertificateRequest) (*ztlsv1.Certificate, error) {
+	ip := peerip(ctx)
+	creq := routes.CSRRequest{
+		CSR:      []byte(req.GetCsr()),
+		RemoteIP: ip,
+		Profile:  req.GetProfile(),
+		TTL:      time.Duration(req.GetTtlSeconds()) * time.Second,
+		Token:    mdvalue(ctx, ztlsv1.TokenMetadata),
+	}
+	if req.GetNotAfter() > 0 {
+		creq.NotAfter = time.Unix(req.GetNotAfter(), 0)
+	}
+	if mdvalue(ctx, ztlsv1.APIKeyMetadata) == "" {
+		// as /2/new-certificate
+		if err := allow(sv.s.limiters().anonymous, ip); err != nil {
+			return nil, err
+		}
+	} else {
+		// as /2/new-server-certificate
+		if err := allow(sv.s.limiters().apikey, ip); err != nil {
+			return nil, err
+		}
+		id, err := sv.s.grpcapikey(ctx, RouteNewServerCertificate)
+		if err != nil {
+			return nil, err
+		}
+		creq.APIKeyID = id
+	}
+	chain, err := sv.s.issuecsr(creq)
+	if err != nil {
+		return nil, grpcerror(err)
+	}
+	return grpccertificate(chain)
+}
+
+func (sv *caservice) Renew(ctx context.Context, req *ztlsv1.RenewRequest) (*ztlsv1.Certificate, error) {
+	ip := peerip(ctx)
+	if err := allow(sv.s.limiters().renew, ip); err != nil {
+		return nil, err
+	}
+	rreq := routes.RenewRequest{
+		CSRRequest: routes.CSRRequest{
+			CSR:      []byte(req.GetCsr()),
+			RemoteIP: ip,
+			Profile:  req.GetProfile(),
+			TTL:      time.Duration(req.GetTtlSeconds()) * time.Second,
+		},
+		Certificate: []byte(req.GetCertificate()),
+		Signature:   req.GetSignature(),
+	}
+	if req.GetNotAfter() > 0 {
+		rreq.NotAfter = time.Unix(req.GetNotAfter(), 0)
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			rreq.PeerCertificates = info.State.PeerCertificates
+		}
+	}
+	chain, err := sv.s.postrenew(rreq)
+	if err != nil {
+		return nil, grpcerror(err)
+	}
+	return grpccertificate(chain)
+}
+
+func (sv *caservice) Revoke(ctx context.Context, req *ztlsv1.RevokeRequest) (*ztlsv1.RevokeResponse, error) {
+	if err := allow(sv.s.limiters().apikey, peerip(ctx)); err != nil {
+		return nil, err
+	}
+	id, err := sv.s.grpcapikey(ctx, RouteRevoke)
+	if err != nil {
+		return nil, err
+	}
+	serial, ok := new(big.Int).SetString(req.GetSerial(), 10)
+	if !ok || serial.Sign() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, errInvalidSerial.Error())
+	}
+	reason, err := ParseRevocationReason(req.GetReason())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if id != middlewares.DefaultAPIKeyID {
+		// named keys can only revoke the certificates of their requests
+		if sv.s.Store == nil {
+			return nil, status.Error(codes.FailedPrecondition, errNoStore.Error())
+		}
+		rec, err := sv.s.Store.Get(serial)
+		if err == store.ErrNotFound {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if rec.APIKeyID != id {
+			return nil, status.Errorf(codes.PermissionDenied, "the certificate %v was not requested with the API key %v", serial, id)
+		}
+	}
+	if err := sv.s.Revoke(serial, reason); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Info().Str("serial", serial.String()).Str("reason", reason.String()).Str("api_key_id", id).Msg("certificate revoked")
+	return &ztlsv1.RevokeResponse{}, nil
+}
+
+func (sv *caservice) ListCertificates(ctx context.Context, req *ztlsv1.ListCertificatesRequest) (*ztlsv1.ListCertificatesResponse, error) {
+	if _, err := sv.s.grpcapikey(ctx, RouteCertificates); err != nil {
+		return nil, err
+	}
+	f := store.Filter{
+		Host:       req.GetHost(),
+		CommonName: req.GetCommonName(),
+		APIKeyID:   req.GetApiKeyId(),
+		Limit:      int(req.GetLimit()),
+	}
+	for _, v := range []struct {
+		t    *time.Time
+		unix int64
+	}{
+		{&f.IssuedAfter, req.GetIssuedAfter()},
+		{&f.IssuedBefore, req.GetIssuedBefore()},
+		{&f.ValidAt, req.GetValidAt()},
+	} {
+		if v.unix > 0 {
+			*v.t = time.Unix(v.unix, 0)
+		}
+	}
+	list, err := sv.s.Certificates(f)
+	if err == errNoStore {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &ztlsv1.ListCertificatesResponse{}
+	for _, r := range list {
+		resp.Certificates = append(resp.Certificates, grpcrecord(r))
+	}
+	return resp, nil
+}
+
+// grpcapikey authenticates a gRPC request with the API key of its metadata
+func (s *Server) grpcapikey(ctx context.Context, route string) (string, error) {
+	id, err := s.AuthenticateAPIKey(mdvalue(ctx, ztlsv1.APIKeyMetadata), route)
+	if errors.Is(err, middlewares.ErrKeyForbidden) {
+		return "", status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return "", status.Error(codes.Unauthenticated, err.Error())
+	}
+	return id, nil
+}
+
+// grpcerror maps an error of the /2/ API (see apierror) to a gRPC status
+func grpcerror(err error) error {
+	var aerr *routes.APIError
+	if !errors.As(err, &aerr) {
+		aerr = apierror(err).(*routes.APIError)
+	}
+	code := codes.Internal
+	switch aerr.Status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, aerr.Message)
+}
+
+func allow(l *middlewares.Limiter, ip string) error {
+	if _, ok := l.Allow(ip); !ok {
+		return status.Error(codes.ResourceExhausted, "too many requests")
+	}
+	return nil
+}
+
+func grpccertificate(chain []byte) (*ztlsv1.Certificate, error) {
+	c, err := routes.NewCertificate(chain)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &ztlsv1.Certificate{
+		Certificate:    c.Certificate,
+		Chain:          c.Chain,
+		Serial:         c.Serial,
+		NotBefore:      c.NotBefore.Unix(),
+		NotAfter:       c.NotAfter.Unix(),
+		Fingerprint:    c.Fingerprint,
+		CommonName:     c.CommonName,
+		DnsNames:       c.DNSNames,
+		IpAddresses:    c.IPAddresses,
+		EmailAddresses: c.EmailAddresses,
+		Uris:           c.URIs,
+	}, nil
+}
+
+func grpcrecord(r store.Record) *ztlsv1.CertificateRecord {
+	v := &ztlsv1.CertificateRecord{
+		CommonName:     r.CommonName,
+		DnsNames:       r.DNSNames,
+		IpAddresses:    r.IPAddresses,
+		EmailAddresses: r.EmailAddresses,
+		Uris:           r.URIs,
+		Requester:      r.Requester,
+		ApiKeyId:       r.APIKeyID,
+		Profile:        r.Profile,
+		IssuedAt:       r.IssuedAt.Unix(),
+		NotBefore:      r.NotBefore.Unix(),
+		NotAfter:       r.NotAfter.Unix(),
+		Certificate:    string(r.Certificate),
+	}
+	if r.Serial != nil {
+		v.Serial = r.Serial.String()
+	}
+	if r.RenewedFrom != nil {
+		v.RenewedFrom = r.RenewedFrom.String()
+	}
+	return v
+}
+
+// mdvalue returns the first value of a key of the incoming metadata
+func mdvalue(ctx context.Context, key string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// peerip returns the IP address of the peer of a gRPC request (the address
+// of the rate limits)
+func peerip(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
+
+// servegrpc serves a gRPC server on addr (host:port, or unix:/path) until
+// ctx is done
+func servegrpc(ctx context.Context, name, addr string, tlsc *tls.Config, register func(gs *grpc.Server)) (<-chan struct{}, error) {
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix:")
+	}
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	var opts []grpc.ServerOption
+	if tlsc != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsc)))
+	}
+	gs := grpc.NewServer(opts...)
+	register(gs)
+	exitch := make(chan struct{})
+	go func() {
+		if err := gs.Serve(l); err != nil {
+			log.Error().Err(err).Msg(name + " Serve error")
+		}
+		close(exitch)
+	}()
+	go func() {
+		<-ctx.Done()
+		stopped := make(chan struct{})
+		go func() {
+			gs.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(time.Second * 3):
+			// streams (e.g. SDS) don't end by themselves
+			gs.Stop()
+		}
+	}()
+	return exitch, nil
+}
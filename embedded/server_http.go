@@ -1,14 +1,23 @@
 package embedded
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/gabstv/ztls/embedded/acme"
+	"github.com/gabstv/ztls/embedded/est"
 	"github.com/gabstv/ztls/embedded/middlewares"
 	"github.com/gabstv/ztls/embedded/routes"
 	"github.com/gabstv/ztls/internal/metadata"
+	"github.com/gabstv/ztls/internal/pkix"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/rs/zerolog/log"
@@ -29,15 +38,200 @@ func (s *Server) httpmust() {
 func (s *Server) registerroutes(e *echo.Echo) {
 	e.GET("/", routes.Root(metadata.Version()))
 
-	postcsr := func(csr []byte) (cert []byte, err error) {
-		return s.NewCertificateRaw(csr)
+	postcsr := func(req routes.CSRRequest) (cert []byte, err error) {
+		cert, err = s.issuecsr(req)
+		if perr, ok := err.(*PolicyError); ok {
+			return nil, echo.NewHTTPError(http.StatusForbidden, perr.Error())
+		}
+		switch err {
+		case errTokenInvalid, errTokenExpired, errTokenUsed:
+			return nil, echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		case errTokenIdentity:
+			return nil, echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		return cert, err
 	}
 
 	// api
 	g := e.Group("/1")
 	g.POST("/new-certificate", routes.PostCSR(postcsr), middlewares.RateLimiter(4, time.Minute))
-	g.POST("/new-server-certificate", routes.PostCSR(postcsr), middlewares.RateLimiter(50, time.Minute), middlewares.APIKey(s.cfg.Apikey))
+	g.POST("/new-server-certificate", routes.PostCSR(postcsr), middlewares.RateLimiter(50, time.Minute), s.apikeymw(RouteNewServerCertificate, middlewares.TextFail))
 	g.GET("/ca.crt.pem", routes.GetCA(s.cfg.Rootcert))
+	g.GET("/ca-chain.crt.pem", routes.GetCA(bytes.Join([][]byte{s.Chain(), s.RootCA()}, nil)))
+	g.GET("/crl", routes.GetBlob("application/pkix-crl", s.CRL))
+	g.GET("/crl.pem", routes.GetBlob("application/x-pem-file", s.CRLPEM))
+	g.GET("/certificates", routes.ListCertificates(s.Certificates), s.apikeymw(RouteCertificates, middlewares.TextFail))
+	g.GET("/ocsp/*", routes.OCSP(s.OCSP, DefaultOCSPValidity/2))
+	g.POST("/ocsp", routes.OCSP(s.OCSP, DefaultOCSPValidity/2))
+
+	// api v2 (JSON responses and typed errors)
+	postcsr2 := func(req routes.CSRRequest) ([]byte, error) {
+		cert, err := s.issuecsr(req)
+		return cert, apierror(err)
+	}
+	g2 := e.Group("/2")
+	limits := s.limiters()
+	g2.POST("/new-certificate", routes.PostCSRV2(postcsr2), middlewares.LimiterMiddleware(limits.anonymous, routes.FailJSON))
+	g2.POST("/new-server-certificate", routes.PostCSRV2(postcsr2), middlewares.LimiterMiddleware(limits.apikey, routes.FailJSON), s.apikeymw(RouteNewServerCertificate, routes.FailJSON))
+	g2.GET("/ca", routes.GetCAV2(s.RootCA(), s.Chain()))
+	g2.GET("/certificates", routes.ListCertificatesV2(s.Certificates), s.apikeymw(RouteCertificates, routes.FailJSON))
+	g2.POST("/tokens", routes.PostToken(s.posttoken), s.apikeymw(RouteTokens, routes.FailJSON))
+	g2.POST("/renew", routes.PostRenew(s.postrenew), middlewares.LimiterMiddleware(limits.renew, routes.FailJSON))
+
+	if s.cfg.GetAcme().GetEnabled() {
+		s.acmeserver().Register(e)
+	}
+	if s.cfg.GetEst().GetEnabled() {
+		s.estserver().Register(e)
+	}
+	if s.cfg.GetScep().GetEnabled() {
+		s.scepserver().Register(e)
+	}
+}
+
+// limits are the rate limits of the /2/ routes, shared with the gRPC API
+type limits struct {
+	once      sync.Once
+	anonymous *middlewares.Limiter
+	apikey    *middlewares.Limiter
+	renew     *middlewares.Limiter
+}
+
+func (s *Server) limiters() *limits {
+	s.limits.once.Do(func() {
+		s.limits.anonymous = middlewares.NewLimiter(4, time.Minute)
+		s.limits.apikey = middlewares.NewLimiter(50, time.Minute)
+		s.limits.renew = middlewares.NewLimiter(50, time.Minute)
+	})
+	return &s.limits
+}
+
+// issuecsr signs a certificate request of the API, authorized by its API key
+// or bootstrap token (if any)
+func (s *Server) issuecsr(req routes.CSRRequest) ([]byte, error) {
+	ireq := IssueRequest{
+		CSR:       req.CSR,
+		Requester: req.RemoteIP,
+		APIKeyID:  req.APIKeyID,
+		Profile:   req.Profile,
+		TTL:       req.TTL,
+		NotAfter:  req.NotAfter,
+	}
+	if req.Token != "" {
+		return s.IssueWithToken(req.Token, ireq)
+	}
+	return s.Issue(ireq)
+}
+
+func (s *Server) posttoken(req routes.TokenRequest) (*routes.Token, error) {
+	t, value, err := s.NewToken(TokenRequest{
+		CommonName: req.CommonName,
+		Names:      req.Names,
+		Profile:    req.Profile,
+		Uses:       req.Uses,
+		TTL:        req.TTL,
+		APIKeyID:   req.APIKeyID,
+	})
+	if err == errTokenIdentity {
+		return nil, routes.NewAPIError(http.StatusBadRequest, routes.CodeInvalidRequest, "common_name is required")
+	}
+	if err != nil {
+		return nil, apierror(err)
+	}
+	return &routes.Token{
+		ID:        t.ID,
+		Token:     value,
+		ExpiresAt: t.ExpiresAt,
+		UsesLeft:  t.UsesLeft,
+	}, nil
+}
+
+// postrenew authenticates a renewal with the TLS client certificate or with
+// the certificate and signature of the request
+func (s *Server) postrenew(req routes.RenewRequest) ([]byte, error) {
+	rreq := RenewRequest{
+		CSR:       req.CSR,
+		Signature: req.Signature,
+		Requester: req.RemoteIP,
+		Profile:   req.Profile,
+		TTL:       req.TTL,
+		NotAfter:  req.NotAfter,
+	}
+	if len(req.PeerCertificates) > 0 {
+		rreq.Current = req.PeerCertificates[0]
+		rreq.TLS = true
+	} else if len(req.Certificate) > 0 {
+		der, err := pkix.DecodePEM(req.Certificate, pkix.PEMCertificate, nil)
+		if err != nil {
+			return nil, routes.NewAPIError(http.StatusBadRequest, routes.CodeInvalidRequest, "certificate: "+err.Error())
+		}
+		if rreq.Current, err = x509.ParseCertificate(der); err != nil {
+			return nil, routes.NewAPIError(http.StatusBadRequest, routes.CodeInvalidRequest, "certificate: "+err.Error())
+		}
+	} else {
+		return nil, routes.NewAPIError(http.StatusUnauthorized, routes.CodeUnauthorized, "a client certificate or the certificate field is required")
+	}
+	cert, err := s.Renew(rreq)
+	return cert, apierror(err)
+}
+
+// apierror maps an Issue (or Renew) error to a typed error of the /2/ API
+func apierror(err error) error {
+	if err == nil {
+		return nil
+	}
+	if perr, ok := err.(*PolicyError); ok {
+		return routes.NewAPIError(http.StatusForbidden, routes.CodePolicyRejected, perr.Reason)
+	}
+	switch {
+	case errors.Is(err, errInvalidCSR), errors.Is(err, errInvalidPEM):
+		return routes.NewAPIError(http.StatusBadRequest, routes.CodeInvalidCSR, err.Error())
+	case errors.Is(err, errUnknownProfile):
+		return routes.NewAPIError(http.StatusBadRequest, routes.CodeUnknownProfile, err.Error())
+	case errors.Is(err, errLifetimeConflict), errors.Is(err, errInvalidLifetime):
+		return routes.NewAPIError(http.StatusBadRequest, routes.CodeInvalidLifetime, err.Error())
+	case err == errRenewProof, err == errRenewUntrusted, err == errRenewExpired, err == errRenewRevoked,
+		err == errTokenInvalid, err == errTokenExpired, err == errTokenUsed:
+		return routes.NewAPIError(http.StatusUnauthorized, routes.CodeUnauthorized, err.Error())
+	case err == errRenewIdentity, err == errTokenIdentity:
+		return routes.NewAPIError(http.StatusForbidden, routes.CodeIdentityMismatch, err.Error())
+	case err == errRenewProfile:
+		return routes.NewAPIError(http.StatusForbidden, routes.CodePolicyRejected, err.Error())
+	}
+	return routes.NewAPIError(http.StatusInternalServerError, routes.CodeInternal, err.Error())
+}
+
+// ACMEAPIKeyID is the API key id of the ACME requests (its policy applies)
+const ACMEAPIKeyID = "acme"
+
+func (s *Server) acmeserver() *acme.Server {
+	cfg := s.cfg.GetAcme()
+	profile := cfg.GetProfile()
+	if profile == "" {
+		profile = ProfileServer
+	}
+	return acme.New(acme.Config{
+		Issue: func(req acme.CertRequest) ([]byte, error) {
+			cert, err := s.Issue(IssueRequest{
+				CSR:       req.CSR,
+				Requester: req.RemoteIP,
+				APIKeyID:  ACMEAPIKeyID,
+				Profile:   req.Profile,
+				NotAfter:  req.NotAfter,
+			})
+			if perr, ok := err.(*PolicyError); ok {
+				return nil, acme.Rejected(perr.Error())
+			}
+			return cert, err
+		},
+		Revoke: func(serial *big.Int, reason int) error {
+			return s.Revoke(serial, RevocationReason(reason))
+		},
+		Storage:     s.ACMEStorage,
+		Resolver:    cfg.GetResolver(),
+		AutoApprove: cfg.GetAutoApprove(),
+		Profile:     profile,
+	})
 }
 
 // ServeHTTP implements `http.Handler` interface, which serves HTTP requests.
@@ -50,13 +244,47 @@ func (e *Server) ListenAndServeAsync(ctx context.Context, listenaddr string, max
 	if ctx == nil {
 		panic("context is nil")
 	}
-	hs := &http.Server{
+	hs := e.httpserver(listenaddr)
+	return serveasync(ctx, hs, hs.ListenAndServe, maxwait)
+}
+
+// ListenAndServeTLSAsync is ListenAndServeAsync over HTTPS (see TLSOptions)
+func (e *Server) ListenAndServeTLSAsync(ctx context.Context, listenaddr string, opts TLSOptions, maxwait time.Duration) (exitch <-chan struct{}, err error) {
+	if ctx == nil {
+		panic("context is nil")
+	}
+	tlsc, err := e.TLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return e.ListenAndServeTLSConfigAsync(ctx, listenaddr, tlsc, maxwait)
+}
+
+// ListenAndServeTLSConfigAsync is ListenAndServeTLSAsync with a TLS config
+// returned by TLSConfig (e.g. shared with the gRPC listeners)
+func (e *Server) ListenAndServeTLSConfigAsync(ctx context.Context, listenaddr string, tlsc *tls.Config, maxwait time.Duration) (exitch <-chan struct{}, err error) {
+	if ctx == nil {
+		panic("context is nil")
+	}
+	hs := e.httpserver(listenaddr)
+	hs.TLSConfig = tlsc
+	return serveasync(ctx, hs, func() error {
+		return hs.ListenAndServeTLS("", "")
+	}, maxwait)
+}
+
+func (e *Server) httpserver(listenaddr string) *http.Server {
+	return &http.Server{
 		Addr:           listenaddr,
 		Handler:        e,
 		ReadTimeout:    time.Second * 30,
 		WriteTimeout:   time.Second * 45,
 		MaxHeaderBytes: 1024 * 1024,
 	}
+}
+
+// serveasync runs listen until it fails or ctx is done
+func serveasync(ctx context.Context, hs *http.Server, listen func() error, maxwait time.Duration) (exitch <-chan struct{}, err error) {
 	//
 	ech := make(chan error, 1)
 	eopen := true
@@ -65,7 +293,7 @@ func (e *Server) ListenAndServeAsync(ctx context.Context, listenaddr string, max
 	var closechonce sync.Once
 	//
 	go func() {
-		err := hs.ListenAndServe()
+		err := listen()
 		closechonce.Do(func() {
 			close(closech)
 		})
@@ -108,3 +336,42 @@ func (e *Server) ListenAndServeAsync(ctx context.Context, listenaddr string, max
 	}(ctx, hs, ech)
 	return closech, nil
 }
+
+// ESTAPIKeyID is the API key id of the EST requests (its policy applies)
+const ESTAPIKeyID = "est"
+
+func (s *Server) estserver() *est.Server {
+	cfg := s.cfg.GetEst()
+	clientcas := x509.NewCertPool()
+	clientcas.AppendCertsFromPEM(s.RootCA())
+	return est.New(est.Config{
+		Enroll: func(req est.EnrollRequest) ([]byte, error) {
+			cert, err := s.Issue(IssueRequest{
+				CSR:       req.CSR,
+				Requester: req.RemoteIP,
+				APIKeyID:  ESTAPIKeyID,
+				Profile:   cfg.GetProfile(),
+			})
+			if perr, ok := err.(*PolicyError); ok {
+				return nil, echo.NewHTTPError(http.StatusForbidden, perr.Error())
+			}
+			return cert, err
+		},
+		CACerts: func() []byte {
+			return bytes.Join([][]byte{s.Chain(), s.RootCA()}, nil)
+		},
+		NewKey: func() (crypto.Signer, error) {
+			key, err := s.NewKey()
+			if err != nil {
+				return nil, err
+			}
+			return pkix.ParsePrivateKey(key, nil)
+		},
+		Username:  cfg.GetUsername(),
+		Password:  cfg.GetPassword(),
+		ClientCAs: clientcas,
+		IsRevoked: func(cert *x509.Certificate) (bool, error) {
+			return s.IsRevoked(cert.SerialNumber)
+		},
+	})
+}
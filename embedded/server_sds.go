@@ -0,0 +1,77 @@
+package embedded
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"strings"
+
+	"github.com/gabstv/ztls/api/ztlsv1"
+	"github.com/gabstv/ztls/embedded/sds"
+	"github.com/gabstv/ztls/internal/pkix"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SDSAPIKeyMetadata is the gRPC metadata key of the API key of the SDS
+// streams (set with the initial_metadata of the Envoy grpc_service)
+const SDSAPIKeyMetadata = ztlsv1.APIKeyMetadata
+
+// SDSServer returns the Envoy SDS server. The streams are authenticated by an
+// API key (SDSAPIKeyMetadata) whose policy applies. The tls_certificate
+// secrets are issued with the peer profile for their name: a SPIFFE ID (see
+// Server.SPIFFEID) allowed by the policy (Policy.AllowedSpiffeIds) or a DNS
+// name. The validation context secret
+// (sds.DefaultValidationName) holds the root certificate.
+func (s *Server) SDSServer() *sds.Server {
+	return sds.New(sds.Config{
+		Issue:        s.sdsissue,
+		CACerts:      s.RootCA,
+		Authenticate: s.sdsauth,
+	})
+}
+
+func (s *Server) sdsauth(ctx context.Context) (string, error) {
+	return s.grpcapikey(ctx, RouteSDS)
+}
+
+func (s *Server) sdsissue(ctx context.Context, req sds.IssueRequest) ([]byte, []byte, error) {
+	key, err := s.NewKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	nfo := pkix.CSRInfo{}
+	if strings.HasPrefix(req.Name, pkix.SPIFFEScheme+"://") {
+		nfo.URIs = []string{req.Name}
+	} else {
+		nfo.CommonName = req.Name
+		nfo.Domains = []string{req.Name}
+	}
+	csr, err := pkix.NewCSRPEM(nfo, key, nil)
+	if err != nil {
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	chain, err := s.Issue(IssueRequest{
+		CSR:       csr,
+		Requester: req.NodeID,
+		APIKeyID:  req.Requester,
+		Profile:   ProfilePeer,
+	})
+	if err != nil {
+		if perr, ok := err.(*PolicyError); ok {
+			return nil, nil, status.Error(codes.PermissionDenied, perr.Error())
+		}
+		if errors.Is(err, errInvalidCSR) {
+			return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil, nil, err
+	}
+	return chain, key, nil
+}
+
+// ListenAndServeSDSAsync serves the SDS server over gRPC on addr (host:port,
+// or unix:/path for a unix socket) until ctx is done, with TLS if tlsc is
+// not nil
+func (s *Server) ListenAndServeSDSAsync(ctx context.Context, addr string, tlsc *tls.Config) (<-chan struct{}, error) {
+	return servegrpc(ctx, "SDS", addr, tlsc, s.SDSServer().Register)
+}
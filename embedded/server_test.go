@@ -0,0 +1,1761 @@
+package embedded
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	cpkix "crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	secretv3 "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"github.com/gabstv/ztls/api/ztls"
+	"github.com/gabstv/ztls/api/ztlsv1"
+	"github.com/gabstv/ztls/embedded/sds"
+	"github.com/gabstv/ztls/embedded/store"
+	"github.com/gabstv/ztls/internal/pkix"
+	"github.com/golang/protobuf/ptypes"
+	"go.mozilla.org/pkcs7"
+	xacme "golang.org/x/crypto/acme"
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// newTestConfig creates a config with ECDSA keys. If intermediate is true,
+// the config holds an intermediate CA and no root key.
+func newTestConfig(t *testing.T, intermediate bool) *Config {
+	t.Helper()
+	rootkey, err := pkix.NewKeyWithType(pkix.KeyECDSAP256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootcert, err := pkix.NewCACertificateWithInput(pkix.NewCACertificateInput{
+		Key:        rootkey,
+		MaxPathLen: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Rootkey:  rootkey,
+		Rootcert: rootcert,
+		Apikey:   "test",
+		KeyType:  string(pkix.KeyECDSAP256),
+	}
+	if !intermediate {
+		return cfg
+	}
+	rootk, err := pkix.ParsePrivateKey(rootkey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intkey, err := pkix.NewKeyWithType(pkix.KeyECDSAP256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intcert, err := pkix.NewIntermediateCertificate(pkix.NewCACertificateInput{
+		Key:       intkey,
+		Parent:    parseTestCert(t, rootcert),
+		ParentKey: rootk,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Rootkey = nil
+	cfg.Issuerkey = intkey
+	cfg.Issuercert = intcert
+	return cfg
+}
+
+func parseTestCert(t *testing.T, certpem []byte) *x509.Certificate {
+	t.Helper()
+	blk, _ := pem.Decode(certpem)
+	if blk == nil {
+		t.Fatal("invalid PEM")
+	}
+	cert, err := x509.ParseCertificate(blk.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestIntermediateChain(t *testing.T) {
+	cfg := newTestConfig(t, true)
+	s := New(context.Background(), cfg)
+	tlsc, _, certpem, err := s.NewServerAuto(&CSRJson{
+		CommonName: "example.com",
+		Domains:    []string{"example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(tlsc.Certificates[0].Certificate); n != 2 {
+		t.Fatalf("expected the leaf and the intermediate, got %v certificates", n)
+	}
+	leaf := parseTestCert(t, certpem)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(cfg.Rootcert)
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM(cfg.Issuercert)
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       "example.com",
+		Roots:         roots,
+		Intermediates: intermediates,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevocationCRL(t *testing.T) {
+	cfg := newTestConfig(t, true)
+	cfg.PublicUrl = "https://ca.example.com/"
+	s := New(context.Background(), cfg)
+	rl, err := OpenFileRevocationList(filepath.Join(t.TempDir(), "revocations.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Revocations = rl
+	_, _, certpem, err := s.NewServerAuto(&CSRJson{CommonName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parseTestCert(t, certpem)
+	if len(cert.CRLDistributionPoints) != 1 || cert.CRLDistributionPoints[0] != "https://ca.example.com/1/crl" {
+		t.Fatal("unexpected CRL distribution points:", cert.CRLDistributionPoints)
+	}
+	if err := s.Revoke(cert.SerialNumber, ReasonKeyCompromise); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := s.IsRevoked(cert.SerialNumber); err != nil || !ok {
+		t.Fatal("expected the certificate to be revoked", err)
+	}
+	der, err := s.CRL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseCRL(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parseTestCert(t, cfg.Issuercert).CheckCRLSignature(crl); err != nil {
+		t.Fatal(err)
+	}
+	entries := crl.TBSCertList.RevokedCertificates
+	if len(entries) != 1 || entries[0].SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Fatal("unexpected CRL entries:", entries)
+	}
+	var reason asn1.Enumerated
+	if len(entries[0].Extensions) != 1 {
+		t.Fatal("expected the reason code extension")
+	}
+	if _, err := asn1.Unmarshal(entries[0].Extensions[0].Value, &reason); err != nil || reason != asn1.Enumerated(ReasonKeyCompromise) {
+		t.Fatal("unexpected reason code:", reason, err)
+	}
+}
+
+func TestOCSP(t *testing.T) {
+	cfg := newTestConfig(t, true)
+	cfg.PublicUrl = "https://ca.example.com"
+	s := New(context.Background(), cfg)
+	revpath := filepath.Join(t.TempDir(), "revocations.json")
+	rl, err := OpenFileRevocationList(revpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Revocations = rl
+	_, _, certpem, err := s.NewServerAuto(&CSRJson{CommonName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := parseTestCert(t, certpem)
+	if len(cert.OCSPServer) != 1 || cert.OCSPServer[0] != "https://ca.example.com/1/ocsp" {
+		t.Fatal("unexpected OCSP server:", cert.OCSPServer)
+	}
+	issuer := parseTestCert(t, cfg.Issuercert)
+	reqder, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func() *ocsp.Response {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/1/ocsp", bytes.NewReader(reqder))
+		r.Header.Set("Content-Type", "application/ocsp-request")
+		s.ServeHTTP(w, r)
+		resp, err := ocsp.ParseResponseForCert(w.Body.Bytes(), cert, issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	if resp := query(); resp.Status != ocsp.Good {
+		t.Fatal("expected status good, got", resp.Status)
+	}
+	if err := s.Revoke(cert.SerialNumber, ReasonSuperseded); err != nil {
+		t.Fatal(err)
+	}
+	resp := query()
+	if resp.Status != ocsp.Revoked || resp.RevocationReason != int(ReasonSuperseded) {
+		t.Fatal("expected status revoked, got", resp.Status, resp.RevocationReason)
+	}
+
+	// a revocation by another process (ztls revoke) replaces a cached Good
+	// response
+	_, _, certpem2, err := s.NewServerAuto(&CSRJson{CommonName: "b.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert = parseTestCert(t, certpem2)
+	if reqder, err = ocsp.CreateRequest(cert, issuer, nil); err != nil {
+		t.Fatal(err)
+	}
+	if resp := query(); resp.Status != ocsp.Good {
+		t.Fatal("expected status good, got", resp.Status)
+	}
+	other, err := OpenFileRevocationList(revpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Revoke(Revocation{Serial: cert.SerialNumber, Reason: ReasonKeyCompromise, RevokedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := query(); resp.Status != ocsp.Revoked || resp.RevocationReason != int(ReasonKeyCompromise) {
+		t.Fatal("expected status revoked, got", resp.Status, resp.RevocationReason)
+	}
+}
+
+func TestOCSPUnknown(t *testing.T) {
+	bst, err := store.OpenBolt(filepath.Join(t.TempDir(), "certificates.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bst.Close()
+	for name, st := range map[string]store.Store{"memory": store.NewMemory(), "bolt": bst} {
+		cfg := newTestConfig(t, false)
+		s := New(context.Background(), cfg)
+		s.Store = st
+		issuer := parseTestCert(t, cfg.Rootcert)
+		for _, serial := range []int64{0, 99} {
+			// a certificate of the CA that is not in the inventory
+			cert := &x509.Certificate{
+				SerialNumber: big.NewInt(serial),
+				RawIssuer:    issuer.RawSubject,
+			}
+			reqder, err := ocsp.CreateRequest(cert, issuer, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/1/ocsp", bytes.NewReader(reqder))
+			r.Header.Set("Content-Type", "application/ocsp-request")
+			s.ServeHTTP(w, r)
+			resp, err := ocsp.ParseResponseForCert(w.Body.Bytes(), cert, issuer)
+			if err != nil {
+				t.Fatal(name, serial, err)
+			}
+			if resp.Status != ocsp.Unknown {
+				t.Fatal(name, serial, "expected status unknown, got", resp.Status)
+			}
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	cfg := newTestConfig(t, false)
+	s := New(context.Background(), cfg)
+	st, err := store.OpenBolt(filepath.Join(t.TempDir(), "certificates.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	s.Store = st
+	// the second certificate must skip the duplicate serial
+	ids := []int64{42, 42, 43}
+	s.NextID = func() int64 {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		_, _, _, err := s.NewServerAuto(&CSRJson{
+			CommonName: host,
+			Domains:    []string{host},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := st.Get(big.NewInt(43))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.CommonName != "b.example.com" || parseTestCert(t, r.Certificate).SerialNumber.Int64() != 43 {
+		t.Fatal("unexpected record:", r.CommonName, r.Serial)
+	}
+	list, err := s.Certificates(store.Filter{Host: "a.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Serial.Int64() != 42 {
+		t.Fatal("unexpected records:", list)
+	}
+}
+
+// failstore is a Store that fails to save the records
+type failstore struct {
+	store.Store
+}
+
+func (failstore) Put(r store.Record) error {
+	return errors.New("put failed")
+}
+
+func TestReleaseSerial(t *testing.T) {
+	bst, err := store.OpenBolt(filepath.Join(t.TempDir(), "certificates.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bst.Close()
+	for name, st := range map[string]store.Store{"memory": store.NewMemory(), "bolt": bst} {
+		s := New(context.Background(), newTestConfig(t, false))
+		s.Store = failstore{st}
+		s.NextID = func() int64 { return 42 }
+		if _, _, _, err := s.NewServerAuto(&CSRJson{CommonName: "a.example.com"}); err == nil {
+			t.Fatal(name, "expected an error")
+		}
+		// the serial number of the failed issuance is free again
+		if err := st.Reserve(big.NewInt(42)); err != nil {
+			t.Fatal(name, err)
+		}
+		// the record of an issued certificate is not released
+		s.Store = st
+		s.NextID = func() int64 { return 43 }
+		if _, _, _, err := s.NewServerAuto(&CSRJson{CommonName: "b.example.com"}); err != nil {
+			t.Fatal(name, err)
+		}
+		if err := st.Release(big.NewInt(43)); err != nil {
+			t.Fatal(name, err)
+		}
+		if _, err := st.Get(big.NewInt(43)); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	cfg := newTestConfig(t, false)
+	cfg.Policy = &Policy{
+		AllowedDns:      []string{"*.internal.example.com"},
+		AllowedKeyTypes: []string{"ecdsa-p256"},
+	}
+	cfg.ApikeyPolicies = map[string]*Policy{
+		"default": {
+			AllowedDns:     []string{"*.example.com"},
+			AllowWildcards: true,
+		},
+	}
+	s := New(context.Background(), cfg)
+	post := func(cn, apikey string) int {
+		t.Helper()
+		key, err := pkix.NewKeyWithType(pkix.KeyECDSAP256, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := pkix.NewCSRPEM(pkix.CSRInfo{CommonName: cn, Domains: []string{cn}}, key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := "/1/new-certificate"
+		if apikey != "" {
+			path = "/1/new-server-certificate"
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(url.Values{"csr": {string(csr)}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("X-API-KEY", apikey)
+		s.ServeHTTP(w, r)
+		return w.Code
+	}
+	for _, v := range []struct {
+		cn     string
+		apikey string
+		code   int
+	}{
+		{"a.internal.example.com", "", 200},
+		{"a.example.com", "", 403},
+		{"*.internal.example.com", "", 403},
+		{"*.example.com", "test", 200},
+		{"*.ourbank.com", "test", 403},
+	} {
+		if code := post(v.cn, v.apikey); code != v.code {
+			t.Errorf("%v (api key %q): expected %v, got %v", v.cn, v.apikey, v.code, code)
+		}
+	}
+	csr, err := x509.ParseCertificateRequest(mustCSR(t, pkix.KeyRSA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Policy.Check(csr).(*PolicyError); !ok {
+		t.Fatal("expected the RSA key to be rejected")
+	}
+}
+
+func mustCSR(t *testing.T, kt pkix.KeyType) []byte {
+	t.Helper()
+	key, err := pkix.NewKeyWithType(kt, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrpem, err := pkix.NewCSRPEM(pkix.CSRInfo{CommonName: "a.internal.example.com"}, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := pkix.DecodePEM(csrpem, pkix.PEMCertificateRequest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestProfiles(t *testing.T) {
+	cfg := newTestConfig(t, false)
+	s := New(context.Background(), cfg)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(cfg.Rootcert)
+	key, err := s.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []struct {
+		profile string
+		usage   x509.ExtKeyUsage
+		server  bool
+	}{
+		{ProfileClient, x509.ExtKeyUsageClientAuth, false},
+		{ProfileServer, x509.ExtKeyUsageServerAuth, true},
+		{"", x509.ExtKeyUsageServerAuth, true},
+	} {
+		certpem, err := s.NewCertificateCSRWithProfile(&CSRJson{CommonName: "a.example.com"}, key, v.profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert := parseTestCert(t, certpem)
+		if cert.KeyUsage != x509.KeyUsageDigitalSignature {
+			t.Errorf("%v: unexpected key usage for an ECDSA key: %v", v.profile, cert.KeyUsage)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{
+			DNSName:   "a.example.com",
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if (err == nil) != v.server {
+			t.Errorf("%v: server verification: %v", v.profile, err)
+		}
+		if _, err := cert.Verify(x509.VerifyOptions{
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{v.usage},
+		}); err != nil {
+			t.Errorf("%v: %v", v.profile, err)
+		}
+	}
+	if _, err := s.NewCertificateCSRWithProfile(&CSRJson{CommonName: "x"}, key, "unknown"); err == nil {
+		t.Fatal("expected an error for an unknown profile")
+	}
+}
+
+func TestLifetimes(t *testing.T) {
+	cfg := newTestConfig(t, false)
+	cfg.PublicUrl = "https://ca.example.com"
+	cfg.MaxLifetimeSeconds = 48 * 3600
+	cfg.BackdateSeconds = -1
+	s := New(context.Background(), cfg)
+	csr := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: mustCSR(t, pkix.KeyECDSAP256),
+	})
+	issue := func(ttl time.Duration) *x509.Certificate {
+		t.Helper()
+		certpem, err := s.Issue(IssueRequest{CSR: csr, TTL: ttl})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parseTestCert(t, certpem)
+	}
+	start := time.Now().Truncate(time.Second)
+	cert := issue(4 * time.Hour)
+	if cert.NotBefore.Before(start) || cert.NotAfter.Sub(cert.NotBefore).Round(time.Minute) != 4*time.Hour {
+		t.Fatal("unexpected validity:", cert.NotBefore, cert.NotAfter)
+	}
+	if len(cert.CRLDistributionPoints) != 0 || len(cert.OCSPServer) != 0 {
+		t.Fatal("short-lived certificates must not have revocation URLs")
+	}
+	// the default lifetime is shortened to the maximum
+	cert = issue(0)
+	if cert.NotAfter.Sub(cert.NotBefore).Round(time.Minute) != 48*time.Hour {
+		t.Fatal("unexpected validity:", cert.NotBefore, cert.NotAfter)
+	}
+	if len(cert.CRLDistributionPoints) != 1 || len(cert.OCSPServer) != 1 {
+		t.Fatal("expected the revocation URLs")
+	}
+	if _, err := s.Issue(IssueRequest{CSR: csr, TTL: 72 * time.Hour}); err == nil {
+		t.Fatal("expected the lifetime to be rejected")
+	}
+
+	// the certificates don't outlive the CA
+	cacert, err := pkix.NewCACertificateWithInput(pkix.NewCACertificateInput{
+		Key:      cfg.Rootkey,
+		NotAfter: time.Now().Add(30 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Rootcert = cacert
+	s = New(context.Background(), cfg)
+	cert = issue(40 * time.Hour)
+	if ca := parseTestCert(t, cacert); !cert.NotAfter.Equal(ca.NotAfter) {
+		t.Fatal("unexpected validity:", cert.NotAfter, "CA:", ca.NotAfter)
+	}
+}
+
+func TestACME(t *testing.T) {
+	cfg := newTestConfig(t, false)
+	cfg.Acme = &ACME{
+		Enabled:     true,
+		AutoApprove: []string{"*.internal.example.com"},
+	}
+	cfg.ApikeyPolicies = map[string]*Policy{
+		ACMEAPIKeyID: {DeniedDns: []string{"denied.internal.example.com"}},
+	}
+	s := New(context.Background(), cfg)
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+
+	ctx := context.Background()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := &xacme.Client{
+		Key:          key,
+		DirectoryURL: hs.URL + "/acme/directory",
+	}
+	if _, err := cl.Register(ctx, &xacme.Account{Contact: []string{"mailto:admin@example.com"}}, xacme.AcceptTOS); err != nil {
+		t.Fatal(err)
+	}
+
+	order := func(name string) (*xacme.Order, []byte) {
+		t.Helper()
+		o, err := cl.AuthorizeOrder(ctx, xacme.DomainIDs(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Status != xacme.StatusReady {
+			t.Fatalf("order status: %v", o.Status)
+		}
+		certkey, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.CreateCertificateRequest(crand.Reader, &x509.CertificateRequest{
+			DNSNames: []string{name},
+		}, certkey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return o, csr
+	}
+
+	o, csr := order("a.internal.example.com")
+	chain, _, err := cl.CreateOrderCert(ctx, o.FinalizeURL, csr, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) == 0 {
+		t.Fatal("empty chain")
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(cfg.Rootcert)
+	if _, err := cert.Verify(x509.VerifyOptions{
+		DNSName:   "a.internal.example.com",
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// names that aren't auto approved need a challenge
+	if o, err := cl.AuthorizeOrder(ctx, xacme.DomainIDs("www.example.com")); err != nil {
+		t.Fatal(err)
+	} else if o.Status != xacme.StatusPending {
+		t.Fatalf("order status: %v", o.Status)
+	}
+
+	// the policy of the acme API key id applies
+	o, csr = order("denied.internal.example.com")
+	if _, _, err := cl.CreateOrderCert(ctx, o.FinalizeURL, csr, true); err == nil {
+		t.Fatal("expected the policy to reject the order")
+	}
+
+	if err := cl.RevokeCert(ctx, nil, chain[0], xacme.CRLReasonKeyCompromise); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := s.IsRevoked(cert.SerialNumber); err != nil || !revoked {
+		t.Fatalf("expected the certificate to be revoked (%v)", err)
+	}
+}
+
+func TestEST(t *testing.T) {
+	cfg := newTestConfig(t, true)
+	cfg.Est = &EST{
+		Enabled:  true,
+		Username: "device",
+		Password: "secret",
+	}
+	s := New(context.Background(), cfg)
+	post := func(path string, body []byte, auth bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/pkcs10")
+		if auth {
+			r.SetBasicAuth("device", "secret")
+		}
+		s.ServeHTTP(w, r)
+		return w
+	}
+	p7certs := func(body []byte) []*x509.Certificate {
+		t.Helper()
+		der, err := base64.StdEncoding.DecodeString(strings.Replace(string(body), "\r\n", "", -1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p7, err := pkcs7.Parse(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p7.Certificates
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/est/cacerts", nil))
+	if w.Code != 200 {
+		t.Fatalf("cacerts: %v %v", w.Code, w.Body.String())
+	}
+	if certs := p7certs(w.Body.Bytes()); len(certs) != 2 {
+		t.Fatalf("expected 2 CA certificates, got %v", len(certs))
+	}
+
+	csr := []byte(base64.StdEncoding.EncodeToString(mustCSR(t, pkix.KeyECDSAP256)))
+	if w := post("/.well-known/est/simpleenroll", csr, false); w.Code != 401 {
+		t.Fatalf("expected 401, got %v", w.Code)
+	}
+	w = post("/.well-known/est/simpleenroll", csr, true)
+	if w.Code != 200 {
+		t.Fatalf("simpleenroll: %v %v", w.Code, w.Body.String())
+	}
+	if certs := p7certs(w.Body.Bytes()); len(certs) != 2 || certs[0].Subject.CommonName != "a.internal.example.com" {
+		t.Fatal("invalid simpleenroll response")
+	}
+
+	w = post("/.well-known/est/serverkeygen", csr, true)
+	if w.Code != 200 {
+		t.Fatalf("serverkeygen: %v %v", w.Code, w.Body.String())
+	}
+	_, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := ioutil.ReadAll(part)
+	der, err := base64.StdEncoding.DecodeString(strings.Replace(string(raw), "\r\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part, err = mr.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ = ioutil.ReadAll(part)
+	cert := p7certs(raw)[0]
+	if !key.(*ecdsa.PrivateKey).PublicKey.Equal(cert.PublicKey) {
+		t.Fatal("the certificate doesn't match the server generated key")
+	}
+
+	// authentication with a client certificate of the CA
+	certpem, err := s.Issue(IssueRequest{CSR: pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: mustCSR(t, pkix.KeyECDSAP256),
+	}), Trusted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := parseTestCert(t, certpem)
+	peer := []*x509.Certificate{client, parseTestCert(t, cfg.Issuercert)}
+	posttls := func(path string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/pkcs10")
+		r.TLS = &tls.ConnectionState{PeerCertificates: peer}
+		s.ServeHTTP(w, r)
+		return w
+	}
+	if w := posttls("/.well-known/est/simplereenroll", csr); w.Code != 200 {
+		t.Fatalf("simplereenroll: %v %v", w.Code, w.Body.String())
+	}
+	othercsr, err := x509.CreateCertificateRequest(crand.Reader, &x509.CertificateRequest{
+		Subject: cpkix.Name{CommonName: "b.internal.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := []byte(base64.StdEncoding.EncodeToString(othercsr))
+	if w := posttls("/.well-known/est/simplereenroll", other); w.Code != 400 {
+		t.Fatalf("expected 400, got %v", w.Code)
+	}
+	// a revoked client certificate doesn't authenticate
+	if err := s.Revoke(client.SerialNumber, ReasonKeyCompromise); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/.well-known/est/simpleenroll", "/.well-known/est/simplereenroll"} {
+		if w := posttls(path, csr); w.Code != 401 {
+			t.Fatalf("%v: expected 401, got %v", path, w.Code)
+		}
+	}
+}
+
+// scepCSR creates a CSR with a challengePassword attribute
+func scepCSR(t *testing.T, key *rsa.PrivateKey, cn, password string) []byte {
+	t.Helper()
+	spki, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := asn1.Marshal(cpkix.Name{CommonName: cn}.ToRDNSequence())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := asn1.Marshal(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attr, err := asn1.Marshal(struct {
+		Type   asn1.ObjectIdentifier
+		Values []asn1.RawValue `asn1:"set"`
+	}{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}, []asn1.RawValue{{FullBytes: pw}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbs, err := asn1.Marshal(struct {
+		Version    int
+		Subject    asn1.RawValue
+		PublicKey  asn1.RawValue
+		Attributes []asn1.RawValue `asn1:"tag:0"`
+	}{0, asn1.RawValue{FullBytes: subject}, asn1.RawValue{FullBytes: spki}, []asn1.RawValue{{FullBytes: attr}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256(tbs)
+	sig, err := rsa.SignPKCS1v15(crand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(struct {
+		TBS       asn1.RawValue
+		Algorithm cpkix.AlgorithmIdentifier
+		Signature asn1.BitString
+	}{
+		asn1.RawValue{FullBytes: tbs},
+		cpkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, Parameters: asn1.NullRawValue},
+		asn1.BitString{Bytes: sig, BitLength: len(sig) * 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestSCEP(t *testing.T) {
+	cfg := newTestConfig(t, true)
+	cfg.Scep = &SCEP{
+		Enabled:            true,
+		ChallengePasswords: []string{"secret"},
+	}
+	s := New(context.Background(), cfg)
+	get := func(op string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scep?operation="+op, nil))
+		if w.Code != 200 {
+			t.Fatalf("%v: %v %v", op, w.Code, w.Body.String())
+		}
+		return w
+	}
+	if !strings.Contains(get("GetCACaps").Body.String(), "AES") {
+		t.Fatal("expected the AES capability")
+	}
+	w := get("GetCACert")
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-x509-ca-ra-cert" {
+		t.Fatalf("GetCACert content type: %v", ct)
+	}
+	p7, err := pkcs7.Parse(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p7.Certificates) != 3 {
+		t.Fatalf("expected the RA, intermediate and root certificates, got %v", len(p7.Certificates))
+	}
+	ra := p7.Certificates[0]
+
+	key, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selfder, err := x509.CreateCertificate(crand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      cpkix.Name{CommonName: "printer"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      cpkix.Name{CommonName: "printer"},
+	}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := x509.ParseCertificate(selfder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkimessage := func(msgtype string, signer *x509.Certificate, cn, password string) *pkcs7.PKCS7 {
+		t.Helper()
+		env, err := pkcs7.Encrypt(scepCSR(t, key, cn, password), []*x509.Certificate{ra})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd, err := pkcs7.NewSignedData(env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sd.AddSigner(signer, key, pkcs7.SignerInfoConfig{
+			ExtraSignedAttributes: []pkcs7.Attribute{
+				{Type: asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}, Value: msgtype},
+				{Type: asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}, Value: "tx1"},
+				{Type: asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}, Value: []byte("0123456789abcdef")},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := sd.Finish()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/scep?operation=PKIOperation", bytes.NewReader(msg)))
+		if w.Code != 200 {
+			t.Fatalf("PKIOperation: %v %v", w.Code, w.Body.String())
+		}
+		resp, err := pkcs7.Parse(w.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := resp.Verify(); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	pkcsreq := func(password string) *pkcs7.PKCS7 {
+		t.Helper()
+		return pkimessage("19", self, "printer.internal.example.com", password)
+	}
+	status := func(resp *pkcs7.PKCS7) string {
+		var v string
+		if err := resp.UnmarshalSignedAttribute(asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}, &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if resp := pkcsreq("wrong"); status(resp) != "2" {
+		t.Fatal("expected the wrong challenge password to fail")
+	}
+	resp := pkcsreq("secret")
+	if status(resp) != "0" {
+		t.Fatal("expected the request to succeed")
+	}
+	env, err := pkcs7.Parse(resp.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certsonly, err := env.Decrypt(self, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := pkcs7.Parse(certsonly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs.Certificates) == 0 || certs.Certificates[0].Subject.CommonName != "printer.internal.example.com" {
+		t.Fatal("invalid certificate")
+	}
+
+	// a RenewalReq signed with the issued certificate must keep its names
+	issued := certs.Certificates[0]
+	if resp := pkimessage("17", issued, "other.internal.example.com", ""); status(resp) != "2" {
+		t.Fatal("expected the renewal with other names to fail")
+	}
+	if resp := pkimessage("17", issued, "printer.internal.example.com", ""); status(resp) != "0" {
+		t.Fatal("expected the renewal to succeed")
+	}
+}
+
+func TestAPIV2(t *testing.T) {
+	cfg := newTestConfig(t, true)
+	cfg.Policy = &Policy{
+		AllowedDns: []string{"*.internal.example.com"},
+	}
+	s := New(context.Background(), cfg)
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	cl := &ztls.Client{
+		Endpoint: hs.URL,
+		APIKey:   "test",
+		KeyType:  ztls.KeyECDSAP256,
+	}
+	ctx := context.Background()
+	key, err := cl.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := cl.NewCSR(ztls.NewCSRInput{
+		CommonName: "a.internal.example.com",
+		IPs:        []string{"10.0.0.1"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := cl.IssueCertificate(ctx, ztls.NewCertificateRequest{CSR: csr, TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := parseTestCert(t, []byte(cert.Certificate))
+	if cert.Serial != x.SerialNumber.String() || !cert.NotAfter.Equal(x.NotAfter) {
+		t.Fatal("invalid certificate metadata")
+	}
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "a.internal.example.com" ||
+		len(cert.IPAddresses) != 1 || cert.IPAddresses[0] != "10.0.0.1" {
+		t.Fatalf("invalid SANs: %v %v", cert.DNSNames, cert.IPAddresses)
+	}
+	if cert.Chain != string(cfg.Issuercert) {
+		t.Fatal("expected the intermediate certificate in the chain")
+	}
+
+	code := func(err error) string {
+		t.Helper()
+		aerr, ok := err.(*ztls.APIError)
+		if !ok {
+			t.Fatalf("expected an APIError, got %v", err)
+		}
+		return aerr.Code
+	}
+	csr2, err := cl.NewCSR(ztls.CommonName("a.example.com"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cl.IssueCertificate(ctx, ztls.NewCertificateRequest{CSR: csr2})
+	if c := code(err); c != ztls.ErrCodePolicyRejected {
+		t.Fatalf("expected %v, got %v", ztls.ErrCodePolicyRejected, c)
+	}
+	_, err = cl.IssueCertificate(ctx, ztls.NewCertificateRequest{CSR: []byte("invalid csr")})
+	if c := code(err); c != ztls.ErrCodeInvalidCSR {
+		t.Fatalf("expected %v, got %v", ztls.ErrCodeInvalidCSR, c)
+	}
+	_, err = cl.IssueCertificate(ctx, ztls.NewCertificateRequest{CSR: csr, Profile: "nope"})
+	if c := code(err); c != ztls.ErrCodeUnknownProfile {
+		t.Fatalf("expected %v, got %v", ztls.ErrCodeUnknownProfile, c)
+	}
+	cl.APIKey = "wrong"
+	_, err = cl.IssueCertificate(ctx, ztls.NewCertificateRequest{CSR: csr})
+	if c := code(err); c != ztls.ErrCodeUnauthorized {
+		t.Fatalf("expected %v, got %v", ztls.ErrCodeUnauthorized, c)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	cfg := newTestConfig(t, true)
+	s := New(context.Background(), cfg)
+	s.Store = store.NewMemory()
+	s.Revocations = NewMemRevocationList()
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	cl := &ztls.Client{
+		Endpoint: hs.URL,
+		APIKey:   "test",
+		KeyType:  ztls.KeyECDSAP256,
+	}
+	ctx := context.Background()
+	newcsr := func(cn string) (key, csr []byte) {
+		t.Helper()
+		key, err := cl.NewKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err = cl.NewCSR(ztls.CommonName(cn), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key, csr
+	}
+	key, csr := newcsr("svc.example.com")
+	cert, err := cl.IssueCertificate(ctx, ztls.NewCertificateRequest{CSR: csr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// renewals don't use the API key
+	cl.APIKey = ""
+	key2, csr2 := newcsr("svc.example.com")
+	renewed, err := cl.Renew(ctx, ztls.RenewRequest{
+		NewCertificateRequest: ztls.NewCertificateRequest{CSR: csr2},
+		Certificate:           []byte(cert.Certificate),
+		Key:                   key,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Store.Get(parseTestCert(t, []byte(renewed.Certificate)).SerialNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RenewedFrom == nil || r.RenewedFrom.String() != cert.Serial || r.APIKeyID != "default" {
+		t.Fatal("unexpected lineage:", r.RenewedFrom, r.APIKeyID)
+	}
+
+	code := func(err error) string {
+		t.Helper()
+		aerr, ok := err.(*ztls.APIError)
+		if !ok {
+			t.Fatalf("expected an APIError, got %v", err)
+		}
+		return aerr.Code
+	}
+	// the proof must be signed by the key of the current certificate
+	_, err = cl.Renew(ctx, ztls.RenewRequest{
+		NewCertificateRequest: ztls.NewCertificateRequest{CSR: csr2},
+		Certificate:           []byte(renewed.Certificate),
+		Key:                   key,
+	})
+	if c := code(err); c != ztls.ErrCodeUnauthorized {
+		t.Fatalf("expected %v, got %v", ztls.ErrCodeUnauthorized, c)
+	}
+	_, csr3 := newcsr("other.example.com")
+	_, err = cl.Renew(ctx, ztls.RenewRequest{
+		NewCertificateRequest: ztls.NewCertificateRequest{CSR: csr3},
+		Certificate:           []byte(renewed.Certificate),
+		Key:                   key2,
+	})
+	if c := code(err); c != ztls.ErrCodeIdentityMismatch {
+		t.Fatalf("expected %v, got %v", ztls.ErrCodeIdentityMismatch, c)
+	}
+	// the rest of the subject can't change either
+	key4, err := cl.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr4, err := cl.NewCSR(ztls.NewCSRInput{
+		CommonName:   "svc.example.com",
+		Organization: []string{"Other"},
+	}, key4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cl.Renew(ctx, ztls.RenewRequest{
+		NewCertificateRequest: ztls.NewCertificateRequest{CSR: csr4},
+		Certificate:           []byte(renewed.Certificate),
+		Key:                   key2,
+	})
+	if c := code(err); c != ztls.ErrCodeIdentityMismatch {
+		t.Fatalf("expected %v, got %v", ztls.ErrCodeIdentityMismatch, c)
+	}
+	if err := s.Revoke(r.Serial, ReasonSuperseded); err != nil {
+		t.Fatal(err)
+	}
+	_, err = cl.Renew(ctx, ztls.RenewRequest{
+		NewCertificateRequest: ztls.NewCertificateRequest{CSR: csr2},
+		Certificate:           []byte(renewed.Certificate),
+		Key:                   key2,
+	})
+	if c := code(err); c != ztls.ErrCodeUnauthorized {
+		t.Fatalf("expected %v, got %v", ztls.ErrCodeUnauthorized, c)
+	}
+}
+
+func TestSelfCertificateRenewal(t *testing.T) {
+	cfg := newTestConfig(t, false)
+	cfg.DefaultLifetimeSeconds = 3
+	cfg.BackdateSeconds = -1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, cfg)
+	tlsc, err := s.TLSConfig(TLSOptions{Hosts: []string{"127.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := tlsc.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// renewed in the background after 2s
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		cert, err := tlsc.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert.Leaf.SerialNumber.Cmp(first.Leaf.SerialNumber) != 0 {
+			if !cert.Leaf.NotAfter.After(first.Leaf.NotAfter) {
+				t.Fatal("expected a later expiration")
+			}
+			return
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	t.Fatal("the certificate was not renewed")
+}
+
+func TestRenewProfile(t *testing.T) {
+	key, err := pkix.NewKeyWithType(pkix.KeyECDSAP256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := pkix.NewCSRPEM(pkix.CSRInfo{CommonName: "client"}, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, withstore := range []bool{true, false} {
+		s := New(context.Background(), newTestConfig(t, false))
+		if withstore {
+			s.Store = store.NewMemory()
+		}
+		chain, err := s.Issue(IssueRequest{CSR: csr, Profile: ProfileClient})
+		if err != nil {
+			t.Fatal(err)
+		}
+		current := parseTestCert(t, chain)
+		renew := func(profile string) ([]byte, error) {
+			return s.Renew(RenewRequest{CSR: csr, Current: current, TLS: true, Profile: profile})
+		}
+		// a client certificate can't be renewed as a server certificate
+		if _, err := renew(ProfileServer); err != errRenewProfile {
+			t.Fatal(withstore, "expected", errRenewProfile, "got", err)
+		}
+		if !withstore {
+			// the default profile (peer) adds serverAuth
+			if _, err := renew(""); err != errRenewProfile {
+				t.Fatal(withstore, "expected", errRenewProfile, "got", err)
+			}
+			chain, err = renew(ProfileClient)
+		} else {
+			// the profile of the record is the default
+			chain, err = renew("")
+		}
+		if err != nil {
+			t.Fatal(withstore, err)
+		}
+		if ekus := parseTestCert(t, chain).ExtKeyUsage; len(ekus) != 1 || ekus[0] != x509.ExtKeyUsageClientAuth {
+			t.Fatal(withstore, "unexpected extended key usages:", ekus)
+		}
+	}
+}
+
+func TestTLSListener(t *testing.T) {
+	cfg := newTestConfig(t, true)
+	s := New(context.Background(), cfg)
+	tlsc, err := s.TLSConfig(TLSOptions{
+		Hosts:      []string{"127.0.0.1"},
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := &http.Server{Handler: s}
+	go hs.Serve(tls.NewListener(l, tlsc))
+	defer hs.Close()
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(s.RootCA())
+	cl := &ztls.Client{
+		Endpoint: "https://" + l.Addr().String(),
+		APIKey:   "test",
+		KeyType:  ztls.KeyECDSAP256,
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots},
+			},
+		},
+	}
+	ctx := context.Background()
+	key, err := cl.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := cl.NewCSR(ztls.CommonName("svc.example.com"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := cl.IssueCertificate(ctx, ztls.NewCertificateRequest{CSR: csr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the issued certificate authenticates the renewal
+	clientcert, err := tls.X509KeyPair([]byte(cert.Certificate+cert.Chain), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.APIKey = ""
+	cl.HTTPClient = &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				Certificates: []tls.Certificate{clientcert},
+			},
+		},
+	}
+	if _, err := cl.Renew(ctx, ztls.RenewRequest{
+		NewCertificateRequest: ztls.NewCertificateRequest{CSR: csr},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	cfg := newTestConfig(t, false)
+	cfg.Apikeys = []*APIKey{
+		{
+			Id:           "team-a",
+			SecretSha256: HashAPIKeySecret("secret-a"),
+			Routes:       []string{RouteNewServerCertificate},
+			AllowedDns:   []string{"*.a.internal"},
+		},
+		{
+			Id:           "team-b",
+			SecretSha256: HashAPIKeySecret("secret-b"),
+			Disabled:     true,
+		},
+		{
+			Id:           "team-c",
+			SecretSha256: HashAPIKeySecret("secret-c"),
+			Expires:      time.Now().Add(-time.Hour).Unix(),
+		},
+		{
+			// the id of the ACME requests
+			Id:           ACMEAPIKeyID,
+			SecretSha256: HashAPIKeySecret("secret-acme"),
+		},
+	}
+	s := New(context.Background(), cfg)
+	s.Store = store.NewMemory()
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	cl := &ztls.Client{
+		Endpoint: hs.URL,
+		APIKey:   "secret-a",
+		KeyType:  ztls.KeyECDSAP256,
+	}
+	ctx := context.Background()
+	key, err := cl.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := func(cn string) error {
+		t.Helper()
+		csr, err := cl.NewCSR(ztls.CommonName(cn), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cl.IssueCertificate(ctx, ztls.NewCertificateRequest{CSR: csr})
+		return err
+	}
+	code := func(err error) string {
+		t.Helper()
+		aerr, ok := err.(*ztls.APIError)
+		if !ok {
+			t.Fatalf("expected an APIError, got %v", err)
+		}
+		return aerr.Code
+	}
+	if err := issue("svc.a.internal"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := s.Certificates(store.Filter{})
+	if err != nil || len(list) != 1 || list[0].APIKeyID != "team-a" {
+		t.Fatal("expected a certificate of team-a", err)
+	}
+	if c := code(issue("svc.b.internal")); c != ztls.ErrCodePolicyRejected {
+		t.Fatalf("expected %v, got %v", ztls.ErrCodePolicyRejected, c)
+	}
+	req, _ := http.NewRequest(http.MethodGet, hs.URL+"/2/certificates", nil)
+	req.Header.Set("X-API-KEY", "secret-a")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatal("expected the route to be forbidden, got", resp.Status)
+	}
+	for _, secret := range []string{"secret-b", "secret-c", "secret-acme", "wrong"} {
+		cl.APIKey = secret
+		if c := code(issue("svc.a.internal")); c != ztls.ErrCodeUnauthorized {
+			t.Fatalf("%v: expected %v, got %v", secret, ztls.ErrCodeUnauthorized, c)
+		}
+	}
+	// the key of the config is still accepted
+	cl.APIKey = "test"
+	if err := issue("svc.b.internal"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	cfg := newTestConfig(t, false)
+	cfg.Apikeys = []*APIKey{
+		{
+			Id:           "team-a",
+			SecretSha256: HashAPIKeySecret("secret-a"),
+			AllowedDns:   []string{"*.a.internal"},
+		},
+	}
+	s := New(context.Background(), cfg)
+	s.Store = store.NewMemory()
+	tl, err := OpenFileTokenList(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Tokens = tl
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	cl := &ztls.Client{
+		Endpoint: hs.URL,
+		APIKey:   "secret-a",
+		KeyType:  ztls.KeyECDSAP256,
+	}
+	ctx := context.Background()
+	tok, err := cl.NewToken(ctx, ztls.NewTokenRequest{
+		CommonName: "vm1.a.internal",
+		Names:      []string{"10.0.0.7"},
+		Uses:       1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := cl.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	newcsr := func(input ztls.NewCSRInput) []byte {
+		t.Helper()
+		csr, err := cl.NewCSR(input, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return csr
+	}
+	code := func(err error) string {
+		t.Helper()
+		aerr, ok := err.(*ztls.APIError)
+		if !ok {
+			t.Fatalf("expected an APIError, got %v", err)
+		}
+		return aerr.Code
+	}
+	// the token replaces the API key
+	cl.APIKey = ""
+	cl.Token = tok.Token
+	_, err = cl.IssueCertificate(ctx, ztls.NewCertificateRequest{CSR: newcsr(ztls.CommonName("vm2.a.internal"))})
+	if c := code(err); c != ztls.ErrCodeIdentityMismatch {
+		t.Fatalf("expected %v, got %v", ztls.ErrCodeIdentityMismatch, c)
+	}
+	csr := newcsr(ztls.NewCSRInput{
+		CommonName: "vm1.a.internal",
+		IPs:        []string{"10.0.0.7"},
+	})
+	cert, err := cl.IssueCertificate(ctx, ztls.NewCertificateRequest{CSR: csr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Store.Get(parseTestCert(t, []byte(cert.Certificate)).SerialNumber)
+	if err != nil || r.APIKeyID != "team-a" {
+		t.Fatal("expected the API key of the token creator", err)
+	}
+	_, err = cl.IssueCertificate(ctx, ztls.NewCertificateRequest{CSR: csr})
+	if c := code(err); c != ztls.ErrCodeUnauthorized {
+		t.Fatalf("expected %v, got %v", ztls.ErrCodeUnauthorized, c)
+	}
+
+	// the scope of the API key applies to the tokens it creates
+	cl.APIKey, cl.Token = "secret-a", ""
+	tok, err = cl.NewToken(ctx, ztls.NewTokenRequest{CommonName: "vm1.b.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.IssueWithToken(tok.Token, IssueRequest{CSR: newcsr(ztls.CommonName("vm1.b.internal"))})
+	if _, ok := err.(*PolicyError); !ok {
+		t.Fatal("expected a policy error, got", err)
+	}
+	// the use of the failed request is given back
+	if v, ok, err := s.Tokens.Get(strings.SplitN(tok.Token, ".", 2)[0]); err != nil || !ok || v.UsesLeft != 1 {
+		t.Fatal("expected the use to be given back", v.UsesLeft, ok, err)
+	}
+
+	// the tokens of an expired API key are rejected
+	tok, err = cl.NewToken(ctx, ztls.NewTokenRequest{CommonName: "vm3.a.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Apikeys[0].Expires = time.Now().Add(-time.Minute).Unix()
+	_, err = s.IssueWithToken(tok.Token, IssueRequest{CSR: newcsr(ztls.CommonName("vm3.a.internal"))})
+	if err != errTokenInvalid {
+		t.Fatal("expected", errTokenInvalid, "got", err)
+	}
+}
+
+func TestSPIFFE(t *testing.T) {
+	cfg := newTestConfig(t, false)
+	cfg.TrustDomain = "example.org"
+	cfg.ApikeyPolicies = map[string]*Policy{
+		"default": {
+			AllowedSpiffeIds: []string{"spiffe://example.org/ns/prod/*"},
+		},
+	}
+	s := New(context.Background(), cfg)
+	issue := func(id, apikey string, trusted bool) ([]byte, error) {
+		t.Helper()
+		key, err := pkix.NewKeyWithType(pkix.KeyECDSAP256, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := pkix.NewCSRPEM(pkix.CSRInfo{URIs: []string{id}}, key, nil)
+		if err != nil {
+			return nil, err
+		}
+		return s.Issue(IssueRequest{
+			CSR:      csr,
+			APIKeyID: apikey,
+			Trusted:  trusted,
+		})
+	}
+	id := s.SPIFFEID("/ns/prod/sa/api")
+	cert, err := issue(id, "default", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uris := parseTestCert(t, cert).URIs; len(uris) != 1 || uris[0].String() != id {
+		t.Fatal("expected the SPIFFE ID in the certificate", uris)
+	}
+	if _, err := issue("spiffe://example.org/ns/dev/sa/api", "default", false); err == nil {
+		t.Fatal("expected the SPIFFE ID to be rejected by the policy of the API key")
+	}
+	if _, err := issue("spiffe://example.org/ns/dev/sa/api", "", true); err != nil {
+		t.Fatal(err)
+	}
+	_, err = issue("spiffe://other.org/svc", "", true)
+	if _, ok := err.(*PolicyError); !ok {
+		t.Fatal("expected the foreign trust domain to be rejected")
+	}
+	if _, err := issue("spiffe://example.org/a/../b", "", true); err == nil {
+		t.Fatal("expected the invalid SPIFFE ID to be rejected")
+	}
+
+	// the anonymous requests without a policy have no URI SANs
+	_, err = issue(id, "", false)
+	if _, ok := err.(*PolicyError); !ok {
+		t.Fatal("expected the SPIFFE ID to be rejected, got", err)
+	}
+
+	// a policy or an API key scope without SPIFFE IDs denies the URI SANs
+	cfg.ApikeyPolicies["team-a"] = &Policy{AllowedDns: []string{"*.example.org"}}
+	cfg.Apikeys = []*APIKey{{Id: "team-b", AllowedDns: []string{"*.example.org"}}}
+	for _, apikey := range []string{"team-a", "team-b"} {
+		_, err = issue(id, apikey, false)
+		if _, ok := err.(*PolicyError); !ok {
+			t.Fatal(apikey, "expected the SPIFFE ID to be rejected, got", err)
+		}
+	}
+
+	// without a trust domain, the certificates have no URI SANs
+	cfg.TrustDomain = ""
+	if _, err := issue(id, "", true); err == nil {
+		t.Fatal("expected the URI SAN to be rejected")
+	}
+}
+
+func TestSDS(t *testing.T) {
+	cfg := newTestConfig(t, true)
+	cfg.TrustDomain = "example.org"
+	cfg.ApikeyPolicies = map[string]*Policy{
+		"default": {AllowedSpiffeIds: []string{"spiffe://example.org/ns/prod/*"}},
+	}
+	cfg.DefaultLifetimeSeconds = 3
+	s := New(context.Background(), cfg)
+	gs := grpc.NewServer()
+	s.SDSServer().Register(gs)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gs.Serve(l)
+	defer gs.Stop()
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cf := context.WithTimeout(context.Background(), time.Second*20)
+	defer cf()
+	cl := secretv3.NewSecretDiscoveryServiceClient(conn)
+	id := s.SPIFFEID("/ns/prod/sa/api")
+	req := &discoveryv3.DiscoveryRequest{
+		Node:          &corev3.Node{Id: "sidecar-1"},
+		ResourceNames: []string{id, sds.DefaultValidationName},
+		TypeUrl:       sds.SecretType,
+	}
+
+	// the streams require the API key
+	stream, err := cl.StreamSecrets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(req)
+	if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Fatal("expected unauthenticated, got", err)
+	}
+
+	stream, err = cl.StreamSecrets(metadata.AppendToOutgoingContext(ctx, SDSAPIKeyMetadata, "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(req); err != nil {
+		t.Fatal(err)
+	}
+	secrets := func() (*x509.Certificate, []byte) {
+		t.Helper()
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Resources) != 2 {
+			t.Fatal("expected 2 secrets, got", len(resp.Resources))
+		}
+		var cert *x509.Certificate
+		var ca []byte
+		for _, v := range resp.Resources {
+			sec := &tlsv3.Secret{}
+			if err := ptypes.UnmarshalAny(v, sec); err != nil {
+				t.Fatal(err)
+			}
+			if tc := sec.GetTlsCertificate(); tc != nil {
+				cert = parseTestCert(t, tc.GetCertificateChain().GetInlineBytes())
+			} else {
+				ca = sec.GetValidationContext().GetTrustedCa().GetInlineBytes()
+			}
+		}
+		// ACK
+		ack := *req
+		ack.VersionInfo, ack.ResponseNonce = resp.VersionInfo, resp.Nonce
+		if err := stream.Send(&ack); err != nil {
+			t.Fatal(err)
+		}
+		return cert, ca
+	}
+	first, ca := secrets()
+	if len(first.URIs) != 1 || first.URIs[0].String() != id {
+		t.Fatal("expected the SPIFFE ID in the certificate", first.URIs)
+	}
+	if !bytes.Equal(ca, s.RootCA()) {
+		t.Fatal("expected the root CA in the validation context")
+	}
+
+	// the renewed certificate is pushed before the first one expires
+	second, _ := secrets()
+	if second.SerialNumber.Cmp(first.SerialNumber) == 0 || time.Now().After(first.NotAfter) {
+		t.Fatal("expected a renewed certificate before the expiration")
+	}
+}
+
+func TestGRPCAPI(t *testing.T) {
+	cfg := newTestConfig(t, true)
+	cfg.Apikeys = []*APIKey{
+		{
+			Id:           "team-a",
+			SecretSha256: HashAPIKeySecret("secret-a"),
+			Routes:       []string{RouteNewServerCertificate, RouteRevoke},
+			AllowedDns:   []string{"*.a.internal"},
+		},
+	}
+	s := New(context.Background(), cfg)
+	s.Store = store.NewMemory()
+	gs := grpc.NewServer()
+	s.RegisterGRPC(gs)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gs.Serve(l)
+	defer gs.Stop()
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cf := context.WithTimeout(context.Background(), time.Second*20)
+	defer cf()
+	ca := ztlsv1.NewCertificateAuthorityClient(conn)
+
+	resp, err := ca.GetCA(ctx, &ztlsv1.GetCARequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Root != string(s.RootCA()) || resp.Chain != string(s.Chain()) {
+		t.Fatal("unexpected CA certificates")
+	}
+
+	newcsr := func(cn string) (key, csr []byte) {
+		t.Helper()
+		key, err := s.NewKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err = pkix.NewCSRPEM(pkix.CSRInfo{CommonName: cn, Domains: []string{cn}}, key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key, csr
+	}
+	key, csr := newcsr("svc.example.com")
+	if _, err := ca.IssueCertificate(ztlsv1.WithAPIKey(ctx, "invalid"), &ztlsv1.IssueCertificateRequest{Csr: string(csr)}); status.Code(err) != codes.Unauthenticated {
+		t.Fatal("expected unauthenticated, got", err)
+	}
+	cert, err := ca.IssueCertificate(ztlsv1.WithAPIKey(ctx, "test"), &ztlsv1.IssueCertificateRequest{Csr: string(csr), TtlSeconds: 3600, Profile: ProfileServer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := parseTestCert(t, []byte(cert.Certificate))
+	if cert.Serial != x.SerialNumber.String() || cert.NotAfter != x.NotAfter.Unix() || cert.Chain != string(s.Chain()) {
+		t.Fatal("unexpected certificate metadata", cert.Serial, cert.NotAfter)
+	}
+
+	// the policy of the API key applies
+	_, csra := newcsr("x.a.internal")
+	certa, err := ca.IssueCertificate(ztlsv1.WithAPIKey(ctx, "secret-a"), &ztlsv1.IssueCertificateRequest{Csr: string(csra)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, csrb := newcsr("x.b.internal")
+	if _, err := ca.IssueCertificate(ztlsv1.WithAPIKey(ctx, "secret-a"), &ztlsv1.IssueCertificateRequest{Csr: string(csrb)}); status.Code(err) != codes.PermissionDenied {
+		t.Fatal("expected permission denied, got", err)
+	}
+
+	// renewal with the key of the certificate
+	csr2, err := pkix.NewCSRPEM(pkix.CSRInfo{CommonName: "svc.example.com", Domains: []string{"svc.example.com"}}, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := ca.Renew(ctx, &ztlsv1.RenewRequest{Csr: string(csr2), Certificate: cert.Certificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.Serial == cert.Serial || renewed.CommonName != "svc.example.com" {
+		t.Fatal("unexpected renewed certificate", renewed.Serial)
+	}
+	_, csr3 := newcsr("svc.example.com")
+	if _, err := ca.Renew(ctx, &ztlsv1.RenewRequest{Csr: string(csr3), Certificate: cert.Certificate}); status.Code(err) != codes.Unauthenticated {
+		t.Fatal("expected unauthenticated (no proof of possession), got", err)
+	}
+
+	list, err := ca.ListCertificates(ztlsv1.WithAPIKey(ctx, "test"), &ztlsv1.ListCertificatesRequest{Host: "svc.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Certificates) != 2 || list.Certificates[1].RenewedFrom != cert.Serial {
+		t.Fatal("expected the certificate and its renewal, got", list.Certificates)
+	}
+	for _, r := range list.Certificates {
+		if r.Profile != ProfileServer {
+			t.Fatal("unexpected profile:", r.Profile)
+		}
+	}
+	if _, err := ca.ListCertificates(ztlsv1.WithAPIKey(ctx, "secret-a"), &ztlsv1.ListCertificatesRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Fatal("expected permission denied, got", err)
+	}
+
+	// named keys only revoke their certificates
+	if _, err := ca.Revoke(ztlsv1.WithAPIKey(ctx, "secret-a"), &ztlsv1.RevokeRequest{Serial: cert.Serial}); status.Code(err) != codes.PermissionDenied {
+		t.Fatal("expected permission denied, got", err)
+	}
+	if _, err := ca.Revoke(ztlsv1.WithAPIKey(ctx, "secret-a"), &ztlsv1.RevokeRequest{Serial: certa.Serial, Reason: "keyCompromise"}); err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := new(big.Int).SetString(certa.Serial, 10)
+	if ok, _ := s.IsRevoked(serial); !ok {
+		t.Fatal("expected a revoked certificate")
+	}
+
+	// the anonymous rate limit is shared with /2/new-certificate
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	hr, err := http.PostForm(hs.URL+"/2/new-certificate", url.Values{"csr": {"invalid"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hr.Body.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := ca.IssueCertificate(ctx, &ztlsv1.IssueCertificateRequest{Csr: "invalid"}); status.Code(err) != codes.InvalidArgument {
+			t.Fatal("expected invalid argument, got", err)
+		}
+	}
+	if _, err := ca.IssueCertificate(ctx, &ztlsv1.IssueCertificateRequest{Csr: "invalid"}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatal("expected resource exhausted, got", err)
+	}
+}
@@ -0,0 +1,74 @@
+package embedded
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/gabstv/ztls/internal/pkix"
+)
+
+// CSRURIReader is implemented by the CSRReaders with URI SANs (e.g. SPIFFE
+// IDs, see Server.SPIFFEID)
+type CSRURIReader interface {
+	GetURIs() []string
+}
+
+// TrustDomain returns the SPIFFE trust domain of the server (empty if the
+// certificates can't have URI SANs)
+func (s *Server) TrustDomain() string {
+	return s.cfg.GetTrustDomain()
+}
+
+// SPIFFEID returns the SPIFFE ID of a workload path (e.g. "/ns/prod/sa/api")
+// in the trust domain of the server
+func (s *Server) SPIFFEID(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return pkix.SPIFFEScheme + "://" + s.TrustDomain() + path
+}
+
+// checkuris validates the URI SANs of a request: they must be SPIFFE IDs of
+// the trust domain, and an X.509-SVID has only one
+func (s *Server) checkuris(csr *x509.CertificateRequest) error {
+	if len(csr.URIs) == 0 {
+		return nil
+	}
+	td := s.TrustDomain()
+	if td == "" {
+		return policyErrorf("URI SANs are not allowed (no trust domain)")
+	}
+	if len(csr.URIs) > 1 {
+		return policyErrorf("only one SPIFFE ID is allowed")
+	}
+	u, err := pkix.ParseSPIFFEID(csr.URIs[0].String())
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidCSR, err)
+	}
+	if u.Host != td {
+		return policyErrorf("SPIFFE ID %q is not in the trust domain %v", u.String(), td)
+	}
+	return nil
+}
+
+// checkspiffe allows the URI SANs matching AllowedSpiffeIds (none if it is
+// empty)
+func (p *Policy) checkspiffe(csr *x509.CertificateRequest) error {
+	for _, u := range csr.URIs {
+		id := u.String()
+		if !matchspiffe(p.GetAllowedSpiffeIds(), id) {
+			return policyErrorf("SPIFFE ID %q is not allowed", id)
+		}
+	}
+	return nil
+}
+
+func matchspiffe(patterns []string, id string) bool {
+	for _, v := range patterns {
+		if pkix.MatchSPIFFEID(v, id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketCertificates = []byte("certificates")
+
+// reserved is the value of a serial number that is reserved but not yet
+// issued
+var reserved = []byte("{}")
+
+// OpenBolt opens (or creates) a Store persisted in a BoltDB file. The file is
+// locked while it is open; OpenBolt fails after timeout if another process
+// holds the lock (0 waits forever).
+func OpenBolt(path string, timeout time.Duration) (Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		if err == bolt.ErrTimeout {
+			return nil, errors.New("store: " + path + " is locked by another process")
+		}
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketCertificates)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func (s *boltStore) Reserve(serial *big.Int) error {
+	if serial == nil || serial.Sign() <= 0 {
+		return errInvalidSerial
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketCertificates)
+		if b.Get(serial.Bytes()) != nil {
+			return ErrSerialExists
+		}
+		return b.Put(serial.Bytes(), reserved)
+	})
+}
+
+func (s *boltStore) Release(serial *big.Int) error {
+	if serial == nil || serial.Sign() <= 0 {
+		return errInvalidSerial
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketCertificates)
+		if string(b.Get(serial.Bytes())) != string(reserved) {
+			return nil
+		}
+		return b.Delete(serial.Bytes())
+	})
+}
+
+func (s *boltStore) Put(r Record) error {
+	if r.Serial == nil || r.Serial.Sign() <= 0 {
+		return errInvalidSerial
+	}
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketCertificates).Put(r.Serial.Bytes(), raw)
+	})
+}
+
+func (s *boltStore) Get(serial *big.Int) (Record, error) {
+	if serial == nil {
+		return Record{}, errInvalidSerial
+	}
+	if serial.Sign() <= 0 {
+		// never issued (as in memStore)
+		return Record{}, ErrNotFound
+	}
+	var r Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(bucketCertificates).Get(serial.Bytes())
+		if raw == nil || string(raw) == string(reserved) {
+			return ErrNotFound
+		}
+		return json.Unmarshal(raw, &r)
+	})
+	return r, err
+}
+
+func (s *boltStore) List(f Filter) ([]Record, error) {
+	outp := make([]Record, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketCertificates).ForEach(func(k, v []byte) error {
+			if string(v) == string(reserved) {
+				return nil
+			}
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if f.Match(r) {
+				outp = append(outp, r)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(outp, func(i, j int) bool {
+		return outp[i].IssuedAt.Before(outp[j].IssuedAt)
+	})
+	return limit(outp, f.Limit), nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"math/big"
+	"sort"
+	"sync"
+)
+
+// NewMemory creates an in-memory (not persisted) Store
+func NewMemory() Store {
+	return &memStore{
+		m: make(map[string]*Record),
+	}
+}
+
+type memStore struct {
+	l sync.RWMutex
+	// a nil value is a reserved serial number
+	m map[string]*Record
+}
+
+func (s *memStore) Reserve(serial *big.Int) error {
+	if serial == nil || serial.Sign() <= 0 {
+		return errInvalidSerial
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.m[serial.String()]; ok {
+		return ErrSerialExists
+	}
+	s.m[serial.String()] = nil
+	return nil
+}
+
+func (s *memStore) Release(serial *big.Int) error {
+	if serial == nil || serial.Sign() <= 0 {
+		return errInvalidSerial
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	if r, ok := s.m[serial.String()]; ok && r == nil {
+		delete(s.m, serial.String())
+	}
+	return nil
+}
+
+func (s *memStore) Put(r Record) error {
+	if r.Serial == nil || r.Serial.Sign() <= 0 {
+		return errInvalidSerial
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.m[r.Serial.String()] = &r
+	return nil
+}
+
+func (s *memStore) Get(serial *big.Int) (Record, error) {
+	if serial == nil {
+		return Record{}, errInvalidSerial
+	}
+	s.l.RLock()
+	defer s.l.RUnlock()
+	r := s.m[serial.String()]
+	if r == nil {
+		return Record{}, ErrNotFound
+	}
+	return *r, nil
+}
+
+func (s *memStore) List(f Filter) ([]Record, error) {
+	s.l.RLock()
+	outp := make([]Record, 0)
+	for _, v := range s.m {
+		if v != nil && f.Match(*v) {
+			outp = append(outp, *v)
+		}
+	}
+	s.l.RUnlock()
+	sort.Slice(outp, func(i, j int) bool {
+		return outp[i].IssuedAt.Before(outp[j].IssuedAt)
+	})
+	return limit(outp, f.Limit), nil
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
@@ -0,0 +1,240 @@
+// Package store keeps the inventory of the certificates issued by a ztls
+// server.
+package store
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a serial number has no record
+	ErrNotFound = errors.New("store: certificate not found")
+	// ErrSerialExists is returned when a serial number is already in use
+	ErrSerialExists  = errors.New("store: serial number already in use")
+	errInvalidSerial = errors.New("store: invalid serial number")
+)
+
+// Store persists the issued certificates
+type Store interface {
+	// Reserve claims a serial number before the certificate is signed. It
+	// returns ErrSerialExists if the serial number was already used.
+	Reserve(serial *big.Int) error
+	// Release removes the reservation of a serial number whose certificate
+	// was not issued. The record of an issued certificate is kept.
+	Release(serial *big.Int) error
+	// Put saves the record of an issued certificate
+	Put(r Record) error
+	// Get returns the record of a serial number (ErrNotFound if there is
+	// none)
+	Get(serial *big.Int) (Record, error)
+	// List returns the records matching the filter, sorted by IssuedAt
+	List(f Filter) ([]Record, error)
+	Close() error
+}
+
+// Record is an issued certificate
+type Record struct {
+	Serial         *big.Int
+	CommonName     string
+	DNSNames       []string
+	IPAddresses    []string
+	EmailAddresses []string
+	URIs           []string
+	// Requester is the address of the client that requested the certificate
+	Requester string
+	// APIKeyID is the id of the API key used in the request (empty if the
+	// request was anonymous)
+	APIKeyID string
+	// Profile is the certificate profile of the request (empty in the
+	// records of older versions)
+	Profile     string
+	IssuedAt    time.Time
+	NotBefore   time.Time
+	NotAfter    time.Time
+	Certificate []byte // PEM encoded
+	// RenewedFrom is the serial number of the certificate that this one
+	// renews (nil if it is not a renewal)
+	RenewedFrom *big.Int
+}
+
+// NewRecord creates a record from a certificate
+func NewRecord(cert *x509.Certificate) Record {
+	r := Record{
+		Serial:         cert.SerialNumber,
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IssuedAt:       time.Now(),
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		Certificate: pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		}),
+	}
+	for _, ip := range cert.IPAddresses {
+		r.IPAddresses = append(r.IPAddresses, ip.String())
+	}
+	for _, u := range cert.URIs {
+		r.URIs = append(r.URIs, u.String())
+	}
+	return r
+}
+
+type recordJSON struct {
+	Serial         string    `json:"serial"`
+	CommonName     string    `json:"common_name,omitempty"`
+	DNSNames       []string  `json:"dns_names,omitempty"`
+	IPAddresses    []string  `json:"ip_addresses,omitempty"`
+	EmailAddresses []string  `json:"email_addresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	Requester      string    `json:"requester,omitempty"`
+	APIKeyID       string    `json:"api_key_id,omitempty"`
+	Profile        string    `json:"profile,omitempty"`
+	IssuedAt       time.Time `json:"issued_at"`
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	Certificate    string    `json:"certificate,omitempty"`
+	RenewedFrom    string    `json:"renewed_from,omitempty"`
+}
+
+// MarshalJSON encodes the record with the serial number as a decimal string
+func (r Record) MarshalJSON() ([]byte, error) {
+	v := recordJSON{
+		CommonName:     r.CommonName,
+		DNSNames:       r.DNSNames,
+		IPAddresses:    r.IPAddresses,
+		EmailAddresses: r.EmailAddresses,
+		URIs:           r.URIs,
+		Requester:      r.Requester,
+		APIKeyID:       r.APIKeyID,
+		Profile:        r.Profile,
+		IssuedAt:       r.IssuedAt.UTC(),
+		NotBefore:      r.NotBefore.UTC(),
+		NotAfter:       r.NotAfter.UTC(),
+		Certificate:    string(r.Certificate),
+	}
+	if r.Serial != nil {
+		v.Serial = r.Serial.String()
+	}
+	if r.RenewedFrom != nil {
+		v.RenewedFrom = r.RenewedFrom.String()
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes a record encoded by MarshalJSON
+func (r *Record) UnmarshalJSON(b []byte) error {
+	var v recordJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	serial, ok := new(big.Int).SetString(v.Serial, 10)
+	if !ok {
+		return errInvalidSerial
+	}
+	*r = Record{
+		Serial:         serial,
+		CommonName:     v.CommonName,
+		DNSNames:       v.DNSNames,
+		IPAddresses:    v.IPAddresses,
+		EmailAddresses: v.EmailAddresses,
+		URIs:           v.URIs,
+		Requester:      v.Requester,
+		APIKeyID:       v.APIKeyID,
+		Profile:        v.Profile,
+		IssuedAt:       v.IssuedAt,
+		NotBefore:      v.NotBefore,
+		NotAfter:       v.NotAfter,
+	}
+	if v.Certificate != "" {
+		r.Certificate = []byte(v.Certificate)
+	}
+	if v.RenewedFrom != "" {
+		if r.RenewedFrom, ok = new(big.Int).SetString(v.RenewedFrom, 10); !ok {
+			return errInvalidSerial
+		}
+	}
+	return nil
+}
+
+// Filter selects records. Empty fields match everything.
+type Filter struct {
+	// Host matches the common name, a DNS name (wildcards included) or an
+	// IP address of the certificate
+	Host       string
+	CommonName string
+	APIKeyID   string
+	// IssuedAfter and IssuedBefore restrict the issuance time
+	IssuedAfter  time.Time
+	IssuedBefore time.Time
+	// ValidAt only selects the certificates valid at this time
+	ValidAt time.Time
+	// Limit is the maximum number of records (the most recent ones)
+	Limit int
+}
+
+// Match reports whether a record matches the filter
+func (f Filter) Match(r Record) bool {
+	if f.CommonName != "" && !strings.EqualFold(f.CommonName, r.CommonName) {
+		return false
+	}
+	if f.APIKeyID != "" && f.APIKeyID != r.APIKeyID {
+		return false
+	}
+	if !f.IssuedAfter.IsZero() && r.IssuedAt.Before(f.IssuedAfter) {
+		return false
+	}
+	if !f.IssuedBefore.IsZero() && !r.IssuedAt.Before(f.IssuedBefore) {
+		return false
+	}
+	if !f.ValidAt.IsZero() && (f.ValidAt.Before(r.NotBefore) || f.ValidAt.After(r.NotAfter)) {
+		return false
+	}
+	if f.Host != "" && !matchhost(f.Host, r) {
+		return false
+	}
+	return true
+}
+
+func matchhost(host string, r Record) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		for _, v := range r.IPAddresses {
+			if rip := net.ParseIP(v); rip != nil && rip.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if strings.EqualFold(host, r.CommonName) {
+		return true
+	}
+	for _, v := range r.DNSNames {
+		v = strings.ToLower(v)
+		if v == host {
+			return true
+		}
+		if strings.HasPrefix(v, "*.") {
+			if i := strings.IndexByte(host, '.'); i > 0 && host[i:] == v[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// limit keeps the last n records (n <= 0 keeps all)
+func limit(list []Record, n int) []Record {
+	if n <= 0 || len(list) <= n {
+		return list
+	}
+	return list[len(list)-n:]
+}
@@ -0,0 +1,155 @@
+package embedded
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TLSOptions configures the HTTPS listener (see ListenAndServeTLSAsync)
+type TLSOptions struct {
+	// [OPTIONAL] PEM encoded certificate (followed by its chain) and key.
+	// If empty, the server issues its own certificate (ProfileServer) and
+	// renews it after 2/3 of its lifetime.
+	Certificate []byte
+	Key         []byte
+	// [OPTIONAL] names and IPs of the self-issued certificate (default: the
+	// host of Config.PublicUrl or localhost)
+	Hosts []string
+	// [OPTIONAL] verification of the client certificates, which must be
+	// issued by the root of this server (default: tls.NoClientCert).
+	// tls.VerifyClientCertIfGiven lets the issued identities authenticate
+	// (e.g. renewals and EST) without preventing anonymous requests.
+	ClientAuth tls.ClientAuthType
+}
+
+// backoff of the failed renewals of the self-issued certificate
+const (
+	selfRetryInterval    = time.Second * 10
+	selfMaxRetryInterval = time.Minute * 10
+)
+
+// selfcert is the certificate the server issues to itself
+type selfcert struct {
+	cert atomic.Value // *tls.Certificate
+}
+
+func (sc *selfcert) current() *tls.Certificate {
+	return sc.cert.Load().(*tls.Certificate)
+}
+
+// TLSConfig returns the TLS config of the HTTPS listener
+func (s *Server) TLSConfig(opts TLSOptions) (*tls.Config, error) {
+	tlsc := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ClientAuth: opts.ClientAuth,
+	}
+	if opts.ClientAuth != tls.NoClientCert {
+		pool := x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(s.RootCA()); !ok {
+			return nil, errors.New("invalid CA")
+		}
+		tlsc.ClientCAs = pool
+	}
+	if len(opts.Certificate) > 0 || len(opts.Key) > 0 {
+		cert, err := tls.X509KeyPair(opts.Certificate, opts.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsc.Certificates = []tls.Certificate{cert}
+		return tlsc, nil
+	}
+	hosts := opts.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{"localhost"}
+		if u, err := url.Parse(s.cfg.GetPublicUrl()); err == nil && u.Hostname() != "" {
+			hosts = []string{u.Hostname()}
+		}
+	}
+	cert, err := s.issueself(hosts)
+	if err != nil {
+		return nil, err
+	}
+	sc := &selfcert{}
+	s.setself(sc, cert)
+	go s.renewself(sc, hosts)
+	tlsc.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return sc.current(), nil
+	}
+	return tlsc, nil
+}
+
+// renewself renews the self-issued certificate after 2/3 of its lifetime,
+// until the server context is done. A failed renewal is logged and retried
+// with a backoff; the current certificate is served meanwhile.
+func (s *Server) renewself(sc *selfcert, hosts []string) {
+	retry := time.Duration(0)
+	for {
+		leaf := sc.current().Leaf
+		wait := time.Until(leaf.NotBefore.Add(leaf.NotAfter.Sub(leaf.NotBefore) / 3 * 2))
+		if retry > 0 {
+			wait = retry
+		} else if wait < time.Second {
+			// a lifetime shorter than the backdate
+			wait = time.Second
+		}
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		cert, err := s.issueself(hosts)
+		if err != nil {
+			if retry *= 2; retry == 0 {
+				retry = selfRetryInterval
+			} else if retry > selfMaxRetryInterval {
+				retry = selfMaxRetryInterval
+			}
+			log.Error().Err(err).Dur("retry", retry).Time("not_after", leaf.NotAfter).Msg("TLS certificate renewal failed")
+			continue
+		}
+		retry = 0
+		s.setself(sc, cert)
+	}
+}
+
+func (s *Server) setself(sc *selfcert, cert *tls.Certificate) {
+	sc.cert.Store(cert)
+	log.Info().Str("serial", cert.Leaf.SerialNumber.String()).Time("not_after", cert.Leaf.NotAfter).Msg("issued the TLS certificate of the server")
+}
+
+// issueself issues a server certificate for hosts
+func (s *Server) issueself(hosts []string) (*tls.Certificate, error) {
+	csr := &CSRJson{
+		CommonName: hosts[0],
+	}
+	for _, h := range hosts {
+		if net.ParseIP(h) != nil {
+			csr.IPs = append(csr.IPs, h)
+		} else {
+			csr.Domains = append(csr.Domains, h)
+		}
+	}
+	keypem, err := s.NewKey()
+	if err != nil {
+		return nil, err
+	}
+	certpem, err := s.NewCertificateCSRWithProfile(csr, keypem, ProfileServer)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certpem, keypem)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
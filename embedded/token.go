@@ -0,0 +1,346 @@
+package embedded
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gabstv/ztls/internal/pkix"
+	"github.com/rs/zerolog/log"
+)
+
+// TokenAPIKeyID is the API key id of the requests authenticated by a
+// bootstrap token created by the operator (its policy applies)
+const TokenAPIKeyID = "token"
+
+// Token is a bootstrap token: it authorizes a limited number of certificate
+// requests for a fixed identity, e.g. to provision a new machine without the
+// API key
+type Token struct {
+	ID         string `json:"id"`
+	Hash       []byte `json:"hash"` // SHA-256 of the secret
+	CommonName string `json:"common_name"`
+	// Names are the alternative names (DNS names, IP addresses) the
+	// certificates can have, in addition to the common name
+	Names    []string `json:"names,omitempty"`
+	Profile  string   `json:"profile,omitempty"` // empty: the default profile
+	UsesLeft int      `json:"uses_left"`
+	// APIKeyID is the API key that created the token (empty if it was
+	// created by the operator); its policy applies to the requests
+	APIKeyID  string    `json:"api_key_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TokenList persists the bootstrap tokens
+type TokenList interface {
+	// Add adds (or replaces) a token
+	Add(t Token) error
+	// Get returns a token (ok is false if it doesn't exist)
+	Get(id string) (t Token, ok bool, err error)
+	// Use consumes a use of a token, failing if there is none left
+	Use(id string) error
+	// Release gives back a use consumed by a failed request
+	Release(id string) error
+	// Delete removes a token
+	Delete(id string) error
+	// List returns all tokens sorted by CreatedAt
+	List() ([]Token, error)
+}
+
+// TokenRequest is the input of Server.NewToken
+type TokenRequest struct {
+	CommonName string        // [REQUIRED]
+	Names      []string      // [OPTIONAL] additional DNS names and IP addresses
+	Profile    string        // [OPTIONAL] certificate profile (default: the default profile)
+	Uses       int           // [OPTIONAL] number of certificate requests (default: 1)
+	TTL        time.Duration // [OPTIONAL] lifetime of the token (default: DefaultTokenTTL)
+	APIKeyID   string        // [OPTIONAL] API key creating the token
+}
+
+// DefaultTokenTTL is the default lifetime of the bootstrap tokens
+const DefaultTokenTTL = time.Hour
+
+// NewToken creates a bootstrap token, returning the token and the value
+// presented by the client (the secret is not stored)
+func (s *Server) NewToken(req TokenRequest) (Token, string, error) {
+	if req.CommonName == "" {
+		return Token{}, "", errTokenIdentity
+	}
+	if req.Profile != "" {
+		if _, err := s.Profile(req.Profile); err != nil {
+			return Token{}, "", err
+		}
+	}
+	if req.Uses <= 0 {
+		req.Uses = 1
+	}
+	if req.TTL <= 0 {
+		req.TTL = DefaultTokenTTL
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Token{}, "", err
+	}
+	secret, err := NewAPIKeySecret()
+	if err != nil {
+		return Token{}, "", err
+	}
+	now := time.Now()
+	t := Token{
+		ID:         hex.EncodeToString(id),
+		Hash:       HashAPIKeySecret(secret),
+		CommonName: req.CommonName,
+		Names:      req.Names,
+		Profile:    req.Profile,
+		UsesLeft:   req.Uses,
+		APIKeyID:   req.APIKeyID,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(req.TTL),
+	}
+	if err := s.Tokens.Add(t); err != nil {
+		return Token{}, "", err
+	}
+	return t, t.ID + "." + secret, nil
+}
+
+// IssueWithToken signs a CSR authorized by a bootstrap token, consuming a
+// use of the token. The CSR must have the identity of the token.
+func (s *Server) IssueWithToken(value string, req IssueRequest) ([]byte, error) {
+	csr, err := parsecsr(req.CSR)
+	if err != nil {
+		return nil, err
+	}
+	id, secret := value, ""
+	if i := strings.IndexByte(value, '.'); i > 0 {
+		id, secret = value[:i], value[i+1:]
+	}
+	t, ok, err := s.Tokens.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || subtle.ConstantTimeCompare(HashAPIKeySecret(secret), t.Hash) != 1 {
+		return nil, errTokenInvalid
+	}
+	if time.Now().After(t.ExpiresAt) {
+		return nil, errTokenExpired
+	}
+	if t.APIKeyID != "" {
+		// the API key that created the token must still be valid
+		k := s.cfg.APIKeyByID(t.APIKeyID)
+		if k == nil || k.check() != nil {
+			return nil, errTokenInvalid
+		}
+	}
+	if !t.allows(csr) {
+		return nil, errTokenIdentity
+	}
+	// the use is consumed first, so concurrent requests can't exceed the
+	// uses of the token, and given back if the issuance fails
+	if err := s.Tokens.Use(t.ID); err != nil {
+		return nil, err
+	}
+	req.APIKeyID = t.APIKeyID
+	if req.APIKeyID == "" {
+		req.APIKeyID = TokenAPIKeyID
+	}
+	req.Profile = t.Profile
+	req.Trusted = false
+	cert, err := s.Issue(req)
+	if err != nil {
+		if rerr := s.Tokens.Release(t.ID); rerr != nil {
+			log.Error().Err(rerr).Str("token", t.ID).Msg("release token use")
+		}
+		return nil, err
+	}
+	return cert, nil
+}
+
+// allows reports whether the CSR has the common name of the token and only
+// its names
+func (t Token) allows(csr *x509.CertificateRequest) bool {
+	if !strings.EqualFold(csr.Subject.CommonName, t.CommonName) {
+		return false
+	}
+	allowed := append([]string{t.CommonName}, t.Names...)
+	names := append([]string{}, csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return false
+	}
+	for _, v := range names {
+		if !pkix.ContainsFold(allowed, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewMemTokenList creates an in-memory (not persisted) TokenList
+func NewMemTokenList() TokenList {
+	return &memTokenList{
+		m: make(map[string]Token),
+	}
+}
+
+type memTokenList struct {
+	l sync.RWMutex
+	m map[string]Token
+}
+
+func (tl *memTokenList) Add(t Token) error {
+	tl.l.Lock()
+	defer tl.l.Unlock()
+	tl.m[t.ID] = t
+	return nil
+}
+
+func (tl *memTokenList) Get(id string) (Token, bool, error) {
+	tl.l.RLock()
+	defer tl.l.RUnlock()
+	t, ok := tl.m[id]
+	return t, ok, nil
+}
+
+func (tl *memTokenList) Use(id string) error {
+	tl.l.Lock()
+	defer tl.l.Unlock()
+	t, ok := tl.m[id]
+	if !ok {
+		return errTokenInvalid
+	}
+	if t.UsesLeft <= 0 {
+		return errTokenUsed
+	}
+	t.UsesLeft--
+	tl.m[id] = t
+	return nil
+}
+
+func (tl *memTokenList) Release(id string) error {
+	tl.l.Lock()
+	defer tl.l.Unlock()
+	t, ok := tl.m[id]
+	if !ok {
+		// deleted meanwhile
+		return nil
+	}
+	t.UsesLeft++
+	tl.m[id] = t
+	return nil
+}
+
+func (tl *memTokenList) Delete(id string) error {
+	tl.l.Lock()
+	defer tl.l.Unlock()
+	delete(tl.m, id)
+	return nil
+}
+
+func (tl *memTokenList) List() ([]Token, error) {
+	tl.l.RLock()
+	defer tl.l.RUnlock()
+	outp := make([]Token, 0, len(tl.m))
+	for _, v := range tl.m {
+		outp = append(outp, v)
+	}
+	sort.Slice(outp, func(i, j int) bool {
+		return outp[i].CreatedAt.Before(outp[j].CreatedAt)
+	})
+	return outp, nil
+}
+
+// OpenFileTokenList opens (or creates) a TokenList persisted as a JSON file.
+// Changes made to the file by other processes (e.g. the `ztls token`
+// command) are picked up automatically.
+func OpenFileTokenList(path string) (TokenList, error) {
+	tl := &fileTokenList{
+		mem: NewMemTokenList().(*memTokenList),
+	}
+	f, err := openjsonfile(path, tl.decode, tl.encode)
+	if err != nil {
+		return nil, err
+	}
+	tl.f = f
+	return tl, nil
+}
+
+type fileTokenList struct {
+	f   *jsonfile
+	mem *memTokenList
+}
+
+func (tl *fileTokenList) decode(raw []byte) error {
+	var items []Token
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return err
+	}
+	m := make(map[string]Token, len(items))
+	for _, v := range items {
+		m[v.ID] = v
+	}
+	tl.mem.l.Lock()
+	tl.mem.m = m
+	tl.mem.l.Unlock()
+	return nil
+}
+
+// encode removes the expired tokens (used up tokens are kept until they
+// expire, so a use can be given back)
+func (tl *fileTokenList) encode() interface{} {
+	all, _ := tl.mem.List()
+	items := make([]Token, 0, len(all))
+	now := time.Now()
+	for _, v := range all {
+		if now.Before(v.ExpiresAt) {
+			items = append(items, v)
+		}
+	}
+	return items
+}
+
+func (tl *fileTokenList) Add(t Token) error {
+	return tl.f.update(func() error {
+		return tl.mem.Add(t)
+	})
+}
+
+func (tl *fileTokenList) Get(id string) (Token, bool, error) {
+	if err := tl.f.read(); err != nil {
+		return Token{}, false, err
+	}
+	return tl.mem.Get(id)
+}
+
+func (tl *fileTokenList) Use(id string) error {
+	return tl.f.update(func() error {
+		return tl.mem.Use(id)
+	})
+}
+
+func (tl *fileTokenList) Release(id string) error {
+	return tl.f.update(func() error {
+		return tl.mem.Release(id)
+	})
+}
+
+func (tl *fileTokenList) Delete(id string) error {
+	return tl.f.update(func() error {
+		return tl.mem.Delete(id)
+	})
+}
+
+func (tl *fileTokenList) List() ([]Token, error) {
+	if err := tl.f.read(); err != nil {
+		return nil, err
+	}
+	return tl.mem.List()
+}
@@ -1,5 +1,22 @@
 package embedded
 
+import "github.com/gabstv/ztls/internal/pkix"
+
+// KeyType is the algorithm (and curve) of a private key
+type KeyType = pkix.KeyType
+
+const (
+	KeyRSA       = pkix.KeyRSA
+	KeyECDSAP256 = pkix.KeyECDSAP256
+	KeyECDSAP384 = pkix.KeyECDSAP384
+	KeyEd25519   = pkix.KeyEd25519
+)
+
+// ParseKeyType parses a key type name (rsa, ecdsa-p256, ecdsa-p384 or ed25519)
+func ParseKeyType(name string) (KeyType, error) {
+	return pkix.ParseKeyType(name)
+}
+
 type CSRReader interface {
 	GetCommonName() string
 	GetCountry() []string
@@ -42,6 +59,7 @@ type CSRJson struct {
 	PostalCode         []string `json:"postal_code"`         // [OPTIONAL]
 	IPs                []string `json:"ips"`                 // [OPTIONAL] Additional IPs
 	Domains            []string `json:"domains"`             // [OPTIONAL] Additional Domains
+	URIs               []string `json:"uris,omitempty"`      // [OPTIONAL] URI SANs, e.g. a SPIFFE ID (see Server.SPIFFEID)
 }
 
 func (j CSRJson) GetCommonName() string           { return j.CommonName }
@@ -54,6 +72,7 @@ func (j CSRJson) GetStreetAddress() []string      { return j.StreetAddress }
 func (j CSRJson) GetPostalCode() []string         { return j.PostalCode }
 func (j CSRJson) GetIPs() []string                { return j.IPs }
 func (j CSRJson) GetDomains() []string            { return j.Domains }
+func (j CSRJson) GetURIs() []string               { return j.URIs }
 
 func (j *CSRJson) SetCommonName(v string) CSRWriter {
 	j.CommonName = v
@@ -95,3 +114,7 @@ func (j *CSRJson) SetDomains(v []string) CSRWriter {
 	j.Domains = v
 	return j
 }
+func (j *CSRJson) SetURIs(v []string) CSRWriter {
+	j.URIs = v
+	return j
+}
@@ -1,15 +1,19 @@
 module github.com/gabstv/ztls
 
-go 1.13
+go 1.15
 
 require (
 	github.com/ReneKroon/ttlcache v1.6.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
+	github.com/envoyproxy/go-control-plane v0.9.5
 	github.com/golang/protobuf v1.3.2
 	github.com/google/uuid v1.1.1
 	github.com/labstack/echo v3.3.10+incompatible
 	github.com/labstack/echo/v4 v4.1.15
 	github.com/rs/zerolog v1.16.0
 	github.com/urfave/cli v1.22.1
-	google.golang.org/grpc v1.24.0
+	go.etcd.io/bbolt v1.3.5
+	go.mozilla.org/pkcs7 v0.0.0-20200128120323-432b2356ecb1
+	golang.org/x/crypto v0.0.0-20200221231518-2aa609cf4a9d
+	google.golang.org/grpc v1.25.1
 )
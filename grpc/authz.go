@@ -0,0 +1,289 @@
+package grpc
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+
+	"github.com/gabstv/ztls/internal/pkix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Identity is the identity of a gRPC peer, from its verified client
+// certificate
+type Identity struct {
+	CommonName  string
+	DNSNames    []string
+	IPAddresses []string
+	URIs        []string // e.g. SPIFFE IDs
+	Certificate *x509.Certificate
+}
+
+// Names returns the common name and all the SANs of the identity
+func (id *Identity) Names() []string {
+	names := make([]string, 0, 1+len(id.DNSNames)+len(id.IPAddresses)+len(id.URIs))
+	if id.CommonName != "" {
+		names = append(names, id.CommonName)
+	}
+	names = append(names, id.DNSNames...)
+	names = append(names, id.IPAddresses...)
+	return append(names, id.URIs...)
+}
+
+// SPIFFEID returns the SPIFFE ID of the identity (its spiffe:// URI SAN), or
+// an empty string
+func (id *Identity) SPIFFEID() string {
+	for _, v := range id.URIs {
+		if u, err := pkix.ParseSPIFFEID(v); err == nil {
+			return u.String()
+		}
+	}
+	return ""
+}
+
+// TrustDomain returns the SPIFFE trust domain of the identity, or an empty
+// string
+func (id *Identity) TrustDomain() string {
+	if u, err := pkix.ParseSPIFFEID(id.SPIFFEID()); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+// SPIFFEIDFromContext returns the SPIFFE ID of the peer set by the
+// interceptors of this package
+func SPIFFEIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := IdentityFromContext(ctx)
+	if !ok || id.SPIFFEID() == "" {
+		return "", false
+	}
+	return id.SPIFFEID(), true
+}
+
+// AllowSPIFFEIDs returns a rule allowing the peers with the given SPIFFE IDs
+// ("spiffe://example.org/svc", or "spiffe://example.org/ns/prod/*" for the
+// IDs under a path) to call the methods matching a pattern
+func AllowSPIFFEIDs(method string, ids ...string) AuthzRule {
+	return AuthzRule{
+		Method: method,
+		Allow:  ids,
+		spiffe: true,
+	}
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the peer identity set by the interceptors of
+// this package (UnaryServerInterceptor, StreamServerInterceptor)
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// PeerIdentity extracts the identity of the peer of a server call from its
+// TLS client certificate. The certificate must have been verified by the
+// transport credentials (ServerCredentials, or a tls.Config with
+// VerifyClientCertIfGiven or RequireAndVerifyClientCert); an unverified
+// certificate (e.g. with RequireAnyClientCert) is not an identity.
+func PeerIdentity(ctx context.Context) (*Identity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, false
+	}
+	// only a verified certificate is an identity
+	chains := info.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil, false
+	}
+	cert := chains[0][0]
+	id := &Identity{
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Certificate: cert,
+	}
+	for _, ip := range cert.IPAddresses {
+		id.IPAddresses = append(id.IPAddresses, ip.String())
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	return id, true
+}
+
+// AuthzRule allows a set of identities to call the methods matching a pattern
+type AuthzRule struct {
+	// Method is a full method name ("/package.Service/Method"), or a prefix
+	// followed by "*" (e.g. "/package.Service/*"; "*" matches all methods)
+	Method string
+	// Allow are the allowed identities: names matched against the common
+	// name and the DNS SANs of the peer (exact, "*.example.com" for any
+	// subdomain, or a prefix followed by "*"), IP addresses, URIs matched
+	// against the URI SANs (e.g. "spiffe://example.org/ns/prod/*"), or "*"
+	// (any verified peer)
+	Allow []string
+
+	spiffe bool // Allow matches only the SPIFFE ID
+}
+
+// AuthzPolicy authorizes the calls of a gRPC server by the identity of the
+// peer. The first rule matching the method applies; the calls of the
+// methods without a rule are denied, unless DefaultAllow is set.
+type AuthzPolicy struct {
+	Rules        []AuthzRule
+	DefaultAllow bool
+}
+
+// Authorize returns a PermissionDenied error if the identity can't call the
+// method
+func (p *AuthzPolicy) Authorize(method string, id *Identity) error {
+	for _, r := range p.Rules {
+		if !matchprefix(r.Method, method) {
+			continue
+		}
+		if id != nil {
+			for _, pattern := range r.Allow {
+				if r.spiffe && pkix.MatchSPIFFEID(pattern, id.SPIFFEID()) {
+					return nil
+				}
+				if !r.spiffe && (pattern == "*" || matchidentity(pattern, id)) {
+					return nil
+				}
+			}
+		}
+		return status.Errorf(codes.PermissionDenied, "%v is not allowed to call %v", id.describe(), method)
+	}
+	if p.DefaultAllow {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "%v is not allowed to call %v", id.describe(), method)
+}
+
+// authorize extracts the peer identity into the context and applies the
+// policy (if any)
+func authorize(ctx context.Context, p *AuthzPolicy, method string) (context.Context, error) {
+	id, ok := PeerIdentity(ctx)
+	if ok {
+		ctx = context.WithValue(ctx, identityKey{}, id)
+	}
+	if p == nil {
+		return ctx, nil
+	}
+	if !ok && !p.DefaultAllow {
+		return nil, status.Error(codes.Unauthenticated, "no client certificate")
+	}
+	if err := p.Authorize(method, id); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// UnaryServerInterceptor returns an interceptor that sets the peer identity
+// in the context of the calls (see IdentityFromContext) and rejects the calls
+// not allowed by p (p can be nil to only extract the identity)
+func UnaryServerInterceptor(p *AuthzPolicy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authorize(ctx, p, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the stream version of UnaryServerInterceptor
+func StreamServerInterceptor(p *AuthzPolicy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(ss.Context(), p, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityStream{ss, ctx})
+	}
+}
+
+// identityStream is a grpc.ServerStream with the identity in its context
+type identityStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+func (id *Identity) describe() string {
+	if id == nil {
+		return "anonymous peer"
+	}
+	if id.CommonName != "" {
+		return id.CommonName
+	}
+	if names := id.Names(); len(names) > 0 {
+		return names[0]
+	}
+	return "peer"
+}
+
+// matchidentity reports whether the identity matches a pattern. URI
+// patterns (e.g. SPIFFE IDs) match the URI SANs; the other patterns match the
+// common name and the DNS names, or an IP address exactly.
+func matchidentity(pattern string, id *Identity) bool {
+	if strings.Contains(pattern, "://") {
+		for _, uri := range id.URIs {
+			if strings.HasPrefix(pattern, pkix.SPIFFEScheme+"://") {
+				if pkix.MatchSPIFFEID(pattern, uri) {
+					return true
+				}
+			} else if matchprefix(pattern, uri) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, ip := range id.IPAddresses {
+		if pattern == ip {
+			return true
+		}
+	}
+	names := id.DNSNames
+	if id.CommonName != "" {
+		names = append([]string{id.CommonName}, names...)
+	}
+	for _, name := range names {
+		if strings.HasPrefix(pattern, "*.") {
+			suffix := strings.ToLower(pattern[1:])
+			name = strings.ToLower(name)
+			if len(name) > len(suffix) && strings.HasSuffix(name, suffix) {
+				return true
+			}
+			continue
+		}
+		if strings.HasSuffix(pattern, "*") {
+			if matchprefix(pattern, name) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchprefix matches a name against a pattern that is exact or a prefix
+// followed by "*"
+func matchprefix(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(name, pattern[:len(pattern)-1])
+	}
+	return pattern == name
+}
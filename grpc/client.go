@@ -2,14 +2,10 @@ package grpc
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
-	"errors"
 
 	"github.com/gabstv/ztls/api/ztls"
 	"github.com/gabstv/ztls/embedded"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
 )
 
 type DialInput struct {
@@ -17,6 +13,10 @@ type DialInput struct {
 	ServerAddress string
 	ClientName    string
 	Options       []grpc.DialOption
+	// [OPTIONAL] renews the client certificate, e.g. to set its OnRenew hook
+	// or read its Stats. Its CSR and Profile default to ClientName and the
+	// client profile (the default profile of the REST server), and its Issuer to the embedded or REST server.
+	CertManager *ztls.CertManager
 }
 
 type DialWithConfigPEMInput struct {
@@ -40,25 +40,22 @@ func DialWithConfigPEM(ctx context.Context, input DialWithConfigPEMInput) (*grpc
 	})
 }
 
+// DialWithEmbedded dials with a client certificate issued by the embedded
+// server, renewed in the background until the server context is done
 func DialWithEmbedded(input DialWithEmbeddedInput) (*grpc.ClientConn, error) {
 	svname := input.ServerName
 	if svname == "" {
 		svname = input.ServerAddress
 	}
-	clname := input.ClientName
-	if clname == "" {
-		clname = "grpc-client"
+	m := input.manager(ztls.ProfileClient)
+	if m.Issuer == nil && m.Client == nil {
+		m.Issuer = EmbeddedIssuer{input.Server}
 	}
-	//
-	cfg, _, _, err := input.Server.NewClientAuto(svname, &embedded.CSRJson{
-		CommonName: clname,
-	})
-	if err != nil {
+	if err := m.Start(input.Server.Context()); err != nil {
 		return nil, err
 	}
-	opt0 := grpc.WithTransportCredentials(credentials.NewTLS(cfg))
 	optx := make([]grpc.DialOption, 1)
-	optx[0] = opt0
+	optx[0] = grpc.WithTransportCredentials(ClientCredentials(m, svname))
 	if input.Options != nil {
 		optx = append(optx, input.Options...)
 	}
@@ -69,64 +66,62 @@ type DialWithRestServerInput struct {
 	DialInput
 	RestAPIKey   string
 	RestEndpoint string
-	KeyPEM       []byte
+	KeyPEM       []byte // [OPTIONAL] key of all the certificates (default: a new key per renewal)
 }
 
+// DialWithRestServer dials with a client certificate issued by the REST
+// server, renewed in the background until ctx is done
 func DialWithRestServer(ctx context.Context, input DialWithRestServerInput) (*grpc.ClientConn, error) {
 	cl := &ztls.Client{
 		APIKey:   input.RestAPIKey,
 		Endpoint: input.RestEndpoint,
 	}
-	key := input.KeyPEM
-	if key == nil {
-		var err error
-		key, err = cl.NewKey()
-		if err != nil {
-			return nil, err
-		}
-	}
-	//
-	ca, err := cl.GetCA(ctx)
-	if err != nil {
-		return nil, err
-	}
-	//
 	svname := input.ServerName
 	if svname == "" {
 		svname = input.ServerAddress
 	}
-	clname := input.ClientName
-	if clname == "" {
-		clname = "grpc-client"
+	m := input.manager("")
+	if m.Issuer == nil && m.Client == nil {
+		m.Client = cl
+		if input.KeyPEM != nil {
+			m.Issuer = fixedKeyIssuer{cl, input.KeyPEM}
+		}
 	}
-	//
-	csr, err := cl.NewCSR(ztls.CommonName(clname), key)
-	if err != nil {
+	if err := m.Start(ctx); err != nil {
 		return nil, err
 	}
-	cert, err := cl.NewCertificate(ctx, csr)
-	if err != nil {
-		return nil, err
-	}
-	//
-	tlscert, err := tls.X509KeyPair(cert, key)
-	if err != nil {
-		return nil, err
-	}
-	certPool := x509.NewCertPool()
-	if ok := certPool.AppendCertsFromPEM(ca); !ok {
-		return nil, errors.New("invalid CA")
-	}
-
-	tlsc := &tls.Config{
-		ServerName:   svname,
-		Certificates: []tls.Certificate{tlscert},
-		RootCAs:      certPool,
-	}
 	optx := make([]grpc.DialOption, 1)
-	optx[0] = grpc.WithTransportCredentials(credentials.NewTLS(tlsc))
+	optx[0] = grpc.WithTransportCredentials(ClientCredentials(m, svname))
 	if input.Options != nil {
 		optx = append(optx, input.Options...)
 	}
 	return grpc.Dial(input.ServerAddress, optx...)
 }
+
+// manager returns the CertManager of the input, filling its identity
+func (input DialInput) manager(profile string) *ztls.CertManager {
+	m := input.CertManager
+	if m == nil {
+		m = &ztls.CertManager{}
+	}
+	if m.CSR.CommonName == "" {
+		m.CSR.CommonName = input.ClientName
+		if m.CSR.CommonName == "" {
+			m.CSR.CommonName = "grpc-client"
+		}
+	}
+	if m.Profile == "" {
+		m.Profile = profile
+	}
+	return m
+}
+
+// fixedKeyIssuer is a REST client issuing all certificates with the same key
+type fixedKeyIssuer struct {
+	*ztls.Client
+	key []byte
+}
+
+func (is fixedKeyIssuer) NewKey() ([]byte, error) {
+	return is.key, nil
+}
@@ -0,0 +1,63 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+
+	"github.com/gabstv/ztls/api/ztls"
+	"github.com/gabstv/ztls/embedded"
+	"google.golang.org/grpc/credentials"
+)
+
+// ServerCredentials returns server TransportCredentials presenting the
+// certificate of m and verifying the client certificates (if required by
+// authType) against its CA pool. The certificate and the pool are replaced at
+// every renewal of m, without restarting the grpc.Server.
+func ServerCredentials(m *ztls.CertManager, authType tls.ClientAuthType) credentials.TransportCredentials {
+	c := m.ServerTLSConfig(authType)
+	// the handshakes clone c (see ServerTLSConfig), not the copy of
+	// credentials.NewTLS that negotiates h2
+	c.NextProtos = []string{"h2"}
+	return credentials.NewTLS(c)
+}
+
+// ClientCredentials returns client TransportCredentials presenting the
+// certificate of m and verifying serverName against its CA pool. The
+// certificate and the pool are replaced at every renewal of m, without
+// re-dialing.
+func ClientCredentials(m *ztls.CertManager, serverName string) credentials.TransportCredentials {
+	return credentials.NewTLS(m.ClientTLSConfig(serverName))
+}
+
+// EmbeddedIssuer issues the certificates of a ztls.CertManager in-process
+// with an embedded server (the issuance policy doesn't apply)
+type EmbeddedIssuer struct {
+	Server *embedded.Server
+}
+
+// NewKey creates a key of the type of the server config
+func (is EmbeddedIssuer) NewKey() ([]byte, error) {
+	return is.Server.NewKey()
+}
+
+// IssueCertificate signs the CSR (the Certificate field holds the whole chain)
+func (is EmbeddedIssuer) IssueCertificate(ctx context.Context, input ztls.NewCertificateRequest) (*ztls.Certificate, error) {
+	cert, err := is.Server.Issue(embedded.IssueRequest{
+		CSR:      input.CSR,
+		Profile:  input.Profile,
+		TTL:      input.TTL,
+		NotAfter: input.NotAfter,
+		Trusted:  true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ztls.Certificate{
+		Certificate: string(cert),
+	}, nil
+}
+
+// GetCA returns the root certificate of the server
+func (is EmbeddedIssuer) GetCA(ctx context.Context) ([]byte, error) {
+	return is.Server.RootCA(), nil
+}
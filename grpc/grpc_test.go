@@ -0,0 +1,285 @@
+package grpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gabstv/ztls/api/ztls"
+	"github.com/gabstv/ztls/embedded"
+	ipkix "github.com/gabstv/ztls/internal/pkix"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// newTestServer creates an embedded server with an ECDSA root CA
+func newTestServer(t *testing.T, ctx context.Context) *embedded.Server {
+	t.Helper()
+	rootkey, err := ipkix.NewKeyWithType(ipkix.KeyECDSAP256, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootcert, err := ipkix.NewCACertificateWithInput(ipkix.NewCACertificateInput{
+		Key: rootkey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return embedded.New(ctx, &embedded.Config{
+		Rootkey:  rootkey,
+		Rootcert: rootcert,
+		KeyType:  string(ipkix.KeyECDSAP256),
+	})
+}
+
+func TestRotatingCredentials(t *testing.T) {
+	ctx, cf := context.WithCancel(context.Background())
+	defer cf()
+	esv := newTestServer(t, ctx)
+	var events []ztls.RenewEvent
+	sm := &ztls.CertManager{
+		OnRenew: func(ev ztls.RenewEvent) {
+			events = append(events, ev)
+		},
+	}
+	gs, err := NewServerWithEmbedded(NewServerWithEmbeddedInput{
+		NewServerInput: NewServerInput{
+			CommonName:  "localhost",
+			Domains:     []string{"localhost"},
+			CertManager: sm,
+		},
+		Server: esv,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthpb.RegisterHealthServer(gs, health.NewServer())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gs.Serve(l)
+	defer gs.Stop()
+
+	cm := &ztls.CertManager{}
+	conn, err := DialWithEmbedded(DialWithEmbeddedInput{
+		DialInput: DialInput{
+			ServerName:    "localhost",
+			ServerAddress: l.Addr().String(),
+			CertManager:   cm,
+		},
+		Server: esv,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hc := healthpb.NewHealthClient(conn)
+	if _, err := hc.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// rotate both certificates without restarting the server
+	first := sm.Certificate().Leaf
+	if err := sm.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Err != nil || events[1].Certificate.SerialNumber.Cmp(first.SerialNumber) == 0 {
+		t.Fatal("expected 2 rotation events", events)
+	}
+	if st := sm.Stats(); st.Renewals != 2 || st.Failures != 0 || st.NotAfter.IsZero() {
+		t.Fatal("unexpected stats", st)
+	}
+	if _, err := hc.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	// new connections present the renewed certificates
+	tlsc := cm.ClientTLSConfig("localhost")
+	tlsc.NextProtos = []string{"h2"}
+	tc, err := tls.Dial("tcp", l.Addr().String(), tlsc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close()
+	cs := tc.ConnectionState()
+	if cs.PeerCertificates[0].SerialNumber.Cmp(sm.Certificate().Leaf.SerialNumber) != 0 {
+		t.Fatal("expected the renewed server certificate")
+	}
+	if cs.NegotiatedProtocol != "h2" {
+		t.Fatal("expected h2, got", cs.NegotiatedProtocol)
+	}
+}
+
+func TestAuthz(t *testing.T) {
+	ctx, cf := context.WithCancel(context.Background())
+	defer cf()
+	esv := newTestServer(t, ctx)
+	policy := &AuthzPolicy{
+		Rules: []AuthzRule{
+			{Method: "/grpc.health.v1.Health/Check", Allow: []string{"svc-a", "*.ops.internal"}},
+			{Method: "/grpc.health.v1.Health/*", Allow: []string{"*"}},
+		},
+	}
+	gs, err := NewServerWithEmbedded(NewServerWithEmbeddedInput{
+		NewServerInput: NewServerInput{
+			CommonName: "localhost",
+			Domains:    []string{"localhost"},
+			Options: []grpc.ServerOption{
+				grpc.UnaryInterceptor(UnaryServerInterceptor(policy)),
+				grpc.StreamInterceptor(StreamServerInterceptor(policy)),
+			},
+		},
+		Server: esv,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthpb.RegisterHealthServer(gs, health.NewServer())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gs.Serve(l)
+	defer gs.Stop()
+
+	check := func(name string) codes.Code {
+		conn, err := DialWithEmbedded(DialWithEmbeddedInput{
+			DialInput: DialInput{
+				ServerName:    "localhost",
+				ServerAddress: l.Addr().String(),
+				ClientName:    name,
+			},
+			Server: esv,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		return status.Code(err)
+	}
+	if c := check("svc-a"); c != codes.OK {
+		t.Fatal("expected svc-a to be allowed", c)
+	}
+	if c := check("db.ops.internal"); c != codes.OK {
+		t.Fatal("expected db.ops.internal to be allowed", c)
+	}
+	if c := check("svc-b"); c != codes.PermissionDenied {
+		t.Fatal("expected svc-b to be denied", c)
+	}
+
+	// methods without a rule are denied
+	id := &Identity{CommonName: "svc-a", URIs: []string{"spiffe://example.org/ns/prod/sa/a"}}
+	if status.Code(policy.Authorize("/other.Service/Call", id)) != codes.PermissionDenied {
+		t.Fatal("expected the method to be denied")
+	}
+	policy.Rules = append(policy.Rules, AuthzRule{Method: "*", Allow: []string{"spiffe://example.org/ns/prod/*"}})
+	if err := policy.Authorize("/other.Service/Call", id); err != nil {
+		t.Fatal(err)
+	}
+
+	// the names don't match the URI SANs, nor the URIs the names
+	policy.Rules = []AuthzRule{{Method: "*", Allow: []string{"*.example.com", "svc-*"}}}
+	for _, uri := range []string{"spiffe://example.org/x.example.com", "svc-a://x"} {
+		if status.Code(policy.Authorize("/pkg.Service/Call", &Identity{URIs: []string{uri}})) != codes.PermissionDenied {
+			t.Fatal("expected the URI SAN not to match:", uri)
+		}
+	}
+	if err := policy.Authorize("/pkg.Service/Call", &Identity{DNSNames: []string{"x.example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	policy.Rules = []AuthzRule{{Method: "*", Allow: []string{"spiffe://example.org/*"}}}
+	if status.Code(policy.Authorize("/pkg.Service/Call", &Identity{CommonName: "spiffe://example.org/a"})) != codes.PermissionDenied {
+		t.Fatal("expected the common name not to match the URI pattern")
+	}
+
+	// SPIFFE IDs
+	if id.SPIFFEID() != "spiffe://example.org/ns/prod/sa/a" || id.TrustDomain() != "example.org" {
+		t.Fatal("unexpected SPIFFE ID", id.SPIFFEID())
+	}
+	sp := &AuthzPolicy{
+		Rules: []AuthzRule{
+			AllowSPIFFEIDs("/pkg.Service/*", "spiffe://example.org/ns/prod/*"),
+		},
+	}
+	if err := sp.Authorize("/pkg.Service/Call", id); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Authorize("/pkg.Service/Call", &Identity{CommonName: "spiffe://example.org/ns/prod/sa/a"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatal("expected the identity without a SPIFFE ID to be denied")
+	}
+}
+
+func TestAuthzUnverifiedPeer(t *testing.T) {
+	ctx, cf := context.WithCancel(context.Background())
+	defer cf()
+	esv := newTestServer(t, ctx)
+	sm := &ztls.CertManager{
+		Issuer:  EmbeddedIssuer{esv},
+		CSR:     ztls.NewCSRInput{CommonName: "localhost", Domains: []string{"localhost"}},
+		Profile: ztls.ProfileServer,
+	}
+	if err := sm.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the transport accepts any client certificate without verifying it
+	policy := &AuthzPolicy{
+		Rules: []AuthzRule{{Method: "*", Allow: []string{"svc-a"}}},
+	}
+	gs := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(sm.ServerTLSConfig(tls.RequireAnyClientCert))),
+		grpc.UnaryInterceptor(UnaryServerInterceptor(policy)),
+	)
+	healthpb.RegisterHealthServer(gs, health.NewServer())
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gs.Serve(l)
+	defer gs.Stop()
+
+	// a self-signed certificate with an allowed name
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "svc-a"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+		ServerName:   "localhost",
+		RootCAs:      sm.CAPool(),
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if c := status.Code(err); c != codes.Unauthenticated {
+		t.Fatal("expected the self-signed peer to be rejected, got", c, err)
+	}
+}
@@ -2,10 +2,11 @@ package grpc
 
 import (
 	"context"
+	"crypto/tls"
 
+	"github.com/gabstv/ztls/api/ztls"
 	"github.com/gabstv/ztls/embedded"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
 )
 
 type NewServerInput struct {
@@ -13,6 +14,10 @@ type NewServerInput struct {
 	Domains    []string
 	IPs        []string
 	Options    []grpc.ServerOption
+	// [OPTIONAL] renews the server certificate, e.g. to set its OnRenew hook
+	// or read its Stats. Its CSR and Profile default to the names above and
+	// the server profile, and its Issuer to the embedded server.
+	CertManager *ztls.CertManager
 }
 
 type NewServerWithConfigPEMInput struct {
@@ -36,18 +41,33 @@ type NewServerWithEmbeddedInput struct {
 	Server *embedded.Server
 }
 
+// NewServerWithEmbedded creates a gRPC server requiring client certificates,
+// with a server certificate issued by the embedded server and renewed in the
+// background until the server context is done
 func NewServerWithEmbedded(input NewServerWithEmbeddedInput) (*grpc.Server, error) {
-	tlsc, _, _, err := input.Server.NewServerAuto(&embedded.CSRJson{
-		CommonName: input.CommonName,
-		Domains:    input.Domains,
-		IPs:        input.IPs,
-	})
-	if err != nil {
+	m := input.CertManager
+	if m == nil {
+		m = &ztls.CertManager{}
+	}
+	if m.CSR.CommonName == "" {
+		m.CSR = ztls.NewCSRInput{
+			CommonName: input.CommonName,
+			Domains:    input.Domains,
+			IPs:        input.IPs,
+		}
+	}
+	if m.Profile == "" {
+		m.Profile = ztls.ProfileServer
+	}
+	if m.Issuer == nil && m.Client == nil {
+		m.Issuer = EmbeddedIssuer{input.Server}
+	}
+	if err := m.Start(input.Server.Context()); err != nil {
 		return nil, err
 	}
 	//
 	optx := make([]grpc.ServerOption, 1)
-	optx[0] = grpc.Creds(credentials.NewTLS(tlsc))
+	optx[0] = grpc.Creds(ServerCredentials(m, tls.RequireAndVerifyClientCert))
 	if input.Options != nil {
 		optx = append(optx, input.Options...)
 	}
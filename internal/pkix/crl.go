@@ -0,0 +1,60 @@
+package pkix
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+)
+
+var oidCRLReasonCode = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+// RevokedCertificate is a CRL entry
+type RevokedCertificate struct {
+	SerialNumber *big.Int
+	RevokedAt    time.Time
+	Reason       int // RFC 5280, section 5.3.1 (0 = unspecified)
+}
+
+type NewCRLInput struct {
+	CACert     *x509.Certificate
+	CAKey      crypto.Signer
+	Revoked    []RevokedCertificate
+	Number     *big.Int // [REQUIRED] monotonically increasing CRL number
+	ThisUpdate time.Time
+	NextUpdate time.Time
+}
+
+// NewCRL creates a DER encoded X.509 v2 CRL signed by the CA
+func NewCRL(input NewCRLInput) ([]byte, error) {
+	entries := make([]pkix.RevokedCertificate, 0, len(input.Revoked))
+	for _, v := range input.Revoked {
+		entry := pkix.RevokedCertificate{
+			SerialNumber:   v.SerialNumber,
+			RevocationTime: v.RevokedAt.UTC(),
+		}
+		// the reasonCode extension is absent if the reason is unspecified
+		if v.Reason != 0 {
+			val, err := asn1.Marshal(asn1.Enumerated(v.Reason))
+			if err != nil {
+				return nil, err
+			}
+			entry.Extensions = []pkix.Extension{
+				{
+					Id:    oidCRLReasonCode,
+					Value: val,
+				},
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificates: entries,
+		Number:              input.Number,
+		ThisUpdate:          input.ThisUpdate,
+		NextUpdate:          input.NextUpdate,
+	}, input.CACert, input.CAKey)
+}
@@ -2,12 +2,16 @@ package pkix
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"math/big"
+	"net"
+	"reflect"
+	"strings"
 	"time"
 )
 
@@ -22,14 +26,11 @@ type CSRInfo struct {
 	PostalCode         []string
 	IPs                []string
 	Domains            []string
+	URIs               []string // e.g. SPIFFE IDs
 }
 
 func NewCSRPEM(info CSRInfo, keypem, password []byte) ([]byte, error) {
-	keybytes, err := DecodePEM(keypem, PEMRSAPrivateKey, password)
-	if err != nil {
-		return nil, err
-	}
-	pk, err := x509.ParsePKCS1PrivateKey(keybytes)
+	pk, err := ParsePrivateKey(keypem, password)
 	if err != nil {
 		return nil, err
 	}
@@ -50,10 +51,16 @@ func NewCSRPEM(info CSRInfo, keypem, password []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	urilist, err := parseURIs(info.URIs)
+	if err != nil {
+		return nil, err
+	}
+
 	tpl := x509.CertificateRequest{
 		Subject:     csrPkixName,
 		IPAddresses: iplist,
 		DNSNames:    info.Domains,
+		URIs:        urilist,
 	}
 
 	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &tpl, pk)
@@ -76,10 +83,51 @@ func NewCSRPEM(info CSRInfo, keypem, password []byte) ([]byte, error) {
 
 type NewCertificatePEMInput struct {
 	CACert       *x509.Certificate
-	CAKey        *rsa.PrivateKey
+	CAKey        crypto.Signer
 	CSR          *x509.CertificateRequest
 	SerialNumber int64
+	NotBefore    time.Time // default: 15 minutes ago
 	Expires      time.Time
+	// CRLDistributionPoints are the CRL URLs of the issuer (optional)
+	CRLDistributionPoints []string
+	// OCSPServer are the OCSP responder URLs (AIA extension, optional)
+	OCSPServer []string
+	// KeyUsage of the certificate (default: DefaultKeyUsage of the public key)
+	KeyUsage x509.KeyUsage
+	// ExtKeyUsage of the certificate (default: server and client auth)
+	ExtKeyUsage []x509.ExtKeyUsage
+	// CopyCommonName adds the common name to the DNS names (if it is a DNS
+	// name and it is not in the CSR)
+	CopyCommonName bool
+	// ExtraExtensions are added to the certificate (optional)
+	ExtraExtensions []pkix.Extension
+}
+
+// DefaultKeyUsage returns the key usage of a TLS certificate with the given
+// public key: RSA keys are used for signatures and key encipherment (RSA key
+// exchange), ECDSA and Ed25519 keys only sign.
+func DefaultKeyUsage(pub crypto.PublicKey) x509.KeyUsage {
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		return x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	}
+	return x509.KeyUsageDigitalSignature
+}
+
+// IsDNSName reports whether a name (e.g. a common name) looks like a DNS
+// name, wildcards included
+func IsDNSName(name string) bool {
+	if !strings.Contains(name, ".") || net.ParseIP(name) != nil {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '-', r == '*', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
 }
 
 func NewCertificatePEM(input NewCertificatePEMInput) ([]byte, error) {
@@ -89,11 +137,8 @@ func NewCertificatePEM(input NewCertificatePEMInput) ([]byte, error) {
 		Subject:      pkix.Name{},
 		NotBefore:    time.Now().Add(time.Minute * -15),
 		NotAfter:     time.Now().AddDate(1, 0, 1),
-		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyAgreement,
-		ExtKeyUsage: []x509.ExtKeyUsage{
-			x509.ExtKeyUsageServerAuth,
-			x509.ExtKeyUsageClientAuth,
-		},
+		KeyUsage:     input.KeyUsage,
+		ExtKeyUsage:  input.ExtKeyUsage,
 		//UnknownExtKeyUsage: nil,
 		// activate CA
 		BasicConstraintsValid: false,
@@ -107,8 +152,7 @@ func NewCertificatePEM(input NewCertificatePEMInput) ([]byte, error) {
 	}
 
 	if input.SerialNumber == 0 {
-		serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-		serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+		serialNumber, err := RandomSerialNumber()
 		if err != nil {
 			return nil, err
 		}
@@ -120,20 +164,42 @@ func NewCertificatePEM(input NewCertificatePEMInput) ([]byte, error) {
 	// RawSubject has a value.
 	tpl.RawSubject = input.CSR.RawSubject
 
-	csrpubk := input.CSR.PublicKey.(*rsa.PublicKey)
+	if err := input.CSR.CheckSignature(); err != nil {
+		return nil, err
+	}
 	var err error
-	tpl.SubjectKeyId, err = GenSubjectKeyID(*csrpubk)
+	tpl.SubjectKeyId, err = GenSubjectKeyID(input.CSR.PublicKey)
 	if err != nil {
 		return nil, err
 	}
+	if !input.NotBefore.IsZero() {
+		tpl.NotBefore = input.NotBefore
+	}
 	if !input.Expires.IsZero() && input.Expires.Before(input.CACert.NotAfter) {
 		tpl.NotAfter = input.Expires
 	} else {
 		tpl.NotAfter = input.CACert.NotAfter
 	}
 
+	if tpl.KeyUsage == 0 {
+		tpl.KeyUsage = DefaultKeyUsage(input.CSR.PublicKey)
+	}
+	if tpl.ExtKeyUsage == nil {
+		tpl.ExtKeyUsage = []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		}
+	}
+
 	tpl.IPAddresses = input.CSR.IPAddresses
 	tpl.DNSNames = input.CSR.DNSNames
+	tpl.URIs = input.CSR.URIs
+	if cn := input.CSR.Subject.CommonName; input.CopyCommonName && IsDNSName(cn) && !ContainsFold(tpl.DNSNames, cn) {
+		tpl.DNSNames = append([]string{cn}, tpl.DNSNames...)
+	}
+	tpl.ExtraExtensions = input.ExtraExtensions
+	tpl.CRLDistributionPoints = input.CRLDistributionPoints
+	tpl.OCSPServer = input.OCSPServer
 
 	raw, err := x509.CreateCertificate(rand.Reader, &tpl, input.CACert, input.CSR.PublicKey, input.CAKey)
 
@@ -153,3 +219,28 @@ func NewCertificatePEM(input NewCertificatePEMInput) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// SameNames reports whether a CSR has the subject and alternative names of a
+// certificate (the names of a renewal). The common name copied to the DNS
+// names of the certificate (NewCertificatePEMInput.CopyCommonName) matches.
+func SameNames(csr *x509.CertificateRequest, cert *x509.Certificate) bool {
+	dns := csr.DNSNames
+	if cn := csr.Subject.CommonName; IsDNSName(cn) && !ContainsFold(dns, cn) && len(cert.DNSNames) == len(dns)+1 {
+		dns = append([]string{cn}, dns...)
+	}
+	return bytes.Equal(csr.RawSubject, cert.RawSubject) &&
+		(reflect.DeepEqual(csr.DNSNames, cert.DNSNames) || reflect.DeepEqual(dns, cert.DNSNames)) &&
+		reflect.DeepEqual(csr.EmailAddresses, cert.EmailAddresses) &&
+		reflect.DeepEqual(csr.IPAddresses, cert.IPAddresses) &&
+		reflect.DeepEqual(csr.URIs, cert.URIs)
+}
+
+// ContainsFold reports whether a list has a value (case insensitive)
+func ContainsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
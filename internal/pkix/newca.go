@@ -2,6 +2,7 @@ package pkix
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
@@ -11,52 +12,123 @@ import (
 	"encoding/pem"
 	"errors"
 	"math/big"
+	"net"
 	"time"
 )
 
-// NewCACertificate creates a new CA Certificate
-// Key: pem encoded RSA PRIVATE KEY
+// DefaultCAValidity is the lifetime of a CA certificate when
+// NewCACertificateInput.NotAfter is not set
+const DefaultCAValidity = time.Hour * 24 * 365 * 20
+
+// NewCACertificateInput holds the parameters of a new CA certificate
+type NewCACertificateInput struct {
+	Key          []byte    // [REQUIRED] PEM encoded private key
+	KeyPassword  []byte    // [OPTIONAL] Key password (if encrypted)
+	Subject      pkix.Name // [OPTIONAL] Default: CN=ztls
+	SerialNumber *big.Int  // [OPTIONAL] Default: random 128 bit serial
+	NotBefore    time.Time // [OPTIONAL] Default: 15 minutes ago
+	NotAfter     time.Time // [OPTIONAL] Default: NotBefore + DefaultCAValidity
+	// MaxPathLen is the maximum number of intermediate CAs below this one.
+	// 0 means that this CA can only sign leaf certificates; -1 means unlimited.
+	MaxPathLen int
+	// Name constraints (RFC 5280, section 4.2.1.10)
+	PermittedDNSDomains     []string
+	ExcludedDNSDomains      []string
+	PermittedIPRanges       []*net.IPNet
+	ExcludedIPRanges        []*net.IPNet
+	NameConstraintsCritical bool
+	// Parent and ParentKey sign the certificate (intermediate CA). If nil,
+	// the certificate is self signed (root CA).
+	Parent    *x509.Certificate
+	ParentKey crypto.Signer
+}
+
+// NewCACertificate creates a new self signed CA Certificate with the
+// default parameters
+// Key: pem encoded RSA, EC or PKCS#8 PRIVATE KEY
 func NewCACertificate(key []byte) ([]byte, error) {
-	blk, _ := pem.Decode(key)
-	if blk.Type != "RSA PRIVATE KEY" {
-		return nil, errors.New("invalid PEM block: " + blk.Type)
+	return NewCACertificateWithInput(NewCACertificateInput{
+		Key: key,
+	})
+}
+
+// NewIntermediateCertificate creates a new intermediate CA Certificate
+// signed by input.Parent
+func NewIntermediateCertificate(input NewCACertificateInput) ([]byte, error) {
+	if input.Parent == nil || input.ParentKey == nil {
+		return nil, errors.New("the parent certificate and key are required")
 	}
-	pk, err := x509.ParsePKCS1PrivateKey(blk.Bytes)
+	return NewCACertificateWithInput(input)
+}
+
+// NewCACertificateWithInput creates a new CA Certificate. It is self signed
+// unless input.Parent is set.
+func NewCACertificateWithInput(input NewCACertificateInput) ([]byte, error) {
+	pk, err := ParsePrivateKey(input.Key, input.KeyPassword)
 	if err != nil {
 		return nil, err
 	}
 
 	tpl := x509.Certificate{
-		SerialNumber:                big.NewInt(1),
-		Subject:                     pkix.Name{},
-		NotBefore:                   time.Now().Add(time.Minute * -15),
-		NotAfter:                    time.Now().AddDate(20, 0, 0),
+		SerialNumber:                input.SerialNumber,
+		Subject:                     input.Subject,
+		NotBefore:                   input.NotBefore,
+		NotAfter:                    input.NotAfter,
 		KeyUsage:                    x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid:       true,
 		IsCA:                        true,
-		MaxPathLenZero:              true,
+		MaxPathLen:                  input.MaxPathLen,
+		MaxPathLenZero:              input.MaxPathLen == 0,
 		SubjectKeyId:                nil,
 		DNSNames:                    nil,
-		PermittedDNSDomainsCritical: false,
-		PermittedDNSDomains:         nil,
+		PermittedDNSDomainsCritical: input.NameConstraintsCritical,
+		PermittedDNSDomains:         input.PermittedDNSDomains,
+		ExcludedDNSDomains:          input.ExcludedDNSDomains,
+		PermittedIPRanges:           input.PermittedIPRanges,
+		ExcludedIPRanges:            input.ExcludedIPRanges,
+	}
+	if tpl.MaxPathLen < 0 {
+		tpl.MaxPathLen = -1
+	}
+	if tpl.SerialNumber == nil {
+		if tpl.SerialNumber, err = RandomSerialNumber(); err != nil {
+			return nil, err
+		}
+	}
+	if tpl.NotBefore.IsZero() {
+		tpl.NotBefore = time.Now().Add(time.Minute * -15)
+	}
+	if tpl.NotAfter.IsZero() {
+		tpl.NotAfter = tpl.NotBefore.Add(DefaultCAValidity)
+	}
+	parent, parentkey := &tpl, pk
+	if input.Parent != nil {
+		if input.ParentKey == nil {
+			return nil, errors.New("the parent key is required")
+		}
+		if !input.Parent.IsCA {
+			return nil, errors.New("the parent certificate is not a CA")
+		}
+		parent, parentkey = input.Parent, input.ParentKey
+		if tpl.NotAfter.After(parent.NotAfter) {
+			tpl.NotAfter = parent.NotAfter
+		}
+	}
+	if !tpl.NotAfter.After(tpl.NotBefore) {
+		return nil, errors.New("invalid validity period (NotAfter must be after NotBefore)")
+	}
+	if tpl.Subject.CommonName == "" && len(tpl.Subject.Organization) == 0 {
+		tpl.Subject.CommonName = "ztls"
 	}
 
-	subjectKeyID, err := GenSubjectKeyID(pk.PublicKey)
+	subjectKeyID, err := GenSubjectKeyID(pk.Public())
 	if err != nil {
 		return nil, err
 	}
 
 	tpl.SubjectKeyId = subjectKeyID
 
-	//TODO: get stuff below dynamically
-	tpl.Subject.Country = []string{"BR"}
-	tpl.Subject.Province = []string{"Sao Paulo"}
-	tpl.Subject.Locality = []string{"Sao Paulo"}
-	tpl.Subject.Organization = []string{"ztls Self Signed Certificates"}
-	tpl.Subject.OrganizationalUnit = []string{"IT"}
-	tpl.Subject.CommonName = "ztls"
-
-	crtbytes, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, &pk.PublicKey, pk)
+	crtbytes, err := x509.CreateCertificate(rand.Reader, &tpl, parent, pk.Public(), parentkey)
 	if err != nil {
 		return nil, err
 	}
@@ -72,19 +144,26 @@ func NewCACertificate(key []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-type keyForASN1 struct {
-	N *big.Int
-	E int
+type subjectPublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
 }
 
-func GenSubjectKeyID(key rsa.PublicKey) ([]byte, error) {
-	raw, err := asn1.Marshal(keyForASN1{
-		N: key.N,
-		E: key.E,
-	})
+// GenSubjectKeyID returns the 160-bit SHA-1 hash of the value of the BIT
+// STRING subjectPublicKey (RFC 5280, section 4.2.1.2, method 1).
+// Supported keys: *rsa.PublicKey, *ecdsa.PublicKey and ed25519.PublicKey.
+func GenSubjectKeyID(key crypto.PublicKey) ([]byte, error) {
+	if rk, ok := key.(rsa.PublicKey); ok {
+		key = &rk
+	}
+	der, err := x509.MarshalPKIXPublicKey(key)
 	if err != nil {
 		return nil, err
 	}
-	hash := sha1.Sum(raw)
+	var spki subjectPublicKeyInfo
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum(spki.PublicKey.Bytes)
 	return hash[:], nil
 }
@@ -2,25 +2,106 @@ package pkix
 
 import (
 	"bytes"
-	"encoding/pem"
-	"crypto/x509"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// KeyType is the algorithm (and curve) of a private key
+type KeyType string
+
+const (
+	KeyRSA       KeyType = "rsa"
+	KeyECDSAP256 KeyType = "ecdsa-p256"
+	KeyECDSAP384 KeyType = "ecdsa-p384"
+	KeyEd25519   KeyType = "ed25519"
 )
 
+// DefaultRSAKeySize is used when an RSA key is requested without a size
+const DefaultRSAKeySize = 4096
+
+// ParseKeyType parses a key type name. An empty string is KeyRSA.
+func ParseKeyType(name string) (KeyType, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "rsa":
+		return KeyRSA, nil
+	case "ecdsa-p256", "ecdsa", "ec", "p256", "p-256":
+		return KeyECDSAP256, nil
+	case "ecdsa-p384", "p384", "p-384":
+		return KeyECDSAP384, nil
+	case "ed25519":
+		return KeyEd25519, nil
+	}
+	return "", fmt.Errorf("unknown key type: %v", name)
+}
+
 // NewKey creates a new RSA Private Key (PEM encoded)
 func NewKey(size int) ([]byte, error) {
-	privk, err := rsa.GenerateKey(rand.Reader, size)
-	if err != nil {
-		return nil, err
-	}
-	pemblk := &pem.Block{
-		Type: "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privk),
+	return NewKeyWithType(KeyRSA, size)
+}
+
+// NewKeyWithType creates a new private key (PEM encoded). The size is only
+// used by RSA keys.
+//
+// RSA keys are encoded as "RSA PRIVATE KEY", ECDSA keys as "EC PRIVATE KEY"
+// and Ed25519 keys as "PRIVATE KEY" (PKCS#8).
+func NewKeyWithType(kt KeyType, size int) ([]byte, error) {
+	var pemblk *pem.Block
+	switch kt {
+	case KeyRSA, "":
+		if size == 0 {
+			size = DefaultRSAKeySize
+		}
+		privk, err := rsa.GenerateKey(rand.Reader, size)
+		if err != nil {
+			return nil, err
+		}
+		pemblk = &pem.Block{
+			Type:  string(PEMRSAPrivateKey),
+			Bytes: x509.MarshalPKCS1PrivateKey(privk),
+		}
+	case KeyECDSAP256, KeyECDSAP384:
+		curve := elliptic.P256()
+		if kt == KeyECDSAP384 {
+			curve = elliptic.P384()
+		}
+		privk, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(privk)
+		if err != nil {
+			return nil, err
+		}
+		pemblk = &pem.Block{
+			Type:  string(PEMECPrivateKey),
+			Bytes: der,
+		}
+	case KeyEd25519:
+		_, privk, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(privk)
+		if err != nil {
+			return nil, err
+		}
+		pemblk = &pem.Block{
+			Type:  string(PEMPrivateKey),
+			Bytes: der,
+		}
+	default:
+		return nil, fmt.Errorf("unknown key type: %v", kt)
 	}
 	buf := new(bytes.Buffer)
 	if err := pem.Encode(buf, pemblk); err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
-}
\ No newline at end of file
+}
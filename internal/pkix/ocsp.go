@@ -0,0 +1,47 @@
+package pkix
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"time"
+)
+
+// id-pkix-ocsp-nocheck (RFC 6960, section 4.2.2.2.1)
+var oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+// NewOCSPSignerCertificate creates a delegated OCSP signing certificate
+// (DER encoded) for pub, issued by the CA
+func NewOCSPSignerCertificate(cacert *x509.Certificate, cakey crypto.Signer, pub crypto.PublicKey, notAfter time.Time) ([]byte, error) {
+	serial, err := RandomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	skid, err := GenSubjectKeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+	if notAfter.After(cacert.NotAfter) {
+		notAfter = cacert.NotAfter
+	}
+	tpl := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: cacert.Subject.CommonName + " OCSP Responder",
+		},
+		NotBefore:    time.Now().Add(time.Minute * -15),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		SubjectKeyId: skid,
+		ExtraExtensions: []pkix.Extension{
+			{
+				Id:    oidOCSPNoCheck,
+				Value: asn1.NullBytes,
+			},
+		},
+	}
+	return x509.CreateCertificate(rand.Reader, &tpl, cacert, pub, cakey)
+}
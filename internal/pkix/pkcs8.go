@@ -0,0 +1,230 @@
+package pkix
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PBKDF2Iterations is the iteration count used by
+// MarshalEncryptedPrivateKeyPEM
+var PBKDF2Iterations = 100000
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1       = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA224     = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 8}
+	oidHMACSHA256     = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA384     = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACSHA512     = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	errNoPassword     = errors.New("the private key is encrypted but no password was provided")
+	errBadPadding     = errors.New("invalid padding (wrong password?)")
+	errUnsupportedPBE = errors.New("unsupported PKCS#8 encryption (only PBES2 is supported)")
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// MarshalPrivateKeyPEM encodes a private key as an unencrypted PKCS#8
+// "PRIVATE KEY" PEM block
+func MarshalPrivateKeyPEM(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  string(PEMPrivateKey),
+		Bytes: der,
+	}), nil
+}
+
+// MarshalEncryptedPrivateKeyPEM encodes a private key as a PKCS#8
+// "ENCRYPTED PRIVATE KEY" PEM block (PBES2, PBKDF2-HMAC-SHA256, AES-256-CBC).
+func MarshalEncryptedPrivateKeyPEM(key crypto.Signer, password []byte) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, errNoPassword
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	dk := pbkdf2.Key(password, salt, PBKDF2Iterations, 32, sha256.New)
+	block, err := aes.NewCipher(dk)
+	if err != nil {
+		return nil, err
+	}
+	padlen := aes.BlockSize - len(der)%aes.BlockSize
+	plain := append(der, bytes.Repeat([]byte{byte(padlen)}, padlen)...)
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+
+	kdfparams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: PBKDF2Iterations,
+		PRF: pkix.AlgorithmIdentifier{
+			Algorithm:  oidHMACSHA256,
+			Parameters: asn1.NullRawValue,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivparams, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	pbes2params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBKDF2,
+			Parameters: asn1.RawValue{FullBytes: kdfparams},
+		},
+		EncryptionScheme: pkix.AlgorithmIdentifier{
+			Algorithm:  oidAES256CBC,
+			Parameters: asn1.RawValue{FullBytes: ivparams},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBES2,
+			Parameters: asn1.RawValue{FullBytes: pbes2params},
+		},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  string(PEMEncryptedPrivateKey),
+		Bytes: out,
+	}), nil
+}
+
+// decryptPKCS8 decrypts the DER bytes of an "ENCRYPTED PRIVATE KEY" block,
+// returning the PKCS#8 PrivateKeyInfo DER bytes.
+func decryptPKCS8(der, password []byte) ([]byte, error) {
+	if len(password) == 0 {
+		return nil, errNoPassword
+	}
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, errUnsupportedPBE
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function: %v", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdfparams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfparams); err != nil {
+		return nil, err
+	}
+	var prf func() hash.Hash
+	switch {
+	case len(kdfparams.PRF.Algorithm) == 0, kdfparams.PRF.Algorithm.Equal(oidHMACSHA1):
+		prf = sha1.New
+	case kdfparams.PRF.Algorithm.Equal(oidHMACSHA224):
+		prf = sha256.New224
+	case kdfparams.PRF.Algorithm.Equal(oidHMACSHA256):
+		prf = sha256.New
+	case kdfparams.PRF.Algorithm.Equal(oidHMACSHA384):
+		prf = sha512.New384
+	case kdfparams.PRF.Algorithm.Equal(oidHMACSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 PRF: %v", kdfparams.PRF.Algorithm)
+	}
+
+	var keylen int
+	var newcipher func(key []byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keylen, newcipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keylen, newcipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keylen, newcipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keylen, newcipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("unsupported encryption scheme: %v", scheme)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+
+	dk := pbkdf2.Key(password, kdfparams.Salt, kdfparams.IterationCount, keylen, prf)
+	block, err := newcipher(dk)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, errors.New("invalid IV length")
+	}
+	bsz := block.BlockSize()
+	if len(info.EncryptedData) == 0 || len(info.EncryptedData)%bsz != 0 {
+		return nil, errors.New("invalid encrypted data length")
+	}
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.EncryptedData)
+	padlen := int(plain[len(plain)-1])
+	if padlen == 0 || padlen > bsz {
+		return nil, errBadPadding
+	}
+	for _, b := range plain[len(plain)-padlen:] {
+		if int(b) != padlen {
+			return nil, errBadPadding
+		}
+	}
+	return plain[:len(plain)-padlen], nil
+}
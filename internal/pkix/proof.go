@@ -0,0 +1,44 @@
+package pkix
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+)
+
+var errInvalidProof = errors.New("invalid proof of possession")
+
+// SignProof signs data to prove the possession of a key: PKCS#1 v1.5 or
+// ECDSA (ASN.1) signature of the SHA-256 digest, or Ed25519 signature of the
+// data
+func SignProof(key crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	h := sha256.Sum256(data)
+	return key.Sign(rand.Reader, h[:], crypto.SHA256)
+}
+
+// VerifyProof verifies a signature created by SignProof
+func VerifyProof(pub crypto.PublicKey, data, sig []byte) error {
+	h := sha256.Sum256(data)
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(k, h[:], sig) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(k, data, sig) {
+			return nil
+		}
+	}
+	return errInvalidProof
+}
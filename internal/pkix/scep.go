@@ -0,0 +1,37 @@
+package pkix
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"time"
+)
+
+// NewSCEPRACertificate creates a SCEP registration authority certificate
+// (DER encoded) for pub, issued by the CA. The RA decrypts the requests and
+// signs the responses.
+func NewSCEPRACertificate(cacert *x509.Certificate, cakey crypto.Signer, pub crypto.PublicKey, notAfter time.Time) ([]byte, error) {
+	serial, err := RandomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	skid, err := GenSubjectKeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+	if notAfter.After(cacert.NotAfter) {
+		notAfter = cacert.NotAfter
+	}
+	tpl := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: cacert.Subject.CommonName + " SCEP RA",
+		},
+		NotBefore:    time.Now().Add(time.Minute * -15),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		SubjectKeyId: skid,
+	}
+	return x509.CreateCertificate(rand.Reader, &tpl, cacert, pub, cakey)
+}
@@ -0,0 +1,73 @@
+package pkix
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SPIFFEScheme is the URI scheme of the SPIFFE IDs
+const SPIFFEScheme = "spiffe"
+
+// ParseSPIFFEID parses a SPIFFE ID (spiffe://<trust-domain>/<path>),
+// checking the rules of the SPIFFE specification: a lowercase trust domain
+// without port or user info, and a path without empty, "." or ".." segments,
+// query or fragment
+func ParseSPIFFEID(id string) (*url.URL, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: %v", id, err)
+	}
+	switch {
+	case u.Scheme != SPIFFEScheme:
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: the scheme must be %v", id, SPIFFEScheme)
+	case u.Host == "" || u.Host != strings.ToLower(u.Host) || u.Port() != "":
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: invalid trust domain", id)
+	case u.User != nil || u.RawQuery != "" || u.Fragment != "" || u.Opaque != "":
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: user info, query and fragment are not allowed", id)
+	}
+	if u.Path != "" {
+		for _, seg := range strings.Split(u.Path[1:], "/") {
+			if seg == "" || seg == "." || seg == ".." {
+				return nil, fmt.Errorf("invalid SPIFFE ID %q: invalid path", id)
+			}
+		}
+	}
+	return u, nil
+}
+
+// MatchSPIFFEID reports whether a SPIFFE ID matches a pattern:
+// "spiffe://example.org/svc" matches the ID, "spiffe://example.org/ns/*"
+// matches the IDs under /ns/
+func MatchSPIFFEID(pattern, id string) bool {
+	if id == "" {
+		return false
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return len(id) > len(pattern)-1 && strings.HasPrefix(id, pattern[:len(pattern)-1])
+	}
+	return pattern == id
+}
+
+// IsSPIFFEID reports whether a URI has the spiffe scheme
+func IsSPIFFEID(u *url.URL) bool {
+	return u != nil && u.Scheme == SPIFFEScheme
+}
+
+// parseURIs parses the URI SANs of a CSR
+func parseURIs(uris []string) ([]*url.URL, error) {
+	outp := make([]*url.URL, 0, len(uris))
+	for _, v := range uris {
+		u, err := url.Parse(v)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(v, SPIFFEScheme+":") {
+			if u, err = ParseSPIFFEID(v); err != nil {
+				return nil, err
+			}
+		}
+		outp = append(outp, u)
+	}
+	return outp, nil
+}
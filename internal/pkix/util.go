@@ -1,18 +1,25 @@
 package pkix
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"math/big"
 	"net"
 )
 
 type PEMLabel string
 
 const (
-	PEMCertificate        PEMLabel = "CERTIFICATE"
-	PEMRSAPrivateKey      PEMLabel = "RSA PRIVATE KEY"
-	PEMCertificateRequest PEMLabel = "CERTIFICATE REQUEST"
+	PEMCertificate         PEMLabel = "CERTIFICATE"
+	PEMRSAPrivateKey       PEMLabel = "RSA PRIVATE KEY"
+	PEMECPrivateKey        PEMLabel = "EC PRIVATE KEY"
+	PEMPrivateKey          PEMLabel = "PRIVATE KEY"
+	PEMEncryptedPrivateKey PEMLabel = "ENCRYPTED PRIVATE KEY"
+	PEMCertificateRequest  PEMLabel = "CERTIFICATE REQUEST"
 )
 
 func DecodePEM(rawpem []byte, label PEMLabel, password []byte) ([]byte, error) {
@@ -24,7 +31,11 @@ func DecodePEM(rawpem []byte, label PEMLabel, password []byte) ([]byte, error) {
 		return nil, fmt.Errorf("invalid PEM label (expected %v, but got %v)", label, block.Type)
 	}
 	blkbytes := block.Bytes
-	if len(password) > 0 {
+	if len(password) == 0 && x509.IsEncryptedPEMBlock(block) {
+		return nil, errNoPassword
+	}
+	if len(password) > 0 && x509.IsEncryptedPEMBlock(block) {
+		// the password of an unencrypted block is ignored
 		var err error
 		blkbytes, err = x509.DecryptPEMBlock(block, password)
 		if err != nil {
@@ -34,21 +45,93 @@ func DecodePEM(rawpem []byte, label PEMLabel, password []byte) ([]byte, error) {
 	return blkbytes, nil
 }
 
-func UnencryptedRSAPrivateKeyPEM(rawpem []byte, password []byte) ([]byte, error) {
-	if rawpem != nil && password == nil {
-		return rawpem, nil
+// ParsePrivateKey parses a PEM encoded private key. Supported labels:
+// "RSA PRIVATE KEY" and "EC PRIVATE KEY" (optionally with legacy PEM
+// encryption), "PRIVATE KEY" (PKCS#8) and "ENCRYPTED PRIVATE KEY" (PKCS#8,
+// PBES2).
+func ParsePrivateKey(rawpem []byte, password []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(rawpem)
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM bytes (pem.Decode)")
+	}
+	label := PEMLabel(block.Type)
+	var der []byte
+	var err error
+	if label == PEMEncryptedPrivateKey {
+		der, err = decryptPKCS8(block.Bytes, password)
+		label = PEMPrivateKey
+	} else {
+		der, err = DecodePEM(rawpem, label, password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch label {
+	case PEMRSAPrivateKey:
+		return x509.ParsePKCS1PrivateKey(der)
+	case PEMECPrivateKey:
+		return x509.ParseECPrivateKey(der)
+	case PEMPrivateKey:
+		key, err := x509.ParsePKCS8PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key: %T", key)
+		}
+		return signer, nil
 	}
-	der, err := DecodePEM(rawpem, PEMRSAPrivateKey, password)
+	return nil, fmt.Errorf("invalid PEM label (expected a private key, but got %v)", block.Type)
+}
+
+// UnencryptedRSAPrivateKeyPEM decrypts (if needed) a PEM encoded RSA private
+// key, returning it as an unencrypted "RSA PRIVATE KEY" PEM block.
+func UnencryptedRSAPrivateKeyPEM(rawpem []byte, password []byte) ([]byte, error) {
+	key, err := ParsePrivateKey(rawpem, password)
 	if err != nil {
 		return nil, err
 	}
+	rsakey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA private key: %T", key)
+	}
 	block := &pem.Block{
 		Type:  string(PEMRSAPrivateKey),
-		Bytes: der,
+		Bytes: x509.MarshalPKCS1PrivateKey(rsakey),
 	}
 	return pem.EncodeToMemory(block), nil
 }
 
+// UnencryptedPrivateKeyPEM decrypts (if needed) a PEM encoded private key,
+// returning it as an unencrypted PKCS#8 "PRIVATE KEY" PEM block.
+func UnencryptedPrivateKeyPEM(rawpem []byte, password []byte) ([]byte, error) {
+	key, err := ParsePrivateKey(rawpem, password)
+	if err != nil {
+		return nil, err
+	}
+	return MarshalPrivateKeyPEM(key)
+}
+
+// RandomSerialNumber returns a random 128 bit certificate serial number
+func RandomSerialNumber() (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, serialNumberLimit)
+}
+
+// ParseCIDRs parses a list of CIDR notation IP ranges
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	outp := make([]*net.IPNet, 0, len(cidrs))
+	for _, v := range cidrs {
+		_, ipnet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		outp = append(outp, ipnet)
+	}
+	return outp, nil
+}
+
 func parseIPs(ips []string) ([]net.IP, error) {
 	if len(ips) == 1 && ips[0] == "" {
 		return []net.IP{}, nil